@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"mye-r/internal/api"
+	"mye-r/internal/database"
+)
+
+// apikey creates, lists and revokes keys of the HTTP API
+func apikey(args []string) int {
+	if len(args) == 0 {
+		apikeyUsage()
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "create":
+		return apikeyCreate(args[1:])
+	case "list":
+		return apikeyList(args[1:])
+	case "revoke":
+		return apikeyRevoke(args[1:])
+	case "help", "-h", "--help":
+		apikeyUsage()
+		return exitOK
+	}
+	fmt.Fprintf(os.Stderr, "unknown apikey command %q\n\n", args[0])
+	apikeyUsage()
+	return exitUsage
+}
+
+func apikeyUsage() {
+	fmt.Fprintf(os.Stderr, `Usage: mye-r apikey <create|list|revoke> [flags]
+
+  create  --name <name> --scope <read|request|admin>  print a new key once
+  list                                                show every key
+  revoke  --name <name>                               stop a key from working
+
+read keys see items, queues and events, request keys can also add items and
+admin keys can also retry, skip, pin and run stages.
+`)
+}
+
+func apikeyCreate(args []string) int {
+	fs, opts := newFlagSet("apikey create", false)
+	name := fs.String("name", "", "Name of the key, recorded with every change made with it")
+	scope := fs.String("scope", api.ScopeRead, "read, request or admin")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	*name = strings.TrimSpace(*name)
+	if *name == "" {
+		fmt.Fprintln(os.Stderr, "--name is required")
+		return exitUsage
+	}
+	if !api.ValidScope(*scope) {
+		fmt.Fprintf(os.Stderr, "unknown scope %q, use read, request or admin\n", *scope)
+		return exitUsage
+	}
+
+	a, err := bootstrap(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitSetup
+	}
+	defer a.Close()
+
+	key, prefix, hash, err := api.NewAPIKey()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	if _, err := a.db.CreateAPIKey(*name, prefix, hash, []string{*scope}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+
+	fmt.Fprintf(os.Stderr, "Created %s key %s. It is not stored and will not be shown again:\n", *scope, *name)
+	fmt.Println(key)
+	return exitOK
+}
+
+func apikeyList(args []string) int {
+	fs, opts := newFlagSet("apikey list", false)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	a, err := bootstrap(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitSetup
+	}
+	defer a.Close()
+
+	keys, err := a.db.ListAPIKeys()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	if len(keys) == 0 {
+		fmt.Println("No API keys, create one with 'mye-r apikey create'")
+		return exitOK
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPREFIX\tSCOPES\tCREATED\tLAST USED\tREVOKED")
+	for _, key := range keys {
+		lastUsed, revoked := "never", ""
+		if key.LastUsedAt.Valid {
+			lastUsed = key.LastUsedAt.Time.Format("2006-01-02 15:04")
+		}
+		if key.RevokedAt.Valid {
+			revoked = key.RevokedAt.Time.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s...\t%s\t%s\t%s\t%s\n", key.Name, key.Prefix, strings.Join(key.Scopes, ","),
+			key.CreatedAt.Format("2006-01-02 15:04"), lastUsed, revoked)
+	}
+	w.Flush()
+	return exitOK
+}
+
+func apikeyRevoke(args []string) int {
+	fs, opts := newFlagSet("apikey revoke", false)
+	name := fs.String("name", "", "Name of the key to revoke")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *name == "" {
+		fmt.Fprintln(os.Stderr, "--name is required")
+		return exitUsage
+	}
+
+	a, err := bootstrap(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitSetup
+	}
+	defer a.Close()
+
+	err = a.db.RevokeAPIKey(*name)
+	if errors.Is(err, database.ErrAPIKeyNotFound) {
+		fmt.Fprintf(os.Stderr, "no active key named %q\n", *name)
+		return exitFailed
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	fmt.Printf("Revoked key %s\n", *name)
+	return exitOK
+}
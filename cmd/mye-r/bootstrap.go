@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"mye-r/internal/config"
+	"mye-r/internal/database"
+	"mye-r/internal/logger"
+	"mye-r/internal/pipeline"
+
+	"github.com/joho/godotenv"
+)
+
+// options holds the flags shared by the commands
+type options struct {
+	configFile  string
+	envFile     string
+	items       itemList
+	itemsFile   string
+	all         bool
+	runID       int
+	metricsFile string
+	dryRun      bool
+}
+
+// newFlagSet creates the flag set of a command. Stage commands also get the
+// item selection flags.
+func newFlagSet(name string, withItems bool) (*flag.FlagSet, *options) {
+	opts := &options{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&opts.configFile, "config", "config.yaml", "Path to config file")
+	fs.StringVar(&opts.envFile, "env", ".env", "Path to env file")
+	if withItems {
+		fs.Var(&opts.items, "item", "Item ID to process, may be repeated or comma separated")
+		fs.StringVar(&opts.itemsFile, "items-file", "", "Path to JSON file containing item IDs to process")
+		fs.BoolVar(&opts.all, "all", false, "Process every item waiting for the stage")
+		fs.IntVar(&opts.runID, "run-id", 0, "Record outcomes under this process run (set by serve)")
+		fs.StringVar(&opts.metricsFile, "metrics-file", "", "Save the metrics of this run to a file (set by serve)")
+		fs.BoolVar(&opts.dryRun, "dry-run", false, "Roll back database changes and skip Real-Debrid and the library")
+	}
+	return fs, opts
+}
+
+// parseFlags parses a command line and reports the exit code to use when the
+// command should not go on
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// validateSelection checks that exactly one way of selecting items was used
+func (o *options) validateSelection() error {
+	selected := 0
+	if len(o.items) > 0 {
+		selected++
+	}
+	if o.itemsFile != "" {
+		selected++
+	}
+	if o.all {
+		selected++
+	}
+	if selected != 1 {
+		return fmt.Errorf("use exactly one of --item, --items-file or --all")
+	}
+	return nil
+}
+
+// app is what every command needs once it is set up
+type app struct {
+	cfg *config.Config
+	db  *database.DB
+	log *logger.Logger
+}
+
+// bootstrap loads the env file and config and connects to the database
+func bootstrap(opts *options) (*app, error) {
+	log := logger.New()
+
+	if err := godotenv.Load(opts.envFile); err != nil {
+		log.Warning("Application", "Config", fmt.Sprintf("Warning: %s not loaded: %v", opts.envFile, err))
+	}
+
+	cfg, err := config.LoadConfig(opts.configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %v", err)
+	}
+
+	db, err := database.NewDB(cfg.Database.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %v", err)
+	}
+
+	// Move items off legacy states and report the ones it cannot place
+	if _, err := pipeline.CheckStates(db); err != nil {
+		log.Error("Application", "Pipeline", fmt.Sprintf("Failed to check item states: %v", err))
+	}
+
+	return &app{cfg: cfg, db: db, log: log}, nil
+}
+
+func (a *app) Close() {
+	a.db.Close()
+}
+
+// startDryRun switches the app to a database transaction that is rolled back
+// on Close and tells the stages to leave Real-Debrid and the library alone
+func (a *app) startDryRun() error {
+	db, err := a.db.BeginDryRun()
+	if err != nil {
+		return err
+	}
+	a.db = db
+	a.cfg.DryRun = true
+	a.log.Info("Application", "DryRun", "Dry run: database changes will be rolled back")
+	return nil
+}
+
+// selectItems loads the items a stage command was asked to process
+func (a *app) selectItems(opts *options, stage pipeline.Stage) ([]*database.WatchlistItem, error) {
+	if opts.all {
+		return a.db.GetQueuedItems(string(stage), string(stage.PendingState()))
+	}
+
+	ids := []int(opts.items)
+	if opts.itemsFile != "" {
+		var err error
+		if ids, err = readItemsFile(opts.itemsFile); err != nil {
+			return nil, err
+		}
+	}
+
+	items := make([]*database.WatchlistItem, 0, len(ids))
+	for _, id := range ids {
+		item, err := a.db.GetWatchlistItem(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get item %d: %v", id, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// readItemsFile reads a JSON array of item IDs, as written by the RunManager
+func readItemsFile(path string) ([]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening items file: %v", err)
+	}
+	defer file.Close()
+
+	var ids []int
+	if err := json.NewDecoder(file).Decode(&ids); err != nil {
+		return nil, fmt.Errorf("error decoding items file: %v", err)
+	}
+	return ids, nil
+}
+
+// itemList is a flag.Value collecting item IDs from repeated or comma
+// separated --item flags
+type itemList []int
+
+func (l *itemList) String() string {
+	parts := make([]string, len(*l))
+	for i, id := range *l {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *itemList) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || id <= 0 {
+			return fmt.Errorf("invalid item ID %q", part)
+		}
+		*l = append(*l, id)
+	}
+	return nil
+}
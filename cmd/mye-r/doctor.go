@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"mye-r/internal/config"
+	"mye-r/internal/database"
+	"mye-r/internal/health"
+
+	"github.com/joho/godotenv"
+)
+
+// doctor runs the checks of /readyz and prints how to fix what failed. It does
+// its own setup so that an unreachable database is reported like the rest.
+func doctor(args []string) int {
+	fs, opts := newFlagSet("doctor", false)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if err := godotenv.Load(opts.envFile); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %s not loaded: %v\n", opts.envFile, err)
+	}
+	cfg, err := config.LoadConfig(opts.configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load configuration: %v\n", err)
+		return exitSetup
+	}
+
+	var db *database.DB
+	if cfg.Database.URL != "" {
+		if db, err = database.Open(cfg.Database.URL); err == nil {
+			defer db.Close()
+		}
+	}
+
+	results := health.New(cfg, db).All(context.Background())
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, result := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", strings.ToUpper(result.Status), result.Name, result.Message)
+		if result.Hint != "" {
+			fmt.Fprintf(w, "\t\t-> %s\n", result.Hint)
+		}
+	}
+	w.Flush()
+
+	if !health.Healthy(results) {
+		return exitFailed
+	}
+	return exitOK
+}
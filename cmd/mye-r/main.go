@@ -1,39 +1,95 @@
 package main
 
 import (
-	"log"
+	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
 
-	"mye-r/internal/database"
-	"mye-r/internal/indexer"
-	"mye-r/internal/manager"
-	"mye-r/internal/scraper"
+	"mye-r/internal"
+	"mye-r/internal/pipeline"
+)
+
+// Exit codes shared by all commands
+const (
+	exitOK     = 0 // everything worked
+	exitFailed = 1 // the command ran but some items or fetches failed
+	exitUsage  = 2 // the command line was wrong
+	exitSetup  = 3 // config or database could not be loaded
 )
 
 func main() {
-	// Initialize database
-	db, err := database.New()
-	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return exitUsage
 	}
 
-	// Initialize components
-	idx := indexer.New(db)
-	scr := scraper.New(db)
-	mgr := manager.New(db, idx, scr)
+	name, args := args[0], args[1:]
+	switch name {
+	case "serve":
+		return serve(args)
+	case "fetch":
+		return fetch(args)
+	case "request":
+		return request(args)
+	case "candidates":
+		return candidates(args)
+	case "pin":
+		return pin(args)
+	case "doctor":
+		return doctor(args)
+	case "apikey":
+		return apikey(args)
+	case "trakt":
+		return trakt(args)
+	case "help", "-h", "--help":
+		usage()
+		return exitOK
+	}
 
-	// Start the manager
-	if err := mgr.Start(); err != nil {
-		log.Fatalf("Failed to start manager: %v", err)
+	if stage, ok := stageForCommand(name); ok {
+		return runStage(stage, name, args)
 	}
 
-	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	return exitUsage
+}
+
+// stageForCommand returns the pipeline stage a subcommand runs
+func stageForCommand(name string) (pipeline.Stage, bool) {
+	for stage, command := range internal.StageCommands {
+		if command == name {
+			return stage, true
+		}
+	}
+	return "", false
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: mye-r <command> [flags]
+
+Commands:
+  serve      run the fetchers and all pipeline stages
+  fetch      fetch new items from the configured feeds once
+  request    add a movie or show by IMDb, TMDB or TVDB ID or by title
+  candidates list the releases scraped for an item
+  pin        make the downloader use a chosen release for an item
+  doctor     check the database, providers and paths and suggest fixes
+  apikey     create, list and revoke keys of the HTTP API
+  trakt      log in to Trakt for the trakt fetcher
+  index      fetch TMDB metadata for items
+  match      match items to custom libraries
+  scrape     scrape releases for items
+  download   add items to Real-Debrid
+  symlink    link downloaded items into the library
 
-	// Cleanup
-	mgr.Stop()
+Stage commands take one of --item <id>, --items-file <file> or --all.
+With --dry-run their database changes are rolled back, the downloader only
+logs the Real-Debrid calls it would make and the symlinker prints the links
+it would create.
+Run 'mye-r <command> -h' for the flags of a command.
+`)
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"mye-r/internal/database"
+	"mye-r/internal/downloader"
+	"mye-r/internal/pipeline"
+	"mye-r/internal/symlinker"
+)
+
+// candidates lists the stored scrape results of an item
+func candidates(args []string) int {
+	fs, opts := newFlagSet("candidates", false)
+	itemID := fs.Int("item", 0, "Item ID")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *itemID <= 0 {
+		fmt.Fprintln(os.Stderr, "--item is required")
+		return exitUsage
+	}
+
+	a, err := bootstrap(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitSetup
+	}
+	defer a.Close()
+
+	results, err := a.db.GetScrapeResultsForItem(*itemID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	if len(results) == 0 {
+		fmt.Printf("Item %d has no candidates\n", *itemID)
+		return exitOK
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPINNED\tSCORE\tSTATUS\tRESOLUTION\tSIZE\tHASH\tFILENAME")
+	for _, result := range results {
+		pinned, score := "", "-"
+		if result.Pinned {
+			pinned = "yes"
+		}
+		if result.ScrapedScore.Valid {
+			score = fmt.Sprint(result.ScrapedScore.Int32)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", result.ID, pinned, score,
+			result.StatusResults.String, result.ScrapedResolution.String, result.ScrapedFileSize.String,
+			result.InfoHash.String, result.ScrapedFilename.String)
+	}
+	w.Flush()
+	return exitOK
+}
+
+// pin makes the downloader use one release of an item instead of the best
+// scored one
+func pin(args []string) int {
+	fs, opts := newFlagSet("pin", false)
+	itemID := fs.Int("item", 0, "Item ID")
+	resultID := fs.Int("result", 0, "Scrape result to pin, see 'mye-r candidates'")
+	release := fs.String("release", "", "Magnet link or info hash to pin")
+	unpin := fs.Bool("clear", false, "Unpin and go back to the ranking")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *itemID <= 0 {
+		fmt.Fprintln(os.Stderr, "--item is required")
+		return exitUsage
+	}
+	actions := 0
+	for _, set := range []bool{*resultID != 0, *release != "", *unpin} {
+		if set {
+			actions++
+		}
+	}
+	if actions != 1 {
+		fmt.Fprintln(os.Stderr, "use exactly one of --result, --release or --clear")
+		return exitUsage
+	}
+
+	infoHash, name := "", ""
+	if *release != "" {
+		var err error
+		if infoHash, name, err = downloader.ParseRelease(*release); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+	}
+
+	a, err := bootstrap(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitSetup
+	}
+	defer a.Close()
+
+	item, err := a.db.GetWatchlistItem(*itemID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+
+	switch {
+	case *unpin:
+		unpinned, err := a.db.UnpinScrapeResult(*itemID)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailed
+		}
+		if !unpinned {
+			fmt.Printf("Item %d has nothing pinned\n", *itemID)
+			return exitOK
+		}
+		fmt.Printf("Unpinned item %d\n", *itemID)
+		return exitOK
+	case *resultID != 0:
+		err = a.db.PinScrapeResult(*itemID, *resultID)
+		if errors.Is(err, database.ErrScrapeResultNotFound) {
+			fmt.Fprintf(os.Stderr, "item %d has no scrape result %d\n", *itemID, *resultID)
+			return exitFailed
+		}
+	default:
+		err = a.db.PinInfoHash(*itemID, infoHash, name)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+
+	pinned, err := a.db.GetLatestScrapeResult(*itemID)
+	if err != nil || pinned == nil {
+		fmt.Fprintf(os.Stderr, "failed to load the pinned release: %v\n", err)
+		return exitFailed
+	}
+	fmt.Printf("Pinned %s for item %d\n", pinned.InfoHash.String, *itemID)
+
+	reason := fmt.Sprintf("pinned %s with mye-r pin", pinned.InfoHash.String)
+	requeued, err := downloader.New(a.cfg, a.db).RequeuePinned(item, symlinker.New(a.cfg, a.db), reason)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to requeue item %d: %v\n", *itemID, err)
+		return exitFailed
+	}
+	if requeued {
+		fmt.Printf("Item %d goes back to %s for the pinned release\n", *itemID, pipeline.StateDownloadPending)
+	}
+	return exitOK
+}
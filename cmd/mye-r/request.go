@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"mye-r/internal/getcontent"
+)
+
+// request adds a movie or show to the watchlist by ID or title
+func request(args []string) int {
+	fs, opts := newFlagSet("request", false)
+	var req getcontent.Request
+	fs.StringVar(&req.MediaType, "type", "", "movie or tv; needed with TMDB IDs, narrows title searches")
+	fs.IntVar(&req.Year, "year", 0, "Release year, narrows title searches")
+	fs.StringVar(&req.RequestedBy, "by", "", "Who asked for the item")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: mye-r request [flags] <id or title>
+
+The item is looked up on TMDB and added to the watchlist unless it is already
+there. IDs are IMDb IDs (tt0133093) or IDs prefixed with their source
+(tmdb:603, tvdb:81189); anything else is searched for as a title.
+
+`)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	what := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if what == "" {
+		fs.Usage()
+		return exitUsage
+	}
+	if _, _, err := getcontent.ParseID(what); err == nil {
+		req.ID = what
+	} else {
+		req.Query = what
+	}
+	if err := req.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	a, err := bootstrap(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitSetup
+	}
+	defer a.Close()
+
+	item, created, err := getcontent.NewRequester(a.cfg, a.db).Add(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+
+	if created {
+		fmt.Printf("Added item %d: %s (%d)\n", item.ID, item.Title, item.ItemYear.Int64)
+	} else {
+		fmt.Printf("Already on the watchlist as item %d: %s (%d), %s\n", item.ID, item.Title, item.ItemYear.Int64, item.CurrentStep.String)
+	}
+	return exitOK
+}
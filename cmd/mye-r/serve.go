@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"mye-r/internal"
+	"mye-r/internal/api"
+	"mye-r/internal/getcontent"
+	"mye-r/internal/indexers"
+	"mye-r/internal/manager"
+	"mye-r/internal/pipeline"
+	"mye-r/internal/symlinker"
+)
+
+// serve runs the fetchers and the RunManager until it is interrupted
+func serve(args []string) int {
+	fs, opts := newFlagSet("serve", false)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	a, err := bootstrap(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitSetup
+	}
+	defer a.Close()
+	a.log.Info("Application", "Start", "Starting application...")
+
+	// Initialize the run manager
+	runManager := internal.NewRunManager(a.cfg, a.db)
+	runManager.UseFiles(opts.configFile, opts.envFile)
+
+	// Initialize and register all components in order of processing
+	if a.cfg.FetchersEnabled() {
+		a.log.Info("Application", "ContentFetcher", "Registering content fetcher...")
+		contentFetcher, err := getcontent.New(a.cfg, a.db)
+		if err != nil {
+			a.log.Error("Application", "ContentFetcher", fmt.Sprintf("Failed to create content fetcher: %v", err))
+			return exitSetup
+		}
+		runManager.RegisterProcess(&internal.ProcessInfo{
+			ProcessName: "getcontent",
+			Process:     contentFetcher,
+		})
+	}
+
+	var apiServer *api.Server
+	if a.cfg.API.Enabled {
+		apiServer = api.New(a.cfg, a.db)
+		apiServer.SetLinker(symlinker.New(a.cfg, a.db))
+	}
+
+	var tmdbIndexer stageProcess
+	for _, stage := range pipeline.Stages() {
+		if !a.stageEnabled(stage) {
+			continue
+		}
+		a.log.Info("Application", string(stage), fmt.Sprintf("Registering %s...", stage))
+		process, err := a.newStage(stage)
+		if err != nil {
+			a.log.Error("Application", string(stage), err.Error())
+			return exitSetup
+		}
+		if stage == pipeline.StageIndexer {
+			tmdbIndexer = process
+		}
+		runManager.RegisterProcess(&internal.ProcessInfo{
+			ProcessName: string(stage),
+			Process:     process,
+		})
+		if apiServer != nil {
+			apiServer.AddStage(stage, process)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Start the run manager
+	a.log.Info("Application", "RunManager", "Starting run manager...")
+	if err := runManager.Start(ctx); err != nil {
+		a.log.Error("Application", "RunManager", fmt.Sprintf("Failed to start run manager: %v", err))
+		return exitFailed
+	}
+
+	// Send finished series back through the pipeline when new episodes air
+	if indexer, ok := tmdbIndexer.(*indexers.TMDBIndexer); ok {
+		episodes := manager.New(a.cfg, a.db, indexer, nil)
+		if err := episodes.Start(); err != nil {
+			a.log.Error("Application", "Manager", fmt.Sprintf("Failed to start episode checks: %v", err))
+		} else {
+			defer episodes.Stop()
+		}
+	}
+
+	if apiServer != nil {
+		if err := apiServer.Start(); err != nil {
+			a.log.Error("Application", "API", fmt.Sprintf("Failed to start API: %v", err))
+			return exitSetup
+		}
+	}
+
+	// Wait for interrupt signal
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	a.log.Info("Application", "Signal", "Waiting for interrupt signal...")
+	<-sigChan
+
+	// Graceful shutdown
+	a.log.Info("Application", "Shutdown", "Shutting down gracefully...")
+	cancel() // Cancel the context to stop all goroutines
+	if apiServer != nil {
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancelShutdown()
+		if err := apiServer.Shutdown(shutdownCtx); err != nil {
+			a.log.Error("Application", "API", fmt.Sprintf("Failed to stop API: %v", err))
+		}
+	}
+	runManager.Stop()
+	return exitOK
+}
+
+// stageEnabled reports whether serve should register a stage
+func (a *app) stageEnabled(stage pipeline.Stage) bool {
+	switch stage {
+	case pipeline.StageIndexer:
+		return a.cfg.TMDB.Enabled && a.cfg.Programs.TMDBIndexer.Active
+	case pipeline.StageScraper:
+		return a.cfg.Scraping.Scrapers["torrentio"].Enabled
+	default:
+		program, _ := a.cfg.Programs.Program(string(stage))
+		return program.Active
+	}
+}
+
+// fetch runs the content fetchers once
+func fetch(args []string) int {
+	fs, opts := newFlagSet("fetch", false)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	a, err := bootstrap(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitSetup
+	}
+	defer a.Close()
+
+	contentFetcher, err := getcontent.New(a.cfg, a.db)
+	if err != nil {
+		a.log.Error("main", "fetch", fmt.Sprintf("Failed to create content fetcher: %v", err))
+		return exitSetup
+	}
+
+	a.log.Info("main", "fetch", "Checking for new content")
+	if err := contentFetcher.Fetch(); err != nil {
+		a.log.Error("main", "fetch", fmt.Sprintf("Error fetching content: %v", err))
+		return exitFailed
+	}
+
+	a.log.Info("main", "fetch", "Content fetch completed successfully")
+	return exitOK
+}
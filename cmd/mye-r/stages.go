@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"mye-r/internal"
+	"mye-r/internal/config"
+	"mye-r/internal/database"
+	"mye-r/internal/downloader"
+	"mye-r/internal/indexers"
+	"mye-r/internal/librarymatcher"
+	"mye-r/internal/metrics"
+	"mye-r/internal/pipeline"
+	"mye-r/internal/scraper"
+	"mye-r/internal/symlinker"
+)
+
+// stageProcess is a pipeline stage that the RunManager can both start and
+// hand single items to
+type stageProcess interface {
+	internal.Process
+	internal.ItemProcessor
+}
+
+// newStage creates the processor of a pipeline stage
+func (a *app) newStage(stage pipeline.Stage) (stageProcess, error) {
+	switch stage {
+	case pipeline.StageIndexer:
+		return indexers.NewTMDBIndexer(a.cfg, a.db, a.log), nil
+	case pipeline.StageLibraryMatcher:
+		return librarymatcher.New(a.cfg, a.db), nil
+	case pipeline.StageScraper:
+		return scraper.NewScraperManager(a.cfg, a.db), nil
+	case pipeline.StageDownloader:
+		return downloader.NewRealDebridDownloader(a.cfg, a.db), nil
+	case pipeline.StageSymlinker:
+		return symlinker.New(a.cfg, a.db), nil
+	default:
+		return nil, fmt.Errorf("unknown pipeline stage: %s", stage)
+	}
+}
+
+// runStage runs one stage on the selected items and exits with exitFailed if
+// any of them failed. Every item's outcome is recorded in process_runs.
+func runStage(stage pipeline.Stage, name string, args []string) int {
+	fs, opts := newFlagSet(name, true)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if err := opts.validateSelection(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fs.Usage()
+		return exitUsage
+	}
+
+	a, err := bootstrap(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitSetup
+	}
+	defer a.Close()
+
+	if opts.metricsFile != "" {
+		defer func() {
+			if err := metrics.Default.WriteFile(opts.metricsFile); err != nil {
+				a.log.Error("main", name, err.Error())
+			}
+		}()
+	}
+
+	if opts.dryRun {
+		if err := a.startDryRun(); err != nil {
+			a.log.Error("main", name, err.Error())
+			return exitSetup
+		}
+	}
+
+	processor, err := a.newStage(stage)
+	if err != nil {
+		a.log.Error("main", name, err.Error())
+		return exitSetup
+	}
+
+	items, err := a.selectItems(opts, stage)
+	if err != nil {
+		a.log.Error("main", name, err.Error())
+		return exitFailed
+	}
+	if len(items) == 0 {
+		a.log.Info("main", name, "No items to process")
+		return exitOK
+	}
+
+	// serve starts the run itself and records the exit code once we are done
+	var run *internal.RunRecorder
+	if opts.runID > 0 {
+		run = internal.ResumeRun(a.db, opts.runID)
+	} else {
+		itemIDs := make([]int, len(items))
+		for i, item := range items {
+			itemIDs[i] = item.ID
+		}
+		run = internal.StartRun(a.db, string(stage), config.RunModeExec, itemIDs)
+	}
+
+	code := a.processItems(processor, stage, name, items, run)
+	if opts.runID == 0 {
+		status := internal.RunStatusSucceeded
+		var runErr error
+		if code != exitOK {
+			status = internal.RunStatusFailed
+			runErr = fmt.Errorf("%d of %d items failed", run.Failed(), len(items))
+		}
+		run.Finish(status, &code, runErr, "")
+	}
+	return code
+}
+
+// processItems runs the stage on every item and records each outcome
+func (a *app) processItems(processor stageProcess, stage pipeline.Stage, name string, items []*database.WatchlistItem, run *internal.RunRecorder) int {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	a.log.Info("main", name, fmt.Sprintf("Processing %d items", len(items)))
+	for _, item := range items {
+		if ctx.Err() != nil {
+			a.log.Warning("main", name, "Interrupted, stopping")
+			return exitFailed
+		}
+
+		a.log.Info("main", name, fmt.Sprintf("Processing item %d: %s", item.ID, item.Title))
+		start := time.Now()
+		err := processor.ProcessItem(ctx, item)
+		run.Record(internal.ItemResult{
+			Stage:    string(stage),
+			ItemID:   item.ID,
+			Title:    item.Title,
+			Err:      err,
+			Duration: time.Since(start),
+		})
+
+		switch internal.Outcome(err) {
+		case internal.OutcomeSuccess:
+			a.log.Info("main", name, fmt.Sprintf("Successfully processed item %d", item.ID))
+		case internal.OutcomeSkipped:
+			// Another worker got to the item first, or it moved on already
+			a.log.Warning("main", name, fmt.Sprintf("Skipped item %d: %v", item.ID, err))
+		default:
+			a.log.Error("main", name, fmt.Sprintf("Error processing item %d: %v", item.ID, err))
+		}
+	}
+
+	if failed := run.Failed(); failed > 0 {
+		a.log.Error("main", name, fmt.Sprintf("%d of %d items failed", failed, len(items)))
+		return exitFailed
+	}
+	return exitOK
+}
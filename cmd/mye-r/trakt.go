@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"mye-r/internal/getcontent"
+)
+
+// trakt logs mye-r in to Trakt for the trakt fetcher, or out again
+func trakt(args []string) int {
+	if len(args) == 0 || (args[0] != "login" && args[0] != "logout") {
+		fmt.Fprintf(os.Stderr, `Usage: mye-r trakt <login|logout> [flags]
+
+  login   log in with a code entered on trakt.tv; the token is kept in the database
+  logout  revoke the token and forget it
+`)
+		return exitUsage
+	}
+
+	fs, opts := newFlagSet("trakt "+args[0], false)
+	if code, ok := parseFlags(fs, args[1:]); !ok {
+		return code
+	}
+
+	a, err := bootstrap(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitSetup
+	}
+	defer a.Close()
+
+	client, err := getcontent.NewTraktClient(a.cfg, a.db)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitSetup
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if args[0] == "logout" {
+		if err := client.Logout(ctx); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailed
+		}
+		fmt.Println("Logged out of Trakt")
+		return exitOK
+	}
+
+	code, err := client.RequestDeviceCode(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	fmt.Printf("Open %s and enter the code %s\n", code.VerificationURL, code.UserCode)
+	fmt.Printf("Waiting up to %d minutes...\n", code.ExpiresIn/60)
+
+	if err := client.WaitForDeviceToken(ctx, code); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	fmt.Println("Logged in to Trakt")
+	return exitOK
+}
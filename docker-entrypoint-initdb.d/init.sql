@@ -36,6 +36,9 @@ CREATE TABLE IF NOT EXISTS public.watchlistitem
     retry_count integer DEFAULT 0,
     show_status character varying(255) COLLATE pg_catalog."default",
     current_step character varying(50) COLLATE pg_catalog."default" DEFAULT 'indexing_pending',
+    requested_by character varying(100) COLLATE pg_catalog."default",
+    forced_library character varying(100) COLLATE pg_catalog."default",
+    quality_profile character varying(100) COLLATE pg_catalog."default",
     CONSTRAINT watchlistitem_pkey PRIMARY KEY (id)
 )
 TABLESPACE pg_default;
@@ -46,6 +49,24 @@ ALTER TABLE IF EXISTS public.watchlistitem
 COMMENT ON COLUMN public.watchlistitem.status
     IS 'Overall status of the watchlist item';
 
+-- Databases created before manual requests
+ALTER TABLE IF EXISTS public.watchlistitem
+    ADD COLUMN IF NOT EXISTS requested_by character varying(100) COLLATE pg_catalog."default";
+
+COMMENT ON COLUMN public.watchlistitem.requested_by
+    IS 'Who asked for a manually requested item';
+
+-- Databases created before feeds were routed to libraries and profiles
+ALTER TABLE IF EXISTS public.watchlistitem
+    ADD COLUMN IF NOT EXISTS forced_library character varying(100) COLLATE pg_catalog."default",
+    ADD COLUMN IF NOT EXISTS quality_profile character varying(100) COLLATE pg_catalog."default";
+
+COMMENT ON COLUMN public.watchlistitem.forced_library
+    IS 'Custom library the item goes to whatever the filters say, set by the first feed that routed it';
+
+COMMENT ON COLUMN public.watchlistitem.quality_profile
+    IS 'Scraping profile the item is scored with, set by the first feed that routed it';
+
 -- Table: public.seasons
 CREATE TABLE IF NOT EXISTS public.seasons
 (
@@ -108,6 +129,7 @@ CREATE TABLE IF NOT EXISTS public.scrape_results
     status_results text COLLATE pg_catalog."default",
     created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP,
     updated_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP,
+    pinned boolean NOT NULL DEFAULT false,
     CONSTRAINT scrape_results_pkey PRIMARY KEY (id),
     CONSTRAINT fk_watchlist_item FOREIGN KEY (watchlist_item_id)
         REFERENCES public.watchlistitem (id) MATCH SIMPLE
@@ -123,6 +145,15 @@ TABLESPACE pg_default;
 ALTER TABLE IF EXISTS public.scrape_results
     OWNER to postgres;
 
+-- Databases created before hash pinning
+ALTER TABLE IF EXISTS public.scrape_results
+    ADD COLUMN IF NOT EXISTS pinned boolean NOT NULL DEFAULT false;
+
+-- At most one pinned release per item
+CREATE UNIQUE INDEX IF NOT EXISTS idx_scrape_results_pinned
+    ON public.scrape_results (watchlist_item_id)
+    WHERE pinned;
+
 -- Create indexes
 CREATE INDEX IF NOT EXISTS idx_scrape_results_status
     ON public.scrape_results USING btree
@@ -144,3 +175,270 @@ CREATE INDEX IF NOT EXISTS idx_watchlistitem_current_step
     ON public.watchlistitem USING btree
     (current_step COLLATE pg_catalog."default" ASC NULLS LAST)
     TABLESPACE pg_default;
+
+-- Table: public.item_transitions
+-- Audit trail of every pipeline state change made through pipeline.Transition
+CREATE TABLE IF NOT EXISTS public.item_transitions
+(
+    id serial NOT NULL,
+    watchlist_item_id integer NOT NULL,
+    from_state character varying(50) COLLATE pg_catalog."default" NOT NULL,
+    to_state character varying(50) COLLATE pg_catalog."default" NOT NULL,
+    reason text COLLATE pg_catalog."default",
+    created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT item_transitions_pkey PRIMARY KEY (id),
+    CONSTRAINT fk_item_transitions_watchlist_item FOREIGN KEY (watchlist_item_id)
+        REFERENCES public.watchlistitem (id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE
+)
+TABLESPACE pg_default;
+
+ALTER TABLE IF EXISTS public.item_transitions
+    OWNER to postgres;
+
+CREATE INDEX IF NOT EXISTS idx_item_transitions_watchlist_item_id
+    ON public.item_transitions USING btree
+    (watchlist_item_id ASC NULLS LAST, created_at ASC NULLS LAST)
+    TABLESPACE pg_default;
+
+-- Table: public.job_leases
+-- A worker leases an item for one stage and renews the lease while it works.
+-- Rows whose expires_at has passed belong to crashed workers and can be claimed again.
+CREATE TABLE IF NOT EXISTS public.job_leases
+(
+    watchlist_item_id integer NOT NULL,
+    stage character varying(50) COLLATE pg_catalog."default" NOT NULL,
+    worker_id character varying(255) COLLATE pg_catalog."default" NOT NULL,
+    leased_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    heartbeat_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    expires_at timestamp with time zone NOT NULL,
+    CONSTRAINT job_leases_pkey PRIMARY KEY (watchlist_item_id, stage),
+    CONSTRAINT fk_job_leases_watchlist_item FOREIGN KEY (watchlist_item_id)
+        REFERENCES public.watchlistitem (id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE
+)
+TABLESPACE pg_default;
+
+ALTER TABLE IF EXISTS public.job_leases
+    OWNER to postgres;
+
+CREATE INDEX IF NOT EXISTS idx_job_leases_expires_at
+    ON public.job_leases USING btree
+    (stage COLLATE pg_catalog."default" ASC NULLS LAST, expires_at ASC NULLS LAST)
+    TABLESPACE pg_default;
+
+-- Table: public.item_retries
+-- Failed attempts of an item per stage. Queues skip an item until next_attempt_at has passed.
+CREATE TABLE IF NOT EXISTS public.item_retries
+(
+    watchlist_item_id integer NOT NULL,
+    stage character varying(50) COLLATE pg_catalog."default" NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    last_error text COLLATE pg_catalog."default",
+    last_attempt_at timestamp with time zone,
+    next_attempt_at timestamp with time zone,
+    updated_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    CONSTRAINT item_retries_pkey PRIMARY KEY (watchlist_item_id, stage),
+    CONSTRAINT fk_item_retries_watchlist_item FOREIGN KEY (watchlist_item_id)
+        REFERENCES public.watchlistitem (id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE
+)
+TABLESPACE pg_default;
+
+ALTER TABLE IF EXISTS public.item_retries
+    OWNER to postgres;
+
+CREATE INDEX IF NOT EXISTS idx_item_retries_next_attempt_at
+    ON public.item_retries USING btree
+    (stage COLLATE pg_catalog."default" ASC NULLS LAST, next_attempt_at ASC NULLS LAST)
+    TABLESPACE pg_default;
+
+-- Function: public.notify_pending_step
+-- Wakes the stage that owns a pending step as soon as an item lands in it.
+-- The channel is mye_r_<current_step>, e.g. mye_r_scrape_pending, and the payload is the item id.
+CREATE OR REPLACE FUNCTION public.notify_pending_step()
+    RETURNS trigger
+    LANGUAGE plpgsql
+AS $$
+BEGIN
+    IF NEW.current_step LIKE '%\_pending' AND
+       (TG_OP = 'INSERT' OR OLD.current_step IS DISTINCT FROM NEW.current_step) THEN
+        PERFORM pg_notify('mye_r_' || NEW.current_step, NEW.id::text);
+    END IF;
+    RETURN NEW;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS watchlistitem_notify_pending_step ON public.watchlistitem;
+
+CREATE TRIGGER watchlistitem_notify_pending_step
+    AFTER INSERT OR UPDATE OF current_step
+    ON public.watchlistitem
+    FOR EACH ROW
+    EXECUTE FUNCTION public.notify_pending_step();
+
+-- Table: public.process_runs
+-- Every stage invocation, whether a mye-r subcommand or an in-process batch.
+CREATE TABLE IF NOT EXISTS public.process_runs
+(
+    id serial NOT NULL,
+    stage character varying(50) COLLATE pg_catalog."default" NOT NULL,
+    mode character varying(20) COLLATE pg_catalog."default" NOT NULL,
+    item_ids integer[] NOT NULL DEFAULT '{}',
+    status character varying(20) COLLATE pg_catalog."default" NOT NULL,
+    exit_code integer,
+    error text COLLATE pg_catalog."default",
+    output text COLLATE pg_catalog."default",
+    started_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    finished_at timestamp with time zone,
+    CONSTRAINT process_runs_pkey PRIMARY KEY (id)
+)
+TABLESPACE pg_default;
+
+ALTER TABLE IF EXISTS public.process_runs
+    OWNER to postgres;
+
+CREATE INDEX IF NOT EXISTS idx_process_runs_stage_started_at
+    ON public.process_runs USING btree
+    (stage COLLATE pg_catalog."default" ASC NULLS LAST, started_at DESC NULLS LAST)
+    TABLESPACE pg_default;
+
+-- Table: public.process_run_items
+-- Outcome of each item of a run: success, skipped or failed.
+CREATE TABLE IF NOT EXISTS public.process_run_items
+(
+    id serial NOT NULL,
+    process_run_id integer NOT NULL,
+    watchlist_item_id integer NOT NULL,
+    outcome character varying(20) COLLATE pg_catalog."default" NOT NULL,
+    error text COLLATE pg_catalog."default",
+    duration_ms bigint NOT NULL DEFAULT 0,
+    finished_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    CONSTRAINT process_run_items_pkey PRIMARY KEY (id),
+    CONSTRAINT fk_process_run_items_run FOREIGN KEY (process_run_id)
+        REFERENCES public.process_runs (id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE,
+    CONSTRAINT fk_process_run_items_watchlist_item FOREIGN KEY (watchlist_item_id)
+        REFERENCES public.watchlistitem (id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE
+)
+TABLESPACE pg_default;
+
+ALTER TABLE IF EXISTS public.process_run_items
+    OWNER to postgres;
+
+CREATE INDEX IF NOT EXISTS idx_process_run_items_item
+    ON public.process_run_items USING btree
+    (watchlist_item_id ASC NULLS LAST, finished_at DESC NULLS LAST)
+    TABLESPACE pg_default;
+
+-- Table: public.api_keys
+-- Keys of the HTTP API. Only the SHA-256 of a key is stored; prefix is its
+-- first characters, to tell keys apart when listing them.
+CREATE TABLE IF NOT EXISTS public.api_keys
+(
+    id serial NOT NULL,
+    name character varying(100) COLLATE pg_catalog."default" NOT NULL,
+    prefix character varying(20) COLLATE pg_catalog."default" NOT NULL,
+    key_hash character(64) COLLATE pg_catalog."default" NOT NULL,
+    scopes text[] NOT NULL DEFAULT '{}',
+    created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    last_used_at timestamp with time zone,
+    revoked_at timestamp with time zone,
+    CONSTRAINT api_keys_pkey PRIMARY KEY (id),
+    CONSTRAINT api_keys_key_hash_key UNIQUE (key_hash)
+)
+TABLESPACE pg_default;
+
+ALTER TABLE IF EXISTS public.api_keys
+    OWNER to postgres;
+
+-- Names only have to be unique among keys that still work
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_active_name
+    ON public.api_keys USING btree
+    (name COLLATE pg_catalog."default" ASC NULLS LAST)
+    TABLESPACE pg_default
+    WHERE revoked_at IS NULL;
+
+-- Table: public.oauth_tokens
+-- Tokens mye-r got by logging in to other services, one row per provider.
+CREATE TABLE IF NOT EXISTS public.oauth_tokens
+(
+    provider character varying(50) COLLATE pg_catalog."default" NOT NULL,
+    access_token text COLLATE pg_catalog."default" NOT NULL,
+    refresh_token text COLLATE pg_catalog."default" NOT NULL DEFAULT '',
+    expires_at timestamp with time zone NOT NULL,
+    updated_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    CONSTRAINT oauth_tokens_pkey PRIMARY KEY (provider)
+)
+TABLESPACE pg_default;
+
+ALTER TABLE IF EXISTS public.oauth_tokens
+    OWNER to postgres;
+
+-- Table: public.feed_items
+-- The GUIDs each watchlist feed contained when it was last fetched, with the
+-- item they were added as. missing_since is set once a feed drops a GUID.
+-- owns_item is set when the feed stamped its requester on the item.
+CREATE TABLE IF NOT EXISTS public.feed_items
+(
+    feed_url text COLLATE pg_catalog."default" NOT NULL,
+    guid text COLLATE pg_catalog."default" NOT NULL,
+    watchlist_item_id integer,
+    first_seen timestamp with time zone NOT NULL DEFAULT NOW(),
+    last_seen timestamp with time zone NOT NULL DEFAULT NOW(),
+    missing_since timestamp with time zone,
+    owns_item boolean NOT NULL DEFAULT false,
+    CONSTRAINT feed_items_pkey PRIMARY KEY (feed_url, guid),
+    CONSTRAINT fk_feed_items_watchlist_item FOREIGN KEY (watchlist_item_id)
+        REFERENCES public.watchlistitem (id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE
+)
+TABLESPACE pg_default;
+
+ALTER TABLE IF EXISTS public.feed_items
+    OWNER to postgres;
+
+CREATE INDEX IF NOT EXISTS idx_feed_items_watchlist_item_id
+    ON public.feed_items USING btree
+    (watchlist_item_id ASC NULLS LAST)
+    TABLESPACE pg_default;
+
+-- Databases created before feeds owned the items they stamped a requester on
+ALTER TABLE IF EXISTS public.feed_items
+    ADD COLUMN IF NOT EXISTS owns_item boolean NOT NULL DEFAULT false;
+
+COMMENT ON COLUMN public.feed_items.owns_item
+    IS 'Whether the requested_by of the item is the requester of this feed rather than someone who asked for it by hand';
+
+-- Table: public.feed_state
+-- How each watchlist feed answered last: the validators sent back on the
+-- next conditional GET and its failures, which push next_attempt_at back.
+-- The GUIDs a feed contains are kept in feed_items.
+CREATE TABLE IF NOT EXISTS public.feed_state
+(
+    url text COLLATE pg_catalog."default" NOT NULL,
+    etag text COLLATE pg_catalog."default" NOT NULL DEFAULT '',
+    last_modified text COLLATE pg_catalog."default" NOT NULL DEFAULT '',
+    last_success_at timestamp with time zone,
+    last_error text COLLATE pg_catalog."default",
+    last_error_at timestamp with time zone,
+    failures integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp with time zone,
+    updated_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    CONSTRAINT feed_state_pkey PRIMARY KEY (url)
+)
+TABLESPACE pg_default;
+
+ALTER TABLE IF EXISTS public.feed_state
+    OWNER to postgres;
+
+-- Databases created while feed_state kept its own copy of the GUIDs
+ALTER TABLE IF EXISTS public.feed_state
+    DROP COLUMN IF EXISTS guids;
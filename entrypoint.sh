@@ -13,10 +13,11 @@ done
 if ! psql -h "$POSTGRES_HOST" -p "$POSTGRES_PORT" -U "$POSTGRES_USER" -lqt | cut -d \| -f 1 | grep -qw "$POSTGRES_DB"; then
     echo "Database does not exist. Creating..."
     createdb -h "$POSTGRES_HOST" -p "$POSTGRES_PORT" -U "$POSTGRES_USER" "$POSTGRES_DB"
-    
-    # Run the initialization script
-    psql -h "$POSTGRES_HOST" -p "$POSTGRES_PORT" -U "$POSTGRES_USER" -d "$POSTGRES_DB" -f /app/init.sql
 fi
 
+# Run the initialization script on every start. It only creates what is
+# missing, so existing databases pick up new tables and columns.
+psql -h "$POSTGRES_HOST" -p "$POSTGRES_PORT" -U "$POSTGRES_USER" -d "$POSTGRES_DB" -f /app/init.sql
+
 # Execute the main application
 exec "$@"
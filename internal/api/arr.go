@@ -0,0 +1,434 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"mye-r/internal/database"
+	"mye-r/internal/getcontent"
+	"mye-r/internal/pipeline"
+)
+
+// The Radarr and Sonarr facades let request tools like Overseerr and
+// Jellyseerr use mye-r as if it were Radarr (under /radarr) and Sonarr (under
+// /sonarr). Only the v3 endpoints those tools call are implemented. Movie and
+// series IDs are watchlist item IDs; adds put items on the watchlist and what
+// the tools read back is derived from the pipeline steps.
+
+// arrVersion is reported by system/status. Request tools pick the API they
+// talk by the major version.
+const arrVersion = "3.0.0.0"
+
+// The facades have a single quality profile, root folder and language
+// profile: mye-r decides on releases and libraries itself
+const (
+	arrProfileID    = 1
+	arrRootFolderID = 1
+	arrProfileName  = "mye-r"
+)
+
+func (s *Server) arrRoutes() {
+	radarr := http.NewServeMux()
+	s.commonArrRoutes(radarr, "Radarr", "/radarr")
+	radarr.Handle("GET /api/v3/movie", s.authorize(ScopeRead, s.listMovies))
+	radarr.Handle("GET /api/v3/movie/lookup", s.authorize(ScopeRead, s.lookupMovie))
+	radarr.Handle("GET /api/v3/movie/{id}", s.authorize(ScopeRead, s.getMovie))
+	radarr.Handle("POST /api/v3/movie", s.authorize(ScopeRequest, s.addMovie))
+	radarr.Handle("PUT /api/v3/movie", s.authorize(ScopeRequest, s.updateMovie))
+	radarr.Handle("PUT /api/v3/movie/{id}", s.authorize(ScopeRequest, s.updateMovie))
+	radarr.Handle("GET /api/v3/queue", s.authorize(ScopeRead, s.movieQueue))
+	s.mountArr("/radarr", radarr)
+
+	sonarr := http.NewServeMux()
+	s.commonArrRoutes(sonarr, "Sonarr", "/sonarr")
+	sonarr.Handle("GET /api/v3/languageprofile", s.authorize(ScopeRead, s.listArrProfiles))
+	sonarr.Handle("GET /api/v3/series", s.authorize(ScopeRead, s.listSeries))
+	sonarr.Handle("GET /api/v3/series/lookup", s.authorize(ScopeRead, s.lookupSeries))
+	sonarr.Handle("GET /api/v3/series/{id}", s.authorize(ScopeRead, s.getSeries))
+	sonarr.Handle("POST /api/v3/series", s.authorize(ScopeRequest, s.addSeries))
+	sonarr.Handle("PUT /api/v3/series", s.authorize(ScopeRequest, s.updateSeries))
+	sonarr.Handle("PUT /api/v3/series/{id}", s.authorize(ScopeRequest, s.updateSeries))
+	sonarr.Handle("GET /api/v3/queue", s.authorize(ScopeRead, s.seriesQueue))
+	s.mountArr("/sonarr", sonarr)
+}
+
+// mountArr serves a facade under prefix. It is mounted per method so that it
+// does not clash with the dashboard's GET /.
+func (s *Server) mountArr(prefix string, mux *http.ServeMux) {
+	handler := arrPaths(prefix, mux)
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut} {
+		s.mux.Handle(method+" "+prefix+"/", handler)
+	}
+}
+
+// commonArrRoutes adds the endpoints Radarr and Sonarr share
+func (s *Server) commonArrRoutes(mux *http.ServeMux, appName, urlBase string) {
+	mux.Handle("GET /api/v3/system/status", s.authorize(ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"appName":      appName,
+			"instanceName": "mye-r",
+			"version":      arrVersion,
+			"urlBase":      urlBase,
+			"isProduction": true,
+		})
+	}))
+	mux.Handle("GET /api/v3/rootfolder", s.authorize(ScopeRead, s.listRootFolders))
+	mux.Handle("GET /api/v3/qualityprofile", s.authorize(ScopeRead, s.listArrProfiles))
+	mux.Handle("GET /api/v3/tag", s.authorize(ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		s.writeJSON(w, http.StatusOK, []interface{}{})
+	}))
+	mux.Handle("POST /api/v3/command", s.authorize(ScopeRequest, s.runArrCommand))
+}
+
+// arrPaths strips the facade's prefix and lower-cases the path, because
+// Radarr and Sonarr route case-insensitively and clients rely on it
+// (qualityProfile, rootFolder)
+func arrPaths(prefix string, next http.Handler) http.Handler {
+	return http.StripPrefix(prefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := *r.URL
+		u.Path, u.RawPath = strings.ToLower(u.Path), ""
+		r2 := r.Clone(r.Context())
+		r2.URL = &u
+		next.ServeHTTP(w, r2)
+	}))
+}
+
+func (s *Server) listRootFolders(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, []map[string]interface{}{{
+		"id":              arrRootFolderID,
+		"path":            s.cfg.General.LibraryPath,
+		"accessible":      true,
+		"unmappedFolders": []interface{}{},
+	}})
+}
+
+func (s *Server) listArrProfiles(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, []map[string]interface{}{{
+		"id":   arrProfileID,
+		"name": arrProfileName,
+	}})
+}
+
+// runArrCommand accepts the search commands request tools send after an add.
+// mye-r scrapes new items on its own, so there is nothing to run.
+func (s *Server) runArrCommand(w http.ResponseWriter, r *http.Request) {
+	var command struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&command); err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid body: %v", err)
+		return
+	}
+	now := time.Now()
+	s.writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":      1,
+		"name":    command.Name,
+		"status":  "completed",
+		"queued":  now,
+		"started": now,
+		"ended":   now,
+	})
+}
+
+// arrImage is an entry of the images of a movie or series
+type arrImage struct {
+	CoverType string `json:"coverType"`
+	URL       string `json:"url"`
+	RemoteURL string `json:"remoteUrl"`
+}
+
+func arrImages(item *database.WatchlistItem) []arrImage {
+	if !item.ThumbnailURL.Valid || item.ThumbnailURL.String == "" {
+		return []arrImage{}
+	}
+	return []arrImage{{CoverType: "poster", URL: item.ThumbnailURL.String, RemoteURL: item.ThumbnailURL.String}}
+}
+
+// arrFolder is the folder Radarr or Sonarr would give an item
+func arrFolder(item *database.WatchlistItem) string {
+	if item.ItemYear.Valid {
+		return fmt.Sprintf("%s (%d)", item.Title, item.ItemYear.Int64)
+	}
+	return item.Title
+}
+
+// arrSlug is the titleSlug of an item, which clients use in links
+func arrSlug(item *database.WatchlistItem) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(arrFolder(item)) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			slug.WriteRune(r)
+			dash = false
+		case !dash && slug.Len() > 0:
+			slug.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(slug.String(), "-")
+}
+
+func (s *Server) arrPath(item *database.WatchlistItem) string {
+	if item.MainLibraryPath.Valid && item.MainLibraryPath.String != "" {
+		return item.MainLibraryPath.String
+	}
+	return filepath.Join(s.cfg.General.LibraryPath, arrFolder(item))
+}
+
+// atoi parses the numeric IDs stored as text, 0 when there is none
+func atoi(v string) int {
+	n, _ := strconv.Atoi(v)
+	return n
+}
+
+// arrLookup resolves the term of a lookup (tmdb:603, tvdb:81189, an IMDb ID or
+// a title) on TMDB. An item already on the watchlist is returned instead of
+// the TMDB result, so clients see its ID. It writes the response when the
+// lookup failed; a term without a match gives an empty list.
+func (s *Server) arrLookup(w http.ResponseWriter, r *http.Request, mediaType string) (*database.WatchlistItem, bool) {
+	term := strings.TrimSpace(r.URL.Query().Get("term"))
+	if term == "" {
+		s.writeError(w, http.StatusBadRequest, "term is required")
+		return nil, false
+	}
+
+	req := getcontent.Request{MediaType: mediaType}
+	if _, _, err := getcontent.ParseID(term); err == nil {
+		req.ID = term
+	} else {
+		req.Query = term
+	}
+	if err := req.Validate(); err != nil {
+		s.writeError(w, http.StatusBadRequest, "%v", err)
+		return nil, false
+	}
+
+	item, err := s.requests.Resolve(req)
+	if errors.Is(err, getcontent.ErrNoMatch) {
+		s.writeJSON(w, http.StatusOK, []interface{}{})
+		return nil, false
+	}
+	if err != nil {
+		s.log.Error("API", "arrLookup", err.Error())
+		s.writeError(w, http.StatusBadGateway, "%v", err)
+		return nil, false
+	}
+
+	existing, err := s.requests.Existing(item)
+	if err != nil {
+		s.log.Error("API", "arrLookup", err.Error())
+		s.writeError(w, http.StatusInternalServerError, "failed to look up %q", term)
+		return nil, false
+	}
+	if existing != nil && existing.MediaType.String == mediaType {
+		return existing, true
+	}
+	return item, true
+}
+
+// arrAdd puts the item with a TMDB or TVDB ID on the watchlist and writes the
+// error response if that fails. Adding an item that is already there is not
+// an error: clients only check for the ID of the answer.
+func (s *Server) arrAdd(w http.ResponseWriter, r *http.Request, id, mediaType string) (*database.WatchlistItem, bool) {
+	req := getcontent.Request{ID: id, MediaType: mediaType, RequestedBy: requestKey(r).Name}
+	item, _, err := s.requests.Add(req)
+	if errors.Is(err, getcontent.ErrNoMatch) {
+		s.writeError(w, http.StatusNotFound, "%v", err)
+		return nil, false
+	}
+	if err != nil {
+		s.log.Error("API", "arrAdd", err.Error())
+		s.writeError(w, http.StatusBadGateway, "%v", err)
+		return nil, false
+	}
+	return item, true
+}
+
+// arrItems lists the watchlist items of a media type
+func (s *Server) arrItems(w http.ResponseWriter, mediaType string) ([]*database.WatchlistItem, bool) {
+	items, err := s.db.ListWatchlistItems(database.ItemFilter{MediaType: mediaType})
+	if err != nil {
+		s.log.Error("API", "arrItems", err.Error())
+		s.writeError(w, http.StatusInternalServerError, "failed to list items")
+		return nil, false
+	}
+	return items, true
+}
+
+// arrItem loads the item of a request's {id} if it has the media type
+func (s *Server) arrItem(w http.ResponseWriter, r *http.Request, mediaType string) (*database.WatchlistItem, bool) {
+	item, ok := s.getItemOrError(w, r)
+	if !ok {
+		return nil, false
+	}
+	if item.MediaType.String != mediaType {
+		s.writeError(w, http.StatusNotFound, "item %d is not a %s", item.ID, mediaType)
+		return nil, false
+	}
+	return item, true
+}
+
+// arrQueueState is how Radarr and Sonarr would describe a download in a step
+type arrQueueState struct {
+	status         string
+	trackedStatus  string
+	trackedState   string
+	downloaded     bool
+	statusMessages bool // the last transition explains what went wrong
+}
+
+// arrQueueStates are the steps that show up in the queue: from the scraper
+// handing an item to the downloader until the symlinker is done with it
+var arrQueueStates = []struct {
+	step  pipeline.State
+	state arrQueueState
+}{
+	{pipeline.StateDownloadPending, arrQueueState{status: "queued", trackedStatus: "ok", trackedState: "downloading"}},
+	{pipeline.StateDownloading, arrQueueState{status: "downloading", trackedStatus: "ok", trackedState: "downloading"}},
+	{pipeline.StateDownloadFailed, arrQueueState{status: "failed", trackedStatus: "error", trackedState: "failed", statusMessages: true}},
+	{pipeline.StateSymlinkPending, arrQueueState{status: "completed", trackedStatus: "ok", trackedState: "importPending", downloaded: true}},
+	{pipeline.StateSymlinking, arrQueueState{status: "completed", trackedStatus: "ok", trackedState: "importing", downloaded: true}},
+	{pipeline.StateSymlinkFailed, arrQueueState{status: "completed", trackedStatus: "warning", trackedState: "importPending", downloaded: true, statusMessages: true}},
+}
+
+// arrQueueRecord is a record of GET /api/v3/queue. movieId or seriesId is
+// set by the facade serving it.
+type arrQueueRecord struct {
+	ID                    int                `json:"id"`
+	MovieID               int                `json:"movieId,omitempty"`
+	SeriesID              int                `json:"seriesId,omitempty"`
+	Title                 string             `json:"title"`
+	Size                  int64              `json:"size"`
+	Sizeleft              int64              `json:"sizeleft"`
+	Status                string             `json:"status"`
+	TrackedDownloadStatus string             `json:"trackedDownloadStatus"`
+	TrackedDownloadState  string             `json:"trackedDownloadState"`
+	StatusMessages        []arrStatusMessage `json:"statusMessages"`
+	DownloadID            string             `json:"downloadId,omitempty"`
+	Protocol              string             `json:"protocol"`
+	DownloadClient        string             `json:"downloadClient"`
+}
+
+type arrStatusMessage struct {
+	Title    string   `json:"title"`
+	Messages []string `json:"messages"`
+}
+
+// arrQueue builds the queue records of a media type
+func (s *Server) arrQueue(mediaType string) ([]arrQueueRecord, error) {
+	records := []arrQueueRecord{}
+	for _, queued := range arrQueueStates {
+		items, err := s.db.ListWatchlistItems(database.ItemFilter{Step: string(queued.step), MediaType: mediaType})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			record, err := s.arrQueueRecord(item, queued.state)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func (s *Server) arrQueueRecord(item *database.WatchlistItem, state arrQueueState) (arrQueueRecord, error) {
+	record := arrQueueRecord{
+		ID:                    item.ID,
+		Title:                 item.Title,
+		Status:                state.status,
+		TrackedDownloadStatus: state.trackedStatus,
+		TrackedDownloadState:  state.trackedState,
+		StatusMessages:        []arrStatusMessage{},
+		Protocol:              "torrent",
+		DownloadClient:        "Real-Debrid",
+	}
+
+	// The first scrape result is the release the downloader takes
+	results, err := s.db.GetScrapeResultsForItem(item.ID)
+	if err != nil {
+		return record, err
+	}
+	if len(results) > 0 {
+		release := results[0]
+		if release.ScrapedFilename.Valid {
+			record.Title = release.ScrapedFilename.String
+		}
+		record.DownloadID = strings.ToUpper(release.InfoHash.String)
+		record.Size = parseSize(release.ScrapedFileSize.String)
+	}
+	if !state.downloaded {
+		record.Sizeleft = record.Size
+	}
+
+	if state.statusMessages {
+		transitions, err := s.db.GetItemTransitions(item.ID)
+		if err != nil {
+			return record, err
+		}
+		if n := len(transitions); n > 0 && transitions[n-1].Reason != "" {
+			record.StatusMessages = []arrStatusMessage{{Title: item.Title, Messages: []string{transitions[n-1].Reason}}}
+		}
+	}
+	return record, nil
+}
+
+// maxArrPageSize caps the queue pages; clients asking for more get this many
+const maxArrPageSize = 1000
+
+// writeArrQueue pages queue records like Radarr and Sonarr do
+func (s *Server) writeArrQueue(w http.ResponseWriter, r *http.Request, records []arrQueueRecord) {
+	query := r.URL.Query()
+	page, err := intParam(query.Get("page"), 1)
+	if err != nil || page < 1 {
+		s.writeError(w, http.StatusBadRequest, "page must be at least 1")
+		return
+	}
+	pageSize, err := intParam(query.Get("pageSize"), 10)
+	if err != nil || pageSize < 1 {
+		s.writeError(w, http.StatusBadRequest, "pageSize must be at least 1")
+		return
+	}
+	pageSize = min(pageSize, maxArrPageSize)
+
+	// Pages past the end are empty; checked first so page*pageSize can not
+	// overflow
+	total := len(records)
+	start := total
+	if page-1 <= total/pageSize {
+		start = min((page-1)*pageSize, total)
+	}
+	end := min(start+pageSize, total)
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"page":          page,
+		"pageSize":      pageSize,
+		"sortKey":       "timeleft",
+		"sortDirection": "ascending",
+		"totalRecords":  total,
+		"records":       records[start:end],
+	})
+}
+
+// parseSize reads sizes like "1.5 GB" as the scrapers store them
+func parseSize(size string) int64 {
+	var value float64
+	var unit string
+	if n, _ := fmt.Sscanf(strings.TrimSpace(size), "%f %s", &value, &unit); n != 2 {
+		if n, _ := fmt.Sscanf(strings.TrimSpace(size), "%f%s", &value, &unit); n != 2 {
+			return 0
+		}
+	}
+	multipliers := map[string]float64{
+		"B": 1, "KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30, "TB": 1 << 40,
+		"KIB": 1 << 10, "MIB": 1 << 20, "GIB": 1 << 30, "TIB": 1 << 40,
+	}
+	return int64(value * multipliers[strings.ToUpper(unit)])
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"database/sql"
+	"testing"
+
+	"mye-r/internal/database"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size string
+		want int64
+	}{
+		{"1.5 GB", 1610612736},
+		{"700MB", 734003200},
+		{"700 MB", 734003200},
+		{" 2 GiB ", 2147483648},
+		{"512 kb", 524288},
+		{"1 TB", 1099511627776},
+		{"100 B", 100},
+		{"", 0},
+		{"unknown", 0},
+		{"12", 0},
+		{"3 XB", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			if got := parseSize(tt.size); got != tt.want {
+				t.Errorf("parseSize(%q) = %d, want %d", tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArrSlug(t *testing.T) {
+	year := func(y int64) sql.NullInt64 { return sql.NullInt64{Int64: y, Valid: true} }
+	tests := []struct {
+		title string
+		year  sql.NullInt64
+		want  string
+	}{
+		{"The Matrix", year(1999), "the-matrix-1999"},
+		{"Breaking Bad", sql.NullInt64{}, "breaking-bad"},
+		{"Spider-Man: No Way Home", year(2021), "spider-man-no-way-home-2021"},
+		{"  WALL·E  ", year(2008), "wall-e-2008"},
+		{"Mission: Impossible - Dead Reckoning Part One", year(2023), "mission-impossible-dead-reckoning-part-one-2023"},
+		{"...", sql.NullInt64{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			item := &database.WatchlistItem{Title: tt.title, ItemYear: tt.year}
+			if got := arrSlug(item); got != tt.want {
+				t.Errorf("arrSlug(%q, %v) = %q, want %q", tt.title, tt.year.Int64, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"mye-r/internal/database"
+)
+
+// Scopes of API keys. Each one includes the ones before it: request keys can
+// also read and admin keys can do everything.
+const (
+	ScopeRead    = "read"    // look at items, queues and events
+	ScopeRequest = "request" // add items to the watchlist
+	ScopeAdmin   = "admin"   // retry, skip, pin and run stages
+)
+
+var scopeRank = map[string]int{ScopeRead: 1, ScopeRequest: 2, ScopeAdmin: 3}
+
+// ValidScope reports whether scope is one of the API key scopes
+func ValidScope(scope string) bool {
+	_, ok := scopeRank[scope]
+	return ok
+}
+
+// keyPrefix starts every API key, so leaked keys are easy to recognise
+const keyPrefix = "myer_"
+
+// touchInterval is how stale last_used_at may get before a request updates it
+const touchInterval = time.Minute
+
+// anonymous stands in for the key of requests without one when
+// api.allow_anonymous is set
+var anonymous = &database.APIKey{Name: "anonymous", Scopes: []string{ScopeAdmin}}
+
+// Errors of requests that get a 401
+var (
+	errNoKey      = errors.New("an API key is required")
+	errInvalidKey = errors.New("invalid or revoked API key")
+)
+
+// NewAPIKey generates a key. Only its hash and prefix are meant to be stored.
+func NewAPIKey() (key, prefix, hash string, err error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate API key: %v", err)
+	}
+	key = keyPrefix + hex.EncodeToString(secret)
+	return key, key[:len(keyPrefix)+8], HashAPIKey(key), nil
+}
+
+// HashAPIKey is how keys are stored and looked up. Keys are random, so a fast
+// hash is enough.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// allows reports whether a key may use a route that needs scope
+func allows(key *database.APIKey, scope string) bool {
+	for _, granted := range key.Scopes {
+		if scopeRank[granted] >= scopeRank[scope] {
+			return true
+		}
+	}
+	return false
+}
+
+type keyContextKey struct{}
+
+// requestKey is the key a request was authenticated with
+func requestKey(r *http.Request) *database.APIKey {
+	if key, ok := r.Context().Value(keyContextKey{}).(*database.APIKey); ok {
+		return key
+	}
+	return anonymous
+}
+
+// presentedKey reads the key from the Authorization or X-Api-Key header, or
+// from the apikey query parameter for clients like EventSource that can not
+// set headers
+func presentedKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	if key := r.Header.Get("X-Api-Key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("apikey")
+}
+
+// authenticate looks up the key of a request
+func (s *Server) authenticate(r *http.Request) (*database.APIKey, error) {
+	presented := presentedKey(r)
+	if presented == "" {
+		if s.cfg.API.AllowAnonymous {
+			return anonymous, nil
+		}
+		return nil, errNoKey
+	}
+
+	key, err := s.db.GetAPIKeyByHash(HashAPIKey(presented))
+	if errors.Is(err, database.ErrAPIKeyNotFound) {
+		return nil, errInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !key.LastUsedAt.Valid || time.Since(key.LastUsedAt.Time) > touchInterval {
+		if err := s.db.TouchAPIKey(key.ID); err != nil {
+			s.log.Warning("API", "authenticate", err.Error())
+		}
+	}
+	return key, nil
+}
+
+// authorize wraps a route that needs a key with scope. Requests that change
+// something are logged with the name of their key.
+func (s *Server) authorize(scope string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := s.authenticate(r)
+		if err != nil {
+			if !errors.Is(err, errNoKey) && !errors.Is(err, errInvalidKey) {
+				s.log.Error("API", "authorize", err.Error())
+				s.writeError(w, http.StatusInternalServerError, "failed to check the API key")
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mye-r"`)
+			s.writeError(w, http.StatusUnauthorized, "%v", err)
+			return
+		}
+		// The path as requested, before a facade stripped its prefix
+		path, _, _ := strings.Cut(r.RequestURI, "?")
+		if path == "" {
+			path = r.URL.Path
+		}
+		if !allows(key, scope) {
+			s.log.Warning("API", "Access", fmt.Sprintf("%s %s denied to key %s", r.Method, path, key.Name))
+			s.writeError(w, http.StatusForbidden, "key %s does not have the %s scope", key.Name, scope)
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), keyContextKey{}, key))
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next(recorder, r)
+		s.log.Info("API", "Access", fmt.Sprintf("%s %s by key %s: %d in %s",
+			r.Method, path, key.Name, recorder.status, time.Since(start).Round(time.Millisecond)))
+	})
+}
+
+// statusRecorder remembers the status a handler answered with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
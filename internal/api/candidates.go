@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"mye-r/internal/database"
+	"mye-r/internal/downloader"
+)
+
+// getCandidates handles GET /api/items/{id}/candidates: every stored scrape
+// result of an item, the pinned one first and then by score
+func (s *Server) getCandidates(w http.ResponseWriter, r *http.Request) {
+	item, ok := s.getItemOrError(w, r)
+	if !ok {
+		return
+	}
+
+	results, err := s.db.GetScrapeResultsForItem(item.ID)
+	if err != nil {
+		s.log.Error("API", "getCandidates", err.Error())
+		s.writeError(w, http.StatusInternalServerError, "failed to get candidates of item %d", item.ID)
+		return
+	}
+	views := make([]scrapeResultView, 0, len(results))
+	for i := range results {
+		views = append(views, newScrapeResultView(&results[i]))
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{"candidates": views})
+}
+
+// pinRequest is the body of PUT /api/items/{id}/pin. It names a stored
+// candidate or pastes a magnet link or info hash.
+type pinRequest struct {
+	ScrapeResultID int    `json:"scrape_result_id"`
+	Release        string `json:"release"`
+}
+
+// pinCandidate handles PUT /api/items/{id}/pin. The downloader takes the
+// pinned release instead of the best scored one and rescrapes leave it alone.
+// Items that got past download_pending are sent back to it, without their
+// old torrent and links; requeued in the answer says whether that happened.
+func (s *Server) pinCandidate(w http.ResponseWriter, r *http.Request) {
+	item, ok := s.getItemOrError(w, r)
+	if !ok {
+		return
+	}
+
+	var req pinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid body: %v", err)
+		return
+	}
+	if (req.ScrapeResultID == 0) == (req.Release == "") {
+		s.writeError(w, http.StatusBadRequest, "give either scrape_result_id or release")
+		return
+	}
+
+	if req.ScrapeResultID != 0 {
+		err := s.db.PinScrapeResult(item.ID, req.ScrapeResultID)
+		if errors.Is(err, database.ErrScrapeResultNotFound) {
+			s.writeError(w, http.StatusNotFound, "item %d has no scrape result %d", item.ID, req.ScrapeResultID)
+			return
+		}
+		if err != nil {
+			s.log.Error("API", "pinCandidate", err.Error())
+			s.writeError(w, http.StatusInternalServerError, "failed to pin scrape result %d", req.ScrapeResultID)
+			return
+		}
+	} else {
+		infoHash, name, err := downloader.ParseRelease(req.Release)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		if err := s.db.PinInfoHash(item.ID, infoHash, name); err != nil {
+			s.log.Error("API", "pinCandidate", err.Error())
+			s.writeError(w, http.StatusInternalServerError, "failed to pin %s", infoHash)
+			return
+		}
+	}
+
+	pinned, err := s.db.GetLatestScrapeResult(item.ID)
+	if err != nil || pinned == nil {
+		s.log.Error("API", "pinCandidate", fmt.Sprintf("Failed to load pinned result of item %d: %v", item.ID, err))
+		s.writeError(w, http.StatusInternalServerError, "failed to load pinned result")
+		return
+	}
+	s.log.Info("API", "pinCandidate", fmt.Sprintf("Pinned %s for item %d", pinned.InfoHash.String, item.ID))
+
+	reason := fmt.Sprintf("pinned %s through the API (key %s)", pinned.InfoHash.String, requestKey(r).Name)
+	requeued, err := s.downloader.RequeuePinned(item, s.symlinker, reason)
+	if err != nil {
+		// The pin stands and is used once the item is retried
+		s.log.Error("API", "pinCandidate", fmt.Sprintf("Failed to requeue item %d: %v", item.ID, err))
+		s.writeError(w, http.StatusInternalServerError, "pinned %s but failed to requeue item %d", pinned.InfoHash.String, item.ID)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"pinned":   newScrapeResultView(pinned),
+		"requeued": requeued,
+	})
+}
+
+// unpinCandidate handles DELETE /api/items/{id}/pin and hands the item back
+// to the ranking
+func (s *Server) unpinCandidate(w http.ResponseWriter, r *http.Request) {
+	item, ok := s.getItemOrError(w, r)
+	if !ok {
+		return
+	}
+
+	unpinned, err := s.db.UnpinScrapeResult(item.ID)
+	if err != nil {
+		s.log.Error("API", "unpinCandidate", err.Error())
+		s.writeError(w, http.StatusInternalServerError, "failed to unpin item %d", item.ID)
+		return
+	}
+	if !unpinned {
+		s.writeError(w, http.StatusNotFound, "item %d has nothing pinned", item.ID)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+
+	"mye-r/internal/database"
+	"mye-r/internal/pipeline"
+	"mye-r/internal/symlinker"
+)
+
+// Linker works out the library links of an item
+type Linker interface {
+	Links(item *database.WatchlistItem) ([]symlinker.Link, error)
+}
+
+// SetLinker lets the API report the symlinks of items
+func (s *Server) SetLinker(linker Linker) {
+	s.linker = linker
+}
+
+// getQueues handles GET /api/queues: how many items sit in each stage
+func (s *Server) getQueues(w http.ResponseWriter, r *http.Request) {
+	queues, completed, err := pipeline.Queues(s.db)
+	if err != nil {
+		s.log.Error("API", "getQueues", err.Error())
+		s.writeError(w, http.StatusInternalServerError, "failed to count items")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"stages":    queues,
+		"completed": completed,
+	})
+}
+
+// getSymlinks handles GET /api/items/{id}/symlinks. Finding the downloaded
+// file walks the mount, so the item detail leaves it out.
+func (s *Server) getSymlinks(w http.ResponseWriter, r *http.Request) {
+	if s.linker == nil {
+		s.writeError(w, http.StatusNotImplemented, "symlinks are not available")
+		return
+	}
+	item, ok := s.getItemOrError(w, r)
+	if !ok {
+		return
+	}
+
+	links, err := s.linker.Links(item)
+	if err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, "%v", err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{"links": links})
+}
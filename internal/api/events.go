@@ -0,0 +1,238 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"mye-r/internal/database"
+	"mye-r/internal/logger"
+	"mye-r/internal/pipeline"
+)
+
+// eventReconnected tells clients that events may have been lost while the
+// database connection was down, so they should reload what they show
+const eventReconnected = "reconnected"
+
+// Tuning of GET /api/events
+const (
+	eventBuffer       = 64
+	keepaliveInterval = 30 * time.Second
+)
+
+// eventHub fans the events published on database.EventsChannel out to the
+// clients of GET /api/events
+type eventHub struct {
+	listener *pq.Listener
+	log      *logger.Logger
+
+	mu      sync.Mutex
+	clients map[chan database.Event]struct{}
+	closed  bool
+}
+
+func newEventHub(db *database.DB) (*eventHub, error) {
+	listener, err := db.NewListener(database.EventsChannel)
+	if err != nil {
+		return nil, err
+	}
+	h := &eventHub{
+		listener: listener,
+		log:      logger.New(),
+		clients:  make(map[chan database.Event]struct{}),
+	}
+	go h.run()
+	return h, nil
+}
+
+func (h *eventHub) run() {
+	for n := range h.listener.Notify {
+		if n == nil {
+			h.broadcast(database.Event{Type: eventReconnected, Time: time.Now()})
+			continue
+		}
+
+		var event database.Event
+		if err := json.Unmarshal([]byte(n.Extra), &event); err != nil {
+			h.log.Warning("EventHub", "run", fmt.Sprintf("Ignoring malformed event: %v", err))
+			continue
+		}
+		if event.Type == database.EventTransition && event.Stage == "" {
+			event.Stage = transitionStage(event)
+		}
+		h.broadcast(event)
+	}
+}
+
+// transitionStage is the stage an item moved into, or for items that left
+// the pipeline the stage they came from
+func transitionStage(event database.Event) string {
+	if stage, ok := pipeline.StageFor(pipeline.State(event.To)); ok {
+		return string(stage)
+	}
+	stage, _ := pipeline.StageFor(pipeline.State(event.From))
+	return string(stage)
+}
+
+// broadcast hands an event to every client. A client that can not keep up is
+// disconnected rather than silently missing events; it will reconnect.
+func (h *eventHub) broadcast(event database.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients {
+		select {
+		case client <- event:
+		default:
+			delete(h.clients, client)
+			close(client)
+		}
+	}
+}
+
+func (h *eventHub) subscribe() (chan database.Event, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, false
+	}
+	client := make(chan database.Event, eventBuffer)
+	h.clients[client] = struct{}{}
+	return client, true
+}
+
+func (h *eventHub) unsubscribe(client chan database.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[client]; ok {
+		delete(h.clients, client)
+		close(client)
+	}
+}
+
+// close ends every stream and stops listening
+func (h *eventHub) close() {
+	h.mu.Lock()
+	h.closed = true
+	for client := range h.clients {
+		delete(h.clients, client)
+		close(client)
+	}
+	h.mu.Unlock()
+	h.listener.Close()
+}
+
+// eventFilter selects the events a client asked for
+type eventFilter struct {
+	items  map[int]bool
+	stages map[string]bool
+}
+
+func parseEventFilter(r *http.Request) (eventFilter, error) {
+	filter := eventFilter{items: map[int]bool{}, stages: map[string]bool{}}
+	query := r.URL.Query()
+	for _, value := range splitList(query["item"]) {
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			return filter, fmt.Errorf("invalid item %q", value)
+		}
+		filter.items[id] = true
+	}
+	for _, value := range splitList(query["stage"]) {
+		if !pipeline.Stage(value).Valid() {
+			return filter, fmt.Errorf("unknown stage %q", value)
+		}
+		filter.stages[value] = true
+	}
+	return filter, nil
+}
+
+// splitList flattens repeated and comma separated query values
+func splitList(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				list = append(list, part)
+			}
+		}
+	}
+	return list
+}
+
+func (f eventFilter) match(event database.Event) bool {
+	if event.Type == eventReconnected {
+		return true
+	}
+	if len(f.items) > 0 && !f.items[event.ItemID] {
+		return false
+	}
+	if len(f.stages) > 0 && !f.stages[event.Stage] {
+		// A transition also belongs to the stage the item left
+		from, _ := pipeline.StageFor(pipeline.State(event.From))
+		return event.Type == database.EventTransition && f.stages[string(from)]
+	}
+	return true
+}
+
+// streamEvents handles GET /api/events: a server-sent event stream of state
+// changes, scrape decisions and download progress. The item and stage query
+// parameters, repeated or comma separated, narrow it down.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	if s.events == nil {
+		s.writeError(w, http.StatusServiceUnavailable, "the event stream is not available")
+		return
+	}
+	client, ok := s.events.subscribe()
+	if !ok {
+		s.writeError(w, http.StatusServiceUnavailable, "the server is shutting down")
+		return
+	}
+	defer s.events.unsubscribe(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case event, ok := <-client:
+			if !ok {
+				return
+			}
+			if !filter.match(event) {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"net/http"
+
+	"mye-r/internal/health"
+)
+
+// healthResponse is the body of GET /healthz and GET /readyz
+type healthResponse struct {
+	Status string          `json:"status"`
+	Checks []health.Result `json:"checks"`
+}
+
+// getHealthz handles GET /healthz: whether the database, the rclone mount and
+// the library are usable. It makes no provider calls, so it can be probed
+// often.
+func (s *Server) getHealthz(w http.ResponseWriter, r *http.Request) {
+	s.writeHealth(w, s.health.Local(r.Context()))
+}
+
+// getReadyz handles GET /readyz: the checks of /healthz plus the TMDB key and
+// the Real-Debrid token
+func (s *Server) getReadyz(w http.ResponseWriter, r *http.Request) {
+	s.writeHealth(w, s.health.All(r.Context()))
+}
+
+// writeHealth answers 200 when no check failed and 503 otherwise
+func (s *Server) writeHealth(w http.ResponseWriter, results []health.Result) {
+	if health.Healthy(results) {
+		s.writeJSON(w, http.StatusOK, healthResponse{Status: health.StatusOK, Checks: results})
+		return
+	}
+	s.writeJSON(w, http.StatusServiceUnavailable, healthResponse{Status: health.StatusFailed, Checks: results})
+}
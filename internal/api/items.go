@@ -0,0 +1,239 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"mye-r/internal/database"
+	"mye-r/internal/pipeline"
+)
+
+// Limits of GET /api/items
+const (
+	defaultItemLimit = 100
+	maxItemLimit     = 1000
+)
+
+// historyLimit is how many runs GET /api/items/{id} returns
+const historyLimit = 50
+
+// listItems handles GET /api/items. It filters on the status, step,
+// media_type and custom_library query parameters, searches titles with q and
+// pages with limit and offset.
+func (s *Server) listItems(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := database.ItemFilter{
+		Status:        query.Get("status"),
+		Step:          query.Get("step"),
+		MediaType:     query.Get("media_type"),
+		CustomLibrary: query.Get("custom_library"),
+		Search:        query.Get("q"),
+		Limit:         defaultItemLimit,
+	}
+
+	var err error
+	if filter.Limit, err = intParam(query.Get("limit"), defaultItemLimit); err != nil || filter.Limit <= 0 || filter.Limit > maxItemLimit {
+		s.writeError(w, http.StatusBadRequest, "limit must be between 1 and %d", maxItemLimit)
+		return
+	}
+	if filter.Offset, err = intParam(query.Get("offset"), 0); err != nil || filter.Offset < 0 {
+		s.writeError(w, http.StatusBadRequest, "offset must not be negative")
+		return
+	}
+
+	items, err := s.db.ListWatchlistItems(filter)
+	if err != nil {
+		s.log.Error("API", "listItems", err.Error())
+		s.writeError(w, http.StatusInternalServerError, "failed to list items")
+		return
+	}
+
+	views := make([]itemView, 0, len(items))
+	for _, item := range items {
+		views = append(views, newItemView(item))
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"items":  views,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+	})
+}
+
+// itemDetail is the response of GET /api/items/{id}
+type itemDetail struct {
+	itemView
+	Seasons       []seasonView              `json:"seasons"`
+	ScrapeResults []scrapeResultView        `json:"scrape_results"`
+	Transitions   []database.ItemTransition `json:"transitions"`
+	Retries       []retryView               `json:"retries"`
+	Runs          []runItemView             `json:"runs"`
+	Actions       map[string]pipeline.State `json:"actions"`
+}
+
+// getItem handles GET /api/items/{id}: the item with its seasons, episodes,
+// scrape results and pipeline history
+func (s *Server) getItem(w http.ResponseWriter, r *http.Request) {
+	item, ok := s.getItemOrError(w, r)
+	if !ok {
+		return
+	}
+
+	detail, err := s.itemDetail(item)
+	if err != nil {
+		s.log.Error("API", "getItem", fmt.Sprintf("Failed to load item %d: %v", item.ID, err))
+		s.writeError(w, http.StatusInternalServerError, "failed to load item %d", item.ID)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, detail)
+}
+
+func (s *Server) itemDetail(item *database.WatchlistItem) (*itemDetail, error) {
+	detail := &itemDetail{
+		itemView:      newItemView(item),
+		Seasons:       []seasonView{},
+		ScrapeResults: []scrapeResultView{},
+		Retries:       []retryView{},
+		Runs:          []runItemView{},
+		Actions:       map[string]pipeline.State{},
+	}
+
+	seasons, err := s.db.GetSeasonsForItem(item.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, season := range seasons {
+		episodes, err := s.db.GetEpisodesForSeason(season.ID)
+		if err != nil {
+			return nil, err
+		}
+		detail.Seasons = append(detail.Seasons, newSeasonView(season, episodes))
+	}
+
+	results, err := s.db.GetScrapeResultsForItem(item.ID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		detail.ScrapeResults = append(detail.ScrapeResults, newScrapeResultView(&results[i]))
+	}
+
+	if detail.Transitions, err = s.db.GetItemTransitions(item.ID); err != nil {
+		return nil, err
+	}
+	if detail.Transitions == nil {
+		detail.Transitions = []database.ItemTransition{}
+	}
+
+	retries, err := s.db.GetItemRetries(item.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, retry := range retries {
+		detail.Retries = append(detail.Retries, newRetryView(retry))
+	}
+
+	runs, err := s.db.GetItemRunHistory(item.ID, historyLimit)
+	if err != nil {
+		return nil, err
+	}
+	for _, run := range runs {
+		detail.Runs = append(detail.Runs, newRunItemView(run))
+	}
+
+	if retry, skip, ok := pipeline.ManualTargets(pipeline.State(item.CurrentStep.String)); ok {
+		detail.Actions["retry"] = retry
+		detail.Actions["skip"] = skip
+	}
+	if pipeline.State(item.CurrentStep.String) == pipeline.StateApprovalPending {
+		detail.Actions["approve"] = pipeline.StateIndexingPending
+	}
+	return detail, nil
+}
+
+// stepPatch is the body of PATCH /api/items/{id}
+type stepPatch struct {
+	Step   pipeline.State `json:"step"`
+	Reason string         `json:"reason"`
+}
+
+// patchItem handles PATCH /api/items/{id}. It moves an item back to its
+// stage's pending step to retry it, or on to the next stage's pending step to
+// skip the stage. Items held for approval are approved by moving them to
+// indexing_pending.
+func (s *Server) patchItem(w http.ResponseWriter, r *http.Request) {
+	item, ok := s.getItemOrError(w, r)
+	if !ok {
+		return
+	}
+
+	var patch stepPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid body: %v", err)
+		return
+	}
+	if !patch.Step.Valid() {
+		s.writeError(w, http.StatusBadRequest, "unknown step %q", patch.Step)
+		return
+	}
+
+	from := pipeline.State(item.CurrentStep.String)
+	approve := from == pipeline.StateApprovalPending && patch.Step == pipeline.StateIndexingPending
+	reason := patch.Reason
+	if approve {
+		if reason == "" {
+			reason = "approved through the API"
+		}
+	} else {
+		retry, skip, ok := pipeline.ManualTargets(from)
+		if !ok || (patch.Step != retry && patch.Step != skip) {
+			s.writeError(w, http.StatusConflict, "item %d in %s can not be moved to %s", item.ID, from, patch.Step)
+			return
+		}
+		if reason == "" {
+			reason = "retried through the API"
+			if patch.Step == skip {
+				reason = "skipped through the API"
+			}
+		}
+	}
+	// The item's history records who moved it
+	reason = fmt.Sprintf("%s (key %s)", reason, requestKey(r).Name)
+
+	var err error
+	if approve {
+		err = s.machine.Approve(item.ID, reason)
+	} else {
+		err = s.machine.Override(item.ID, from, patch.Step, reason)
+	}
+	if errors.Is(err, pipeline.ErrStateMismatch) {
+		s.writeError(w, http.StatusConflict, "item %d moved on while it was being changed", item.ID)
+		return
+	}
+	if err != nil {
+		s.log.Error("API", "patchItem", err.Error())
+		s.writeError(w, http.StatusInternalServerError, "failed to move item %d", item.ID)
+		return
+	}
+
+	// A manual retry starts the stage's attempts and backoff over
+	if stage, ok := pipeline.StageFor(from); ok {
+		if err := s.db.ClearItemRetry(item.ID, string(stage)); err != nil {
+			s.log.Error("API", "patchItem", fmt.Sprintf("Failed to clear retries of item %d: %v", item.ID, err))
+		}
+	}
+
+	if item, ok = s.getItemOrError(w, r); ok {
+		s.writeJSON(w, http.StatusOK, newItemView(item))
+	}
+}
+
+// intParam parses an optional integer query parameter
+func intParam(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
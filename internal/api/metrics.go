@@ -0,0 +1,34 @@
+package api
+
+import (
+	"mye-r/internal/metrics"
+	"mye-r/internal/pipeline"
+)
+
+// itemGauges counts items by their pipeline step when /metrics is scraped
+func (s *Server) itemGauges() ([]metrics.Gauge, error) {
+	counts, err := s.db.CountItemsByStep()
+	if err != nil {
+		return nil, err
+	}
+
+	gauge := metrics.Gauge{
+		Name:   "mye_r_items",
+		Help:   "Items by pipeline stage and step",
+		Labels: []string{"stage", "step"},
+	}
+	// Empty steps are reported as 0 rather than left out
+	for _, state := range pipeline.States() {
+		if _, ok := counts[string(state)]; !ok {
+			counts[string(state)] = 0
+		}
+	}
+	for step, count := range counts {
+		stage, _ := pipeline.StageFor(pipeline.State(step))
+		gauge.Samples = append(gauge.Samples, metrics.Sample{
+			Values: []string{string(stage), step},
+			Value:  float64(count),
+		})
+	}
+	return []metrics.Gauge{gauge}, nil
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"mye-r/internal/database"
+	"mye-r/internal/pipeline"
+)
+
+// radarrMovie is the part of a Radarr movie resource request tools read
+type radarrMovie struct {
+	ID                  int        `json:"id,omitempty"` // left out for movies not on the watchlist
+	Title               string     `json:"title"`
+	Year                int        `json:"year"`
+	TmdbID              int        `json:"tmdbId"`
+	ImdbID              string     `json:"imdbId,omitempty"`
+	TitleSlug           string     `json:"titleSlug"`
+	Overview            string     `json:"overview"`
+	Images              []arrImage `json:"images"`
+	Status              string     `json:"status"`
+	Monitored           bool       `json:"monitored"`
+	HasFile             bool       `json:"hasFile"`
+	IsAvailable         bool       `json:"isAvailable"`
+	MinimumAvailability string     `json:"minimumAvailability"`
+	QualityProfileID    int        `json:"qualityProfileId"`
+	RootFolderPath      string     `json:"rootFolderPath"`
+	Path                string     `json:"path"`
+	FolderName          string     `json:"folderName"`
+	Tags                []int      `json:"tags"`
+	Added               time.Time  `json:"added"`
+	SizeOnDisk          int64      `json:"sizeOnDisk"`
+}
+
+func (s *Server) newRadarrMovie(item *database.WatchlistItem) radarrMovie {
+	movie := radarrMovie{
+		ID:                  item.ID,
+		Title:               item.Title,
+		Year:                int(item.ItemYear.Int64),
+		TmdbID:              atoi(item.TmdbID.String),
+		ImdbID:              item.ImdbID.String,
+		TitleSlug:           arrSlug(item),
+		Overview:            item.Description.String,
+		Images:              arrImages(item),
+		Status:              "tba",
+		Monitored:           item.ID != 0,
+		HasFile:             pipeline.State(item.CurrentStep.String) == pipeline.StateCompleted,
+		MinimumAvailability: "released",
+		QualityProfileID:    arrProfileID,
+		RootFolderPath:      s.cfg.General.LibraryPath,
+		Path:                s.arrPath(item),
+		FolderName:          s.arrPath(item),
+		Tags:                []int{},
+		Added:               item.CreatedAt,
+	}
+	if item.ReleaseDate.Valid {
+		movie.Status = "announced"
+		if !item.ReleaseDate.Time.After(time.Now()) {
+			movie.Status, movie.IsAvailable = "released", true
+		}
+	}
+	return movie
+}
+
+// listMovies handles GET /radarr/api/v3/movie: the movies on the watchlist,
+// or with tmdbId the one with that TMDB ID
+func (s *Server) listMovies(w http.ResponseWriter, r *http.Request) {
+	items, ok := s.arrItems(w, "movie")
+	if !ok {
+		return
+	}
+	tmdbID := r.URL.Query().Get("tmdbId")
+
+	movies := []radarrMovie{}
+	for _, item := range items {
+		if tmdbID == "" || item.TmdbID.String == tmdbID {
+			movies = append(movies, s.newRadarrMovie(item))
+		}
+	}
+	s.writeJSON(w, http.StatusOK, movies)
+}
+
+// lookupMovie handles GET /radarr/api/v3/movie/lookup?term=
+func (s *Server) lookupMovie(w http.ResponseWriter, r *http.Request) {
+	item, ok := s.arrLookup(w, r, "movie")
+	if !ok {
+		return
+	}
+	s.writeJSON(w, http.StatusOK, []radarrMovie{s.newRadarrMovie(item)})
+}
+
+func (s *Server) getMovie(w http.ResponseWriter, r *http.Request) {
+	item, ok := s.arrItem(w, r, "movie")
+	if !ok {
+		return
+	}
+	s.writeJSON(w, http.StatusOK, s.newRadarrMovie(item))
+}
+
+// addMovie handles POST /radarr/api/v3/movie. Only the TMDB ID of the body is
+// used; quality profile, root folder and search options are mye-r's own.
+func (s *Server) addMovie(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		TmdbID int `json:"tmdbId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid body: %v", err)
+		return
+	}
+	if body.TmdbID <= 0 {
+		s.writeError(w, http.StatusBadRequest, "tmdbId is required")
+		return
+	}
+
+	item, ok := s.arrAdd(w, r, fmt.Sprintf("tmdb:%d", body.TmdbID), "movie")
+	if !ok {
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, s.newRadarrMovie(item))
+}
+
+// updateMovie handles PUT /radarr/api/v3/movie, which request tools send to
+// monitor a movie again. Watchlist items are always monitored, so it only
+// answers with the movie.
+func (s *Server) updateMovie(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid body: %v", err)
+		return
+	}
+	if r.PathValue("id") == "" {
+		r.SetPathValue("id", strconv.Itoa(body.ID))
+	}
+	s.getMovie(w, r)
+}
+
+// movieQueue handles GET /radarr/api/v3/queue
+func (s *Server) movieQueue(w http.ResponseWriter, r *http.Request) {
+	records, err := s.arrQueue("movie")
+	if err != nil {
+		s.log.Error("API", "movieQueue", err.Error())
+		s.writeError(w, http.StatusInternalServerError, "failed to get the queue")
+		return
+	}
+	for i := range records {
+		records[i].MovieID = records[i].ID
+	}
+	s.writeArrQueue(w, r, records)
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"mye-r/internal/getcontent"
+)
+
+// createRequest handles POST /api/requests. The body names the item by id or
+// query; the answer is the new item, or with 200 the item that was already on
+// the watchlist.
+func (s *Server) createRequest(w http.ResponseWriter, r *http.Request) {
+	var req getcontent.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid body: %v", err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		s.writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if req.RequestedBy == "" {
+		req.RequestedBy = requestKey(r).Name
+	}
+
+	item, created, err := s.requests.Add(req)
+	if errors.Is(err, getcontent.ErrNoMatch) {
+		s.writeError(w, http.StatusNotFound, "%v", err)
+		return
+	}
+	if err != nil {
+		s.log.Error("API", "createRequest", err.Error())
+		s.writeError(w, http.StatusBadGateway, "%v", err)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	s.writeJSON(w, status, map[string]interface{}{
+		"item":    newItemView(item),
+		"created": created,
+	})
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"mye-r/internal"
+	"mye-r/internal/config"
+	"mye-r/internal/database"
+	"mye-r/internal/downloader"
+	"mye-r/internal/getcontent"
+	"mye-r/internal/health"
+	"mye-r/internal/logger"
+	"mye-r/internal/metrics"
+	"mye-r/internal/pipeline"
+	"mye-r/internal/symlinker"
+	"mye-r/internal/web"
+)
+
+// Server is the HTTP API of mye-r serve. It lets operators inspect items and
+// nudge them through the pipeline without going to the database.
+type Server struct {
+	cfg      *config.Config
+	db       *database.DB
+	log      *logger.Logger
+	machine  *pipeline.Machine
+	stages   map[pipeline.Stage]internal.ItemProcessor
+	linker   Linker
+	requests *getcontent.Requester
+	health   *health.Checker
+	events   *eventHub
+	mux      *http.ServeMux
+	server   *http.Server
+
+	// Pinning a release replaces the torrent and links of downloaded items
+	downloader *downloader.RealDebridDownloader
+	symlinker  *symlinker.Symlinker
+
+	// Stage runs started through the API outlive their request
+	ctx    context.Context
+	cancel context.CancelFunc
+	runs   sync.WaitGroup
+}
+
+func New(cfg *config.Config, db *database.DB) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		cfg:        cfg,
+		db:         db,
+		log:        logger.New(),
+		machine:    pipeline.New(db),
+		requests:   getcontent.NewRequester(cfg, db),
+		downloader: downloader.New(cfg, db),
+		symlinker:  symlinker.New(cfg, db),
+		health:     health.New(cfg, db),
+		stages:     make(map[pipeline.Stage]internal.ItemProcessor),
+		mux:        http.NewServeMux(),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	s.routes()
+	return s
+}
+
+// AddStage lets the API run a stage on single items
+func (s *Server) AddStage(stage pipeline.Stage, processor internal.ItemProcessor) {
+	s.stages[stage] = processor
+}
+
+func (s *Server) routes() {
+	s.mux.Handle("GET /api/items", s.authorize(ScopeRead, s.listItems))
+	s.mux.Handle("GET /api/items/{id}", s.authorize(ScopeRead, s.getItem))
+	s.mux.Handle("GET /api/items/{id}/symlinks", s.authorize(ScopeRead, s.getSymlinks))
+	s.mux.Handle("GET /api/items/{id}/candidates", s.authorize(ScopeRead, s.getCandidates))
+	s.mux.Handle("PUT /api/items/{id}/pin", s.authorize(ScopeAdmin, s.pinCandidate))
+	s.mux.Handle("DELETE /api/items/{id}/pin", s.authorize(ScopeAdmin, s.unpinCandidate))
+	s.mux.Handle("PATCH /api/items/{id}", s.authorize(ScopeAdmin, s.patchItem))
+	s.mux.Handle("POST /api/stages/{stage}/items/{id}", s.authorize(ScopeAdmin, s.runStage))
+	s.mux.Handle("GET /api/queues", s.authorize(ScopeRead, s.getQueues))
+	s.mux.Handle("POST /api/requests", s.authorize(ScopeRequest, s.createRequest))
+	s.mux.Handle("GET /api/events", s.authorize(ScopeRead, s.streamEvents))
+	s.arrRoutes()
+
+	// Probes and scrapers do not carry keys
+	s.mux.Handle("GET /metrics", metrics.Default.Handler(s.itemGauges))
+	s.mux.HandleFunc("GET /healthz", s.getHealthz)
+	s.mux.HandleFunc("GET /readyz", s.getReadyz)
+
+	// Everything else is the dashboard, which asks for a key itself
+	s.mux.Handle("GET /", web.Handler())
+}
+
+// Handler returns the handler serving every route
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Start listens on api.listen and serves in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.cfg.API.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", s.cfg.API.Listen, err)
+	}
+
+	s.server = &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Without the event stream the rest of the API still works
+	if s.events, err = newEventHub(s.db); err != nil {
+		s.log.Warning("API", "Start", fmt.Sprintf("Event stream disabled: %v", err))
+	} else {
+		s.server.RegisterOnShutdown(s.events.close)
+	}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Error("API", "Start", fmt.Sprintf("Server stopped: %v", err))
+		}
+	}()
+
+	s.log.Info("API", "Start", fmt.Sprintf("Listening on %s", listener.Addr()))
+	return nil
+}
+
+// Shutdown stops accepting requests and cancels stage runs started through
+// the API, waiting for them until ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	var err error
+	if s.server != nil {
+		err = s.server.Shutdown(ctx)
+	}
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.runs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	return err
+}
+
+// writeJSON writes v as the JSON response body
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.log.Error("API", "writeJSON", fmt.Sprintf("Failed to write response: %v", err))
+	}
+}
+
+// writeError writes an error response of the form {"error": "..."}
+func (s *Server) writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	s.writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+// pathID parses the {id} path value of a request
+func pathID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid id %q", r.PathValue("id"))
+	}
+	return id, nil
+}
+
+// getItemOrError loads the item of a request, writing the error response if
+// that fails
+func (s *Server) getItemOrError(w http.ResponseWriter, r *http.Request) (*database.WatchlistItem, bool) {
+	id, err := pathID(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "%v", err)
+		return nil, false
+	}
+
+	item, err := s.db.GetWatchlistItem(id)
+	if errors.Is(err, database.ErrItemNotFound) {
+		s.writeError(w, http.StatusNotFound, "item %d not found", id)
+		return nil, false
+	}
+	if err != nil {
+		s.log.Error("API", "getItem", err.Error())
+		s.writeError(w, http.StatusInternalServerError, "failed to get item %d", id)
+		return nil, false
+	}
+	return item, true
+}
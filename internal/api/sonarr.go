@@ -0,0 +1,258 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"mye-r/internal/database"
+	"mye-r/internal/pipeline"
+)
+
+// sonarrSeries is the part of a Sonarr series resource request tools read
+type sonarrSeries struct {
+	ID                int              `json:"id,omitempty"` // left out for shows not on the watchlist
+	Title             string           `json:"title"`
+	Year              int              `json:"year"`
+	TvdbID            int              `json:"tvdbId"`
+	TmdbID            int              `json:"tmdbId"`
+	ImdbID            string           `json:"imdbId,omitempty"`
+	TitleSlug         string           `json:"titleSlug"`
+	Overview          string           `json:"overview"`
+	Images            []arrImage       `json:"images"`
+	Status            string           `json:"status"`
+	Seasons           []sonarrSeason   `json:"seasons"`
+	Monitored         bool             `json:"monitored"`
+	SeasonFolder      bool             `json:"seasonFolder"`
+	SeriesType        string           `json:"seriesType"`
+	QualityProfileID  int              `json:"qualityProfileId"`
+	LanguageProfileID int              `json:"languageProfileId"`
+	RootFolderPath    string           `json:"rootFolderPath"`
+	Path              string           `json:"path"`
+	Tags              []int            `json:"tags"`
+	Added             time.Time        `json:"added"`
+	Statistics        sonarrStatistics `json:"statistics"`
+}
+
+type sonarrSeason struct {
+	SeasonNumber int              `json:"seasonNumber"`
+	Monitored    bool             `json:"monitored"`
+	Statistics   sonarrStatistics `json:"statistics"`
+}
+
+type sonarrStatistics struct {
+	SeasonCount       int     `json:"seasonCount,omitempty"`
+	EpisodeFileCount  int     `json:"episodeFileCount"`
+	EpisodeCount      int     `json:"episodeCount"`
+	TotalEpisodeCount int     `json:"totalEpisodeCount"`
+	SizeOnDisk        int64   `json:"sizeOnDisk"`
+	PercentOfEpisodes float64 `json:"percentOfEpisodes"`
+}
+
+func (s *sonarrStatistics) add(other sonarrStatistics) {
+	s.EpisodeFileCount += other.EpisodeFileCount
+	s.EpisodeCount += other.EpisodeCount
+	s.TotalEpisodeCount += other.TotalEpisodeCount
+}
+
+func (s *sonarrStatistics) percent() {
+	if s.EpisodeCount > 0 {
+		s.PercentOfEpisodes = 100 * float64(s.EpisodeFileCount) / float64(s.EpisodeCount)
+	}
+}
+
+// sonarrStatus maps TMDB's show status onto Sonarr's
+func sonarrStatus(showStatus string) string {
+	switch strings.ToLower(showStatus) {
+	case "ended", "canceled":
+		return "ended"
+	case "planned", "in production":
+		return "upcoming"
+	default:
+		return "continuing"
+	}
+}
+
+// newSonarrSeries builds the resource of a show. Shows on the watchlist get
+// their seasons from the database: an aired episode counts as having a file
+// once it was scraped and the show went through the symlinker. Shows that
+// are only looked up list their seasons without statistics.
+func (s *Server) newSonarrSeries(item *database.WatchlistItem) (sonarrSeries, error) {
+	series := sonarrSeries{
+		ID:                item.ID,
+		Title:             item.Title,
+		Year:              int(item.ItemYear.Int64),
+		TvdbID:            atoi(item.TvdbID.String),
+		TmdbID:            atoi(item.TmdbID.String),
+		ImdbID:            item.ImdbID.String,
+		TitleSlug:         arrSlug(item),
+		Overview:          item.Description.String,
+		Images:            arrImages(item),
+		Status:            sonarrStatus(item.ShowStatus.String),
+		Seasons:           []sonarrSeason{},
+		Monitored:         item.ID != 0,
+		SeasonFolder:      true,
+		SeriesType:        "standard",
+		QualityProfileID:  arrProfileID,
+		LanguageProfileID: arrProfileID,
+		RootFolderPath:    s.cfg.General.LibraryPath,
+		Path:              s.arrPath(item),
+		Tags:              []int{},
+		Added:             item.CreatedAt,
+	}
+
+	if item.ID == 0 {
+		for number := 1; number <= int(item.TotalSeasons.Int32); number++ {
+			series.Seasons = append(series.Seasons, sonarrSeason{SeasonNumber: number})
+		}
+		series.Statistics.SeasonCount = len(series.Seasons)
+		return series, nil
+	}
+
+	completed := pipeline.State(item.CurrentStep.String) == pipeline.StateCompleted
+	seasons, err := s.db.GetSeasonsForItem(item.ID)
+	if err != nil {
+		return series, err
+	}
+	now := time.Now()
+	for _, season := range seasons {
+		episodes, err := s.db.GetEpisodesForSeason(season.ID)
+		if err != nil {
+			return series, err
+		}
+
+		result := sonarrSeason{SeasonNumber: season.SeasonNumber, Monitored: true}
+		result.Statistics.TotalEpisodeCount = len(episodes)
+		for _, episode := range episodes {
+			if !episode.AirDate.Valid || episode.AirDate.Time.After(now) {
+				continue
+			}
+			result.Statistics.EpisodeCount++
+			if episode.Scraped && completed {
+				result.Statistics.EpisodeFileCount++
+			}
+		}
+		result.Statistics.percent()
+		series.Statistics.add(result.Statistics)
+		series.Seasons = append(series.Seasons, result)
+	}
+	series.Statistics.SeasonCount = len(series.Seasons)
+	series.Statistics.percent()
+	return series, nil
+}
+
+// writeSeries answers with the resource of a show
+func (s *Server) writeSeries(w http.ResponseWriter, status int, item *database.WatchlistItem) {
+	series, err := s.newSonarrSeries(item)
+	if err != nil {
+		s.log.Error("API", "writeSeries", fmt.Sprintf("Failed to load item %d: %v", item.ID, err))
+		s.writeError(w, http.StatusInternalServerError, "failed to load item %d", item.ID)
+		return
+	}
+	s.writeJSON(w, status, series)
+}
+
+// listSeries handles GET /sonarr/api/v3/series: the shows on the watchlist,
+// or with tvdbId the one with that TVDB ID
+func (s *Server) listSeries(w http.ResponseWriter, r *http.Request) {
+	items, ok := s.arrItems(w, "tv")
+	if !ok {
+		return
+	}
+	tvdbID := r.URL.Query().Get("tvdbId")
+
+	list := []sonarrSeries{}
+	for _, item := range items {
+		if tvdbID != "" && item.TvdbID.String != tvdbID {
+			continue
+		}
+		series, err := s.newSonarrSeries(item)
+		if err != nil {
+			s.log.Error("API", "listSeries", fmt.Sprintf("Failed to load item %d: %v", item.ID, err))
+			s.writeError(w, http.StatusInternalServerError, "failed to list series")
+			return
+		}
+		list = append(list, series)
+	}
+	s.writeJSON(w, http.StatusOK, list)
+}
+
+// lookupSeries handles GET /sonarr/api/v3/series/lookup?term=
+func (s *Server) lookupSeries(w http.ResponseWriter, r *http.Request) {
+	item, ok := s.arrLookup(w, r, "tv")
+	if !ok {
+		return
+	}
+	series, err := s.newSonarrSeries(item)
+	if err != nil {
+		s.log.Error("API", "lookupSeries", err.Error())
+		s.writeError(w, http.StatusInternalServerError, "failed to load %s", item.Title)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, []sonarrSeries{series})
+}
+
+func (s *Server) getSeries(w http.ResponseWriter, r *http.Request) {
+	item, ok := s.arrItem(w, r, "tv")
+	if !ok {
+		return
+	}
+	s.writeSeries(w, http.StatusOK, item)
+}
+
+// addSeries handles POST /sonarr/api/v3/series. Only the TVDB ID of the body
+// is used: mye-r always fetches every season.
+func (s *Server) addSeries(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		TvdbID int `json:"tvdbId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid body: %v", err)
+		return
+	}
+	if body.TvdbID <= 0 {
+		s.writeError(w, http.StatusBadRequest, "tvdbId is required")
+		return
+	}
+
+	item, ok := s.arrAdd(w, r, fmt.Sprintf("tvdb:%d", body.TvdbID), "tv")
+	if !ok {
+		return
+	}
+	s.writeSeries(w, http.StatusCreated, item)
+}
+
+// updateSeries handles PUT /sonarr/api/v3/series, which request tools send to
+// monitor more seasons. Every season is already monitored, so it only
+// answers with the series.
+func (s *Server) updateSeries(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid body: %v", err)
+		return
+	}
+	if r.PathValue("id") == "" {
+		r.SetPathValue("id", strconv.Itoa(body.ID))
+	}
+	s.getSeries(w, r)
+}
+
+// seriesQueue handles GET /sonarr/api/v3/queue. mye-r downloads whole shows,
+// so records are per series rather than per episode.
+func (s *Server) seriesQueue(w http.ResponseWriter, r *http.Request) {
+	records, err := s.arrQueue("tv")
+	if err != nil {
+		s.log.Error("API", "seriesQueue", err.Error())
+		s.writeError(w, http.StatusInternalServerError, "failed to get the queue")
+		return
+	}
+	for i := range records {
+		records[i].SeriesID = records[i].ID
+	}
+	s.writeArrQueue(w, r, records)
+}
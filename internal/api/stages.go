@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"mye-r/internal"
+	"mye-r/internal/pipeline"
+)
+
+// runMode is recorded for process runs started through the API
+const runMode = "api"
+
+// runStage handles POST /api/stages/{stage}/items/{id}. It runs one stage on
+// one item in the background and answers with the process run that records
+// the outcome.
+func (s *Server) runStage(w http.ResponseWriter, r *http.Request) {
+	stage := pipeline.Stage(r.PathValue("stage"))
+	if !stage.Valid() {
+		s.writeError(w, http.StatusNotFound, "unknown stage %q", stage)
+		return
+	}
+	processor, ok := s.stages[stage]
+	if !ok {
+		s.writeError(w, http.StatusConflict, "stage %s is not enabled", stage)
+		return
+	}
+
+	item, ok := s.getItemOrError(w, r)
+	if !ok {
+		return
+	}
+	if pipeline.State(item.CurrentStep.String) != stage.PendingState() {
+		s.writeError(w, http.StatusConflict, "item %d is %s, %s only runs on %s items",
+			item.ID, item.CurrentStep.String, stage, stage.PendingState())
+		return
+	}
+
+	run := internal.StartRun(s.db, string(stage), runMode, []int{item.ID})
+	s.runs.Add(1)
+	go func() {
+		defer s.runs.Done()
+
+		start := time.Now()
+		err := processor.ProcessItem(s.ctx, item)
+		run.Record(internal.ItemResult{
+			Stage:    string(stage),
+			ItemID:   item.ID,
+			Title:    item.Title,
+			Err:      err,
+			Duration: time.Since(start),
+		})
+
+		if internal.Outcome(err) == internal.OutcomeFailed {
+			s.log.Error("API", "runStage", fmt.Sprintf("%s failed on item %d: %v", stage, item.ID, err))
+			run.Finish(internal.RunStatusFailed, nil, err, "")
+			return
+		}
+		run.Finish(internal.RunStatusSucceeded, nil, nil, "")
+	}()
+
+	s.writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"run_id":  run.ID(),
+		"stage":   stage,
+		"item_id": item.ID,
+	})
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"database/sql"
+	"time"
+
+	"mye-r/internal/database"
+)
+
+// The database types keep sql.Null* fields, which would be encoded as
+// {"String": ..., "Valid": ...}. The views below encode them as plain values
+// or null.
+
+type itemView struct {
+	ID                    int        `json:"id"`
+	Title                 string     `json:"title"`
+	Year                  *int64     `json:"year"`
+	RequestedDate         time.Time  `json:"requested_date"`
+	Link                  *string    `json:"link"`
+	ImdbID                *string    `json:"imdb_id"`
+	TmdbID                *string    `json:"tmdb_id"`
+	TvdbID                *string    `json:"tvdb_id"`
+	Description           *string    `json:"description"`
+	Category              *string    `json:"category"`
+	Genres                *string    `json:"genres"`
+	Rating                *string    `json:"rating"`
+	Status                *string    `json:"status"`
+	CurrentStep           *string    `json:"current_step"`
+	ThumbnailURL          *string    `json:"thumbnail_url"`
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+	BestScrapedFilename   *string    `json:"best_scraped_filename"`
+	BestScrapedResolution *string    `json:"best_scraped_resolution"`
+	BestScrapedScore      *int32     `json:"best_scraped_score"`
+	LastScrapedDate       *time.Time `json:"last_scraped_date"`
+	CustomLibrary         *string    `json:"custom_library"`
+	MainLibraryPath       *string    `json:"main_library_path"`
+	MediaType             *string    `json:"media_type"`
+	TotalSeasons          *int32     `json:"total_seasons"`
+	TotalEpisodes         *int32     `json:"total_episodes"`
+	ReleaseDate           *time.Time `json:"release_date"`
+	ShowStatus            *string    `json:"show_status"`
+	RequestedBy           *string    `json:"requested_by"`
+	ForcedLibrary         *string    `json:"forced_library"`
+	QualityProfile        *string    `json:"quality_profile"`
+}
+
+func newItemView(item *database.WatchlistItem) itemView {
+	return itemView{
+		ID:                    item.ID,
+		Title:                 item.Title,
+		Year:                  nullInt64(item.ItemYear),
+		RequestedDate:         item.RequestedDate,
+		Link:                  nullString(item.Link),
+		ImdbID:                nullString(item.ImdbID),
+		TmdbID:                nullString(item.TmdbID),
+		TvdbID:                nullString(item.TvdbID),
+		Description:           nullString(item.Description),
+		Category:              nullString(item.Category),
+		Genres:                nullString(item.Genres),
+		Rating:                nullString(item.Rating),
+		Status:                nullString(item.Status),
+		CurrentStep:           nullString(item.CurrentStep),
+		ThumbnailURL:          nullString(item.ThumbnailURL),
+		CreatedAt:             item.CreatedAt,
+		UpdatedAt:             item.UpdatedAt,
+		BestScrapedFilename:   nullString(item.BestScrapedFilename),
+		BestScrapedResolution: nullString(item.BestScrapedResolution),
+		BestScrapedScore:      nullInt32(item.BestScrapedScore),
+		LastScrapedDate:       nullTime(item.LastScrapedDate),
+		CustomLibrary:         nullString(item.CustomLibrary),
+		MainLibraryPath:       nullString(item.MainLibraryPath),
+		MediaType:             nullString(item.MediaType),
+		TotalSeasons:          nullInt32(item.TotalSeasons),
+		TotalEpisodes:         nullInt32(item.TotalEpisodes),
+		ReleaseDate:           nullTime(item.ReleaseDate),
+		ShowStatus:            nullString(item.ShowStatus),
+		RequestedBy:           nullString(item.RequestedBy),
+		ForcedLibrary:         nullString(item.ForcedLibrary),
+		QualityProfile:        nullString(item.QualityProfile),
+	}
+}
+
+type scrapeResultView struct {
+	ID          int        `json:"id"`
+	Filename    *string    `json:"scraped_filename"`
+	Resolution  *string    `json:"scraped_resolution"`
+	ScrapedDate *time.Time `json:"scraped_date"`
+	InfoHash    *string    `json:"info_hash"`
+	Score       *int32     `json:"scraped_score"`
+	FileSize    *string    `json:"scraped_file_size"`
+	Codec       *string    `json:"scraped_codec"`
+	Status      *string    `json:"status"`
+	DebridID    *string    `json:"debrid_id"`
+	DebridURI   *string    `json:"debrid_uri"`
+	Pinned      bool       `json:"pinned"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+func newScrapeResultView(result *database.ScrapeResult) scrapeResultView {
+	return scrapeResultView{
+		ID:          result.ID,
+		Filename:    nullString(result.ScrapedFilename),
+		Resolution:  nullString(result.ScrapedResolution),
+		ScrapedDate: nullTime(result.ScrapedDate),
+		InfoHash:    nullString(result.InfoHash),
+		Score:       nullInt32(result.ScrapedScore),
+		FileSize:    nullString(result.ScrapedFileSize),
+		Codec:       nullString(result.ScrapedCodec),
+		Status:      nullString(result.StatusResults),
+		DebridID:    nullString(result.DebridID),
+		DebridURI:   nullString(result.DebridURI),
+		Pinned:      result.Pinned,
+		CreatedAt:   result.CreatedAt,
+		UpdatedAt:   result.UpdatedAt,
+	}
+}
+
+type seasonView struct {
+	ID           int           `json:"id"`
+	SeasonNumber int           `json:"season_number"`
+	AirDate      *time.Time    `json:"air_date"`
+	Overview     *string       `json:"overview"`
+	PosterPath   *string       `json:"poster_path"`
+	EpisodeCount *int32        `json:"episode_count"`
+	Episodes     []episodeView `json:"episodes"`
+}
+
+func newSeasonView(season *database.Season, episodes []database.TVEpisode) seasonView {
+	view := seasonView{
+		ID:           season.ID,
+		SeasonNumber: season.SeasonNumber,
+		AirDate:      nullTime(season.AirDate),
+		Overview:     nullString(season.Overview),
+		PosterPath:   nullString(season.PosterPath),
+		EpisodeCount: nullInt32(season.EpisodeCount),
+		Episodes:     make([]episodeView, 0, len(episodes)),
+	}
+	for _, episode := range episodes {
+		view.Episodes = append(view.Episodes, episodeView{
+			ID:             episode.ID,
+			EpisodeNumber:  episode.EpisodeNumber,
+			Name:           nullString(episode.EpisodeName),
+			AirDate:        nullTime(episode.AirDate),
+			Overview:       nullString(episode.Overview),
+			StillPath:      nullString(episode.StillPath),
+			Scraped:        episode.Scraped,
+			ScrapeResultID: nullInt32(episode.ScrapeResultID),
+		})
+	}
+	return view
+}
+
+type episodeView struct {
+	ID             int        `json:"id"`
+	EpisodeNumber  int        `json:"episode_number"`
+	Name           *string    `json:"episode_name"`
+	AirDate        *time.Time `json:"air_date"`
+	Overview       *string    `json:"overview"`
+	StillPath      *string    `json:"still_path"`
+	Scraped        bool       `json:"scraped"`
+	ScrapeResultID *int32     `json:"scrape_result_id"`
+}
+
+type retryView struct {
+	Stage         string     `json:"stage"`
+	Attempts      int        `json:"attempts"`
+	LastError     *string    `json:"last_error"`
+	LastAttemptAt *time.Time `json:"last_attempt_at"`
+	NextAttemptAt *time.Time `json:"next_attempt_at"`
+}
+
+func newRetryView(retry database.ItemRetry) retryView {
+	return retryView{
+		Stage:         retry.Stage,
+		Attempts:      retry.Attempts,
+		LastError:     nullString(retry.LastError),
+		LastAttemptAt: nullTime(retry.LastAttemptAt),
+		NextAttemptAt: nullTime(retry.NextAttemptAt),
+	}
+}
+
+type runItemView struct {
+	RunID      int       `json:"run_id"`
+	Stage      string    `json:"stage"`
+	Outcome    string    `json:"outcome"`
+	Error      *string   `json:"error"`
+	DurationMs int64     `json:"duration_ms"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+func newRunItemView(run database.ProcessRunItem) runItemView {
+	return runItemView{
+		RunID:      run.ProcessRunID,
+		Stage:      run.Stage,
+		Outcome:    run.Outcome,
+		Error:      nullString(run.Error),
+		DurationMs: run.DurationMs,
+		FinishedAt: run.FinishedAt,
+	}
+}
+
+func nullString(v sql.NullString) *string {
+	if !v.Valid {
+		return nil
+	}
+	return &v.String
+}
+
+func nullInt64(v sql.NullInt64) *int64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Int64
+}
+
+func nullInt32(v sql.NullInt32) *int32 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Int32
+}
+
+func nullTime(v sql.NullTime) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Time
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"text/template"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -20,12 +21,60 @@ type Config struct {
 	Programs        ProgramsConfig           `yaml:"programs"`
 	TMDB            TMDB                     `yaml:"tmdb"`
 	ProcessManagement ProcessManagementConfig `yaml:"process_management"`
+	API             APIConfig                `yaml:"api"`
+	Notifications   NotificationsConfig      `yaml:"notifications"`
+
+	// DryRun is set by --dry-run: nothing is written to Real-Debrid or the library
+	DryRun bool `yaml:"-"`
 }
 
 type FetcherConfig struct {
-	Enabled  bool     `yaml:"enabled"`
-	URLs     []string `yaml:"urls"`
-	Interval int      `yaml:"interval"`
+	Enabled  bool         `yaml:"enabled"`
+	URLs     []FeedConfig `yaml:"urls"`
+	Interval int          `yaml:"interval"`
+
+	// Plex RSS: what happens to items that drop off a feed
+	OnRemove    string        `yaml:"on_remove"`    // OnRemoveLeave, OnRemoveCancel or OnRemoveDelete
+	RemoveGrace time.Duration `yaml:"remove_grace"` // how long a downloaded item may be missing before it is deleted
+
+	// Trakt: the app's credentials and what to fetch
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Watchlist    bool     `yaml:"watchlist"` // the watchlist of the user logged in with mye-r trakt login
+	Lists        []string `yaml:"lists"`     // user/list-slug or trakt.tv list URLs
+
+	// Drop folder: the directory watched for import files and where they go
+	// once processed, <path>/processed by default
+	Path        string `yaml:"path"`
+	ArchivePath string `yaml:"archive_path"`
+}
+
+// FeedConfig is a Plex RSS feed and where its items are routed. A feed can be
+// given as just its URL.
+type FeedConfig struct {
+	URL            string `yaml:"url"`
+	RequestedBy    string `yaml:"requester"`       // stamped as requested_by on its items
+	Library        string `yaml:"library"`         // custom library its items go to whatever the filters say
+	QualityProfile string `yaml:"quality_profile"` // scraping profile its items are scored with
+	AutoApprove    bool   `yaml:"auto_approve"`    // false holds its items in approval_pending
+}
+
+// UnmarshalYAML reads a feed from its URL or from a mapping. Feeds are
+// approved automatically unless auto_approve is false.
+func (f *FeedConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var url string
+	if err := unmarshal(&url); err == nil {
+		*f = FeedConfig{URL: url, AutoApprove: true}
+		return nil
+	}
+
+	type plain FeedConfig
+	feed := plain{AutoApprove: true}
+	if err := unmarshal(&feed); err != nil {
+		return err
+	}
+	*f = FeedConfig(feed)
+	return nil
 }
 
 type DatabaseConfig struct {
@@ -70,6 +119,10 @@ type ScrapingConfig struct {
 	PreferredUploaders []string                 `yaml:"preferredUploaders"`
 	Languages          LanguagesConfig          `yaml:"languages"`
 	Ranking            RankingConfig            `yaml:"ranking"`
+
+	// Profiles score the items of feeds routed to them. Scores a profile
+	// leaves out are taken from ranking.scoring.
+	Profiles map[string]ScoringConfig `yaml:"profiles"`
 }
 
 type ScraperConfig struct {
@@ -95,6 +148,31 @@ type ScoringConfig struct {
 	PreferredUploaderScore int            `yaml:"preferredUploaderScore"`
 }
 
+// withDefaults fills the scores s leaves out from base
+func (s ScoringConfig) withDefaults(base ScoringConfig) ScoringConfig {
+	for _, score := range []struct{ value, fallback *int }{
+		{&s.LanguageIncludeScore, &base.LanguageIncludeScore},
+		{&s.LanguageExcludePenalty, &base.LanguageExcludePenalty},
+		{&s.MaxSeederScore, &base.MaxSeederScore},
+		{&s.MaxSizeScore, &base.MaxSizeScore},
+		{&s.PreferredUploaderScore, &base.PreferredUploaderScore},
+	} {
+		if *score.value == 0 {
+			*score.value = *score.fallback
+		}
+	}
+	for _, scores := range []struct{ value, fallback *map[string]int }{
+		{&s.ResolutionScores, &base.ResolutionScores},
+		{&s.QualityScores, &base.QualityScores},
+		{&s.CodecScores, &base.CodecScores},
+	} {
+		if *scores.value == nil {
+			*scores.value = *scores.fallback
+		}
+	}
+	return s
+}
+
 type RankingConfig struct {
 	BingeGroupPriority      []map[string][]string `yaml:"bingeGroupPriority"`
 	MaxResultsPerResolution int                   `yaml:"maxResultsPerResolution"`
@@ -103,6 +181,7 @@ type RankingConfig struct {
 
 type ProgramsConfig struct {
 	ContentFetcher  ProgramStatus `yaml:"content_fetcher"`
+	TMDBIndexer    ProgramStatus `yaml:"tmdb_indexer"`
 	Scraper        ProgramStatus `yaml:"scraper"`
 	Downloader     ProgramStatus `yaml:"downloader"`
 	LibraryMatcher ProgramStatus `yaml:"library_matcher"`
@@ -114,11 +193,110 @@ type ProgramStatus struct {
 	Priority      int           `yaml:"priority"`
 	CheckInterval time.Duration `yaml:"check_interval"`
 	MaxRetries    int           `yaml:"max_retries"`
+	Concurrency   int           `yaml:"concurrency"`
+	BatchSize     int           `yaml:"batch_size"`
+}
+
+// Program returns the programs block of a process, looked up by the name the
+// RunManager registers it under
+func (p ProgramsConfig) Program(name string) (ProgramStatus, bool) {
+	switch name {
+	case "getcontent":
+		return p.ContentFetcher, true
+	case "tmdb_indexer":
+		return p.TMDBIndexer, true
+	case "librarymatcher":
+		return p.LibraryMatcher, true
+	case "scraper":
+		return p.Scraper, true
+	case "downloader":
+		return p.Downloader, true
+	case "symlinker":
+		return p.Symlinker, true
+	default:
+		return ProgramStatus{}, false
+	}
 }
 
+// What the plexrss fetcher does with items that drop off a feed
+const (
+	OnRemoveLeave  = "leave"  // keep them
+	OnRemoveCancel = "cancel" // delete them unless they were downloaded
+	OnRemoveDelete = "remove" // delete them, downloaded ones after remove_grace with their symlinks and Real-Debrid torrents
+)
+
+// DefaultRemoveGrace is used when fetchers.plexrss has no remove_grace
+const DefaultRemoveGrace = 24 * time.Hour
+
+// Run modes for the RunManager
+const (
+	RunModeExec      = "exec"      // run every stage as a mye-r subcommand
+	RunModeInProcess = "inprocess" // call the stages directly from a worker pool
+)
+
 type ProcessManagementConfig struct {
 	DefaultRetryWaitTime time.Duration `yaml:"default_retry_wait_time"`
 	DefaultMaxRetries    int           `yaml:"default_max_retries"`
+	Mode                 string        `yaml:"mode"`
+	Workers              int           `yaml:"workers"`
+	LeaseTTL             time.Duration `yaml:"lease_ttl"`
+	RetryBaseDelay       time.Duration `yaml:"retry_base_delay"`
+	SweepInterval        time.Duration `yaml:"sweep_interval"`
+	MaxParallelStages    int           `yaml:"max_parallel_stages"`
+}
+
+// APIConfig configures the HTTP API served by mye-r serve
+type APIConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Listen  string `yaml:"listen"`
+
+	// AllowAnonymous lets requests without an API key do everything, as
+	// before keys existed. Requests with a key are still checked.
+	AllowAnonymous bool `yaml:"allow_anonymous"`
+}
+
+// Events that can be notified
+const (
+	NotifyCompleted   = "completed"    // the symlinker linked an item into the library
+	NotifyFailed      = "failed"       // a stage gave up on an item
+	NotifyNoStreams   = "no_streams"   // the scrapers found no streams for an item, said once per round of retries
+	NotifyNewEpisodes = "new_episodes" // a finished series went back through the pipeline
+)
+
+// Types of notification sinks
+const (
+	SinkDiscord = "discord" // Discord webhook
+	SinkNtfy    = "ntfy"    // ntfy topic
+	SinkSMTP    = "smtp"    // email
+	SinkWebhook = "webhook" // JSON POST to any URL
+)
+
+// NotificationsConfig names the sinks notifications go to and routes each
+// event to some of them
+type NotificationsConfig struct {
+	Sinks  map[string]SinkConfig  `yaml:"sinks"`
+	Events map[string]EventConfig `yaml:"events"`
+}
+
+// SinkConfig configures one sink. URL is used by all types but smtp. URL,
+// Token and Password may refer to environment variables as ${NAME}.
+type SinkConfig struct {
+	Type     string   `yaml:"type"`
+	URL      string   `yaml:"url"`
+	Token    string   `yaml:"token"`
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+// EventConfig routes an event to sinks. Template is a text/template rendering
+// the message; events without one use a default.
+type EventConfig struct {
+	Sinks    []string `yaml:"sinks"`
+	Template string   `yaml:"template"`
 }
 
 type TMDB struct {
@@ -177,8 +355,23 @@ func LoadConfig(filename string) (*Config, error) {
 
 	cfg.TMDB.APIKey = os.Getenv("TMDB_API_KEY")
 
+	for name, fetcher := range cfg.Fetchers {
+		fetcher.ClientID = os.ExpandEnv(fetcher.ClientID)
+		fetcher.ClientSecret = os.ExpandEnv(fetcher.ClientSecret)
+		cfg.Fetchers[name] = fetcher
+	}
+
+	for name, sink := range cfg.Notifications.Sinks {
+		sink.URL = os.ExpandEnv(sink.URL)
+		sink.Token = os.ExpandEnv(sink.Token)
+		sink.Password = os.ExpandEnv(sink.Password)
+		cfg.Notifications.Sinks[name] = sink
+	}
+
 	// Add other environment variable overrides as needed...
 
+	cfg.applyDefaults()
+
 	// Validate the configuration
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %v", err)
@@ -187,6 +380,26 @@ func LoadConfig(filename string) (*Config, error) {
 	return &cfg, nil
 }
 
+// FetchersEnabled reports whether any fetcher is enabled
+func (c *Config) FetchersEnabled() bool {
+	for _, fetcher := range c.Fetchers {
+		if fetcher.Enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// CustomLibrary looks up a custom library by name
+func (c *Config) CustomLibrary(name string) (CustomLibrary, bool) {
+	for _, lib := range c.CustomLibraries {
+		if lib.Name == name {
+			return lib, true
+		}
+	}
+	return CustomLibrary{}, false
+}
+
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
 	// Validate scraping configuration
@@ -194,6 +407,134 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid scraping config: %v", err)
 	}
 
+	switch c.ProcessManagement.Mode {
+	case "", RunModeExec, RunModeInProcess:
+	default:
+		return fmt.Errorf("invalid process_management mode %q, must be %q or %q",
+			c.ProcessManagement.Mode, RunModeExec, RunModeInProcess)
+	}
+
+	if trakt, ok := c.Fetchers["trakt"]; ok && trakt.Enabled {
+		if trakt.ClientID == "" || trakt.ClientSecret == "" {
+			return fmt.Errorf("fetcher trakt: client_id and client_secret are required")
+		}
+		if !trakt.Watchlist && len(trakt.Lists) == 0 {
+			return fmt.Errorf("fetcher trakt: enable watchlist or configure lists")
+		}
+	}
+
+	if plexRSS, ok := c.Fetchers["plexrss"]; ok {
+		switch plexRSS.OnRemove {
+		case "", OnRemoveLeave, OnRemoveCancel, OnRemoveDelete:
+		default:
+			return fmt.Errorf("fetcher plexrss: invalid on_remove %q, must be %q, %q or %q",
+				plexRSS.OnRemove, OnRemoveLeave, OnRemoveCancel, OnRemoveDelete)
+		}
+		if plexRSS.RemoveGrace < 0 {
+			return fmt.Errorf("fetcher plexrss: remove_grace cannot be negative")
+		}
+		for _, feed := range plexRSS.URLs {
+			if lib, ok := c.CustomLibrary(feed.Library); feed.Library != "" && (!ok || !lib.Active) {
+				return fmt.Errorf("fetcher plexrss: feed %s: library %q is not an active custom library", feed.URL, feed.Library)
+			}
+			if _, ok := c.Scraping.Profiles[feed.QualityProfile]; feed.QualityProfile != "" && !ok {
+				return fmt.Errorf("fetcher plexrss: feed %s: unknown quality_profile %q", feed.URL, feed.QualityProfile)
+			}
+		}
+	}
+
+	if dropFolder, ok := c.Fetchers["dropfolder"]; ok && dropFolder.Enabled && dropFolder.Path == "" {
+		return fmt.Errorf("fetcher dropfolder: path is required")
+	}
+
+	if err := c.Notifications.validate(); err != nil {
+		return fmt.Errorf("invalid notifications config: %v", err)
+	}
+
+	if c.ProcessManagement.MaxParallelStages < 0 {
+		return fmt.Errorf("process_management max_parallel_stages cannot be negative")
+	}
+
+	for _, name := range []string{"getcontent", "tmdb_indexer", "librarymatcher", "scraper", "downloader", "symlinker"} {
+		program, _ := c.Programs.Program(name)
+		if program.CheckInterval < 0 || program.Concurrency < 0 || program.MaxRetries < 0 {
+			return fmt.Errorf("program %s: check_interval, concurrency and max_retries cannot be negative", name)
+		}
+	}
+
+	return nil
+}
+
+// applyDefaults fills in settings older config files do not have
+func (c *Config) applyDefaults() {
+	// Before the tmdb_indexer block existed the indexer only followed tmdb.enabled
+	if c.Programs.TMDBIndexer == (ProgramStatus{}) {
+		c.Programs.TMDBIndexer.Active = true
+	}
+
+	if plexRSS, ok := c.Fetchers["plexrss"]; ok {
+		if plexRSS.OnRemove == "" {
+			plexRSS.OnRemove = OnRemoveLeave
+		}
+		if plexRSS.RemoveGrace == 0 {
+			plexRSS.RemoveGrace = DefaultRemoveGrace
+		}
+		c.Fetchers["plexrss"] = plexRSS
+	}
+
+	for name, profile := range c.Scraping.Profiles {
+		c.Scraping.Profiles[name] = profile.withDefaults(c.Scraping.Ranking.Scoring)
+	}
+
+	if c.API.Listen == "" {
+		c.API.Listen = ":8080"
+	}
+
+	// The library matcher only touches the database and takes bigger batches
+	if c.Programs.LibraryMatcher.BatchSize <= 0 {
+		c.Programs.LibraryMatcher.BatchSize = 20
+	}
+	for _, program := range []*ProgramStatus{
+		&c.Programs.ContentFetcher, &c.Programs.TMDBIndexer, &c.Programs.Scraper,
+		&c.Programs.Downloader, &c.Programs.Symlinker,
+	} {
+		if program.BatchSize <= 0 {
+			program.BatchSize = 10
+		}
+	}
+}
+
+func (n NotificationsConfig) validate() error {
+	for name, sink := range n.Sinks {
+		switch sink.Type {
+		case SinkDiscord, SinkNtfy, SinkWebhook:
+			if sink.URL == "" {
+				return fmt.Errorf("sink %s: url is required", name)
+			}
+		case SinkSMTP:
+			if sink.Host == "" || sink.From == "" || len(sink.To) == 0 {
+				return fmt.Errorf("sink %s: host, from and to are required", name)
+			}
+		default:
+			return fmt.Errorf("sink %s: unknown type %q", name, sink.Type)
+		}
+	}
+
+	for event, route := range n.Events {
+		switch event {
+		case NotifyCompleted, NotifyFailed, NotifyNoStreams, NotifyNewEpisodes:
+		default:
+			return fmt.Errorf("unknown event %q", event)
+		}
+		for _, sink := range route.Sinks {
+			if _, ok := n.Sinks[sink]; !ok {
+				return fmt.Errorf("event %s: unknown sink %q", event, sink)
+			}
+		}
+		if _, err := template.New(event).Parse(route.Template); err != nil {
+			return fmt.Errorf("event %s: invalid template: %v", event, err)
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrAPIKeyNotFound is returned when no active API key matches
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKey is a key of the HTTP API. The key itself is never stored.
+type APIKey struct {
+	ID         int          `json:"id"`
+	Name       string       `json:"name"`
+	Prefix     string       `json:"prefix"`
+	Scopes     []string     `json:"scopes"`
+	CreatedAt  time.Time    `json:"created_at"`
+	LastUsedAt sql.NullTime `json:"last_used_at"`
+	RevokedAt  sql.NullTime `json:"revoked_at"`
+}
+
+const apiKeyColumns = `id, name, prefix, scopes, created_at, last_used_at, revoked_at`
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var key APIKey
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, pq.Array(&key.Scopes),
+		&key.CreatedAt, &key.LastUsedAt, &key.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// CreateAPIKey stores a new key by the SHA-256 hash of its secret
+func (db *DB) CreateAPIKey(name, prefix, hash string, scopes []string) (*APIKey, error) {
+	key, err := scanAPIKey(db.QueryRow(`
+		INSERT INTO api_keys (name, prefix, key_hash, scopes)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+apiKeyColumns,
+		name, prefix, hash, pq.Array(scopes)))
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, fmt.Errorf("an API key named %q already exists", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create API key: %v", err)
+	}
+	return key, nil
+}
+
+// GetAPIKeyByHash returns the active key with the given hash
+func (db *DB) GetAPIKeyByHash(hash string) (*APIKey, error) {
+	key, err := scanAPIKey(db.QueryRow(`
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL
+	`, hash))
+	if err == sql.ErrNoRows {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key: %v", err)
+	}
+	return key, nil
+}
+
+// ListAPIKeys returns every key, revoked ones included, oldest first
+func (db *DB) ListAPIKeys() ([]APIKey, error) {
+	rows, err := db.Query(`SELECT ` + apiKeyColumns + ` FROM api_keys ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %v", err)
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %v", err)
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey disables the active key with the given name
+func (db *DB) RevokeAPIKey(name string) error {
+	result, err := db.Exec(`
+		UPDATE api_keys SET revoked_at = NOW()
+		WHERE name = $1 AND revoked_at IS NULL
+	`, name)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %v", err)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to read affected rows: %v", err)
+	} else if affected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey records that a key was used
+func (db *DB) TouchAPIKey(id int) error {
+	if _, err := db.Exec(`UPDATE api_keys SET last_used_at = NOW() WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to update API key: %v", err)
+	}
+	return nil
+}
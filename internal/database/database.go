@@ -2,16 +2,23 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	_ "github.com/lib/pq"
 )
 
+// ErrItemNotFound is returned when a watchlist item does not exist
+var ErrItemNotFound = errors.New("watchlist item not found")
+
 // DB struct represents the database connection
 type DB struct {
 	*sql.DB
+	dsn string // kept for connections that can not come from the pool, like LISTEN
+	dry *dryRun // set by BeginDryRun
 }
 
 // WatchlistItem represents a single watchlist item.
@@ -45,18 +52,32 @@ type WatchlistItem struct {
 	ReleaseDate           sql.NullTime   `json:"release_date"`
 	ShowStatus            sql.NullString `json:"show_status"`
 	RetryCount            sql.NullInt32  `json:"retry_count"`
+	RequestedBy           sql.NullString `json:"requested_by"`
+	ForcedLibrary         sql.NullString `json:"forced_library"`
+	QualityProfile        sql.NullString `json:"quality_profile"`
 }
 
 // NewDB creates a new database connection
 func NewDB(dataSourceName string) (*DB, error) {
-	db, err := sql.Open("postgres", dataSourceName)
+	db, err := Open(dataSourceName)
 	if err != nil {
 		return nil, err
 	}
 	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Open prepares a connection pool without connecting, for callers that report
+// an unreachable database themselves
+func Open(dataSourceName string) (*DB, error) {
+	db, err := sql.Open("postgres", dataSourceName)
+	if err != nil {
 		return nil, err
 	}
-	return &DB{db}, nil
+	return &DB{DB: db, dsn: dataSourceName}, nil
 }
 
 // GetWatchlistItem retrieves a single watchlist item by ID
@@ -66,7 +87,8 @@ func (db *DB) GetWatchlistItem(id int) (*WatchlistItem, error) {
 			   description, category, genres, rating, status, current_step, thumbnail_url,
 			   created_at, updated_at, best_scraped_filename, best_scraped_resolution,
 			   last_scraped_date, custom_library, main_library_path, best_scraped_score,
-			   media_type, total_seasons, total_episodes, release_date
+			   media_type, total_seasons, total_episodes, release_date, requested_by,
+			   forced_library, quality_profile
 		FROM watchlistitem
 		WHERE id = $1
 	`
@@ -99,10 +121,13 @@ func (db *DB) GetWatchlistItem(id int) (*WatchlistItem, error) {
 		&item.TotalSeasons,
 		&item.TotalEpisodes,
 		&item.ReleaseDate,
+		&item.RequestedBy,
+		&item.ForcedLibrary,
+		&item.QualityProfile,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("watchlist item not found")
+			return nil, ErrItemNotFound
 		}
 		return nil, fmt.Errorf("error getting watchlist item: %v", err)
 	}
@@ -115,7 +140,7 @@ func (db *DB) GetInfoHashForItem(itemID int) (string, error) {
 		SELECT info_hash
 		FROM scrape_results
 		WHERE watchlist_item_id = $1
-		ORDER BY scraped_score DESC
+		ORDER BY pinned DESC, scraped_score DESC
 		LIMIT 1
 	`
 	var infoHash string
@@ -137,8 +162,9 @@ func (db *DB) CreateWatchlistItem(item *WatchlistItem) error {
 			description, category, genres, rating, status, current_step,
 			thumbnail_url, created_at, updated_at, best_scraped_filename, best_scraped_resolution,
 			last_scraped_date, custom_library, main_library_path, best_scraped_score,
-			media_type, total_seasons, total_episodes, release_date
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26)
+			media_type, total_seasons, total_episodes, release_date, requested_by,
+			forced_library, quality_profile
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29)
 		RETURNING id
 	`
 
@@ -151,6 +177,7 @@ func (db *DB) CreateWatchlistItem(item *WatchlistItem) error {
 		item.BestScrapedFilename, item.BestScrapedResolution, item.LastScrapedDate,
 		item.CustomLibrary, item.MainLibraryPath, item.BestScrapedScore,
 		item.MediaType, item.TotalSeasons, item.TotalEpisodes, item.ReleaseDate,
+		item.RequestedBy, item.ForcedLibrary, item.QualityProfile,
 	).Scan(&item.ID)
 
 	if err != nil {
@@ -160,26 +187,95 @@ func (db *DB) CreateWatchlistItem(item *WatchlistItem) error {
 	return nil
 }
 
-// FetcherUpdateWatchlistItem updates an existing watchlist item in the database
+// SetItemRequestedBy records who asked for an item by hand, unless someone
+// already did. A requester stamped by a feed gives way, and no feed owns the
+// item afterwards. It reports whether the requester was recorded.
+func (db *DB) SetItemRequestedBy(itemID int, requestedBy string) (bool, error) {
+	tx, err := db.begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE watchlistitem
+		SET requested_by = $2, updated_at = NOW()
+		WHERE id = $1 AND (
+			COALESCE(requested_by, '') = ''
+			OR EXISTS (SELECT 1 FROM feed_items WHERE watchlist_item_id = $1 AND owns_item)
+		)
+	`, itemID, requestedBy)
+	if err != nil {
+		return false, fmt.Errorf("failed to set requester of item %d: %v", itemID, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to set requester of item %d: %v", itemID, err)
+	}
+	if _, err := tx.Exec(`UPDATE feed_items SET owns_item = false WHERE watchlist_item_id = $1`, itemID); err != nil {
+		return false, fmt.Errorf("failed to disown item %d: %v", itemID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to set requester of item %d: %v", itemID, err)
+	}
+	return rows > 0, nil
+}
+
+// RouteWatchlistItem fills in the requester, library and quality profile a
+// feed routes an existing item with, where the item has none yet. It
+// reports whether the item took the feed's requester.
+func (db *DB) RouteWatchlistItem(itemID int, requestedBy, library, profile string) (bool, error) {
+	var stamped bool
+	err := db.QueryRow(`
+		WITH old AS (
+			SELECT id, COALESCE(requested_by, '') = '' AS unrequested
+			FROM watchlistitem
+			WHERE id = $1
+			FOR UPDATE
+		)
+		UPDATE watchlistitem w
+		SET requested_by = COALESCE(NULLIF(w.requested_by, ''), NULLIF($2, '')),
+			forced_library = COALESCE(NULLIF(w.forced_library, ''), NULLIF($3, '')),
+			quality_profile = COALESCE(NULLIF(w.quality_profile, ''), NULLIF($4, '')),
+			updated_at = NOW()
+		FROM old
+		WHERE w.id = old.id AND (
+			(old.unrequested AND $2 <> '')
+			OR (COALESCE(w.forced_library, '') = '' AND $3 <> '')
+			OR (COALESCE(w.quality_profile, '') = '' AND $4 <> '')
+		)
+		RETURNING old.unrequested AND $2 <> ''
+	`, itemID, requestedBy, library, profile).Scan(&stamped)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to route item %d: %v", itemID, err)
+	}
+	return stamped, nil
+}
+
+// FetcherUpdateWatchlistItem updates an existing watchlist item in the database.
+// status and current_step are owned by the pipeline and are not written here.
 func (db *DB) FetcherUpdateWatchlistItem(item *WatchlistItem) error {
 	query := `
 		UPDATE watchlistitem SET
 			title = $2, item_year = $3, requested_date = $4, link = $5,
 			imdb_id = $6, tmdb_id = $7, tvdb_id = $8, description = $9,
-			category = $10, genres = $11, rating = $12, status = $13,
-			current_step = $14, thumbnail_url = $15, updated_at = $16, 
-			best_scraped_filename = $17, best_scraped_resolution = $18, last_scraped_date = $19, 
-			custom_library = $20, main_library_path = $21, 
-			best_scraped_score = $22, release_date = $23, media_type = $24,
-			total_seasons = $25, total_episodes = $26
+			category = $10, genres = $11, rating = $12,
+			thumbnail_url = $13, updated_at = $14, 
+			best_scraped_filename = $15, best_scraped_resolution = $16, last_scraped_date = $17, 
+			custom_library = $18, main_library_path = $19, 
+			best_scraped_score = $20, release_date = $21, media_type = $22,
+			total_seasons = $23, total_episodes = $24
 		WHERE id = $1
 	`
 
 	_, err := db.Exec(query,
 		item.ID, item.Title, item.ItemYear, item.RequestedDate, item.Link,
 		item.ImdbID, item.TmdbID, item.TvdbID, item.Description,
-		item.Category, item.Genres, item.Rating, item.Status,
-		item.CurrentStep, item.ThumbnailURL, time.Now(), item.BestScrapedFilename,
+		item.Category, item.Genres, item.Rating,
+		item.ThumbnailURL, time.Now(), item.BestScrapedFilename,
 		item.BestScrapedResolution, item.LastScrapedDate, item.CustomLibrary,
 		item.MainLibraryPath, item.BestScrapedScore, item.ReleaseDate, item.MediaType,
 		item.TotalSeasons, item.TotalEpisodes,
@@ -192,7 +288,8 @@ func (db *DB) FetcherUpdateWatchlistItem(item *WatchlistItem) error {
 	return nil
 }
 
-// UpdateWatchlistItem updates an existing watchlist item in the database
+// UpdateWatchlistItem updates the metadata of an existing watchlist item.
+// status and current_step are owned by the pipeline and are not written here.
 func (db *DB) UpdateWatchlistItem(item *WatchlistItem) error {
 	query := `UPDATE watchlistitem SET 
 		tmdb_id = CASE WHEN $1 = '' THEN NULL ELSE $1 END, 
@@ -205,12 +302,10 @@ func (db *DB) UpdateWatchlistItem(item *WatchlistItem) error {
 		total_seasons = $8, 
 		total_episodes = $9,
 		show_status = $10,
-		status = $11,
-		current_step = $12,
-		imdb_id = CASE WHEN $13 = '' THEN NULL ELSE $13 END,
-		tvdb_id = CASE WHEN $14 = '' THEN NULL ELSE $14 END,
-		updated_at = $15
-		WHERE id = $16`
+		imdb_id = CASE WHEN $11 = '' THEN NULL ELSE $11 END,
+		tvdb_id = CASE WHEN $12 = '' THEN NULL ELSE $12 END,
+		updated_at = $13
+		WHERE id = $14`
 
 	_, err := db.Exec(query,
 		item.TmdbID.String,
@@ -223,8 +318,6 @@ func (db *DB) UpdateWatchlistItem(item *WatchlistItem) error {
 		item.TotalSeasons.Int32,
 		item.TotalEpisodes.Int32,
 		item.ShowStatus.String,
-		item.Status.String,
-		item.CurrentStep.String,
 		item.ImdbID.String,
 		item.TvdbID.String,
 		time.Now(),
@@ -240,9 +333,9 @@ func (db *DB) GetNextItemForScraping() (*WatchlistItem, error) {
 			   description, category, genres, rating, status, current_step, thumbnail_url,
 			   created_at, updated_at, best_scraped_filename, best_scraped_resolution,
 			   last_scraped_date, custom_library, main_library_path, best_scraped_score,
-			   media_type, total_seasons, total_episodes, release_date
+			   media_type, total_seasons, total_episodes, release_date, forced_library, quality_profile
 		FROM watchlistitem
-		WHERE status = 'new' OR status = 'scrape_failed'
+		WHERE current_step = 'scrape_pending'` + queueFilter("watchlistitem", "scraper") + `
 		ORDER BY id ASC
 		LIMIT 1
 	`
@@ -253,7 +346,7 @@ func (db *DB) GetNextItemForScraping() (*WatchlistItem, error) {
 		&item.Genres, &item.Rating, &item.Status, &item.CurrentStep, &item.ThumbnailURL,
 		&item.CreatedAt, &item.UpdatedAt, &item.BestScrapedFilename, &item.BestScrapedResolution,
 		&item.LastScrapedDate, &item.CustomLibrary, &item.MainLibraryPath, &item.BestScrapedScore,
-		&item.MediaType, &item.TotalSeasons, &item.TotalEpisodes, &item.ReleaseDate,
+		&item.MediaType, &item.TotalSeasons, &item.TotalEpisodes, &item.ReleaseDate, &item.ForcedLibrary, &item.QualityProfile,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -271,9 +364,9 @@ func (db *DB) GetNextItemForLibraryMatching() (*WatchlistItem, error) {
 			   description, category, genres, rating, status, current_step, thumbnail_url,
 			   created_at, updated_at, best_scraped_filename, best_scraped_resolution,
 			   last_scraped_date, custom_library, main_library_path, best_scraped_score,
-			   media_type, total_seasons, total_episodes, release_date
+			   media_type, total_seasons, total_episodes, release_date, forced_library, quality_profile
 		FROM watchlistitem
-		WHERE status IN ('new', 'ready_for_matching')
+		WHERE current_step = 'librarymatch_pending'` + queueFilter("watchlistitem", "librarymatcher") + `
 		ORDER BY requested_date ASC
 		LIMIT 1
 	`
@@ -284,7 +377,7 @@ func (db *DB) GetNextItemForLibraryMatching() (*WatchlistItem, error) {
 		&item.Genres, &item.Rating, &item.Status, &item.CurrentStep, &item.ThumbnailURL,
 		&item.CreatedAt, &item.UpdatedAt, &item.BestScrapedFilename, &item.BestScrapedResolution,
 		&item.LastScrapedDate, &item.CustomLibrary, &item.MainLibraryPath, &item.BestScrapedScore,
-		&item.MediaType, &item.TotalSeasons, &item.TotalEpisodes, &item.ReleaseDate,
+		&item.MediaType, &item.TotalSeasons, &item.TotalEpisodes, &item.ReleaseDate, &item.ForcedLibrary, &item.QualityProfile,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil // No items available
@@ -302,9 +395,9 @@ func (db *DB) GetNextItemForSymlinking() (*WatchlistItem, error) {
 			   w.description, w.category, w.genres, w.rating, w.status, w.current_step, w.thumbnail_url,
 			   w.created_at, w.updated_at, w.best_scraped_filename, w.best_scraped_resolution,
 			   w.last_scraped_date, w.custom_library, w.main_library_path, w.best_scraped_score,
-			   w.release_date, w.media_type, w.total_seasons, w.total_episodes
+			   w.release_date, w.media_type, w.total_seasons, w.total_episodes, w.forced_library, w.quality_profile
 		FROM watchlistitem w
-		WHERE w.status = 'downloaded'
+		WHERE w.current_step = 'symlink_pending'` + queueFilter("w", "symlinker") + `
 		ORDER BY w.id ASC
 		LIMIT 1
 	`
@@ -315,7 +408,7 @@ func (db *DB) GetNextItemForSymlinking() (*WatchlistItem, error) {
 		&item.Genres, &item.Rating, &item.Status, &item.CurrentStep, &item.ThumbnailURL,
 		&item.CreatedAt, &item.UpdatedAt, &item.BestScrapedFilename, &item.BestScrapedResolution,
 		&item.LastScrapedDate, &item.CustomLibrary, &item.MainLibraryPath, &item.BestScrapedScore,
-		&item.ReleaseDate, &item.MediaType, &item.TotalSeasons, &item.TotalEpisodes,
+		&item.ReleaseDate, &item.MediaType, &item.TotalSeasons, &item.TotalEpisodes, &item.ForcedLibrary, &item.QualityProfile,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -333,7 +426,7 @@ func (db *DB) GetAllWatchlistItems() ([]WatchlistItem, error) {
 			   description, category, genres, rating, status, current_step, thumbnail_url,
 			   created_at, updated_at, best_scraped_filename, best_scraped_resolution,
 			   last_scraped_date, custom_library, main_library_path, best_scraped_score,
-			   media_type, total_seasons, total_episodes, release_date
+			   media_type, total_seasons, total_episodes, release_date, forced_library, quality_profile
 		FROM watchlistitem
 		ORDER BY id ASC
 	`
@@ -352,7 +445,7 @@ func (db *DB) GetAllWatchlistItems() ([]WatchlistItem, error) {
 			&item.Genres, &item.Rating, &item.Status, &item.CurrentStep, &item.ThumbnailURL,
 			&item.CreatedAt, &item.UpdatedAt, &item.BestScrapedFilename, &item.BestScrapedResolution,
 			&item.LastScrapedDate, &item.CustomLibrary, &item.MainLibraryPath, &item.BestScrapedScore,
-			&item.MediaType, &item.TotalSeasons, &item.TotalEpisodes, &item.ReleaseDate,
+			&item.MediaType, &item.TotalSeasons, &item.TotalEpisodes, &item.ReleaseDate, &item.ForcedLibrary, &item.QualityProfile,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning watchlist item: %v", err)
@@ -373,7 +466,7 @@ func (db *DB) FindWatchlistItemByIDs(imdbID, tmdbID, tvdbID string) (*WatchlistI
 	exactQuery := `SELECT id, title, item_year, requested_date, link, imdb_id, tmdb_id, tvdb_id, 
 		description, category, genres, rating, status, current_step, thumbnail_url, created_at, 
 		updated_at, best_scraped_filename, best_scraped_resolution, last_scraped_date, custom_library, 
-		main_library_path, best_scraped_score, media_type, total_seasons, total_episodes, release_date, show_status
+		main_library_path, best_scraped_score, media_type, total_seasons, total_episodes, release_date, show_status, forced_library, quality_profile
 		FROM watchlistitem 
 		WHERE 
 		($1 = '' OR imdb_id = $1) AND 
@@ -388,7 +481,7 @@ func (db *DB) FindWatchlistItemByIDs(imdbID, tmdbID, tvdbID string) (*WatchlistI
 		&item.Genres, &item.Rating, &item.Status, &item.CurrentStep, &item.ThumbnailURL,
 		&item.CreatedAt, &item.UpdatedAt, &item.BestScrapedFilename, &item.BestScrapedResolution,
 		&item.LastScrapedDate, &item.CustomLibrary, &item.MainLibraryPath, &item.BestScrapedScore,
-		&item.MediaType, &item.TotalSeasons, &item.TotalEpisodes, &item.ReleaseDate, &item.ShowStatus,
+		&item.MediaType, &item.TotalSeasons, &item.TotalEpisodes, &item.ReleaseDate, &item.ShowStatus, &item.ForcedLibrary, &item.QualityProfile,
 	)
 
 	if err != nil && err != sql.ErrNoRows {
@@ -406,7 +499,7 @@ func (db *DB) FindWatchlistItemByIDs(imdbID, tmdbID, tvdbID string) (*WatchlistI
 		imdbQuery := `SELECT id, title, item_year, requested_date, link, imdb_id, tmdb_id, tvdb_id, 
 			description, category, genres, rating, status, current_step, thumbnail_url, created_at, 
 			updated_at, best_scraped_filename, best_scraped_resolution, last_scraped_date, custom_library, 
-			main_library_path, best_scraped_score, media_type, total_seasons, total_episodes, release_date, show_status
+			main_library_path, best_scraped_score, media_type, total_seasons, total_episodes, release_date, show_status, forced_library, quality_profile
 			FROM watchlistitem 
 			WHERE imdb_id = $1
 			LIMIT 1`
@@ -417,7 +510,7 @@ func (db *DB) FindWatchlistItemByIDs(imdbID, tmdbID, tvdbID string) (*WatchlistI
 			&item.Genres, &item.Rating, &item.Status, &item.CurrentStep, &item.ThumbnailURL,
 			&item.CreatedAt, &item.UpdatedAt, &item.BestScrapedFilename, &item.BestScrapedResolution,
 			&item.LastScrapedDate, &item.CustomLibrary, &item.MainLibraryPath, &item.BestScrapedScore,
-			&item.MediaType, &item.TotalSeasons, &item.TotalEpisodes, &item.ReleaseDate, &item.ShowStatus,
+			&item.MediaType, &item.TotalSeasons, &item.TotalEpisodes, &item.ReleaseDate, &item.ShowStatus, &item.ForcedLibrary, &item.QualityProfile,
 		)
 
 		if err == nil {
@@ -431,7 +524,7 @@ func (db *DB) FindWatchlistItemByIDs(imdbID, tmdbID, tvdbID string) (*WatchlistI
 		tmdbQuery := `SELECT id, title, item_year, requested_date, link, imdb_id, tmdb_id, tvdb_id, 
 			description, category, genres, rating, status, current_step, thumbnail_url, created_at, 
 			updated_at, best_scraped_filename, best_scraped_resolution, last_scraped_date, custom_library, 
-			main_library_path, best_scraped_score, media_type, total_seasons, total_episodes, release_date, show_status
+			main_library_path, best_scraped_score, media_type, total_seasons, total_episodes, release_date, show_status, forced_library, quality_profile
 			FROM watchlistitem 
 			WHERE tmdb_id = $1
 			LIMIT 1`
@@ -442,7 +535,7 @@ func (db *DB) FindWatchlistItemByIDs(imdbID, tmdbID, tvdbID string) (*WatchlistI
 			&item.Genres, &item.Rating, &item.Status, &item.CurrentStep, &item.ThumbnailURL,
 			&item.CreatedAt, &item.UpdatedAt, &item.BestScrapedFilename, &item.BestScrapedResolution,
 			&item.LastScrapedDate, &item.CustomLibrary, &item.MainLibraryPath, &item.BestScrapedScore,
-			&item.MediaType, &item.TotalSeasons, &item.TotalEpisodes, &item.ReleaseDate, &item.ShowStatus,
+			&item.MediaType, &item.TotalSeasons, &item.TotalEpisodes, &item.ReleaseDate, &item.ShowStatus, &item.ForcedLibrary, &item.QualityProfile,
 		)
 
 		if err == nil {
@@ -459,10 +552,10 @@ func (db *DB) FindWatchlistItemByIDs(imdbID, tmdbID, tvdbID string) (*WatchlistI
 func (db *DB) UpdateWatchlistItemForLibraryMatching(item *WatchlistItem) error {
 	query := `
 		UPDATE watchlistitem
-		SET custom_library = $1, status = $2, main_library_path = $3, current_step = $4, updated_at = NOW()
-		WHERE id = $5
+		SET custom_library = $1, main_library_path = $2, updated_at = NOW()
+		WHERE id = $3
 	`
-	_, err := db.Exec(query, item.CustomLibrary, item.Status, item.MainLibraryPath, item.CurrentStep, item.ID)
+	_, err := db.Exec(query, item.CustomLibrary, item.MainLibraryPath, item.ID)
 	if err != nil {
 		return fmt.Errorf("failed to update watchlist item: %v", err)
 	}
@@ -504,8 +597,13 @@ type TVEpisode struct {
 	ScrapeResultID sql.NullInt32  `json:"scrape_result_id"`
 }
 
-// Close closes the database connection
+// Close closes the database connection, rolling back a dry run first
 func (db *DB) Close() error {
+	if db.dry != nil {
+		if err := db.dry.rollback(); err != nil {
+			log.Printf("Failed to roll back dry run: %v", err)
+		}
+	}
 	return db.DB.Close()
 }
 
@@ -564,9 +662,9 @@ func (db *DB) GetNextItemForDownload() (*WatchlistItem, error) {
 			   description, category, genres, rating, status, current_step, thumbnail_url,
 			   created_at, updated_at, best_scraped_filename, best_scraped_resolution,
 			   last_scraped_date, custom_library, main_library_path, best_scraped_score,
-			   media_type, total_seasons, total_episodes, release_date
+			   media_type, total_seasons, total_episodes, release_date, forced_library, quality_profile
 		FROM watchlistitem
-		WHERE status = 'ready_for_download'
+		WHERE current_step = 'download_pending'` + queueFilter("watchlistitem", "downloader") + `
 		ORDER BY requested_date ASC
 		LIMIT 1
 	`
@@ -577,7 +675,7 @@ func (db *DB) GetNextItemForDownload() (*WatchlistItem, error) {
 		&item.Genres, &item.Rating, &item.Status, &item.CurrentStep, &item.ThumbnailURL,
 		&item.CreatedAt, &item.UpdatedAt, &item.BestScrapedFilename, &item.BestScrapedResolution,
 		&item.LastScrapedDate, &item.CustomLibrary, &item.MainLibraryPath, &item.BestScrapedScore,
-		&item.MediaType, &item.TotalSeasons, &item.TotalEpisodes, &item.ReleaseDate,
+		&item.MediaType, &item.TotalSeasons, &item.TotalEpisodes, &item.ReleaseDate, &item.ForcedLibrary, &item.QualityProfile,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil // No items available
@@ -594,7 +692,7 @@ func (db *DB) GetWatchlistItemByID(itemID int) (*WatchlistItem, error) {
 			   description, category, genres, rating, status, current_step, thumbnail_url,
 			   created_at, updated_at, best_scraped_filename, best_scraped_resolution,
 			   last_scraped_date, custom_library, main_library_path, best_scraped_score,
-			   media_type, total_seasons, total_episodes, release_date
+			   media_type, total_seasons, total_episodes, release_date, forced_library, quality_profile
 		FROM watchlistitem
 		WHERE id = $1
 	`
@@ -605,7 +703,7 @@ func (db *DB) GetWatchlistItemByID(itemID int) (*WatchlistItem, error) {
 		&item.Genres, &item.Rating, &item.Status, &item.CurrentStep, &item.ThumbnailURL,
 		&item.CreatedAt, &item.UpdatedAt, &item.BestScrapedFilename, &item.BestScrapedResolution,
 		&item.LastScrapedDate, &item.CustomLibrary, &item.MainLibraryPath, &item.BestScrapedScore,
-		&item.MediaType, &item.TotalSeasons, &item.TotalEpisodes, &item.ReleaseDate,
+		&item.MediaType, &item.TotalSeasons, &item.TotalEpisodes, &item.ReleaseDate, &item.ForcedLibrary, &item.QualityProfile,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil // No item found
@@ -654,7 +752,7 @@ func (db *DB) FindWatchlistItemByTitleAndYear(title string, year int64) (*Watchl
 			   description, category, genres, rating, status, current_step, thumbnail_url,
 			   created_at, updated_at, best_scraped_filename, best_scraped_resolution,
 			   last_scraped_date, custom_library, main_library_path, best_scraped_score,
-			   media_type, total_seasons, total_episodes, release_date, show_status
+			   media_type, total_seasons, total_episodes, release_date, show_status, forced_library, quality_profile
 		FROM watchlistitem
 		WHERE title = $1 AND item_year = $2
 		LIMIT 1
@@ -689,6 +787,8 @@ func (db *DB) FindWatchlistItemByTitleAndYear(title string, year int64) (*Watchl
 		&item.TotalEpisodes,
 		&item.ReleaseDate,
 		&item.ShowStatus,
+		&item.ForcedLibrary,
+		&item.QualityProfile,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -727,6 +827,53 @@ func (db *DB) DeleteWatchlistItemByTitleAndYear(title string, year int64) error
 	return nil
 }
 
+// DeleteWatchlistItem removes an item that is still in step and that no stage
+// holds a lease on, with everything recorded about it. The returned bool is
+// false when the item moved on or is being worked on.
+func (db *DB) DeleteWatchlistItem(itemID int, step, reason string) (bool, error) {
+	tx, err := db.begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// scrape_results has a second foreign key without ON DELETE CASCADE
+	if _, err := tx.Exec(`DELETE FROM scrape_results WHERE watchlist_item_id = $1`, itemID); err != nil {
+		return false, fmt.Errorf("failed to delete scrape results: %v", err)
+	}
+	result, err := tx.Exec(`
+		DELETE FROM watchlistitem w
+		WHERE w.id = $1 AND w.current_step = $2
+		AND NOT EXISTS (
+			SELECT 1 FROM job_leases l
+			WHERE l.watchlist_item_id = w.id AND l.expires_at > NOW()
+		)
+	`, itemID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete item %d: %v", itemID, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to read affected rows: %v", err)
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	payload, err := Event{Type: EventRemoved, ItemID: itemID, From: step, Reason: reason}.payload()
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`SELECT pg_notify($1, $2)`, EventsChannel, payload); err != nil {
+		return false, fmt.Errorf("failed to publish removal: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit removal: %v", err)
+	}
+	return true, nil
+}
+
 func (db *DB) InsertSeason(watchlistItemID int, seasonNumber int, episodeCount int, airDate time.Time) (int, error) {
 	var seasonID int
 	// Check if the season already exists
@@ -983,13 +1130,12 @@ func (db *DB) SaveScrapeResult(result *ScrapeResult) (int, error) {
 	return id, nil
 }
 
-// GetItemsForTMDB returns a list of item IDs that need TMDB metadata. Items are selected if:
-// 1. They have status = 'new'
+// GetItemsForTMDB returns a list of item IDs that are waiting for TMDB metadata
 func (db *DB) GetItemsForTMDB() ([]int, error) {
 	query := `
 		SELECT DISTINCT id 
 		FROM watchlistitem
-		WHERE status = 'new'
+		WHERE current_step = 'indexing_pending'` + queueFilter("watchlistitem", "tmdb_indexer") + `
 		ORDER BY id ASC
 	`
 	return db.getItemIDs(query)
@@ -1000,9 +1146,7 @@ func (db *DB) GetItemsForScraper() ([]int, error) {
 	query := `
 		SELECT id
 		FROM watchlistitem
-		WHERE (best_scraped_score IS NULL OR best_scraped_score < 100)
-		AND status != 'completed'
-		AND tmdb_id IS NOT NULL
+		WHERE current_step = 'scrape_pending'` + queueFilter("watchlistitem", "scraper") + `
 		ORDER BY created_at DESC
 	`
 	return db.getItemIDs(query)
@@ -1011,12 +1155,9 @@ func (db *DB) GetItemsForScraper() ([]int, error) {
 // GetItemsForDownloader returns a list of item IDs that need downloading
 func (db *DB) GetItemsForDownloader() ([]int, error) {
 	query := `
-		SELECT DISTINCT w.id
+		SELECT w.id
 		FROM watchlistitem w
-		INNER JOIN scrape_results sr ON w.id = sr.watchlist_item_id
-		WHERE w.status != 'completed'
-		AND sr.info_hash IS NOT NULL
-		AND sr.downloaded = false
+		WHERE w.current_step = 'download_pending'` + queueFilter("w", "downloader") + `
 		ORDER BY w.created_at DESC
 	`
 	return db.getItemIDs(query)
@@ -1027,9 +1168,7 @@ func (db *DB) GetItemsForLibraryMatcher() ([]int, error) {
 	query := `
 		SELECT id
 		FROM watchlistitem
-		WHERE (custom_library IS NULL OR main_library_path IS NULL)
-		AND status != 'completed'
-		AND tmdb_id IS NOT NULL
+		WHERE current_step = 'librarymatch_pending'` + queueFilter("watchlistitem", "librarymatcher") + `
 		ORDER BY created_at DESC
 	`
 	return db.getItemIDs(query)
@@ -1096,7 +1235,7 @@ func (db *DB) FindWatchlistItemByIMDBID(imdbID string) (*WatchlistItem, error) {
 			   description, category, genres, rating, status, current_step, thumbnail_url,
 			   created_at, updated_at, best_scraped_filename, best_scraped_resolution,
 			   last_scraped_date, custom_library, main_library_path, best_scraped_score,
-			   media_type, total_seasons, total_episodes, release_date, show_status
+			   media_type, total_seasons, total_episodes, release_date, show_status, forced_library, quality_profile
 		FROM watchlistitem
 		WHERE imdb_id = $1
 		LIMIT 1
@@ -1131,6 +1270,8 @@ func (db *DB) FindWatchlistItemByIMDBID(imdbID string) (*WatchlistItem, error) {
 		&item.TotalEpisodes,
 		&item.ReleaseDate,
 		&item.ShowStatus,
+		&item.ForcedLibrary,
+		&item.QualityProfile,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -1148,7 +1289,7 @@ func (db *DB) FindWatchlistItemByTMDBID(tmdbID string) (*WatchlistItem, error) {
 			   description, category, genres, rating, status, current_step, thumbnail_url,
 			   created_at, updated_at, best_scraped_filename, best_scraped_resolution,
 			   last_scraped_date, custom_library, main_library_path, best_scraped_score,
-			   media_type, total_seasons, total_episodes, release_date
+			   media_type, total_seasons, total_episodes, release_date, forced_library, quality_profile
 		FROM watchlistitem
 		WHERE tmdb_id = $1
 		LIMIT 1
@@ -1182,6 +1323,8 @@ func (db *DB) FindWatchlistItemByTMDBID(tmdbID string) (*WatchlistItem, error) {
 		&item.TotalSeasons,
 		&item.TotalEpisodes,
 		&item.ReleaseDate,
+		&item.ForcedLibrary,
+		&item.QualityProfile,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -1199,7 +1342,7 @@ func (db *DB) FindWatchlistItemByTVDBID(tvdbID string) (*WatchlistItem, error) {
 			   description, category, genres, rating, status, current_step, thumbnail_url,
 			   created_at, updated_at, best_scraped_filename, best_scraped_resolution,
 			   last_scraped_date, custom_library, main_library_path, best_scraped_score,
-			   media_type, total_seasons, total_episodes, release_date
+			   media_type, total_seasons, total_episodes, release_date, forced_library, quality_profile
 		FROM watchlistitem
 		WHERE tvdb_id = $1
 		LIMIT 1
@@ -1233,6 +1376,8 @@ func (db *DB) FindWatchlistItemByTVDBID(tvdbID string) (*WatchlistItem, error) {
 		&item.TotalSeasons,
 		&item.TotalEpisodes,
 		&item.ReleaseDate,
+		&item.ForcedLibrary,
+		&item.QualityProfile,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -1281,20 +1426,125 @@ func (db *DB) SaveEpisode(episode *Episode) error {
 
 // GetItemsByStatus retrieves all items with a specific status
 func (db *DB) GetItemsByStatus(status string) ([]*WatchlistItem, error) {
+	return db.getItemsWhere("status = $1", status)
+}
+
+// GetItemsByStep retrieves all items whose current_step is step
+func (db *DB) GetItemsByStep(step string) ([]*WatchlistItem, error) {
+	return db.getItemsWhere("current_step = $1", step)
+}
+
+// GetQueuedItems retrieves the items waiting in step that stage may work on
+// now: nobody holds a lease on them and their next retry is due
+func (db *DB) GetQueuedItems(stage, step string) ([]*WatchlistItem, error) {
+	return db.getItemsWhere("current_step = $1"+queueFilter("watchlistitem", stage), step)
+}
+
+// ItemFilter narrows down ListWatchlistItems. Empty fields match everything.
+type ItemFilter struct {
+	Status        string
+	Step          string
+	MediaType     string
+	CustomLibrary string // one of the comma separated libraries the item matched
+	Search        string // part of the title, case insensitive
+	Limit         int
+	Offset        int
+}
+
+// ListWatchlistItems returns the items matching filter, oldest first
+func (db *DB) ListWatchlistItems(filter ItemFilter) ([]*WatchlistItem, error) {
+	conditions := []string{"TRUE"}
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Status != "" {
+		add("status = $%d", filter.Status)
+	}
+	if filter.Step != "" {
+		add("current_step = $%d", filter.Step)
+	}
+	if filter.MediaType != "" {
+		add("media_type = $%d", filter.MediaType)
+	}
+	if filter.CustomLibrary != "" {
+		add("$%d = ANY(string_to_array(custom_library, ','))", filter.CustomLibrary)
+	}
+	if filter.Search != "" {
+		add("title ILIKE '%%' || $%d || '%%'", filter.Search)
+	}
+
+	condition := strings.Join(conditions, " AND ")
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit, filter.Offset)
+		return db.queryItems(condition+fmt.Sprintf(" ORDER BY id LIMIT $%d OFFSET $%d", len(args)-1, len(args)), args...)
+	}
+	return db.getItemsWhere(condition, args...)
+}
+
+// CountItemsByStep returns how many items are in each current_step
+func (db *DB) CountItemsByStep() (map[string]int, error) {
+	rows, err := db.Query(`
+		SELECT COALESCE(current_step, ''), COUNT(*)
+		FROM watchlistitem
+		GROUP BY current_step
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error counting items: %v", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var step string
+		var count int
+		if err := rows.Scan(&step, &count); err != nil {
+			return nil, fmt.Errorf("error scanning item count: %v", err)
+		}
+		counts[step] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating item counts: %v", err)
+	}
+	return counts, nil
+}
+
+// CountQueuedItems returns how many items GetQueuedItems would return
+func (db *DB) CountQueuedItems(stage, step string) (int, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*)
+		FROM watchlistitem
+		WHERE current_step = $1`+queueFilter("watchlistitem", stage), step).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting queued items: %v", err)
+	}
+	return count, nil
+}
+
+// getItemsWhere runs the shared item select with the given condition
+func (db *DB) getItemsWhere(condition string, args ...interface{}) ([]*WatchlistItem, error) {
+	return db.queryItems(condition+" ORDER BY id", args...)
+}
+
+// queryItems runs the shared item select. tail is everything after WHERE.
+func (db *DB) queryItems(tail string, args ...interface{}) ([]*WatchlistItem, error) {
 	query := `
 		SELECT 
 			id, title, item_year, requested_date, link, imdb_id, tmdb_id, tvdb_id,
 			description, category, genres, rating, status, thumbnail_url, created_at,
 			updated_at, best_scraped_filename, best_scraped_resolution, last_scraped_date,
 			custom_library, main_library_path, best_scraped_score, media_type, total_seasons,
-			total_episodes, release_date, retry_count, show_status, current_step
+			total_episodes, release_date, retry_count, show_status, current_step, requested_by,
+			forced_library, quality_profile
 		FROM watchlistitem 
-		WHERE status = $1 OR current_step = $1
-		ORDER BY id`
+		WHERE ` + tail
 
-	rows, err := db.Query(query, status)
+	rows, err := db.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("error querying items by status: %v", err)
+		return nil, fmt.Errorf("error querying items: %v", err)
 	}
 	defer rows.Close()
 
@@ -1309,6 +1559,7 @@ func (db *DB) GetItemsByStatus(status string) ([]*WatchlistItem, error) {
 			&item.LastScrapedDate, &item.CustomLibrary, &item.MainLibraryPath,
 			&item.BestScrapedScore, &item.MediaType, &item.TotalSeasons, &item.TotalEpisodes,
 			&item.ReleaseDate, &item.RetryCount, &item.ShowStatus, &item.CurrentStep,
+			&item.RequestedBy, &item.ForcedLibrary, &item.QualityProfile,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning item: %v", err)
@@ -1332,7 +1583,7 @@ func (db *DB) GetReturningSeriesWithUnscrapedEpisodes() ([]*WatchlistItem, error
 			   w.status, w.current_step, w.thumbnail_url, w.created_at, w.updated_at, 
 			   w.best_scraped_filename, w.best_scraped_resolution, w.last_scraped_date, 
 			   w.custom_library, w.main_library_path, w.best_scraped_score, w.media_type, 
-			   w.total_seasons, w.total_episodes, w.release_date, w.show_status
+			   w.total_seasons, w.total_episodes, w.release_date, w.show_status, w.forced_library, w.quality_profile
 		FROM watchlistitem w
 		JOIN seasons s ON s.watchlist_item_id = w.id
 		JOIN tv_episodes e ON e.season_id = s.id
@@ -1357,7 +1608,7 @@ func (db *DB) GetReturningSeriesWithUnscrapedEpisodes() ([]*WatchlistItem, error
 			&item.Genres, &item.Rating, &item.Status, &item.CurrentStep, &item.ThumbnailURL,
 			&item.CreatedAt, &item.UpdatedAt, &item.BestScrapedFilename, &item.BestScrapedResolution,
 			&item.LastScrapedDate, &item.CustomLibrary, &item.MainLibraryPath, &item.BestScrapedScore,
-			&item.MediaType, &item.TotalSeasons, &item.TotalEpisodes, &item.ReleaseDate, &item.ShowStatus,
+			&item.MediaType, &item.TotalSeasons, &item.TotalEpisodes, &item.ReleaseDate, &item.ShowStatus, &item.ForcedLibrary, &item.QualityProfile,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning returning series: %v", err)
@@ -1367,3 +1618,22 @@ func (db *DB) GetReturningSeriesWithUnscrapedEpisodes() ([]*WatchlistItem, error
 
 	return items, nil
 }
+
+// HasUnscrapedEpisodes reports whether a series has aired episodes that have not been scraped yet
+func (db *DB) HasUnscrapedEpisodes(watchlistItemID int) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM seasons s
+			JOIN tv_episodes e ON e.season_id = s.id
+			WHERE s.watchlist_item_id = $1
+			AND e.air_date <= CURRENT_DATE
+			AND e.scraped = false
+		)
+	`
+	var unscraped bool
+	if err := db.QueryRow(query, watchlistItemID).Scan(&unscraped); err != nil {
+		return false, fmt.Errorf("error checking unscraped episodes: %v", err)
+	}
+	return unscraped, nil
+}
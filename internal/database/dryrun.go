@@ -0,0 +1,193 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// dryRun runs every statement of a DB in one transaction that is never
+// committed. Each statement gets its own savepoint, so a failing statement
+// does not abort the rest of the dry run.
+type dryRun struct {
+	mu         sync.Mutex
+	tx         *sql.Tx
+	savepoints int
+	pending    bool // the savepoint of the last query is still open
+}
+
+// txn is the part of a transaction the database methods use
+type txn interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Commit() error
+	Rollback() error
+}
+
+// BeginDryRun returns a DB whose writes all go to a transaction that is
+// rolled back when it is closed
+func (db *DB) BeginDryRun() (*DB, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin dry-run transaction: %v", err)
+	}
+	return &DB{DB: db.DB, dsn: db.dsn, dry: &dryRun{tx: tx}}, nil
+}
+
+// IsDryRun reports whether the DB rolls back everything it writes
+func (db *DB) IsDryRun() bool {
+	return db.dry != nil
+}
+
+// Exec runs a statement on the pool, or in the dry-run transaction
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	if db.dry == nil {
+		return db.DB.Exec(query, args...)
+	}
+	return db.dry.exec(query, args...)
+}
+
+// Query runs a query on the pool, or in the dry-run transaction
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	if db.dry == nil {
+		return db.DB.Query(query, args...)
+	}
+	return db.dry.query(query, args...)
+}
+
+// QueryRow runs a single row query on the pool, or in the dry-run transaction
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	if db.dry == nil {
+		return db.DB.QueryRow(query, args...)
+	}
+	return db.dry.queryRow(query, args...)
+}
+
+// begin starts a transaction, which is a savepoint in a dry run
+func (db *DB) begin() (txn, error) {
+	if db.dry == nil {
+		return db.DB.Begin()
+	}
+	return db.dry.savepoint()
+}
+
+func (d *dryRun) exec(query string, args ...interface{}) (sql.Result, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.settle()
+	if _, err := d.tx.Exec("SAVEPOINT dry_run_stmt"); err != nil {
+		return nil, fmt.Errorf("dry run: %v", err)
+	}
+	result, err := d.tx.Exec(query, args...)
+	d.end(err != nil)
+	return result, err
+}
+
+func (d *dryRun) query(query string, args ...interface{}) (*sql.Rows, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.settle()
+	if _, err := d.tx.Exec("SAVEPOINT dry_run_stmt"); err != nil {
+		return nil, fmt.Errorf("dry run: %v", err)
+	}
+	rows, err := d.tx.Query(query, args...)
+	if err != nil {
+		d.end(true)
+		return nil, err
+	}
+	// The rows are read after we return, so the savepoint ends with the next statement
+	d.pending = true
+	return rows, nil
+}
+
+func (d *dryRun) queryRow(query string, args ...interface{}) *sql.Row {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.settle()
+	// If the savepoint fails the query fails the same way and Scan reports it
+	d.tx.Exec("SAVEPOINT dry_run_stmt")
+	d.pending = true
+	return d.tx.QueryRow(query, args...)
+}
+
+// settle ends the savepoint of the last query. Reading its rows may have
+// failed and aborted the transaction, which rolling back to it undoes.
+func (d *dryRun) settle() {
+	if !d.pending {
+		return
+	}
+	d.pending = false
+	if _, err := d.tx.Exec("RELEASE SAVEPOINT dry_run_stmt"); err != nil {
+		d.end(true)
+	}
+}
+
+// end releases the statement savepoint, undoing the statement if it failed
+func (d *dryRun) end(failed bool) {
+	if failed {
+		d.tx.Exec("ROLLBACK TO SAVEPOINT dry_run_stmt")
+	}
+	d.tx.Exec("RELEASE SAVEPOINT dry_run_stmt")
+}
+
+// savepoint starts a nested transaction within the dry run
+func (d *dryRun) savepoint() (txn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.settle()
+	d.savepoints++
+	name := fmt.Sprintf("dry_run_tx_%d", d.savepoints)
+	if _, err := d.tx.Exec("SAVEPOINT " + name); err != nil {
+		return nil, fmt.Errorf("dry run: %v", err)
+	}
+	return &dryRunTx{dry: d, name: name}, nil
+}
+
+func (d *dryRun) rollback() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.tx.Rollback()
+}
+
+// dryRunTx is a transaction nested in a dry run as a savepoint
+type dryRunTx struct {
+	dry  *dryRun
+	name string
+	done bool
+}
+
+func (t *dryRunTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.dry.exec(query, args...)
+}
+
+func (t *dryRunTx) Commit() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+
+	t.dry.mu.Lock()
+	defer t.dry.mu.Unlock()
+	t.dry.settle()
+	_, err := t.dry.tx.Exec("RELEASE SAVEPOINT " + t.name)
+	return err
+}
+
+func (t *dryRunTx) Rollback() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+
+	t.dry.mu.Lock()
+	defer t.dry.mu.Unlock()
+	t.dry.settle()
+	if _, err := t.dry.tx.Exec("ROLLBACK TO SAVEPOINT " + t.name); err != nil {
+		return err
+	}
+	_, err := t.dry.tx.Exec("RELEASE SAVEPOINT " + t.name)
+	return err
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// EventsChannel is the NOTIFY channel pipeline events are published on, so
+// that serve sees what stage commands running as other processes do
+const EventsChannel = "mye_r_events"
+
+// Types of pipeline events
+const (
+	EventTransition       = "transition"        // an item changed state
+	EventScrape           = "scrape"            // the scraper chose a release, or found none
+	EventDownloadProgress = "download_progress" // Real-Debrid reported progress on a torrent
+	EventRemoved          = "removed"           // an item was taken off the watchlist
+)
+
+// maxEventReason keeps payloads well below the 8000 bytes NOTIFY takes
+const maxEventReason = 1000
+
+// Event is something that happened to an item in the pipeline
+type Event struct {
+	Type   string                 `json:"type"`
+	ItemID int                    `json:"item_id"`
+	Stage  string                 `json:"stage,omitempty"`
+	From   string                 `json:"from,omitempty"`
+	To     string                 `json:"to,omitempty"`
+	Reason string                 `json:"reason,omitempty"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+	Time   time.Time              `json:"time"`
+}
+
+// payload encodes an event for pg_notify
+func (e Event) payload() (string, error) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if runes := []rune(e.Reason); len(runes) > maxEventReason {
+		e.Reason = string(runes[:maxEventReason]) + "..."
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode event: %v", err)
+	}
+	return string(data), nil
+}
+
+// PublishEvent notifies listeners of EventsChannel. Like any NOTIFY it is
+// only delivered once the surrounding transaction commits, so dry runs
+// publish nothing.
+func (db *DB) PublishEvent(event Event) error {
+	payload, err := event.payload()
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`SELECT pg_notify($1, $2)`, EventsChannel, payload); err != nil {
+		return fmt.Errorf("failed to publish event: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// FeedItem is a GUID a watchlist feed contains, or contained until
+// MissingSince
+type FeedItem struct {
+	FeedURL         string
+	GUID            string
+	WatchlistItemID int // 0 when no item could be added for it
+	FirstSeen       time.Time
+	LastSeen        time.Time
+	MissingSince    sql.NullTime
+}
+
+// FeedEntry is what a feed entry was added to the watchlist as
+type FeedEntry struct {
+	ItemID int  // 0 when no item could be added for it
+	Owns   bool // the feed stamped its requester on the item
+}
+
+// FeedItemIDs returns the GUIDs of a feed that were added as an item, with
+// their item. Entries no item could be added for are left out, so they are
+// tried again.
+func (db *DB) FeedItemIDs(feedURL string) (map[string]int, error) {
+	rows, err := db.Query(`
+		SELECT guid, watchlist_item_id
+		FROM feed_items
+		WHERE feed_url = $1 AND watchlist_item_id IS NOT NULL
+	`, feedURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get feed items of %s: %v", feedURL, err)
+	}
+	defer rows.Close()
+
+	items := make(map[string]int)
+	for rows.Next() {
+		var guid string
+		var itemID int
+		if err := rows.Scan(&guid, &itemID); err != nil {
+			return nil, fmt.Errorf("failed to scan feed item: %v", err)
+		}
+		items[guid] = itemID
+	}
+	return items, rows.Err()
+}
+
+// SyncFeedItems records the GUIDs a feed contains now, each with the item it
+// stands for, and marks the ones it no longer has as missing. A feed keeps
+// owning the items it owned. It returns every entry of the feed that is
+// missing, since this fetch or earlier.
+func (db *DB) SyncFeedItems(feedURL string, items map[string]FeedEntry) ([]FeedItem, error) {
+	tx, err := db.begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	guids := make([]string, 0, len(items))
+	for guid, entry := range items {
+		guids = append(guids, guid)
+		_, err := tx.Exec(`
+			INSERT INTO feed_items (feed_url, guid, watchlist_item_id, owns_item)
+			VALUES ($1, $2, NULLIF($3, 0), $4)
+			ON CONFLICT (feed_url, guid) DO UPDATE
+			SET watchlist_item_id = COALESCE(EXCLUDED.watchlist_item_id, feed_items.watchlist_item_id),
+				owns_item = feed_items.owns_item OR EXCLUDED.owns_item,
+				last_seen = NOW(),
+				missing_since = NULL
+		`, feedURL, guid, entry.ItemID, entry.Owns)
+		if err != nil {
+			return nil, fmt.Errorf("failed to record %s: %v", guid, err)
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE feed_items
+		SET missing_since = NOW()
+		WHERE feed_url = $1 AND missing_since IS NULL AND NOT (guid = ANY($2))
+	`, feedURL, pq.Array(guids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark missing feed items: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit feed items: %v", err)
+	}
+	return db.MissingFeedItems(feedURL)
+}
+
+// MissingFeedItems returns the entries a feed dropped, oldest first
+func (db *DB) MissingFeedItems(feedURL string) ([]FeedItem, error) {
+	rows, err := db.Query(`
+		SELECT feed_url, guid, COALESCE(watchlist_item_id, 0), first_seen, last_seen, missing_since
+		FROM feed_items
+		WHERE feed_url = $1 AND missing_since IS NOT NULL
+		ORDER BY missing_since ASC
+	`, feedURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get missing feed items: %v", err)
+	}
+	defer rows.Close()
+
+	var missing []FeedItem
+	for rows.Next() {
+		var item FeedItem
+		if err := rows.Scan(&item.FeedURL, &item.GUID, &item.WatchlistItemID, &item.FirstSeen, &item.LastSeen, &item.MissingSince); err != nil {
+			return nil, fmt.Errorf("failed to scan feed item: %v", err)
+		}
+		missing = append(missing, item)
+	}
+	return missing, rows.Err()
+}
+
+// FeedItemListed reports whether any feed still contains an item
+func (db *DB) FeedItemListed(itemID int) (bool, error) {
+	var listed bool
+	err := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM feed_items
+			WHERE watchlist_item_id = $1 AND missing_since IS NULL
+		)
+	`, itemID).Scan(&listed)
+	if err != nil {
+		return false, fmt.Errorf("failed to check feeds of item %d: %v", itemID, err)
+	}
+	return listed, nil
+}
+
+// FeedOwnsItem reports whether the requester of an item was stamped by a
+// feed, whether or not the feed still contains it
+func (db *DB) FeedOwnsItem(itemID int) (bool, error) {
+	var owned bool
+	err := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM feed_items
+			WHERE watchlist_item_id = $1 AND owns_item
+		)
+	`, itemID).Scan(&owned)
+	if err != nil {
+		return false, fmt.Errorf("failed to check feeds of item %d: %v", itemID, err)
+	}
+	return owned, nil
+}
+
+// DeleteFeedItem forgets a GUID of a feed
+func (db *DB) DeleteFeedItem(feedURL, guid string) error {
+	if _, err := db.Exec(`DELETE FROM feed_items WHERE feed_url = $1 AND guid = $2`, feedURL, guid); err != nil {
+		return fmt.Errorf("failed to delete feed item %s: %v", guid, err)
+	}
+	return nil
+}
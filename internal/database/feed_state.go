@@ -0,0 +1,87 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// FeedState is what mye-r remembers about a watchlist feed between fetches
+type FeedState struct {
+	URL           string
+	ETag          string
+	LastModified  string
+	LastSuccessAt sql.NullTime
+	LastError     sql.NullString
+	LastErrorAt   sql.NullTime
+	Failures      int // since the last success
+	NextAttemptAt sql.NullTime
+}
+
+// GetFeedState returns the state of a feed, empty for feeds never fetched
+func (db *DB) GetFeedState(url string) (*FeedState, error) {
+	state := FeedState{URL: url}
+	err := db.QueryRow(`
+		SELECT etag, last_modified, last_success_at, last_error, last_error_at, failures, next_attempt_at
+		FROM feed_state
+		WHERE url = $1
+	`, url).Scan(&state.ETag, &state.LastModified, &state.LastSuccessAt,
+		&state.LastError, &state.LastErrorAt, &state.Failures, &state.NextAttemptAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get state of feed %s: %v", url, err)
+	}
+	return &state, nil
+}
+
+// RecordFeedSuccess stores the validators of a feed that answered and clears
+// its failures
+func (db *DB) RecordFeedSuccess(url, etag, lastModified string) error {
+	_, err := db.Exec(`
+		INSERT INTO feed_state (url, etag, last_modified, last_success_at, updated_at)
+		VALUES ($1, $2, $3, NOW(), NOW())
+		ON CONFLICT (url) DO UPDATE
+		SET etag = EXCLUDED.etag,
+			last_modified = EXCLUDED.last_modified,
+			last_success_at = EXCLUDED.last_success_at,
+			failures = 0,
+			next_attempt_at = NULL,
+			updated_at = EXCLUDED.updated_at
+	`, url, etag, lastModified)
+	if err != nil {
+		return fmt.Errorf("failed to record success of feed %s: %v", url, err)
+	}
+	return nil
+}
+
+// RecordFeedFailure counts a failed fetch of a feed and returns the number of
+// failures since it last answered
+func (db *DB) RecordFeedFailure(url, lastError string) (int, error) {
+	var failures int
+	err := db.QueryRow(`
+		INSERT INTO feed_state (url, last_error, last_error_at, failures, updated_at)
+		VALUES ($1, $2, NOW(), 1, NOW())
+		ON CONFLICT (url) DO UPDATE
+		SET last_error = EXCLUDED.last_error,
+			last_error_at = EXCLUDED.last_error_at,
+			failures = feed_state.failures + 1,
+			updated_at = EXCLUDED.updated_at
+		RETURNING failures
+	`, url, lastError).Scan(&failures)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record failure of feed %s: %v", url, err)
+	}
+	return failures, nil
+}
+
+// SetFeedNextAttempt sets when a failed feed is fetched again
+func (db *DB) SetFeedNextAttempt(url string, next time.Time) error {
+	_, err := db.Exec(`
+		UPDATE feed_state
+		SET next_attempt_at = $2, updated_at = NOW()
+		WHERE url = $1
+	`, url, next)
+	if err != nil {
+		return fmt.Errorf("failed to set next attempt of feed %s: %v", url, err)
+	}
+	return nil
+}
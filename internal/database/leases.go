@@ -0,0 +1,146 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ClaimItemLease leases an item for a stage. It succeeds when the item has no
+// lease for that stage yet or the existing lease has expired; the returned bool
+// is false when another worker still holds it.
+func (db *DB) ClaimItemLease(itemID int, stage, workerID string, ttl time.Duration) (bool, error) {
+	result, err := db.Exec(`
+		INSERT INTO job_leases (watchlist_item_id, stage, worker_id, leased_at, heartbeat_at, expires_at)
+		VALUES ($1, $2, $3, NOW(), NOW(), NOW() + make_interval(secs => $4))
+		ON CONFLICT (watchlist_item_id, stage) DO UPDATE
+		SET worker_id = EXCLUDED.worker_id,
+			leased_at = EXCLUDED.leased_at,
+			heartbeat_at = EXCLUDED.heartbeat_at,
+			expires_at = EXCLUDED.expires_at
+		WHERE job_leases.expires_at <= NOW()
+	`, itemID, stage, workerID, ttl.Seconds())
+	if err != nil {
+		return false, fmt.Errorf("failed to claim lease: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to read affected rows: %v", err)
+	}
+	return affected == 1, nil
+}
+
+// ClaimNextItemLease leases the oldest item waiting in step that nobody else
+// holds a lease on. Rows locked by a concurrent claim are skipped, so two
+// workers never get the same item. It returns 0 when there is nothing to claim.
+func (db *DB) ClaimNextItemLease(stage, step, workerID string, ttl time.Duration) (int, error) {
+	var itemID int
+	err := db.QueryRow(`
+		WITH candidate AS (
+			SELECT w.id
+			FROM watchlistitem w
+			WHERE w.current_step = $1
+			AND NOT EXISTS (
+				SELECT 1 FROM job_leases l
+				WHERE l.watchlist_item_id = w.id AND l.stage = $2 AND l.expires_at > NOW()
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM item_retries r
+				WHERE r.watchlist_item_id = w.id AND r.stage = $2 AND r.next_attempt_at > NOW()
+			)
+			ORDER BY w.id ASC
+			LIMIT 1
+			FOR UPDATE OF w SKIP LOCKED
+		)
+		INSERT INTO job_leases (watchlist_item_id, stage, worker_id, leased_at, heartbeat_at, expires_at)
+		SELECT id, $2, $3, NOW(), NOW(), NOW() + make_interval(secs => $4)
+		FROM candidate
+		ON CONFLICT (watchlist_item_id, stage) DO UPDATE
+		SET worker_id = EXCLUDED.worker_id,
+			leased_at = EXCLUDED.leased_at,
+			heartbeat_at = EXCLUDED.heartbeat_at,
+			expires_at = EXCLUDED.expires_at
+		WHERE job_leases.expires_at <= NOW()
+		RETURNING watchlist_item_id
+	`, step, stage, workerID, ttl.Seconds()).Scan(&itemID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim next lease: %v", err)
+	}
+	return itemID, nil
+}
+
+// RenewItemLease pushes the expiry of a lease held by workerID forward. The
+// returned bool is false when the lease was lost to another worker.
+func (db *DB) RenewItemLease(itemID int, stage, workerID string, ttl time.Duration) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE job_leases
+		SET heartbeat_at = NOW(), expires_at = NOW() + make_interval(secs => $4)
+		WHERE watchlist_item_id = $1 AND stage = $2 AND worker_id = $3
+	`, itemID, stage, workerID, ttl.Seconds())
+	if err != nil {
+		return false, fmt.Errorf("failed to renew lease: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to read affected rows: %v", err)
+	}
+	return affected == 1, nil
+}
+
+// ReleaseItemLease drops a lease held by workerID
+func (db *DB) ReleaseItemLease(itemID int, stage, workerID string) error {
+	_, err := db.Exec(`
+		DELETE FROM job_leases
+		WHERE watchlist_item_id = $1 AND stage = $2 AND worker_id = $3
+	`, itemID, stage, workerID)
+	if err != nil {
+		return fmt.Errorf("failed to release lease: %v", err)
+	}
+	return nil
+}
+
+// GetStaleItems returns items sitting in step without a live lease for stage
+// whose lease expired, or that have not been updated for timeout. These were
+// abandoned by a worker that died mid-way.
+func (db *DB) GetStaleItems(stage, step string, timeout time.Duration) ([]int, error) {
+	query := `
+		SELECT w.id
+		FROM watchlistitem w
+		WHERE w.current_step = $1
+		AND NOT EXISTS (
+			SELECT 1 FROM job_leases l
+			WHERE l.watchlist_item_id = w.id AND l.stage = $2 AND l.expires_at > NOW()
+		)
+		AND (
+			w.updated_at < NOW() - make_interval(secs => $3)
+			OR EXISTS (
+				SELECT 1 FROM job_leases l
+				WHERE l.watchlist_item_id = w.id AND l.stage = $2
+			)
+		)
+		ORDER BY w.id ASC
+	`
+	rows, err := db.Query(query, step, stage, timeout.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("error querying stale items: %v", err)
+	}
+	defer rows.Close()
+
+	var itemIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning item ID: %v", err)
+		}
+		itemIDs = append(itemIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+	return itemIDs, nil
+}
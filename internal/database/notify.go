@@ -0,0 +1,26 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// NewListener opens a dedicated connection that LISTENs on the given channels.
+// The listener reconnects by itself; after a reconnect it delivers a nil
+// notification, since anything sent in between was lost.
+func (db *DB) NewListener(channels ...string) (*pq.Listener, error) {
+	if db.dsn == "" {
+		return nil, fmt.Errorf("no connection string to listen with")
+	}
+
+	listener := pq.NewListener(db.dsn, 10*time.Second, time.Minute, nil)
+	for _, channel := range channels {
+		if err := listener.Listen(channel); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to listen on %s: %v", channel, err)
+		}
+	}
+	return listener, nil
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrOAuthTokenNotFound is returned when mye-r is not logged in to a provider
+var ErrOAuthTokenNotFound = errors.New("oauth token not found")
+
+// OAuthToken is the login of mye-r at another service
+type OAuthToken struct {
+	Provider     string
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// GetOAuthToken returns the token of a provider
+func (db *DB) GetOAuthToken(provider string) (*OAuthToken, error) {
+	token := OAuthToken{Provider: provider}
+	err := db.QueryRow(`
+		SELECT access_token, refresh_token, expires_at, updated_at
+		FROM oauth_tokens
+		WHERE provider = $1
+	`, provider).Scan(&token.AccessToken, &token.RefreshToken, &token.ExpiresAt, &token.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrOAuthTokenNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s token: %v", provider, err)
+	}
+	return &token, nil
+}
+
+// SaveOAuthToken stores the token of a provider, replacing the previous one
+func (db *DB) SaveOAuthToken(token *OAuthToken) error {
+	_, err := db.Exec(`
+		INSERT INTO oauth_tokens (provider, access_token, refresh_token, expires_at, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (provider) DO UPDATE
+		SET access_token = EXCLUDED.access_token,
+			refresh_token = EXCLUDED.refresh_token,
+			expires_at = EXCLUDED.expires_at,
+			updated_at = EXCLUDED.updated_at
+	`, token.Provider, token.AccessToken, token.RefreshToken, token.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to save %s token: %v", token.Provider, err)
+	}
+	return nil
+}
+
+// DeleteOAuthToken forgets the token of a provider
+func (db *DB) DeleteOAuthToken(provider string) error {
+	if _, err := db.Exec(`DELETE FROM oauth_tokens WHERE provider = $1`, provider); err != nil {
+		return fmt.Errorf("failed to delete %s token: %v", provider, err)
+	}
+	return nil
+}
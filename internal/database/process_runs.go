@@ -0,0 +1,169 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ProcessRun is one invocation of a stage on a batch of items
+type ProcessRun struct {
+	ID         int            `json:"id"`
+	Stage      string         `json:"stage"`
+	Mode       string         `json:"mode"`
+	ItemIDs    []int64        `json:"item_ids"`
+	Status     string         `json:"status"`
+	ExitCode   sql.NullInt32  `json:"exit_code"`
+	Error      sql.NullString `json:"error"`
+	Output     sql.NullString `json:"output"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt sql.NullTime   `json:"finished_at"`
+}
+
+// ProcessRunItem is the outcome of one item within a process run
+type ProcessRunItem struct {
+	ID              int            `json:"id"`
+	ProcessRunID    int            `json:"process_run_id"`
+	Stage           string         `json:"stage"`
+	WatchlistItemID int            `json:"watchlist_item_id"`
+	Outcome         string         `json:"outcome"`
+	Error           sql.NullString `json:"error"`
+	DurationMs      int64          `json:"duration_ms"`
+	FinishedAt      time.Time      `json:"finished_at"`
+}
+
+// CreateProcessRun records the start of a stage run and returns its ID
+func (db *DB) CreateProcessRun(stage, mode string, itemIDs []int) (int, error) {
+	ids := make([]int64, len(itemIDs))
+	for i, id := range itemIDs {
+		ids[i] = int64(id)
+	}
+
+	var runID int
+	err := db.QueryRow(`
+		INSERT INTO process_runs (stage, mode, item_ids, status, started_at)
+		VALUES ($1, $2, $3, 'running', NOW())
+		RETURNING id
+	`, stage, mode, pq.Array(ids)).Scan(&runID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create process run: %v", err)
+	}
+	return runID, nil
+}
+
+// FinishProcessRun records how a stage run ended. exitCode is nil for runs
+// that did not start a separate process.
+func (db *DB) FinishProcessRun(runID int, status string, exitCode *int, errText, output string) error {
+	var code sql.NullInt32
+	if exitCode != nil {
+		code = sql.NullInt32{Int32: int32(*exitCode), Valid: true}
+	}
+
+	_, err := db.Exec(`
+		UPDATE process_runs
+		SET status = $2, exit_code = $3, error = NULLIF($4, ''), output = NULLIF($5, ''), finished_at = NOW()
+		WHERE id = $1
+	`, runID, status, code, errText, output)
+	if err != nil {
+		return fmt.Errorf("failed to finish process run: %v", err)
+	}
+	return nil
+}
+
+// AbandonProcessRuns fails the runs still marked running that started more
+// than timeout ago and have not finished an item within it, which is what a
+// run whose process died looks like. It returns how many were failed.
+func (db *DB) AbandonProcessRuns(timeout time.Duration) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE process_runs r
+		SET status = 'failed', error = 'abandoned', finished_at = NOW()
+		WHERE r.status = 'running'
+		AND r.started_at < NOW() - make_interval(secs => $1)
+		AND NOT EXISTS (
+			SELECT 1 FROM process_run_items i
+			WHERE i.process_run_id = r.id AND i.finished_at > NOW() - make_interval(secs => $1)
+		)
+	`, timeout.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to abandon process runs: %v", err)
+	}
+	abandoned, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read affected rows: %v", err)
+	}
+	return abandoned, nil
+}
+
+// RecordProcessRunItem records the outcome of one item of a run
+func (db *DB) RecordProcessRunItem(runID, itemID int, outcome, errText string, duration time.Duration) error {
+	_, err := db.Exec(`
+		INSERT INTO process_run_items (process_run_id, watchlist_item_id, outcome, error, duration_ms, finished_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, NOW())
+	`, runID, itemID, outcome, errText, duration.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("failed to record process run item: %v", err)
+	}
+	return nil
+}
+
+// GetProcessRuns returns the most recent runs, newest first. An empty stage
+// returns runs of every stage.
+func (db *DB) GetProcessRuns(stage string, limit int) ([]ProcessRun, error) {
+	rows, err := db.Query(`
+		SELECT id, stage, mode, item_ids, status, exit_code, error, output, started_at, finished_at
+		FROM process_runs
+		WHERE $1 = '' OR stage = $1
+		ORDER BY started_at DESC, id DESC
+		LIMIT $2
+	`, stage, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying process runs: %v", err)
+	}
+	defer rows.Close()
+
+	var runs []ProcessRun
+	for rows.Next() {
+		var r ProcessRun
+		if err := rows.Scan(&r.ID, &r.Stage, &r.Mode, pq.Array(&r.ItemIDs), &r.Status, &r.ExitCode,
+			&r.Error, &r.Output, &r.StartedAt, &r.FinishedAt); err != nil {
+			return nil, fmt.Errorf("error scanning process run: %v", err)
+		}
+		runs = append(runs, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating process runs: %v", err)
+	}
+	return runs, nil
+}
+
+// GetItemRunHistory returns the outcomes of an item across all runs, newest first
+func (db *DB) GetItemRunHistory(itemID int, limit int) ([]ProcessRunItem, error) {
+	rows, err := db.Query(`
+		SELECT i.id, i.process_run_id, r.stage, i.watchlist_item_id, i.outcome, i.error, i.duration_ms, i.finished_at
+		FROM process_run_items i
+		JOIN process_runs r ON r.id = i.process_run_id
+		WHERE i.watchlist_item_id = $1
+		ORDER BY i.finished_at DESC, i.id DESC
+		LIMIT $2
+	`, itemID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying item run history: %v", err)
+	}
+	defer rows.Close()
+
+	var items []ProcessRunItem
+	for rows.Next() {
+		var item ProcessRunItem
+		if err := rows.Scan(&item.ID, &item.ProcessRunID, &item.Stage, &item.WatchlistItemID, &item.Outcome,
+			&item.Error, &item.DurationMs, &item.FinishedAt); err != nil {
+			return nil, fmt.Errorf("error scanning item run history: %v", err)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating item run history: %v", err)
+	}
+	return items, nil
+}
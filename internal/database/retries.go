@@ -0,0 +1,104 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ItemRetry is the retry state of one item in one stage
+type ItemRetry struct {
+	WatchlistItemID int            `json:"watchlist_item_id"`
+	Stage           string         `json:"stage"`
+	Attempts        int            `json:"attempts"`
+	LastError       sql.NullString `json:"last_error"`
+	LastAttemptAt   sql.NullTime   `json:"last_attempt_at"`
+	NextAttemptAt   sql.NullTime   `json:"next_attempt_at"`
+}
+
+// queueFilter returns the conditions that keep an item out of a stage's queue
+// while another worker holds its lease or its next retry is not due yet.
+// alias is the watchlistitem alias used by the surrounding query.
+func queueFilter(alias, stage string) string {
+	return fmt.Sprintf(`
+		AND NOT EXISTS (
+			SELECT 1 FROM job_leases l
+			WHERE l.watchlist_item_id = %[1]s.id AND l.stage = '%[2]s' AND l.expires_at > NOW()
+		)
+		AND NOT EXISTS (
+			SELECT 1 FROM item_retries r
+			WHERE r.watchlist_item_id = %[1]s.id AND r.stage = '%[2]s' AND r.next_attempt_at > NOW()
+		)`, alias, stage)
+}
+
+// RecordItemFailure counts a failed attempt of an item in a stage and returns
+// the number of attempts so far
+func (db *DB) RecordItemFailure(itemID int, stage, lastError string) (int, error) {
+	var attempts int
+	err := db.QueryRow(`
+		INSERT INTO item_retries (watchlist_item_id, stage, attempts, last_error, last_attempt_at, updated_at)
+		VALUES ($1, $2, 1, $3, NOW(), NOW())
+		ON CONFLICT (watchlist_item_id, stage) DO UPDATE
+		SET attempts = item_retries.attempts + 1,
+			last_error = EXCLUDED.last_error,
+			last_attempt_at = EXCLUDED.last_attempt_at,
+			updated_at = EXCLUDED.updated_at
+		RETURNING attempts
+	`, itemID, stage, lastError).Scan(&attempts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record item failure: %v", err)
+	}
+	return attempts, nil
+}
+
+// SetItemNextAttempt sets when an item may be picked up by a stage again
+func (db *DB) SetItemNextAttempt(itemID int, stage string, next time.Time) error {
+	_, err := db.Exec(`
+		UPDATE item_retries
+		SET next_attempt_at = $3, updated_at = NOW()
+		WHERE watchlist_item_id = $1 AND stage = $2
+	`, itemID, stage, next)
+	if err != nil {
+		return fmt.Errorf("failed to set next attempt: %v", err)
+	}
+	return nil
+}
+
+// ClearItemRetry forgets the retry state of an item in a stage
+func (db *DB) ClearItemRetry(itemID int, stage string) error {
+	_, err := db.Exec(`
+		DELETE FROM item_retries
+		WHERE watchlist_item_id = $1 AND stage = $2
+	`, itemID, stage)
+	if err != nil {
+		return fmt.Errorf("failed to clear item retry: %v", err)
+	}
+	return nil
+}
+
+// GetItemRetries returns the retry state of an item in every stage
+func (db *DB) GetItemRetries(itemID int) ([]ItemRetry, error) {
+	rows, err := db.Query(`
+		SELECT watchlist_item_id, stage, attempts, last_error, last_attempt_at, next_attempt_at
+		FROM item_retries
+		WHERE watchlist_item_id = $1
+		ORDER BY stage ASC
+	`, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying item retries: %v", err)
+	}
+	defer rows.Close()
+
+	var retries []ItemRetry
+	for rows.Next() {
+		var r ItemRetry
+		if err := rows.Scan(&r.WatchlistItemID, &r.Stage, &r.Attempts, &r.LastError, &r.LastAttemptAt, &r.NextAttemptAt); err != nil {
+			return nil, fmt.Errorf("error scanning item retry: %v", err)
+		}
+		retries = append(retries, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating item retries: %v", err)
+	}
+	return retries, nil
+}
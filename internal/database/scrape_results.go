@@ -2,10 +2,14 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 )
 
+// ErrScrapeResultNotFound is returned when an item has no such scrape result
+var ErrScrapeResultNotFound = errors.New("scrape result not found")
+
 type ScrapeResult struct {
 	ID                int            `json:"id"`
 	WatchlistItemID   int            `json:"watchlist_item_id"`
@@ -21,6 +25,7 @@ type ScrapeResult struct {
 	DebridURI         sql.NullString `json:"debrid_uri"`
 	CreatedAt         time.Time      `json:"created_at"`
 	UpdatedAt         time.Time      `json:"updated_at"`
+	Pinned            bool           `json:"pinned"` // chosen by hand, wins over the ranking
 }
 
 func (db *DB) StoreScrapeResult(result *ScrapeResult) error {
@@ -64,10 +69,10 @@ func (db *DB) GetScrapeResultsForItem(itemID int) ([]ScrapeResult, error) {
 		SELECT id, watchlist_item_id, scraped_filename, scraped_resolution,
 			   scraped_date, info_hash, scraped_score, scraped_file_size,
 			   scraped_codec, status_results, debrid_id, debrid_uri,
-			   created_at, updated_at
+			   created_at, updated_at, pinned
 		FROM scrape_results
 		WHERE watchlist_item_id = $1
-		ORDER BY scraped_score DESC
+		ORDER BY pinned DESC, scraped_score DESC
 	`
 	rows, err := db.Query(query, itemID)
 	if err != nil {
@@ -83,7 +88,7 @@ func (db *DB) GetScrapeResultsForItem(itemID int) ([]ScrapeResult, error) {
 			&result.ScrapedResolution, &result.ScrapedDate, &result.InfoHash,
 			&result.ScrapedScore, &result.ScrapedFileSize, &result.ScrapedCodec,
 			&result.StatusResults, &result.DebridID, &result.DebridURI,
-			&result.CreatedAt, &result.UpdatedAt,
+			&result.CreatedAt, &result.UpdatedAt, &result.Pinned,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan scrape result: %v", err)
 		}
@@ -98,7 +103,7 @@ func (db *DB) GetNextScrapeResultForDownload() (*ScrapeResult, error) {
 		SELECT id, watchlist_item_id, scraped_filename, scraped_resolution,
 			   scraped_date, info_hash, scraped_score, scraped_file_size,
 			   scraped_codec, status_results, debrid_id, debrid_uri,
-			   created_at, updated_at
+			   created_at, updated_at, pinned
 		FROM scrape_results
 		WHERE status_results = 'pending_download'
 		ORDER BY scraped_score DESC
@@ -110,7 +115,7 @@ func (db *DB) GetNextScrapeResultForDownload() (*ScrapeResult, error) {
 		&result.ScrapedResolution, &result.ScrapedDate, &result.InfoHash,
 		&result.ScrapedScore, &result.ScrapedFileSize, &result.ScrapedCodec,
 		&result.StatusResults, &result.DebridID, &result.DebridURI,
-		&result.CreatedAt, &result.UpdatedAt,
+		&result.CreatedAt, &result.UpdatedAt, &result.Pinned,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -143,16 +148,17 @@ func (db *DB) UpdateScrapeResult(result *ScrapeResult) error {
 	return nil
 }
 
-// GetLatestScrapeResult gets the most recent scrape result for an item
+// GetLatestScrapeResult gets the pinned scrape result of an item, or else
+// the most recent one
 func (db *DB) GetLatestScrapeResult(itemID int) (*ScrapeResult, error) {
 	query := `
 		SELECT id, watchlist_item_id, scraped_filename, scraped_resolution,
 			   scraped_date, info_hash, scraped_score, scraped_file_size,
 			   scraped_codec, status_results, debrid_id, debrid_uri,
-			   created_at, updated_at
+			   created_at, updated_at, pinned
 		FROM scrape_results
 		WHERE watchlist_item_id = $1
-		ORDER BY scraped_date DESC
+		ORDER BY pinned DESC, scraped_date DESC
 		LIMIT 1
 	`
 	var result ScrapeResult
@@ -161,7 +167,7 @@ func (db *DB) GetLatestScrapeResult(itemID int) (*ScrapeResult, error) {
 		&result.ScrapedResolution, &result.ScrapedDate, &result.InfoHash,
 		&result.ScrapedScore, &result.ScrapedFileSize, &result.ScrapedCodec,
 		&result.StatusResults, &result.DebridID, &result.DebridURI,
-		&result.CreatedAt, &result.UpdatedAt,
+		&result.CreatedAt, &result.UpdatedAt, &result.Pinned,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -202,3 +208,154 @@ func (db *DB) UpdateScrapeResultStatus(itemID int, status string) error {
 	}
 	return nil
 }
+
+// GetStaleScrapeResults returns scrape results that have been in status for
+// longer than timeout while no downloader holds a lease on their item
+func (db *DB) GetStaleScrapeResults(status string, timeout time.Duration) ([]ScrapeResult, error) {
+	query := `
+		SELECT s.id, s.watchlist_item_id, s.scraped_filename, s.scraped_resolution,
+			   s.scraped_date, s.info_hash, s.scraped_score, s.scraped_file_size,
+			   s.scraped_codec, s.status_results, s.debrid_id, s.debrid_uri,
+			   s.created_at, s.updated_at, s.pinned
+		FROM scrape_results s
+		WHERE s.status_results = $1
+		AND s.updated_at < NOW() - make_interval(secs => $2)
+		AND NOT EXISTS (
+			SELECT 1 FROM job_leases l
+			WHERE l.watchlist_item_id = s.watchlist_item_id AND l.stage = 'downloader' AND l.expires_at > NOW()
+		)
+		ORDER BY s.id ASC
+	`
+	rows, err := db.Query(query, status, timeout.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("error querying stale scrape results: %v", err)
+	}
+	defer rows.Close()
+
+	var results []ScrapeResult
+	for rows.Next() {
+		var result ScrapeResult
+		err := rows.Scan(
+			&result.ID, &result.WatchlistItemID, &result.ScrapedFilename, &result.ScrapedResolution,
+			&result.ScrapedDate, &result.InfoHash, &result.ScrapedScore, &result.ScrapedFileSize,
+			&result.ScrapedCodec, &result.StatusResults, &result.DebridID, &result.DebridURI,
+			&result.CreatedAt, &result.UpdatedAt, &result.Pinned,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning stale scrape result: %v", err)
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating stale scrape results: %v", err)
+	}
+	return results, nil
+}
+
+// PinScrapeResult pins one of an item's scrape results, unpinning any other,
+// and makes it ready for download again
+func (db *DB) PinScrapeResult(itemID, resultID int) error {
+	tx, err := db.begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := unpin(tx, itemID); err != nil {
+		return err
+	}
+	result, err := tx.Exec(`
+		UPDATE scrape_results
+		SET pinned = true, status_results = 'ready_for_download', updated_at = NOW()
+		WHERE id = $1 AND watchlist_item_id = $2
+	`, resultID, itemID)
+	if err != nil {
+		return fmt.Errorf("failed to pin scrape result: %v", err)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to read affected rows: %v", err)
+	} else if affected == 0 {
+		return ErrScrapeResultNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit pin: %v", err)
+	}
+	return nil
+}
+
+// PinInfoHash pins a release given by hand. A scrape result of the item with
+// the same hash is reused, otherwise one is added.
+func (db *DB) PinInfoHash(itemID int, infoHash, filename string) error {
+	tx, err := db.begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := unpin(tx, itemID); err != nil {
+		return err
+	}
+	result, err := tx.Exec(`
+		UPDATE scrape_results
+		SET pinned = true, status_results = 'ready_for_download', updated_at = NOW(),
+			scraped_filename = COALESCE(NULLIF($3, ''), scraped_filename)
+		WHERE id = (
+			SELECT id FROM scrape_results
+			WHERE watchlist_item_id = $1 AND lower(info_hash) = $2
+			ORDER BY id DESC
+			LIMIT 1
+		)
+	`, itemID, infoHash, filename)
+	if err != nil {
+		return fmt.Errorf("failed to pin scrape result: %v", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to read affected rows: %v", err)
+	}
+	if affected == 0 {
+		_, err = tx.Exec(`
+			INSERT INTO scrape_results (
+				watchlist_item_id, scraped_filename, scraped_date, info_hash,
+				status_results, pinned
+			) VALUES ($1, NULLIF($2, ''), NOW(), $3, 'ready_for_download', true)
+		`, itemID, filename, infoHash)
+		if err != nil {
+			return fmt.Errorf("failed to add pinned scrape result: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit pin: %v", err)
+	}
+	return nil
+}
+
+// UnpinScrapeResult hands an item back to the ranking. It reports whether
+// anything was pinned.
+func (db *DB) UnpinScrapeResult(itemID int) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE scrape_results SET pinned = false, updated_at = NOW()
+		WHERE watchlist_item_id = $1 AND pinned
+	`, itemID)
+	if err != nil {
+		return false, fmt.Errorf("failed to unpin scrape result: %v", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to read affected rows: %v", err)
+	}
+	return affected > 0, nil
+}
+
+func unpin(tx txn, itemID int) error {
+	_, err := tx.Exec(`
+		UPDATE scrape_results SET pinned = false, updated_at = NOW()
+		WHERE watchlist_item_id = $1 AND pinned
+	`, itemID)
+	if err != nil {
+		return fmt.Errorf("failed to unpin scrape results: %v", err)
+	}
+	return nil
+}
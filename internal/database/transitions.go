@@ -0,0 +1,132 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ItemTransition is one row of the item_transitions history table
+type ItemTransition struct {
+	ID              int       `json:"id"`
+	WatchlistItemID int       `json:"watchlist_item_id"`
+	FromState       string    `json:"from_state"`
+	ToState         string    `json:"to_state"`
+	Reason          string    `json:"reason"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// ItemState is the minimal view of an item used by state checks
+type ItemState struct {
+	ID          int    `json:"id"`
+	Title       string `json:"title"`
+	Status      string `json:"status"`
+	CurrentStep string `json:"current_step"`
+}
+
+// TransitionItemState moves an item from one step to another and records the change.
+// The update only applies if the item is still in fromStep; the returned bool is
+// false when it was not.
+func (db *DB) TransitionItemState(itemID int, fromStep, toStep, toStatus, reason string) (bool, error) {
+	tx, err := db.begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE watchlistitem
+		SET status = $1, current_step = $2, updated_at = NOW()
+		WHERE id = $3 AND current_step = $4
+	`, toStatus, toStep, itemID, fromStep)
+	if err != nil {
+		return false, fmt.Errorf("failed to update item state: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to read affected rows: %v", err)
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO item_transitions (watchlist_item_id, from_state, to_state, reason)
+		VALUES ($1, $2, $3, $4)
+	`, itemID, fromStep, toStep, reason)
+	if err != nil {
+		return false, fmt.Errorf("failed to record transition: %v", err)
+	}
+
+	// Published with the change, so listeners never see one that was rolled back
+	payload, err := Event{Type: EventTransition, ItemID: itemID, From: fromStep, To: toStep, Reason: reason}.payload()
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`SELECT pg_notify($1, $2)`, EventsChannel, payload); err != nil {
+		return false, fmt.Errorf("failed to publish transition: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transition: %v", err)
+	}
+	return true, nil
+}
+
+// GetItemsInUnknownState returns items whose current_step is not one of knownSteps
+func (db *DB) GetItemsInUnknownState(knownSteps []string) ([]ItemState, error) {
+	query := `
+		SELECT id, title, COALESCE(status, ''), COALESCE(current_step, '')
+		FROM watchlistitem
+		WHERE current_step IS NULL OR NOT (current_step = ANY($1))
+		ORDER BY id ASC
+	`
+	rows, err := db.Query(query, pq.Array(knownSteps))
+	if err != nil {
+		return nil, fmt.Errorf("error querying item states: %v", err)
+	}
+	defer rows.Close()
+
+	var items []ItemState
+	for rows.Next() {
+		var item ItemState
+		if err := rows.Scan(&item.ID, &item.Title, &item.Status, &item.CurrentStep); err != nil {
+			return nil, fmt.Errorf("error scanning item state: %v", err)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating item states: %v", err)
+	}
+	return items, nil
+}
+
+// GetItemTransitions returns the state history of an item, oldest first
+func (db *DB) GetItemTransitions(itemID int) ([]ItemTransition, error) {
+	query := `
+		SELECT id, watchlist_item_id, from_state, to_state, COALESCE(reason, ''), created_at
+		FROM item_transitions
+		WHERE watchlist_item_id = $1
+		ORDER BY created_at ASC, id ASC
+	`
+	rows, err := db.Query(query, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying item transitions: %v", err)
+	}
+	defer rows.Close()
+
+	var transitions []ItemTransition
+	for rows.Next() {
+		var t ItemTransition
+		if err := rows.Scan(&t.ID, &t.WatchlistItemID, &t.FromState, &t.ToState, &t.Reason, &t.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning item transition: %v", err)
+		}
+		transitions = append(transitions, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating item transitions: %v", err)
+	}
+	return transitions, nil
+}
@@ -4,31 +4,43 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	"mye-r/internal/config"
 	"mye-r/internal/database"
 	"mye-r/internal/logger"
+	"mye-r/internal/metrics"
+	"mye-r/internal/pipeline"
 )
 
 type RealDebridDownloader struct {
-	config *config.Config
-	db     *database.DB
-	log    *logger.Logger
-	client *http.Client
+	config   *config.Config
+	db       *database.DB
+	log      *logger.Logger
+	client   *http.Client
+	pipeline *pipeline.Machine
+	leaser   *pipeline.Leaser
+	retrier  *pipeline.Retrier
 }
 
 func NewRealDebridDownloader(cfg *config.Config, db *database.DB) *RealDebridDownloader {
 	return &RealDebridDownloader{
-		config: cfg,
-		db:     db,
-		log:    logger.New(),
-		client: &http.Client{},
+		config:   cfg,
+		db:       db,
+		log:      logger.New(),
+		client:   &http.Client{},
+		pipeline: pipeline.New(db),
+		leaser:   pipeline.NewLeaser(db, cfg.ProcessManagement.LeaseTTL),
+		retrier:  pipeline.NewRetrier(cfg, db),
 	}
 }
 
@@ -36,7 +48,64 @@ func New(cfg *config.Config, db *database.DB) *RealDebridDownloader {
 	return NewRealDebridDownloader(cfg, db)
 }
 
+// errRescrape is returned when Real-Debrid does not have the torrent cached
+// and the item has to go back to the scraper for another hash.
+var errRescrape = errors.New("torrent not cached, re-scrape needed")
+
+// Download downloads an item waiting in download_pending and hands it to the
+// symlinker. Items whose torrent is not cached are sent back to the scraper.
+// The item is leased for the whole download so it is never added twice.
 func (d *RealDebridDownloader) Download(item *database.WatchlistItem) error {
+	return d.leaser.Run(item.ID, pipeline.StageDownloader, func(ctx context.Context) error {
+		return d.downloadItem(ctx, item)
+	})
+}
+
+func (d *RealDebridDownloader) downloadItem(ctx context.Context, item *database.WatchlistItem) error {
+	if err := d.pipeline.Transition(item.ID, pipeline.StateDownloadPending, pipeline.StateDownloading, "download started"); err != nil {
+		return err
+	}
+
+	err := d.download(item)
+	if lerr := pipeline.LeaseLost(ctx); lerr != nil {
+		return lerr
+	}
+	if err != nil {
+		var terr error
+		if errors.Is(err, errRescrape) {
+			// Not a download failure: the scraper has to find another release
+			terr = d.pipeline.Transition(item.ID, pipeline.StateDownloading, pipeline.StateScrapePending, err.Error())
+		} else {
+			terr = d.retrier.Fail(item.ID, pipeline.StageDownloader, err)
+		}
+		if terr != nil {
+			d.log.Error("RealDebridDownloader", "Download", fmt.Sprintf("Failed to move item %d on after error: %v", item.ID, terr))
+		}
+		return err
+	}
+
+	if err := d.pipeline.Transition(item.ID, pipeline.StateDownloading, pipeline.StateSymlinkPending, "downloaded"); err != nil {
+		return err
+	}
+	d.retrier.Succeed(item.ID, pipeline.StageDownloader)
+	return nil
+}
+
+// ProcessItem downloads one item for the RunManager worker pool
+func (d *RealDebridDownloader) ProcessItem(ctx context.Context, item *database.WatchlistItem) error {
+	return d.Download(item)
+}
+
+// isDownloadable reports whether a scrape result is waiting to be downloaded
+func isDownloadable(result *database.ScrapeResult) bool {
+	switch result.StatusResults.String {
+	case "scraped", "pending_download", "ready_for_download":
+		return true
+	}
+	return false
+}
+
+func (d *RealDebridDownloader) download(item *database.WatchlistItem) error {
 	// Get all scrape results for this item
 	scrapeResults, err := d.db.GetScrapeResultsForItem(item.ID)
 	if err != nil {
@@ -47,13 +116,29 @@ func (d *RealDebridDownloader) Download(item *database.WatchlistItem) error {
 		return fmt.Errorf("no scrape results found for item %d", item.ID)
 	}
 
+	// A release pinned by hand is the only one considered
+	if pinned := pinnedResult(scrapeResults); pinned != nil {
+		if !isDownloadable(pinned) {
+			return fmt.Errorf("pinned release %s is %s, pin another one or unpin it", pinned.InfoHash.String, pinned.StatusResults.String)
+		}
+		scrapeResults = []database.ScrapeResult{*pinned}
+	}
+
 	// For TV shows, we need to download each episode
 	if item.MediaType.Valid && item.MediaType.String == "tv" {
+		downloaded := 0
+		rescrape := false
 		for _, result := range scrapeResults {
-			if result.StatusResults.String == "scraped" {
+			if isDownloadable(&result) {
 				d.log.Info("RealDebridDownloader", "Download", fmt.Sprintf("Starting download for %s - %s",
 					item.Title, result.ScrapedFilename.String))
 
+				if d.config.DryRun {
+					d.logDryRun(&result)
+					downloaded++
+					continue
+				}
+
 				// Add torrent to RealDebrid
 				torrentID, err := d.addTorrent(result.InfoHash.String)
 				if err != nil {
@@ -64,6 +149,8 @@ func (d *RealDebridDownloader) Download(item *database.WatchlistItem) error {
 					}
 					continue
 				}
+				result.DebridID = sql.NullString{String: torrentID, Valid: true}
+				d.fillFilename(torrentID, &result)
 
 				// Select files to download
 				if err := d.selectFiles(torrentID); err != nil {
@@ -77,6 +164,11 @@ func (d *RealDebridDownloader) Download(item *database.WatchlistItem) error {
 
 				// Get download link
 				downloadLink, err := d.getDownloadLink(torrentID, &result)
+				if errors.Is(err, errRescrape) {
+					d.log.Warning("RealDebridDownloader", "Download", fmt.Sprintf("Failed to get download link: %v", err))
+					rescrape = true
+					continue
+				}
 				if err != nil {
 					d.log.Error("RealDebridDownloader", "Download", fmt.Sprintf("Failed to get download link: %v", err))
 					// Mark this hash as ignored
@@ -100,36 +192,54 @@ func (d *RealDebridDownloader) Download(item *database.WatchlistItem) error {
 					}
 					continue
 				}
+				downloaded++
+			}
+		}
+		if downloaded == 0 {
+			if rescrape {
+				return fmt.Errorf("no episodes downloaded for item %d: %w", item.ID, errRescrape)
 			}
+			return fmt.Errorf("no episodes downloaded for item %d", item.ID)
 		}
 		return nil
 	}
 
-	// For movies, find the best quality version that hasn't been ignored
-	var bestResult *database.ScrapeResult
-	bestScore := int32(0)
-	for i := range scrapeResults {
-		result := &scrapeResults[i]
-		if result.StatusResults.String == "scraped" && 
-		   result.ScrapedScore.Valid && 
-		   result.ScrapedScore.Int32 > bestScore {
-			bestScore = result.ScrapedScore.Int32
-			bestResult = result
+	// For movies, find the best quality version that hasn't been ignored,
+	// unless one was pinned
+	bestResult := pinnedResult(scrapeResults)
+	if bestResult == nil {
+		bestScore := int32(0)
+		for i := range scrapeResults {
+			result := &scrapeResults[i]
+			if isDownloadable(result) &&
+				result.ScrapedScore.Valid &&
+				result.ScrapedScore.Int32 > bestScore {
+				bestScore = result.ScrapedScore.Int32
+				bestResult = result
+			}
 		}
-	}
 
-	if bestScore == 0 {
-		return fmt.Errorf("no valid scrape results found for item %d", item.ID)
+		if bestScore == 0 {
+			return fmt.Errorf("no valid scrape results found for item %d", item.ID)
+		}
 	}
 
 	d.log.Info("RealDebridDownloader", "Download", fmt.Sprintf("Starting download for %s (InfoHash: %s)",
 		item.Title, bestResult.InfoHash.String))
 
+	if d.config.DryRun {
+		d.logDryRun(bestResult)
+		return nil
+	}
+
 	// Add torrent to RealDebrid
 	torrentID, err := d.addTorrent(bestResult.InfoHash.String)
 	if err != nil {
 		return fmt.Errorf("failed to add torrent: %v", err)
 	}
+	// Kept so a download interrupted by a restart can be looked up again
+	bestResult.DebridID = sql.NullString{String: torrentID, Valid: true}
+	d.fillFilename(torrentID, bestResult)
 
 	// Select files to download
 	if err := d.selectFiles(torrentID); err != nil {
@@ -139,7 +249,7 @@ func (d *RealDebridDownloader) Download(item *database.WatchlistItem) error {
 	// Get download link
 	downloadLink, err := d.getDownloadLink(torrentID, bestResult)
 	if err != nil {
-		return fmt.Errorf("failed to get download link: %v", err)
+		return fmt.Errorf("failed to get download link: %w", err)
 	}
 
 	// Update status to downloading
@@ -152,25 +262,90 @@ func (d *RealDebridDownloader) Download(item *database.WatchlistItem) error {
 		return fmt.Errorf("failed to wait for download: %v", err)
 	}
 
-	// Update status to downloaded
-	item.Status = sql.NullString{String: "downloaded", Valid: true}
-	item.CurrentStep = sql.NullString{String: "symlink_pending", Valid: true}
-	if err := d.db.UpdateWatchlistItem(item); err != nil {
-		return fmt.Errorf("failed to update item status: %v", err)
-	}
+	return nil
+}
 
+// pinnedResult returns the scrape result pinned by hand, if there is one
+func pinnedResult(results []database.ScrapeResult) *database.ScrapeResult {
+	for i := range results {
+		if results[i].Pinned {
+			return &results[i]
+		}
+	}
 	return nil
 }
 
+// fillFilename names a release pasted by hand without a name after the
+// torrent, so the symlinker can find it on the mount
+func (d *RealDebridDownloader) fillFilename(torrentID string, result *database.ScrapeResult) {
+	if result.ScrapedFilename.String != "" {
+		return
+	}
+	info, err := d.getTorrentInfo(torrentID)
+	if err != nil || info.Filename == "" {
+		d.log.Warning("RealDebridDownloader", "fillFilename", fmt.Sprintf("No filename for %s: %v", result.InfoHash.String, err))
+		return
+	}
+	result.ScrapedFilename = sql.NullString{String: info.Filename, Valid: true}
+}
+
+// logDryRun logs the Real-Debrid calls that downloading a result would make
+func (d *RealDebridDownloader) logDryRun(result *database.ScrapeResult) {
+	d.log.Info("RealDebridDownloader", "DryRun", fmt.Sprintf("Would call addMagnet with %s (%s)",
+		magnetLink(result.InfoHash.String), result.ScrapedFilename.String))
+	d.log.Info("RealDebridDownloader", "DryRun", fmt.Sprintf("Would call selectFiles on the new torrent with %s", selectAllFiles))
+}
+
+// selectAllFiles is the selectFiles form data; every file of a torrent is downloaded
+const selectAllFiles = "files=all"
+
+func magnetLink(infoHash string) string {
+	return fmt.Sprintf("magnet:?xt=urn:btih:%s", infoHash)
+}
+
+// ParseRelease reads a release pasted by hand: a magnet link or a bare info
+// hash, in hex or base32. It returns the hash as lower case hex and the
+// display name of a magnet link, if it has one.
+func ParseRelease(release string) (string, string, error) {
+	release = strings.TrimSpace(release)
+	name := ""
+	if strings.HasPrefix(release, "magnet:?") {
+		params, err := url.ParseQuery(strings.TrimPrefix(release, "magnet:?"))
+		if err != nil {
+			return "", "", fmt.Errorf("invalid magnet link: %v", err)
+		}
+		hash := ""
+		for _, xt := range params["xt"] {
+			if strings.HasPrefix(xt, "urn:btih:") {
+				hash = strings.TrimPrefix(xt, "urn:btih:")
+				break
+			}
+		}
+		if hash == "" {
+			return "", "", fmt.Errorf("magnet link has no BitTorrent info hash")
+		}
+		release, name = hash, params.Get("dn")
+	}
+
+	switch len(release) {
+	case 40:
+		if _, err := hex.DecodeString(release); err == nil {
+			return strings.ToLower(release), name, nil
+		}
+	case 32:
+		if raw, err := base32.StdEncoding.DecodeString(strings.ToUpper(release)); err == nil {
+			return hex.EncodeToString(raw), name, nil
+		}
+	}
+	return "", "", fmt.Errorf("invalid info hash %q", release)
+}
+
 func (d *RealDebridDownloader) addTorrent(infoHash string) (string, error) {
 	apiURL := "https://api.real-debrid.com/rest/1.0/torrents/addMagnet"
 	d.log.Info("RealDebridDownloader", "addTorrent", fmt.Sprintf("Request URL: %s", apiURL))
 
-	// Create the magnet link
-	magnetLink := fmt.Sprintf("magnet:?xt=urn:btih:%s", infoHash)
-
 	// Create form data
-	data := fmt.Sprintf("magnet=%s", magnetLink)
+	data := fmt.Sprintf("magnet=%s", magnetLink(infoHash))
 
 	req, err := http.NewRequest("POST", apiURL, strings.NewReader(data))
 	if err != nil {
@@ -180,7 +355,7 @@ func (d *RealDebridDownloader) addTorrent(infoHash string) (string, error) {
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", d.config.DebridAPI))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	resp, err := d.client.Do(req)
+	resp, err := d.do(req, "addMagnet")
 	if err != nil {
 		return "", fmt.Errorf("failed to add torrent: %v", err)
 	}
@@ -206,7 +381,7 @@ func (d *RealDebridDownloader) getDownloadLink(torrentID string, scrapeResult *d
 
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", d.config.DebridAPI))
 
-	resp, err := d.client.Do(req)
+	resp, err := d.do(req, "info")
 	if err != nil {
 		return "", fmt.Errorf("failed to send request: %v", err)
 	}
@@ -240,7 +415,7 @@ func (d *RealDebridDownloader) getDownloadLink(torrentID string, scrapeResult *d
 		if err := d.db.UpdateScrapeResult(scrapeResult); err != nil {
 			return "", fmt.Errorf("failed to update scrape result for re-scrape: %v", err)
 		}
-		return "", fmt.Errorf("torrent not ready for download, status: %s", status)
+		return "", fmt.Errorf("%w: torrent status is %s", errRescrape, status)
 	}
 
 	links, ok := result["links"].([]interface{})
@@ -258,8 +433,7 @@ func (d *RealDebridDownloader) getDownloadLink(torrentID string, scrapeResult *d
 
 func (d *RealDebridDownloader) selectFiles(torrentID string) error {
 	url := fmt.Sprintf("https://api.real-debrid.com/rest/1.0/torrents/selectFiles/%s", torrentID)
-	data := "files=all" // Select all files; you can customize this to select specific files
-	req, err := http.NewRequest("POST", url, bytes.NewBufferString(data))
+	req, err := http.NewRequest("POST", url, bytes.NewBufferString(selectAllFiles))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %v", err)
 	}
@@ -267,7 +441,7 @@ func (d *RealDebridDownloader) selectFiles(torrentID string) error {
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", d.config.DebridAPI))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	resp, err := d.client.Do(req)
+	resp, err := d.do(req, "selectFiles")
 	if err != nil {
 		return fmt.Errorf("failed to send request: %v", err)
 	}
@@ -293,12 +467,15 @@ func (d *RealDebridDownloader) removeTorrent(torrentID string) error {
 
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", d.config.DebridAPI))
 
-	resp, err := d.client.Do(req)
+	resp, err := d.do(req, "delete")
 	if err != nil {
 		return fmt.Errorf("failed to send request: %v", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", errTorrentNotFound, torrentID)
+	}
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
 		body, _ := ioutil.ReadAll(resp.Body)
 		d.log.Error("RealDebridDownloader", "removeTorrent", fmt.Sprintf("Response Body: %s", string(body)))
@@ -308,6 +485,72 @@ func (d *RealDebridDownloader) removeTorrent(torrentID string) error {
 	return nil
 }
 
+// RemoveTorrents deletes the torrents added for an item from Real-Debrid.
+// Torrents Real-Debrid no longer has are skipped.
+func (d *RealDebridDownloader) RemoveTorrents(item *database.WatchlistItem) error {
+	results, err := d.db.GetScrapeResultsForItem(item.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get scrape results: %v", err)
+	}
+	for _, result := range results {
+		if !result.DebridID.Valid || result.DebridID.String == "" {
+			continue
+		}
+		if d.config.DryRun {
+			d.log.Info("RealDebridDownloader", "DryRun", fmt.Sprintf("Would delete torrent %s (%s)", result.DebridID.String, result.ScrapedFilename.String))
+			continue
+		}
+		err := d.removeTorrent(result.DebridID.String)
+		if err != nil && !errors.Is(err, errTorrentNotFound) {
+			return fmt.Errorf("failed to delete torrent %s: %v", result.DebridID.String, err)
+		}
+		d.log.Info("RealDebridDownloader", "RemoveTorrents", fmt.Sprintf("Deleted torrent %s of item %d", result.DebridID.String, item.ID))
+	}
+	return nil
+}
+
+// Unlinker removes the library links of an item
+type Unlinker interface {
+	Unlink(item *database.WatchlistItem) error
+}
+
+// RequeuePinned sends an item that got past download_pending back to it, so
+// the release pinned for it replaces the one it was downloaded with. The old
+// torrents and library links are removed first. Items that were not
+// downloaded yet take the pin where they are and items a stage is working on
+// are left alone. It reports whether the item was requeued.
+func (d *RealDebridDownloader) RequeuePinned(item *database.WatchlistItem, linker Unlinker, reason string) (bool, error) {
+	from := pipeline.State(item.CurrentStep.String)
+	switch from {
+	case pipeline.StateDownloadFailed, pipeline.StateSymlinkPending:
+	case pipeline.StateSymlinkFailed, pipeline.StateCompleted:
+		if err := linker.Unlink(item); err != nil {
+			return false, fmt.Errorf("failed to remove symlinks: %v", err)
+		}
+	default:
+		return false, nil
+	}
+
+	if err := d.RemoveTorrents(item); err != nil {
+		return false, err
+	}
+	if err := d.pipeline.Transition(item.ID, from, pipeline.StateDownloadPending, reason); err != nil {
+		return false, err
+	}
+	// The pinned release gets the downloader's full set of attempts
+	if err := d.db.ClearItemRetry(item.ID, string(pipeline.StageDownloader)); err != nil {
+		d.log.Error("RealDebridDownloader", "RequeuePinned", fmt.Sprintf("Failed to clear retries of item %d: %v", item.ID, err))
+	}
+	return true, nil
+}
+
+// do sends a Real-Debrid API request and counts its outcome under endpoint
+func (d *RealDebridDownloader) do(req *http.Request, endpoint string) (*http.Response, error) {
+	resp, err := d.client.Do(req)
+	metrics.RealDebridRequests.Inc(endpoint, metrics.Status(resp, err))
+	return resp, err
+}
+
 func (d *RealDebridDownloader) updateDownloadStatus(scrapeResult *database.ScrapeResult, status string, details string) error {
 	scrapeResult.StatusResults = sql.NullString{
 		String: status,
@@ -324,33 +567,65 @@ func (d *RealDebridDownloader) updateDownloadStatus(scrapeResult *database.Scrap
 	return nil
 }
 
-func (d *RealDebridDownloader) checkDownloadStatus(torrentID string, result *database.ScrapeResult) error {
+// torrentInfo is the part of a Real-Debrid torrent the downloader looks at
+type torrentInfo struct {
+	Status   string   `json:"status"`
+	Filename string   `json:"filename"`
+	Links    []string `json:"links"`
+	Progress float64  `json:"progress"`
+}
+
+// errTorrentNotFound is returned when Real-Debrid no longer knows a torrent
+var errTorrentNotFound = errors.New("torrent not found on Real-Debrid")
+
+func (d *RealDebridDownloader) getTorrentInfo(torrentID string) (*torrentInfo, error) {
 	url := fmt.Sprintf("https://api.real-debrid.com/rest/1.0/torrents/info/%s", torrentID)
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", d.config.DebridAPI))
 
-	resp, err := d.client.Do(req)
+	resp, err := d.do(req, "info")
 	if err != nil {
-		return fmt.Errorf("failed to get torrent info: %v", err)
+		return nil, fmt.Errorf("failed to get torrent info: %v", err)
 	}
 	defer resp.Body.Close()
 
-	var torrentInfo struct {
-		Status   string   `json:"status"`
-		Links    []string `json:"links"`
-		Progress float64  `json:"progress"`
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", errTorrentNotFound, torrentID)
+	}
+
+	var info torrentInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
 	}
+	return &info, nil
+}
 
-	if err := json.NewDecoder(resp.Body).Decode(&torrentInfo); err != nil {
-		return fmt.Errorf("failed to decode response: %v", err)
+func (d *RealDebridDownloader) checkDownloadStatus(torrentID string, result *database.ScrapeResult) error {
+	torrentInfo, err := d.getTorrentInfo(torrentID)
+	if err != nil {
+		return err
 	}
 
 	d.log.Info("RealDebridDownloader", "checkDownloadStatus", fmt.Sprintf("Torrent progress: %.2f%%", torrentInfo.Progress))
+	err = d.db.PublishEvent(database.Event{
+		Type:   database.EventDownloadProgress,
+		ItemID: result.WatchlistItemID,
+		Stage:  string(pipeline.StageDownloader),
+		Data: map[string]interface{}{
+			"scrape_result_id": result.ID,
+			"torrent_id":       torrentID,
+			"status":           torrentInfo.Status,
+			"progress":         torrentInfo.Progress,
+		},
+	})
+	if err != nil {
+		d.log.Warning("RealDebridDownloader", "checkDownloadStatus", err.Error())
+	}
 
 	// RealDebrid uses progress 100 to indicate download is complete
 	if torrentInfo.Progress >= 100 {
@@ -377,26 +652,99 @@ func (d *RealDebridDownloader) waitForDownload(torrentID string, result *databas
 	return fmt.Errorf("download did not complete within timeout")
 }
 
+// Recover works out where an item left in downloading belongs by asking
+// Real-Debrid about the torrents it had added
+func (d *RealDebridDownloader) Recover(item *database.WatchlistItem) (pipeline.State, string, error) {
+	results, err := d.db.GetScrapeResultsForItem(item.ID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get scrape results: %v", err)
+	}
+
+	downloadable, downloaded, rescrape := 0, 0, 0
+	for i := range results {
+		result := &results[i]
+		if result.StatusResults.String == "downloading" {
+			if err := d.RecoverResult(result); err != nil {
+				return "", "", err
+			}
+		}
+		switch {
+		case isDownloadable(result):
+			downloadable++
+		case result.StatusResults.String == "downloaded":
+			downloaded++
+		case result.StatusResults.String == "re-scrape":
+			rescrape++
+		}
+	}
+
+	switch {
+	case downloadable > 0:
+		return pipeline.StateDownloadPending, "download interrupted", nil
+	case downloaded > 0:
+		return pipeline.StateSymlinkPending, "downloaded on Real-Debrid", nil
+	case rescrape > 0:
+		return pipeline.StateScrapePending, "torrent gone from Real-Debrid", nil
+	default:
+		return pipeline.StateDownloadPending, "download interrupted", nil
+	}
+}
+
+// RecoverResult settles a scrape result left in downloading by the torrent's
+// status on Real-Debrid: downloaded, to be downloaded again, or re-scraped
+func (d *RealDebridDownloader) RecoverResult(result *database.ScrapeResult) error {
+	if !result.DebridID.Valid || result.DebridID.String == "" {
+		// Added before torrent IDs were kept; adding the hash again is harmless
+		return d.updateDownloadStatus(result, "pending_download", "no torrent ID, downloading again")
+	}
+
+	info, err := d.getTorrentInfo(result.DebridID.String)
+	if errors.Is(err, errTorrentNotFound) {
+		return d.updateDownloadStatus(result, "re-scrape", err.Error())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check torrent %s: %v", result.DebridID.String, err)
+	}
+
+	switch info.Status {
+	case "downloaded":
+		return d.updateDownloadStatus(result, "downloaded", "found downloaded on Real-Debrid")
+	case "error", "magnet_error", "virus", "dead":
+		return d.updateDownloadStatus(result, "re-scrape", fmt.Sprintf("torrent status is %s", info.Status))
+	default:
+		return d.updateDownloadStatus(result, "pending_download", fmt.Sprintf("torrent status is %s, progress %.2f%%", info.Status, info.Progress))
+	}
+}
+
 func (d *RealDebridDownloader) Start(ctx context.Context) error {
 	d.log.Info("RealDebridDownloader", "Start", "Starting downloader")
 	go func() {
+		wakeups := pipeline.ListenOrPoll(d.db, pipeline.StageDownloader)
+		defer wakeups.Close()
+
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			default:
-				item, err := d.db.GetNextItemForDownload()
+				lease, err := d.leaser.ClaimNext(pipeline.StageDownloader)
 				if err != nil {
 					d.log.Error("RealDebridDownloader", "Start", fmt.Sprintf("Error getting next item: %v", err))
 					time.Sleep(5 * time.Second)
 					continue
 				}
-				if item != nil {
-					if err := d.Download(item); err != nil {
-						d.log.Error("RealDebridDownloader", "Start", fmt.Sprintf("Error downloading item %d: %v", item.ID, err))
-					}
+				if lease == nil {
+					wakeups.Wait(ctx, pipeline.StageDownloader, pipeline.CheckInterval(d.config, pipeline.StageDownloader))
+					continue
+				}
+
+				item, err := d.db.GetWatchlistItem(lease.ItemID)
+				if err != nil {
+					d.log.Error("RealDebridDownloader", "Start", fmt.Sprintf("Error getting item %d: %v", lease.ItemID, err))
+				} else if err := d.downloadItem(lease.Context(), item); err != nil {
+					d.log.Error("RealDebridDownloader", "Start", fmt.Sprintf("Error downloading item %d: %v", item.ID, err))
 				}
-				time.Sleep(5 * time.Second)
+				lease.Release()
 			}
 		}
 	}()
@@ -417,8 +765,7 @@ func (d *RealDebridDownloader) IsNeeded() bool {
 	err := d.db.QueryRow(`
         SELECT COUNT(*) 
         FROM watchlistitem 
-        WHERE status = 'new' 
-        AND current_step = 'download_pending'
+        WHERE current_step = 'download_pending'
     `).Scan(&count)
 
 	return err == nil && count > 0
@@ -0,0 +1,380 @@
+package getcontent
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"mye-r/internal/config"
+	"mye-r/internal/database"
+	"mye-r/internal/logger"
+)
+
+// defaultDropFolderInterval is used when fetchers.dropfolder has no interval,
+// in minutes
+const defaultDropFolderInterval = 5
+
+// dropFolderSettle is how long a file must be left alone before it is read,
+// so files that are still being copied in are not imported half
+const dropFolderSettle = 10 * time.Second
+
+// DropFolderFetcher imports the files dropped into a directory: .txt files
+// with an ID or "Title (Year)" per line, .csv exports of IMDb and Letterboxd
+// and .jsonl files with a request per line. Every line is resolved on TMDB
+// and added like a manual request; processed files are archived with a
+// report of the lines that failed.
+type DropFolderFetcher struct {
+	cfg      *config.Config
+	log      *logger.Logger
+	requests *Requester
+	stop     chan struct{}
+}
+
+func NewDropFolderFetcher(cfg *config.Config, db *database.DB) *DropFolderFetcher {
+	return &DropFolderFetcher{
+		cfg:      cfg,
+		log:      logger.New(),
+		requests: NewRequester(cfg, db),
+		stop:     make(chan struct{}),
+	}
+}
+
+func (f *DropFolderFetcher) Start(ctx context.Context) {
+	interval := f.cfg.Fetchers["dropfolder"].Interval
+	if interval <= 0 {
+		interval = defaultDropFolderInterval
+	}
+
+	if err := f.Fetch(); err != nil {
+		f.log.Error("DropFolderFetcher", "Start", err.Error())
+	}
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			if err := f.Fetch(); err != nil {
+				f.log.Error("DropFolderFetcher", "Start", err.Error())
+			}
+		}
+	}
+}
+
+func (f *DropFolderFetcher) Stop() {
+	close(f.stop)
+}
+
+// Fetch imports every file waiting in the drop folder
+func (f *DropFolderFetcher) Fetch() error {
+	dropConfig := f.cfg.Fetchers["dropfolder"]
+	archive := dropConfig.ArchivePath
+	if archive == "" {
+		archive = filepath.Join(dropConfig.Path, "processed")
+	}
+
+	entries, err := os.ReadDir(dropConfig.Path)
+	if err != nil {
+		return fmt.Errorf("failed to list the drop folder: %v", err)
+	}
+
+	var failed int
+	for _, entry := range entries {
+		if entry.IsDir() || !importable(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < dropFolderSettle {
+			continue
+		}
+
+		path := filepath.Join(dropConfig.Path, entry.Name())
+		if err := f.importFile(path, archive); err != nil {
+			f.log.Error("DropFolderFetcher", "Fetch", fmt.Sprintf("Failed to import %s: %v", entry.Name(), err))
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be imported", failed)
+	}
+	return nil
+}
+
+// importable reports whether a file has one of the formats the drop folder
+// reads
+func importable(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".txt", ".csv", ".jsonl":
+		return true
+	}
+	return false
+}
+
+// importLine is a line of an import file with what became of it
+type importLine struct {
+	number int
+	text   string
+	req    Request
+	err    error
+}
+
+// importFile adds the items of one file and moves it to the archive with its
+// report. A file that can not be parsed at all stays in place.
+func (f *DropFolderFetcher) importFile(path, archive string) error {
+	lines, err := parseImportFile(path)
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Base(path)
+	var added, existing, failed int
+	for i := range lines {
+		line := &lines[i]
+		if line.err != nil {
+			failed++
+			continue
+		}
+		if line.req.RequestedBy == "" {
+			line.req.RequestedBy = "import " + name
+		}
+
+		var created bool
+		_, created, line.err = f.requests.Add(line.req)
+		switch {
+		case line.err != nil:
+			failed++
+		case created:
+			added++
+		default:
+			existing++
+		}
+	}
+	f.log.Info("DropFolderFetcher", "importFile", fmt.Sprintf("%s: %d added, %d already on the watchlist, %d failed", name, added, existing, failed))
+
+	if err := os.MkdirAll(archive, 0o755); err != nil {
+		return fmt.Errorf("failed to create the archive: %v", err)
+	}
+	archived := filepath.Join(archive, time.Now().Format("20060102-150405-")+name)
+	if err := os.Rename(path, archived); err != nil {
+		return fmt.Errorf("failed to archive the file: %v", err)
+	}
+	if failed > 0 {
+		return writeImportReport(archived+".report.txt", name, lines, failed)
+	}
+	return nil
+}
+
+// writeImportReport lists the lines of a file that were not added
+func writeImportReport(path, name string, lines []importLine, failed int) error {
+	var report strings.Builder
+	fmt.Fprintf(&report, "%s: %d of %d lines could not be imported\n\n", name, failed, len(lines))
+	for _, line := range lines {
+		if line.err != nil {
+			fmt.Fprintf(&report, "line %d: %s\n  %v\n", line.number, line.text, line.err)
+		}
+	}
+	if err := os.WriteFile(path, []byte(report.String()), 0o644); err != nil {
+		return fmt.Errorf("failed to write the report: %v", err)
+	}
+	return nil
+}
+
+// parseImportFile reads the requests of a file by its extension
+func parseImportFile(path string) ([]importLine, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return parseImportCSV(file)
+	case ".jsonl":
+		return parseImportLines(file, parseJSONLine)
+	default:
+		return parseImportLines(file, parseTextLine)
+	}
+}
+
+// parseImportLines reads a file line by line, skipping blank lines and
+// # comments
+func parseImportLines(r io.Reader, parse func(string) (Request, error)) ([]importLine, error) {
+	var lines []importLine
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		text := scanner.Text()
+		if number == 1 {
+			// Windows editors like to start files with a byte order mark
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		text = strings.TrimSpace(text)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		req, err := parse(text)
+		lines = append(lines, importLine{number: number, text: text, req: req, err: err})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read: %v", err)
+	}
+	return lines, nil
+}
+
+// parseTextLine reads an IMDb ID, an ID prefixed with its source or a title
+// with an optional (Year)
+func parseTextLine(text string) (Request, error) {
+	if _, _, err := ParseID(text); err == nil {
+		return Request{ID: text}, nil
+	}
+	title, year := extractTitleAndYear(text)
+	return Request{Query: title, Year: int(year.Int64)}, nil
+}
+
+// jsonImport is a line of a .jsonl file: a request, or the IDs and title of
+// an item
+type jsonImport struct {
+	Request
+	Title  string      `json:"title"`
+	ImdbID string      `json:"imdb_id"`
+	TmdbID json.Number `json:"tmdb_id"`
+	TvdbID json.Number `json:"tvdb_id"`
+}
+
+func parseJSONLine(text string) (Request, error) {
+	var line jsonImport
+	if err := json.Unmarshal([]byte(text), &line); err != nil {
+		return Request{}, fmt.Errorf("invalid JSON: %v", err)
+	}
+	req := line.Request
+	if req.ID == "" {
+		req.ID = importID(line.ImdbID, line.TmdbID.String(), line.TvdbID.String())
+	}
+	if req.ID == "" && req.Query == "" {
+		req.Query = line.Title
+	}
+	return req, nil
+}
+
+// importID picks the ID a request is made with, IMDb first
+func importID(imdbID, tmdbID, tvdbID string) string {
+	switch {
+	case imdbID != "":
+		return imdbID
+	case tmdbID != "" && tmdbID != "0":
+		return "tmdb:" + tmdbID
+	case tvdbID != "" && tvdbID != "0":
+		return "tvdb:" + tvdbID
+	}
+	return ""
+}
+
+// csvColumns are the headers import CSVs name their columns with, lower
+// cased. IMDb exports have Const, Title, Title Type and Year; Letterboxd
+// exports Name and Year.
+var csvColumns = map[string][]string{
+	"imdb":  {"const", "imdb_id", "imdb", "imdbid", "imdb id"},
+	"tmdb":  {"tmdb_id", "tmdb", "tmdbid", "tmdb id"},
+	"tvdb":  {"tvdb_id", "tvdb", "tvdbid", "tvdb id"},
+	"title": {"title", "name"},
+	"year":  {"year", "release year"},
+	"type":  {"title type", "type", "media_type", "media type"},
+}
+
+// imdbTitleTypes maps the Title Type of IMDb exports onto media types. Older
+// exports write them like tvSeries, newer ones like TV Series; both are looked
+// up lower cased without spaces.
+var imdbTitleTypes = map[string]string{
+	"movie":        "movie",
+	"tvmovie":      "movie",
+	"video":        "movie",
+	"short":        "movie",
+	"tvseries":     "tv",
+	"tvminiseries": "tv",
+	"tv":           "tv",
+	"show":         "tv",
+}
+
+func parseImportCSV(r io.Reader) ([]importLine, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the header: %v", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		for column, aliases := range csvColumns {
+			for _, alias := range aliases {
+				if _, seen := columns[column]; !seen && name == alias {
+					columns[column] = i
+				}
+			}
+		}
+	}
+	_, hasTitle := columns["title"]
+	_, hasIMDb := columns["imdb"]
+	_, hasTMDB := columns["tmdb"]
+	_, hasTVDB := columns["tvdb"]
+	if !hasTitle && !hasIMDb && !hasTMDB && !hasTVDB {
+		return nil, fmt.Errorf("the header has no title or ID column")
+	}
+
+	// Letterboxd only knows movies
+	defaultType := ""
+	for _, name := range header {
+		if strings.EqualFold(strings.TrimSpace(name), "letterboxd uri") {
+			defaultType = "movie"
+		}
+	}
+
+	var lines []importLine
+	for number := 2; ; number++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			lines = append(lines, importLine{number: number, err: fmt.Errorf("invalid CSV: %v", err)})
+			continue
+		}
+
+		field := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		line := importLine{number: number, text: strings.Join(record, ",")}
+		line.req.MediaType = defaultType
+		if mediaType, ok := imdbTitleTypes[strings.ToLower(strings.ReplaceAll(field("type"), " ", ""))]; ok {
+			line.req.MediaType = mediaType
+		}
+		if year := field("year"); year != "" {
+			line.req.Year, _ = strconv.Atoi(year)
+		}
+		if line.req.ID = importID(field("imdb"), field("tmdb"), field("tvdb")); line.req.ID == "" {
+			line.req.Query = field("title")
+		}
+		if line.req.ID == "" && line.req.Query == "" {
+			line.err = fmt.Errorf("no title or ID")
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
@@ -0,0 +1,167 @@
+package getcontent
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTextLine(t *testing.T) {
+	tests := []struct {
+		text string
+		want Request
+	}{
+		{"tt0133093", Request{ID: "tt0133093"}},
+		{"tmdb:603", Request{ID: "tmdb:603"}},
+		{"tmdb://603", Request{ID: "tmdb://603"}},
+		{"tvdb:81189", Request{ID: "tvdb:81189"}},
+		{"The Matrix (1999)", Request{Query: "The Matrix", Year: 1999}},
+		{"The Matrix", Request{Query: "The Matrix"}},
+		{"Blade Runner 2049", Request{Query: "Blade Runner 2049"}},
+		{"tt123abc", Request{Query: "tt123abc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := parseTextLine(tt.text)
+			if err != nil {
+				t.Fatalf("parseTextLine(%q) failed: %v", tt.text, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseTextLine(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJSONLine(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    Request
+		wantErr bool
+	}{
+		{`{"id": "tt0133093"}`, Request{ID: "tt0133093"}, false},
+		{`{"query": "The Matrix", "year": 1999, "media_type": "movie"}`, Request{Query: "The Matrix", Year: 1999, MediaType: "movie"}, false},
+		{`{"imdb_id": "tt0133093", "tmdb_id": 603}`, Request{ID: "tt0133093"}, false},
+		{`{"tmdb_id": 603, "media_type": "movie"}`, Request{ID: "tmdb:603", MediaType: "movie"}, false},
+		{`{"tmdb_id": 0, "tvdb_id": 81189}`, Request{ID: "tvdb:81189"}, false},
+		{`{"title": "Breaking Bad", "tmdb_id": 0}`, Request{Query: "Breaking Bad"}, false},
+		{`{"id": "tmdb:1396", "title": "Breaking Bad"}`, Request{ID: "tmdb:1396"}, false},
+		{`{"id": }`, Request{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := parseJSONLine(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseJSONLine(%q) error = %v, want error %v", tt.text, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseJSONLine(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseImportLines(t *testing.T) {
+	input := "\ufefftt0133093\n\n# comments are skipped\n  The Matrix (1999)  \n"
+	lines, err := parseImportLines(strings.NewReader(input), parseTextLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []importLine{
+		{number: 1, text: "tt0133093", req: Request{ID: "tt0133093"}},
+		{number: 4, text: "The Matrix (1999)", req: Request{Query: "The Matrix", Year: 1999}},
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("parseImportLines = %+v, want %+v", lines, want)
+	}
+}
+
+func TestParseImportCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Request
+	}{
+		{
+			name: "IMDb watchlist export",
+			input: "\ufeffPosition,Const,Created,Modified,Description,Title,Original Title,URL,Title Type,IMDb Rating,Runtime (mins),Year,Genres,Num Votes,Release Date,Directors,Your Rating,Date Rated\n" +
+				"1,tt0133093,2024-01-01,2024-01-01,,The Matrix,The Matrix,https://www.imdb.com/title/tt0133093/,Movie,8.7,136,1999,\"Action, Sci-Fi\",2000000,1999-03-31,Lana Wachowski,,\n" +
+				"2,tt0903747,2024-01-01,2024-01-01,,Breaking Bad,Breaking Bad,https://www.imdb.com/title/tt0903747/,TV Series,9.5,49,2008,Drama,2000000,2008-01-20,,,\n" +
+				"3,tt0795176,2024-01-01,2024-01-01,,Planet Earth,Planet Earth,https://www.imdb.com/title/tt0795176/,TV Mini Series,9.4,538,2006,Documentary,200000,2006-03-05,,,\n",
+			want: []Request{
+				{ID: "tt0133093", MediaType: "movie", Year: 1999},
+				{ID: "tt0903747", MediaType: "tv", Year: 2008},
+				{ID: "tt0795176", MediaType: "tv", Year: 2006},
+			},
+		},
+		{
+			name: "older IMDb export",
+			input: "Position,Const,Created,Modified,Description,Title,URL,Title Type,IMDb Rating,Runtime (mins),Year,Genres,Num Votes,Release Date,Directors\n" +
+				"1,tt0133093,2020-01-01,2020-01-01,,The Matrix,https://www.imdb.com/title/tt0133093/,movie,8.7,136,1999,Action,2000000,1999-03-31,\n" +
+				"2,tt0903747,2020-01-01,2020-01-01,,Breaking Bad,https://www.imdb.com/title/tt0903747/,tvSeries,9.5,49,2008,Drama,2000000,2008-01-20,\n" +
+				"3,tt0120737,2020-01-01,2020-01-01,,Some TV Movie,https://www.imdb.com/title/tt0120737/,tvMovie,7.0,90,2001,Drama,1000,2001-01-01,\n",
+			want: []Request{
+				{ID: "tt0133093", MediaType: "movie", Year: 1999},
+				{ID: "tt0903747", MediaType: "tv", Year: 2008},
+				{ID: "tt0120737", MediaType: "movie", Year: 2001},
+			},
+		},
+		{
+			name: "Letterboxd watchlist export",
+			input: "Date,Name,Year,Letterboxd URI\n" +
+				"2024-01-01,The Matrix,1999,https://boxd.it/28Q8\n" +
+				"2024-01-02,Perfect Blue,1997,https://boxd.it/1ZhE\n",
+			want: []Request{
+				{Query: "The Matrix", MediaType: "movie", Year: 1999},
+				{Query: "Perfect Blue", MediaType: "movie", Year: 1997},
+			},
+		},
+		{
+			name: "TMDB and TVDB columns",
+			input: "title,tmdb_id,tvdb_id,media_type\n" +
+				"The Matrix,603,,movie\n" +
+				"Breaking Bad,,81189,tv\n" +
+				"Dune,0,,\n",
+			want: []Request{
+				{ID: "tmdb:603", MediaType: "movie"},
+				{ID: "tvdb:81189", MediaType: "tv"},
+				{Query: "Dune"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := parseImportCSV(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("parseImportCSV failed: %v", err)
+			}
+			var got []Request
+			for _, line := range lines {
+				if line.err != nil {
+					t.Errorf("line %d: %v", line.number, line.err)
+				}
+				got = append(got, line.req)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseImportCSV = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseImportCSVErrors(t *testing.T) {
+	if _, err := parseImportCSV(strings.NewReader("Position,Created,Description\n1,2024-01-01,\n")); err == nil {
+		t.Error("a header without a title or ID column was accepted")
+	}
+	if _, err := parseImportCSV(strings.NewReader("")); err == nil {
+		t.Error("an empty file was accepted")
+	}
+
+	lines, err := parseImportCSV(strings.NewReader("Const,Title\n,\ntt0133093,The Matrix\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0].err == nil || lines[0].number != 2 || lines[1].err != nil {
+		t.Errorf("parseImportCSV = %+v, want line 2 to fail and line 3 to parse", lines)
+	}
+}
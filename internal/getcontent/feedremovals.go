@@ -0,0 +1,172 @@
+package getcontent
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"mye-r/internal/config"
+	"mye-r/internal/database"
+	"mye-r/internal/pipeline"
+)
+
+// feedGUID is what an entry of a feed is tracked by: its guid, or its link
+// or title for feeds without one
+func feedGUID(guid string, item *database.WatchlistItem) string {
+	switch {
+	case guid != "":
+		return guid
+	case item.Link.Valid && item.Link.String != "":
+		return item.Link.String
+	case item.ItemYear.Valid:
+		return fmt.Sprintf("%s (%d)", item.Title, item.ItemYear.Int64)
+	default:
+		return item.Title
+	}
+}
+
+// syncRemovals records what a feed contains and applies on_remove to the
+// items it dropped. Items are only touched when no other feed has them and
+// nobody requested them by hand, a requester stamped by a feed not counting;
+// items a stage is working on wait for the next fetch.
+func (f *PlexRSSFetcher) syncRemovals(url string, seen map[string]database.FeedEntry) error {
+	// An empty feed is more likely a hiccup of Plex than an emptied watchlist
+	if len(seen) == 0 {
+		f.log.Warning("PlexRSSFetcher", "syncRemovals", fmt.Sprintf("Feed %s is empty, not checking it for removed items", url))
+		return nil
+	}
+
+	missing, err := f.db.SyncFeedItems(url, seen)
+	if err != nil {
+		return err
+	}
+	f.applyRemovals(url, missing)
+	return nil
+}
+
+// recheckRemovals applies on_remove again to the entries a feed that did not
+// change dropped earlier, whose items may have left a working state or
+// waited out remove_grace since
+func (f *PlexRSSFetcher) recheckRemovals(url string) error {
+	if f.cfg.Fetchers["plexrss"].OnRemove == config.OnRemoveLeave {
+		return nil
+	}
+	missing, err := f.db.MissingFeedItems(url)
+	if err != nil {
+		return err
+	}
+	f.applyRemovals(url, missing)
+	return nil
+}
+
+// applyRemovals applies on_remove to the entries a feed dropped
+func (f *PlexRSSFetcher) applyRemovals(url string, missing []database.FeedItem) {
+	plexRSSConfig := f.cfg.Fetchers["plexrss"]
+	if plexRSSConfig.OnRemove == config.OnRemoveLeave {
+		return
+	}
+
+	for _, entry := range missing {
+		done, err := f.removeMissing(entry, plexRSSConfig)
+		if err != nil {
+			f.log.Error("PlexRSSFetcher", "applyRemovals", fmt.Sprintf("Failed to remove item %d, dropped from %s: %v", entry.WatchlistItemID, url, err))
+			continue
+		}
+		if done {
+			// Deleting the item deleted the entry with it, unless there was none
+			if err := f.db.DeleteFeedItem(entry.FeedURL, entry.GUID); err != nil {
+				f.log.Error("PlexRSSFetcher", "applyRemovals", err.Error())
+			}
+		}
+	}
+}
+
+// removeMissing applies on_remove to the item of an entry a feed dropped. It
+// reports whether the entry is settled, either because the item is gone or
+// because it stays.
+func (f *PlexRSSFetcher) removeMissing(entry database.FeedItem, plexRSSConfig config.FetcherConfig) (bool, error) {
+	if entry.WatchlistItemID == 0 {
+		return true, nil
+	}
+	listed, err := f.db.FeedItemListed(entry.WatchlistItemID)
+	if err != nil {
+		return false, err
+	}
+	if listed {
+		// Kept, so the entry still records whether this feed owns the item
+		return false, nil
+	}
+	item, err := f.db.GetWatchlistItem(entry.WatchlistItemID)
+	if errors.Is(err, database.ErrItemNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if item.RequestedBy.Valid && item.RequestedBy.String != "" {
+		owned, err := f.db.FeedOwnsItem(item.ID)
+		if err != nil {
+			return false, err
+		}
+		if !owned {
+			f.log.Info("PlexRSSFetcher", "removeMissing", fmt.Sprintf("Keeping %s, dropped from the watchlist but requested by %s", item.Title, item.RequestedBy.String))
+			return true, nil
+		}
+	}
+
+	state := pipeline.State(item.CurrentStep.String)
+	if stage, ok := pipeline.StageFor(state); ok && state == stage.WorkingState() {
+		return false, nil
+	}
+
+	reason := "dropped from the watchlist"
+	if !downloaded(state) {
+		// Failed downloads may have left a torrent behind
+		if err := f.downloader.RemoveTorrents(item); err != nil {
+			return false, err
+		}
+		return f.deleteItem(item, state, reason)
+	}
+
+	if plexRSSConfig.OnRemove != config.OnRemoveDelete {
+		f.log.Info("PlexRSSFetcher", "removeMissing", fmt.Sprintf("Keeping %s, dropped from the watchlist after it was downloaded", item.Title))
+		return true, nil
+	}
+	if time.Since(entry.MissingSince.Time) < plexRSSConfig.RemoveGrace {
+		return false, nil
+	}
+	if state == pipeline.StateCompleted || state == pipeline.StateSymlinkFailed {
+		if err := f.symlinker.Unlink(item); err != nil {
+			return false, fmt.Errorf("failed to remove symlinks: %v", err)
+		}
+	}
+	if err := f.downloader.RemoveTorrents(item); err != nil {
+		return false, err
+	}
+	return f.deleteItem(item, state, fmt.Sprintf("%s more than %s ago", reason, plexRSSConfig.RemoveGrace))
+}
+
+// downloaded reports whether an item in state got as far as Real-Debrid
+func downloaded(state pipeline.State) bool {
+	stage, ok := pipeline.StageFor(state)
+	if !ok {
+		return state == pipeline.StateCompleted
+	}
+	switch stage {
+	case pipeline.StageIndexer, pipeline.StageLibraryMatcher, pipeline.StageScraper:
+		return false
+	case pipeline.StageDownloader:
+		return state == pipeline.StateDownloading
+	default:
+		return true
+	}
+}
+
+func (f *PlexRSSFetcher) deleteItem(item *database.WatchlistItem, state pipeline.State, reason string) (bool, error) {
+	deleted, err := f.db.DeleteWatchlistItem(item.ID, string(state), reason)
+	if err != nil || !deleted {
+		return false, err
+	}
+	f.log.Info("PlexRSSFetcher", "deleteItem", fmt.Sprintf("Removed %s (%d) in %s: %s", item.Title, item.ItemYear.Int64, state, reason))
+	return true, nil
+}
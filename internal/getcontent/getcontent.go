@@ -10,6 +10,7 @@ import (
 type Fetcher interface {
 	Start(context.Context)
 	Stop()
+	Fetch() error
 }
 
 type GetContent struct {
@@ -32,6 +33,14 @@ func New(cfg *config.Config, db *database.DB) (*GetContent, error) {
 			switch name {
 			case "plexrss":
 				gc.fetchers[name] = NewPlexRSSFetcher(cfg, db)
+			case "trakt":
+				fetcher, err := NewTraktFetcher(cfg, db)
+				if err != nil {
+					return nil, err
+				}
+				gc.fetchers[name] = fetcher
+			case "dropfolder":
+				gc.fetchers[name] = NewDropFolderFetcher(cfg, db)
 			default:
 				gc.log.Warning("GetContent", "New", "Unknown fetcher type: "+name)
 			}
@@ -52,6 +61,21 @@ func (gc *GetContent) Start(ctx context.Context) error {
 	return nil
 }
 
+// Fetch runs every fetcher once and returns the first error
+func (gc *GetContent) Fetch() error {
+	var firstErr error
+	for name, fetcher := range gc.fetchers {
+		gc.log.Info("GetContent", "Fetch", "Running "+name+" fetcher")
+		if err := fetcher.Fetch(); err != nil {
+			gc.log.Error("GetContent", "Fetch", name+" fetcher failed: "+err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
 func (gc *GetContent) Stop() error {
 	for name, fetcher := range gc.fetchers {
 		gc.log.Info("GetContent", "Stop", "Stopping "+name+" fetcher")
@@ -64,14 +88,8 @@ func (gc *GetContent) Name() string {
     return "plexrss"
 }
 
+// IsNeeded reports whether any fetcher is enabled. Fetchers create items
+// rather than consume them, so there is no queue to look at.
 func (gc *GetContent) IsNeeded() bool {
-    var count int
-    err := gc.db.QueryRow(`
-        SELECT COUNT(*) 
-        FROM watchlistitem 
-        WHERE status = 'new' 
-        AND current_step = 'fetch_pending'
-    `).Scan(&count)
-    
-    return err == nil && count > 0
+    return len(gc.fetchers) > 0
 }
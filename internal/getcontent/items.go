@@ -0,0 +1,47 @@
+package getcontent
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"mye-r/internal/database"
+	"mye-r/internal/pipeline"
+)
+
+// findExistingItem looks for an item already on the watchlist, first by any
+// of its external IDs and then by title and year. It returns nil if there is
+// none.
+func findExistingItem(db *database.DB, item *database.WatchlistItem) (*database.WatchlistItem, error) {
+	existing, err := db.FindWatchlistItemByIDs(item.ImdbID.String, item.TmdbID.String, item.TvdbID.String)
+	if err != nil {
+		return nil, fmt.Errorf("error checking if item exists in database by IDs: %v", err)
+	}
+
+	if existing == nil && item.ItemYear.Valid {
+		existing, err = db.FindWatchlistItemByTitleAndYear(item.Title, item.ItemYear.Int64)
+		if err != nil {
+			return nil, fmt.Errorf("error checking if item exists in database by title and year: %v", err)
+		}
+	}
+	return existing, nil
+}
+
+// createItem adds a new item to the watchlist, waiting to be indexed
+func createItem(db *database.DB, item *database.WatchlistItem) error {
+	return createItemIn(db, item, pipeline.StateIndexingPending)
+}
+
+// createItemIn adds a new item to the watchlist in state, indexing_pending or
+// approval_pending
+func createItemIn(db *database.DB, item *database.WatchlistItem, state pipeline.State) error {
+	now := time.Now()
+	item.Status = sql.NullString{String: state.Status(), Valid: true}
+	item.CurrentStep = sql.NullString{String: string(state), Valid: true}
+	item.CreatedAt = now
+	item.UpdatedAt = now
+	if item.RequestedDate.IsZero() {
+		item.RequestedDate = now.Truncate(time.Second)
+	}
+	return db.CreateWatchlistItem(item)
+}
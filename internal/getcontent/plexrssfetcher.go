@@ -14,16 +14,30 @@ import (
 
 	"mye-r/internal/config"
 	"mye-r/internal/database"
+	"mye-r/internal/downloader"
 	"mye-r/internal/logger"
+	"mye-r/internal/pipeline"
+	"mye-r/internal/symlinker"
 )
 
 type PlexRSSFetcher struct {
 	cfg  *config.Config
 	db   *database.DB
-	log  *logger.Logger
-	stop chan struct{}
+	log    *logger.Logger
+	stop   chan struct{}
+	client *http.Client
+
+	// Used to delete items that dropped off a feed
+	symlinker  *symlinker.Symlinker
+	downloader *downloader.RealDebridDownloader
 }
 
+// feedTimeout bounds a whole feed request, body included
+const feedTimeout = 30 * time.Second
+
+// feedBackoff spaces out the fetches of a feed that keeps failing
+var feedBackoff = pipeline.RetryPolicy{BaseDelay: time.Minute, MaxDelay: time.Hour}
+
 type MediaKeywords struct {
 	Keywords string `xml:",chardata"`
 }
@@ -37,10 +51,13 @@ func NewPlexRSSFetcher(cfg *config.Config, db *database.DB) *PlexRSSFetcher {
 	log := logger.New()
 	log.Info("PlexRSSFetcher", "NewPlexRSSFetcher", "Creating new PlexRSSFetcher instance")
 	fetcher := &PlexRSSFetcher{
-		cfg:  cfg,
-		db:   db,
-		log:  log,
-		stop: make(chan struct{}),
+		cfg:        cfg,
+		db:         db,
+		log:        log,
+		stop:       make(chan struct{}),
+		client:     &http.Client{Timeout: feedTimeout},
+		symlinker:  symlinker.New(cfg, db),
+		downloader: downloader.New(cfg, db),
 	}
 	log.Info("PlexRSSFetcher", "NewPlexRSSFetcher", "PlexRSSFetcher instance created successfully")
 	return fetcher
@@ -55,15 +72,15 @@ func (f *PlexRSSFetcher) Start(ctx context.Context) {
 	}
 
 	f.log.Info("PlexRSSFetcher", "Start", fmt.Sprintf("PlexRSSFetcher configured with interval: %d minutes", plexRSSConfig.Interval))
-	f.log.Info("PlexRSSFetcher", "Start", fmt.Sprintf("Configured URLs: %v", plexRSSConfig.URLs))
+	f.log.Info("PlexRSSFetcher", "Start", fmt.Sprintf("Configured feeds: %d", len(plexRSSConfig.URLs)))
 
 	// Perform initial fetch
 	f.log.Info("PlexRSSFetcher", "Start", "Performing initial fetch")
-	for _, url := range plexRSSConfig.URLs {
-		f.log.Info("PlexRSSFetcher", "Start", fmt.Sprintf("Fetching from URL: %s", url))
-		err := f.fetchWithCustomParser(url)
+	for _, feed := range plexRSSConfig.URLs {
+		f.log.Info("PlexRSSFetcher", "Start", fmt.Sprintf("Fetching from URL: %s", feed.URL))
+		err := f.fetchWithCustomParser(feed)
 		if err != nil {
-			f.log.Error("PlexRSSFetcher", "Start", fmt.Sprintf("Error fetching from URL %s: %v", url, err))
+			f.log.Error("PlexRSSFetcher", "Start", fmt.Sprintf("Error fetching from URL %s: %v", feed.URL, err))
 		}
 	}
 
@@ -81,11 +98,11 @@ func (f *PlexRSSFetcher) Start(ctx context.Context) {
 			return
 		case <-ticker.C:
 			f.log.Info("PlexRSSFetcher", "Start", "Ticker triggered, starting fetch process")
-			for _, url := range plexRSSConfig.URLs {
-				f.log.Info("PlexRSSFetcher", "Start", fmt.Sprintf("Fetching from URL: %s", url))
-				err := f.fetchWithCustomParser(url)
+			for _, feed := range plexRSSConfig.URLs {
+				f.log.Info("PlexRSSFetcher", "Start", fmt.Sprintf("Fetching from URL: %s", feed.URL))
+				err := f.fetchWithCustomParser(feed)
 				if err != nil {
-					f.log.Error("PlexRSSFetcher", "Start", fmt.Sprintf("Error fetching from URL %s: %v", url, err))
+					f.log.Error("PlexRSSFetcher", "Start", fmt.Sprintf("Error fetching from URL %s: %v", feed.URL, err))
 				}
 			}
 			f.log.Info("PlexRSSFetcher", "Start", "Fetch process completed")
@@ -93,26 +110,118 @@ func (f *PlexRSSFetcher) Start(ctx context.Context) {
 	}
 }
 
+// Fetch fetches every configured feed once
+func (f *PlexRSSFetcher) Fetch() error {
+	plexRSSConfig, ok := f.cfg.Fetchers["plexrss"]
+	if !ok || !plexRSSConfig.Enabled {
+		return fmt.Errorf("plexrss fetcher not enabled or not configured")
+	}
+
+	var failed int
+	for _, feed := range plexRSSConfig.URLs {
+		f.log.Info("PlexRSSFetcher", "Fetch", fmt.Sprintf("Fetching from URL: %s", feed.URL))
+		if err := f.fetchWithCustomParser(feed); err != nil {
+			f.log.Error("PlexRSSFetcher", "Fetch", fmt.Sprintf("Error fetching from URL %s: %v", feed.URL, err))
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d feeds failed", failed, len(plexRSSConfig.URLs))
+	}
+	return nil
+}
+
 func (f *PlexRSSFetcher) Stop() {
 	f.log.Info("PlexRSSFetcher", "Stop", "Stopping PlexRSSFetcher")
 	close(f.stop)
 	f.log.Info("PlexRSSFetcher", "Stop", "PlexRSSFetcher stopped")
 }
 
-func (f *PlexRSSFetcher) fetchWithCustomParser(url string) error {
+// fetchWithCustomParser fetches a feed unless it is backing off after
+// failures, and records how it went in feed_state
+func (f *PlexRSSFetcher) fetchWithCustomParser(feed config.FeedConfig) error {
+	url := feed.URL
 	f.log.Info("PlexRSSFetcher", "fetchWithCustomParser", fmt.Sprintf("Starting fetch from URL: %s", url))
 
-	resp, err := http.Get(url)
+	state, err := f.db.GetFeedState(url)
+	if err != nil {
+		return err
+	}
+	if state.NextAttemptAt.Valid && time.Now().Before(state.NextAttemptAt.Time) {
+		f.log.Info("PlexRSSFetcher", "fetchWithCustomParser", fmt.Sprintf("Skipping %s until %s after %d failures, last: %s",
+			url, state.NextAttemptAt.Time.Format(time.RFC3339), state.Failures, state.LastError.String))
+		return nil
+	}
+
+	if err := f.fetchFeed(feed, state); err != nil {
+		f.recordFailure(url, err)
+		return err
+	}
+	return nil
+}
+
+// recordFailure counts a failed fetch and backs the feed off
+func (f *PlexRSSFetcher) recordFailure(url string, cause error) {
+	failures, err := f.db.RecordFeedFailure(url, cause.Error())
+	if err != nil {
+		f.log.Error("PlexRSSFetcher", "recordFailure", err.Error())
+		return
+	}
+	delay := feedBackoff.Backoff(failures)
+	if err := f.db.SetFeedNextAttempt(url, time.Now().Add(delay)); err != nil {
+		f.log.Error("PlexRSSFetcher", "recordFailure", err.Error())
+		return
+	}
+	f.log.Warning("PlexRSSFetcher", "recordFailure", fmt.Sprintf("Feed %s failed %d times in a row, next attempt in %s", url, failures, delay.Round(time.Second)))
+}
+
+// fetchFeed fetches a feed with the validators of the last answer. An
+// unmodified feed only has the entries it dropped earlier checked again;
+// otherwise only entries that were not added from it before are processed.
+func (f *PlexRSSFetcher) fetchFeed(feed config.FeedConfig, state *database.FeedState) error {
+	url := feed.URL
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("invalid feed URL: %v", err)
+	}
+	if state.ETag != "" {
+		req.Header.Set("If-None-Match", state.ETag)
+	}
+	if state.LastModified != "" {
+		req.Header.Set("If-Modified-Since", state.LastModified)
+	}
+
+	resp, err := f.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("error fetching RSS feed: %v", err)
 	}
 	defer resp.Body.Close()
 
-	f.log.Info("PlexRSSFetcher", "fetchWithCustomParser", "Successfully fetched RSS feed, starting to parse")
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		f.log.Info("PlexRSSFetcher", "fetchFeed", fmt.Sprintf("Feed %s has not changed", url))
+		// Items it dropped may have waited out remove_grace or their stage
+		if err := f.recheckRemovals(url); err != nil {
+			return err
+		}
+		return f.db.RecordFeedSuccess(url, headerOr(resp, "ETag", state.ETag), headerOr(resp, "Last-Modified", state.LastModified))
+	default:
+		return fmt.Errorf("feed answered %s", resp.Status)
+	}
+
+	f.log.Info("PlexRSSFetcher", "fetchFeed", "Successfully fetched RSS feed, starting to parse")
+
+	known, err := f.db.FeedItemIDs(url)
+	if err != nil {
+		return err
+	}
 
 	decoder := xml.NewDecoder(resp.Body)
 	var currentItem *database.WatchlistItem
-	itemCount := 0
+	var currentGUID string
+	itemCount, unchanged := 0, 0
+	seen := make(map[string]database.FeedEntry)
 
 	for {
 		tok, err := decoder.Token()
@@ -120,15 +229,16 @@ func (f *PlexRSSFetcher) fetchWithCustomParser(url string) error {
 			break
 		}
 		if err != nil {
-			f.log.Error("PlexRSSFetcher", "fetchWithCustomParser", fmt.Sprintf("Error decoding XML: %v", err))
+			f.log.Error("PlexRSSFetcher", "fetchFeed", fmt.Sprintf("Error decoding XML: %v", err))
 			return fmt.Errorf("error decoding XML: %v", err)
 		}
 
 		switch elem := tok.(type) {
 		case xml.StartElement:
 			if elem.Name.Local == "item" {
-				f.log.Info("PlexRSSFetcher", "fetchWithCustomParser", "Found new item, starting to parse")
+				f.log.Debug("PlexRSSFetcher", "fetchFeed", "Found new item, starting to parse")
 				currentItem = &database.WatchlistItem{}
+				currentGUID = ""
 				itemCount++
 			}
 			if currentItem != nil {
@@ -137,7 +247,7 @@ func (f *PlexRSSFetcher) fetchWithCustomParser(url string) error {
 					var title string
 					decoder.DecodeElement(&title, &elem)
 					currentItem.Title, currentItem.ItemYear = extractTitleAndYear(title)
-					f.log.Info("PlexRSSFetcher", "fetchWithCustomParser", fmt.Sprintf("Parsed title: %s, year: %d", currentItem.Title, currentItem.ItemYear.Int64))
+					f.log.Debug("PlexRSSFetcher", "fetchFeed", fmt.Sprintf("Parsed title: %s, year: %d", currentItem.Title, currentItem.ItemYear.Int64))
 				case elem.Name.Local == "link":
 					var link string
 					decoder.DecodeElement(&link, &elem)
@@ -149,17 +259,18 @@ func (f *PlexRSSFetcher) fetchWithCustomParser(url string) error {
 					if err == nil {
 						currentItem.RequestedDate = parsedDate.Truncate(time.Second)
 					}
-					f.log.Info("PlexRSSFetcher", "fetchWithCustomParser", fmt.Sprintf("Parsed pubDate: %s", currentItem.RequestedDate))
+					f.log.Debug("PlexRSSFetcher", "fetchFeed", fmt.Sprintf("Parsed pubDate: %s", currentItem.RequestedDate))
 				case elem.Name.Local == "guid":
 					var guid string
 					decoder.DecodeElement(&guid, &elem)
+					currentGUID = guid
 					currentItem.ImdbID, currentItem.TmdbID, currentItem.TvdbID = extractIDs(guid)
-					f.log.Info("PlexRSSFetcher", "fetchWithCustomParser", fmt.Sprintf("Parsed IDs - IMDB: %s, TMDB: %s, TVDB: %s", currentItem.ImdbID.String, currentItem.TmdbID.String, currentItem.TvdbID.String))
+					f.log.Debug("PlexRSSFetcher", "fetchFeed", fmt.Sprintf("Parsed IDs - IMDB: %s, TMDB: %s, TVDB: %s", currentItem.ImdbID.String, currentItem.TmdbID.String, currentItem.TvdbID.String))
 				case elem.Name.Local == "description":
 					var desc string
 					decoder.DecodeElement(&desc, &elem)
 					currentItem.Description = sql.NullString{String: desc, Valid: true}
-					f.log.Info("PlexRSSFetcher", "fetchWithCustomParser", fmt.Sprintf("Parsed description: %s", currentItem.Description.String))
+					f.log.Debug("PlexRSSFetcher", "fetchFeed", fmt.Sprintf("Parsed description: %s", currentItem.Description.String))
 				case elem.Name.Local == "category":
 					var category string
 					decoder.DecodeElement(&category, &elem)
@@ -170,17 +281,17 @@ func (f *PlexRSSFetcher) fetchWithCustomParser(url string) error {
 					} else if strings.ToLower(category) == "movie" {
 						currentItem.MediaType = sql.NullString{String: "movie", Valid: true}
 					}
-					f.log.Info("PlexRSSFetcher", "fetchWithCustomParser", fmt.Sprintf("Parsed category: %s, set media_type: %s", currentItem.Category.String, currentItem.MediaType.String))
+					f.log.Debug("PlexRSSFetcher", "fetchFeed", fmt.Sprintf("Parsed category: %s, set media_type: %s", currentItem.Category.String, currentItem.MediaType.String))
 				case elem.Name.Local == "keywords" && elem.Name.Space == "http://search.yahoo.com/mrss/":
 					var keywords string
 					decoder.DecodeElement(&keywords, &elem)
 					currentItem.Genres = sql.NullString{String: keywords, Valid: keywords != ""}
-					f.log.Info("PlexRSSFetcher", "fetchWithCustomParser", fmt.Sprintf("Parsed keywords (genres): %s", keywords))
+					f.log.Debug("PlexRSSFetcher", "fetchFeed", fmt.Sprintf("Parsed keywords (genres): %s", keywords))
 				case elem.Name.Local == "rating" && elem.Name.Space == "http://search.yahoo.com/mrss/":
 					var rating string
 					decoder.DecodeElement(&rating, &elem)
 					currentItem.Rating = sql.NullString{String: rating, Valid: rating != ""}
-					f.log.Info("PlexRSSFetcher", "fetchWithCustomParser", fmt.Sprintf("Parsed rating: %s", rating))
+					f.log.Debug("PlexRSSFetcher", "fetchFeed", fmt.Sprintf("Parsed rating: %s", rating))
 				case elem.Name.Local == "thumbnail" && elem.Name.Space == "http://search.yahoo.com/mrss/":
 					for _, attr := range elem.Attr {
 						if attr.Name.Local == "url" {
@@ -208,32 +319,45 @@ func (f *PlexRSSFetcher) fetchWithCustomParser(url string) error {
 			}
 		case xml.EndElement:
 			if elem.Name.Local == "item" && currentItem != nil {
-				f.log.Info("PlexRSSFetcher", "fetchWithCustomParser", "Finished parsing item, processing it")
-				f.processCustomParsedItem(currentItem)
+				guid := feedGUID(currentGUID, currentItem)
+				if itemID, ok := known[guid]; ok {
+					seen[guid] = database.FeedEntry{ItemID: itemID}
+					unchanged++
+				} else {
+					f.log.Info("PlexRSSFetcher", "fetchFeed", "Finished parsing item, processing it")
+					seen[guid] = f.processCustomParsedItem(currentItem, feed)
+				}
 				currentItem = nil
 			}
 		}
 	}
 
-	f.log.Info("PlexRSSFetcher", "fetchWithCustomParser", fmt.Sprintf("Finished parsing RSS feed. Total items processed: %d", itemCount))
-	return nil
+	f.log.Info("PlexRSSFetcher", "fetchFeed", fmt.Sprintf("Finished parsing RSS feed. Total items: %d, processed: %d", itemCount, itemCount-unchanged))
+	if err := f.syncRemovals(url, seen); err != nil {
+		return err
+	}
+	return f.db.RecordFeedSuccess(url, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"))
 }
 
-func (f *PlexRSSFetcher) processCustomParsedItem(item *database.WatchlistItem) {
-	// First try to find by IDs
-	existingItem, err := f.db.FindWatchlistItemByIDs(item.ImdbID.String, item.TmdbID.String, item.TvdbID.String)
-	if err != nil {
-		f.log.Error("PlexRSSFetcher", "processCustomParsedItem", fmt.Sprintf("Error checking if item exists in database by IDs: %v", err))
-		return
+// headerOr returns a header of the answer, or fallback when it has none
+func headerOr(resp *http.Response, name, fallback string) string {
+	if value := resp.Header.Get(name); value != "" {
+		return value
 	}
+	return fallback
+}
 
-	// If not found by IDs, try to find by title and year
-	if existingItem == nil && item.ItemYear.Valid {
-		existingItem, err = f.db.FindWatchlistItemByTitleAndYear(item.Title, item.ItemYear.Int64)
-		if err != nil {
-			f.log.Error("PlexRSSFetcher", "processCustomParsedItem", fmt.Sprintf("Error checking if item exists in database by title and year: %v", err))
-			return
-		}
+// processCustomParsedItem adds or updates an item and returns what the entry
+// was added as, with no item when that failed. New items get the requester,
+// library and profile of the feed and wait for approval when it asks for
+// that. Items already on the watchlist stay where they are in the pipeline
+// and only take the requester, library and profile they have none of, so
+// whatever another feed or a user set is kept.
+func (f *PlexRSSFetcher) processCustomParsedItem(item *database.WatchlistItem, feed config.FeedConfig) database.FeedEntry {
+	existingItem, err := findExistingItem(f.db, item)
+	if err != nil {
+		f.log.Error("PlexRSSFetcher", "processCustomParsedItem", err.Error())
+		return database.FeedEntry{}
 	}
 
 	f.log.Info("PlexRSSFetcher", "processCustomParsedItem", fmt.Sprintf("Preparing to process item: Title: %s, ItemYear: %d, ImdbID: %s, TmdbID: %s, TvdbID: %s",
@@ -242,22 +366,31 @@ func (f *PlexRSSFetcher) processCustomParsedItem(item *database.WatchlistItem) {
 	if existingItem == nil {
 		f.log.Info("PlexRSSFetcher", "processCustomParsedItem", fmt.Sprintf("New item found: %s (%d)", item.Title, item.ItemYear.Int64))
 
-		item.Status = sql.NullString{String: "new", Valid: true}
-		item.CurrentStep = sql.NullString{String: "indexing_pending", Valid: true}  // Set to first step for TMDB indexing
-		f.log.Info("PlexRSSFetcher", "processCustomParsedItem", fmt.Sprintf("Setting current_step to: %s (valid: %v)", item.CurrentStep.String, item.CurrentStep.Valid))
-		item.CreatedAt = time.Now()
-		item.UpdatedAt = time.Now()
+		item.RequestedBy = sql.NullString{String: feed.RequestedBy, Valid: feed.RequestedBy != ""}
+		item.ForcedLibrary = sql.NullString{String: feed.Library, Valid: feed.Library != ""}
+		item.QualityProfile = sql.NullString{String: feed.QualityProfile, Valid: feed.QualityProfile != ""}
+		state := pipeline.StateIndexingPending
+		if !feed.AutoApprove {
+			state = pipeline.StateApprovalPending
+		}
 
-		err = f.db.CreateWatchlistItem(item)
+		err = createItemIn(f.db, item, state)
 		if err != nil {
 			f.log.Error("PlexRSSFetcher", "processCustomParsedItem", fmt.Sprintf("Error adding new item to database: %v", err))
-			return
+			return database.FeedEntry{}
 		}
 
 		f.log.Info("PlexRSSFetcher", "processCustomParsedItem", fmt.Sprintf("Successfully added new item to watchlist: %s (%d) with current_step: %s", item.Title, item.ItemYear.Int64, item.CurrentStep.String))
+		return database.FeedEntry{ItemID: item.ID, Owns: item.RequestedBy.Valid}
 	} else {
 		f.log.Info("PlexRSSFetcher", "processCustomParsedItem", fmt.Sprintf("Item already exists in database: %s (%d)", item.Title, item.ItemYear.Int64))
 
+		entry := database.FeedEntry{ItemID: existingItem.ID}
+		entry.Owns, err = f.db.RouteWatchlistItem(existingItem.ID, feed.RequestedBy, feed.Library, feed.QualityProfile)
+		if err != nil {
+			f.log.Error("PlexRSSFetcher", "processCustomParsedItem", fmt.Sprintf("Error routing item %d: %v", existingItem.ID, err))
+		}
+
 		// Update fields if they differ
 		updated := false
 
@@ -320,12 +453,13 @@ func (f *PlexRSSFetcher) processCustomParsedItem(item *database.WatchlistItem) {
 			err = f.db.FetcherUpdateWatchlistItem(existingItem)
 			if err != nil {
 				f.log.Error("PlexRSSFetcher", "processCustomParsedItem", fmt.Sprintf("Error updating item in database: %v", err))
-				return
+				return entry
 			}
 			f.log.Info("PlexRSSFetcher", "processCustomParsedItem", fmt.Sprintf("Successfully updated item in database: %s (%d)", item.Title, item.ItemYear.Int64))
 		} else {
 			f.log.Info("PlexRSSFetcher", "processCustomParsedItem", fmt.Sprintf("No updates needed for item: %s (%d)", item.Title, item.ItemYear.Int64))
 		}
+		return entry
 	}
 }
 
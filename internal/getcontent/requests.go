@@ -0,0 +1,206 @@
+package getcontent
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"mye-r/internal/config"
+	"mye-r/internal/database"
+	"mye-r/internal/indexers"
+	"mye-r/internal/logger"
+)
+
+// ErrNoMatch is returned when TMDB has nothing matching a request
+var ErrNoMatch = errors.New("no match on TMDB")
+
+var imdbIDPattern = regexp.MustCompile(`^tt\d+$`)
+
+// Request asks for a movie or show by hand, for people who do not have a
+// Plex watchlist
+type Request struct {
+	ID          string `json:"id"`           // IMDb ID, or an ID prefixed with its source like tmdb:603 or tvdb://81189
+	Query       string `json:"query"`        // title to search TMDB for when there is no ID
+	MediaType   string `json:"media_type"`   // movie or tv; needed with TMDB IDs, narrows searches
+	Year        int    `json:"year"`         // narrows searches
+	RequestedBy string `json:"requested_by"` // who asked
+}
+
+// Validate checks a request before anything is looked up
+func (req *Request) Validate() error {
+	switch req.MediaType {
+	case "", "movie", "tv":
+	case "show":
+		req.MediaType = "tv"
+	default:
+		return fmt.Errorf("media type must be movie or tv, got %q", req.MediaType)
+	}
+
+	req.ID, req.Query = strings.TrimSpace(req.ID), strings.TrimSpace(req.Query)
+	if (req.ID == "") == (req.Query == "") {
+		return fmt.Errorf("give either an ID or a query")
+	}
+	if req.ID != "" {
+		source, _, err := ParseID(req.ID)
+		if err != nil {
+			return err
+		}
+		if source == "tmdb" && req.MediaType == "" {
+			return fmt.Errorf("a TMDB ID needs a media type, TMDB movie and show IDs overlap")
+		}
+	}
+	return nil
+}
+
+// ParseID splits an external ID into its source (imdb, tmdb or tvdb) and the
+// ID itself. Sources are written like Plex GUIDs (tmdb://603) or shorter
+// (tmdb:603); IMDb IDs are recognised without one.
+func ParseID(id string) (string, string, error) {
+	if imdbIDPattern.MatchString(id) {
+		return "imdb", id, nil
+	}
+	source, value, ok := strings.Cut(id, ":")
+	value = strings.TrimPrefix(value, "//")
+	source = strings.ToLower(source)
+	if !ok || value == "" || (source != "imdb" && source != "tmdb" && source != "tvdb") {
+		return "", "", fmt.Errorf("unknown ID %q, use an IMDb ID or prefix it with imdb:, tmdb: or tvdb:", id)
+	}
+	return source, value, nil
+}
+
+// Requester adds manually requested items to the watchlist
+type Requester struct {
+	db   *database.DB
+	log  *logger.Logger
+	tmdb *indexers.TMDBIndexer
+}
+
+func NewRequester(cfg *config.Config, db *database.DB) *Requester {
+	log := logger.New()
+	return &Requester{
+		db:   db,
+		log:  log,
+		tmdb: indexers.NewTMDBIndexer(cfg, db, log),
+	}
+}
+
+// Add resolves a request on TMDB and puts the item on the watchlist, unless
+// it is already there. It returns the item and whether it was created.
+func (r *Requester) Add(req Request) (*database.WatchlistItem, bool, error) {
+	if err := req.Validate(); err != nil {
+		return nil, false, err
+	}
+
+	item, err := r.Resolve(req)
+	if err != nil {
+		return nil, false, err
+	}
+
+	existing, err := findExistingItem(r.db, item)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing != nil {
+		r.log.Info("Requester", "Add", fmt.Sprintf("%s asked for %s (%d), already item %d", req.RequestedBy, item.Title, item.ItemYear.Int64, existing.ID))
+		// The request keeps feeds from removing the item
+		if req.RequestedBy != "" {
+			recorded, err := r.db.SetItemRequestedBy(existing.ID, req.RequestedBy)
+			if err != nil {
+				return nil, false, err
+			}
+			if recorded {
+				existing.RequestedBy = sql.NullString{String: req.RequestedBy, Valid: true}
+			}
+		}
+		return existing, false, nil
+	}
+
+	item.RequestedBy = sql.NullString{String: req.RequestedBy, Valid: req.RequestedBy != ""}
+	if err := createItem(r.db, item); err != nil {
+		return nil, false, err
+	}
+	r.log.Info("Requester", "Add", fmt.Sprintf("%s asked for %s (%d), added item %d", req.RequestedBy, item.Title, item.ItemYear.Int64, item.ID))
+	return item, true, nil
+}
+
+// Existing returns the watchlist item a resolved item is already on the
+// watchlist as, or nil
+func (r *Requester) Existing(item *database.WatchlistItem) (*database.WatchlistItem, error) {
+	return findExistingItem(r.db, item)
+}
+
+// Resolve looks a validated request up on TMDB and returns the item it
+// stands for, without saving it
+func (r *Requester) Resolve(req Request) (*database.WatchlistItem, error) {
+	var item *database.WatchlistItem
+	var err error
+	if req.ID != "" {
+		item, err = r.resolveID(req)
+	} else {
+		item, err = r.search(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Plex calls shows "show"; libraries are laid out by category
+	item.Category = sql.NullString{String: item.MediaType.String, Valid: true}
+	if item.MediaType.String == "tv" {
+		item.Category.String = "show"
+	}
+	return item, nil
+}
+
+func (r *Requester) resolveID(req Request) (*database.WatchlistItem, error) {
+	source, id, _ := ParseID(req.ID)
+	if source == "tmdb" {
+		item, err := r.tmdb.LookupTMDBID(id, req.MediaType)
+		if errors.Is(err, indexers.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %v", ErrNoMatch, err)
+		}
+		return item, err
+	}
+
+	item, err := r.tmdb.FindByID(id, source+"_id")
+	if errors.Is(err, indexers.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s ID %s: %v", ErrNoMatch, source, id, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find %s ID %s: %v", source, id, err)
+	}
+	if req.MediaType != "" && item.MediaType.String != req.MediaType {
+		return nil, fmt.Errorf("%w: %s ID %s is a %s", ErrNoMatch, source, id, item.MediaType.String)
+	}
+
+	// TMDB does not echo the ID that was looked up
+	if source == "imdb" {
+		item.ImdbID = sql.NullString{String: id, Valid: true}
+	} else {
+		item.TvdbID = sql.NullString{String: id, Valid: true}
+	}
+	return item, nil
+}
+
+// search takes the best TMDB search result that fits the request
+func (r *Requester) search(req Request) (*database.WatchlistItem, error) {
+	results, err := r.tmdb.SearchMulti(req.Query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search TMDB: %v", err)
+	}
+
+	for _, item := range results {
+		if req.MediaType != "" && item.MediaType.String != req.MediaType {
+			continue
+		}
+		if req.Year != 0 && item.ItemYear.Int64 != int64(req.Year) {
+			continue
+		}
+		if err := r.tmdb.AddExternalIDs(item); err != nil {
+			r.log.Warning("Requester", "search", fmt.Sprintf("Failed to get external IDs of %s: %v", item.Title, err))
+		}
+		return item, nil
+	}
+	return nil, fmt.Errorf("%w for %q", ErrNoMatch, req.Query)
+}
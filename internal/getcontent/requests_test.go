@@ -0,0 +1,68 @@
+package getcontent
+
+import "testing"
+
+func TestParseID(t *testing.T) {
+	tests := []struct {
+		id      string
+		source  string
+		value   string
+		wantErr bool
+	}{
+		{"tt0133093", "imdb", "tt0133093", false},
+		{"imdb:tt0133093", "imdb", "tt0133093", false},
+		{"imdb://tt0133093", "imdb", "tt0133093", false},
+		{"tmdb:603", "tmdb", "603", false},
+		{"tmdb://603", "tmdb", "603", false},
+		{"TMDB:603", "tmdb", "603", false},
+		{"tvdb:81189", "tvdb", "81189", false},
+		{"tvdb://81189", "tvdb", "81189", false},
+		{"tmdb:", "", "", true},
+		{"tmdb://", "", "", true},
+		{"trakt:123", "", "", true},
+		{"603", "", "", true},
+		{"The Matrix", "", "", true},
+		{"", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			source, value, err := ParseID(tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseID(%q) error = %v, want error %v", tt.id, err, tt.wantErr)
+			}
+			if source != tt.source || value != tt.value {
+				t.Errorf("ParseID(%q) = %q, %q, want %q, %q", tt.id, source, value, tt.source, tt.value)
+			}
+		})
+	}
+}
+
+func TestRequestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		req       Request
+		mediaType string
+		wantErr   bool
+	}{
+		{"IMDb ID", Request{ID: "tt0133093"}, "", false},
+		{"TMDB ID with media type", Request{ID: "tmdb:603", MediaType: "movie"}, "movie", false},
+		{"TMDB ID without media type", Request{ID: "tmdb:603"}, "", true},
+		{"show is tv", Request{Query: "Breaking Bad", MediaType: "show"}, "tv", false},
+		{"unknown media type", Request{Query: "Breaking Bad", MediaType: "anime"}, "anime", true},
+		{"ID and query", Request{ID: "tt0133093", Query: "The Matrix"}, "", true},
+		{"neither", Request{Query: "  "}, "", true},
+		{"unknown ID", Request{ID: "trakt:123"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			err := req.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate(%+v) error = %v, want error %v", tt.req, err, tt.wantErr)
+			}
+			if req.MediaType != tt.mediaType {
+				t.Errorf("Validate(%+v) left media type %q, want %q", tt.req, req.MediaType, tt.mediaType)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package getcontent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"mye-r/internal/config"
+	"mye-r/internal/database"
+)
+
+const (
+	traktAPIURL      = "https://api.trakt.tv"
+	traktProvider    = "trakt" // oauth_tokens.provider
+	traktRedirectURI = "urn:ietf:wg:oauth:2.0:oob"
+)
+
+// traktRefreshBefore is how long before it expires a token is refreshed. An
+// expired token is refreshed too, the refresh token outlives it.
+const traktRefreshBefore = time.Hour
+
+// ErrTraktLogin is returned when the watchlist is fetched before anyone
+// logged mye-r in to Trakt
+var ErrTraktLogin = errors.New("not logged in to Trakt, run 'mye-r trakt login'")
+
+// Answers of the device token endpoint while the user has not entered the code
+var (
+	errTraktPending  = errors.New("waiting for the code to be entered")
+	errTraktSlowDown = errors.New("polling too fast")
+)
+
+// TraktClient talks to the Trakt API with the app credentials of the trakt
+// fetcher and the token stored by mye-r trakt login
+type TraktClient struct {
+	clientID     string
+	clientSecret string
+	db           *database.DB
+	client       *http.Client
+}
+
+func NewTraktClient(cfg *config.Config, db *database.DB) (*TraktClient, error) {
+	trakt := cfg.Fetchers["trakt"]
+	if trakt.ClientID == "" || trakt.ClientSecret == "" {
+		return nil, fmt.Errorf("fetchers.trakt needs client_id and client_secret")
+	}
+	return &TraktClient{
+		clientID:     trakt.ClientID,
+		clientSecret: trakt.ClientSecret,
+		db:           db,
+		client:       &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// TraktDeviceCode is what the user enters on trakt.tv to let mye-r in
+type TraktDeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURL string `json:"verification_url"`
+	ExpiresIn       int    `json:"expires_in"` // seconds
+	Interval        int    `json:"interval"`   // seconds between polls
+}
+
+// traktToken is the answer of the token endpoints
+type traktToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	CreatedAt    int64  `json:"created_at"`
+}
+
+func (t traktToken) save(db *database.DB) error {
+	return db.SaveOAuthToken(&database.OAuthToken{
+		Provider:     traktProvider,
+		AccessToken:  t.AccessToken,
+		RefreshToken: t.RefreshToken,
+		ExpiresAt:    time.Unix(t.CreatedAt+t.ExpiresIn, 0),
+	})
+}
+
+// RequestDeviceCode starts a device login
+func (c *TraktClient) RequestDeviceCode(ctx context.Context) (*TraktDeviceCode, error) {
+	var code TraktDeviceCode
+	status, err := c.do(ctx, http.MethodPost, "/oauth/device/code", map[string]string{"client_id": c.clientID}, "", &code)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("trakt answered %d to the device code request", status)
+	}
+	return &code, nil
+}
+
+// WaitForDeviceToken polls until the user entered the code, then stores the
+// token
+func (c *TraktClient) WaitForDeviceToken(ctx context.Context, code *TraktDeviceCode) error {
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(code.ExpiresIn)*time.Second)
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("the code expired before it was entered")
+			}
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+
+		err := c.pollDeviceToken(ctx, code)
+		switch {
+		case errors.Is(err, errTraktPending):
+		case errors.Is(err, errTraktSlowDown):
+			interval += time.Second
+		default:
+			return err
+		}
+	}
+}
+
+func (c *TraktClient) pollDeviceToken(ctx context.Context, code *TraktDeviceCode) error {
+	var token traktToken
+	status, err := c.do(ctx, http.MethodPost, "/oauth/device/token", map[string]string{
+		"code":          code.DeviceCode,
+		"client_id":     c.clientID,
+		"client_secret": c.clientSecret,
+	}, "", &token)
+	if err != nil {
+		return err
+	}
+
+	switch status {
+	case http.StatusOK:
+		return token.save(c.db)
+	case http.StatusBadRequest:
+		return errTraktPending
+	case http.StatusTooManyRequests:
+		return errTraktSlowDown
+	case http.StatusNotFound:
+		return fmt.Errorf("trakt does not know the device code")
+	case http.StatusConflict:
+		return fmt.Errorf("the code was already used")
+	case http.StatusGone:
+		return fmt.Errorf("the code expired before it was entered")
+	case 418:
+		return fmt.Errorf("the login was denied on trakt.tv")
+	default:
+		return fmt.Errorf("trakt answered %d while waiting for the login", status)
+	}
+}
+
+// Logout revokes the stored token and forgets it
+func (c *TraktClient) Logout(ctx context.Context) error {
+	token, err := c.db.GetOAuthToken(traktProvider)
+	if errors.Is(err, database.ErrOAuthTokenNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// A token Trakt already forgot is no reason to keep it here
+	c.do(ctx, http.MethodPost, "/oauth/revoke", map[string]string{
+		"token":         token.AccessToken,
+		"client_id":     c.clientID,
+		"client_secret": c.clientSecret,
+	}, "", nil)
+	return c.db.DeleteOAuthToken(traktProvider)
+}
+
+// accessToken returns the stored token, refreshed when it is about to
+// expire. It returns ErrTraktLogin when there is none.
+func (c *TraktClient) accessToken(ctx context.Context) (string, error) {
+	token, err := c.db.GetOAuthToken(traktProvider)
+	if errors.Is(err, database.ErrOAuthTokenNotFound) {
+		return "", ErrTraktLogin
+	}
+	if err != nil {
+		return "", err
+	}
+	if time.Until(token.ExpiresAt) > traktRefreshBefore {
+		return token.AccessToken, nil
+	}
+
+	var refreshed traktToken
+	status, err := c.do(ctx, http.MethodPost, "/oauth/token", map[string]string{
+		"refresh_token": token.RefreshToken,
+		"client_id":     c.clientID,
+		"client_secret": c.clientSecret,
+		"redirect_uri":  traktRedirectURI,
+		"grant_type":    "refresh_token",
+	}, "", &refreshed)
+	if err != nil {
+		return "", fmt.Errorf("failed to refresh the Trakt token: %v", err)
+	}
+	if status != http.StatusOK {
+		if time.Now().Before(token.ExpiresAt) {
+			// Still usable, try again on the next fetch
+			return token.AccessToken, nil
+		}
+		return "", fmt.Errorf("trakt answered %d to the token refresh, run 'mye-r trakt login' again", status)
+	}
+	if err := refreshed.save(c.db); err != nil {
+		return "", err
+	}
+	return refreshed.AccessToken, nil
+}
+
+// traktIDs are the IDs of a movie or show
+type traktIDs struct {
+	Trakt int    `json:"trakt"`
+	Slug  string `json:"slug"`
+	IMDB  string `json:"imdb"`
+	TMDB  int    `json:"tmdb"`
+	TVDB  int    `json:"tvdb"`
+}
+
+type traktMedia struct {
+	Title string   `json:"title"`
+	Year  int      `json:"year"`
+	IDs   traktIDs `json:"ids"`
+}
+
+// traktListItem is an entry of a watchlist or list. Seasons and episodes
+// come with their show.
+type traktListItem struct {
+	Type     string      `json:"type"`
+	ListedAt time.Time   `json:"listed_at"`
+	Movie    *traktMedia `json:"movie"`
+	Show     *traktMedia `json:"show"`
+}
+
+// watchlist returns the movies and shows on the watchlist of the logged in
+// user
+func (c *TraktClient) watchlist(ctx context.Context) ([]traktListItem, error) {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.listItems(ctx, "/sync/watchlist", token)
+}
+
+// list returns the items of a list, given as user/list-slug or as its
+// trakt.tv URL. Private lists need the login of their owner.
+func (c *TraktClient) list(ctx context.Context, list string) ([]traktListItem, error) {
+	user, slug, err := parseTraktList(list)
+	if err != nil {
+		return nil, err
+	}
+	token, err := c.accessToken(ctx)
+	if err != nil && !errors.Is(err, ErrTraktLogin) {
+		return nil, err
+	}
+	return c.listItems(ctx, fmt.Sprintf("/users/%s/lists/%s/items", user, slug), token)
+}
+
+func (c *TraktClient) listItems(ctx context.Context, path, token string) ([]traktListItem, error) {
+	var items []traktListItem
+	status, err := c.do(ctx, http.MethodGet, path, nil, token, &items)
+	if err != nil {
+		return nil, err
+	}
+	switch status {
+	case http.StatusOK:
+		return items, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		if token == "" {
+			return nil, fmt.Errorf("%s is private: %w", path, ErrTraktLogin)
+		}
+		return nil, fmt.Errorf("trakt denied access to %s, run 'mye-r trakt login' as its owner", path)
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%s does not exist", path)
+	default:
+		return nil, fmt.Errorf("trakt answered %d for %s", status, path)
+	}
+}
+
+// parseTraktList splits user/list-slug or a trakt.tv list URL
+func parseTraktList(list string) (string, string, error) {
+	path := list
+	if i := strings.Index(path, "trakt.tv/"); i >= 0 {
+		path = path[i+len("trakt.tv/"):]
+	}
+	path, _, _ = strings.Cut(path, "?")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case len(parts) == 2:
+		return parts[0], parts[1], nil
+	case len(parts) == 4 && parts[0] == "users" && parts[2] == "lists":
+		return parts[1], parts[3], nil
+	}
+	return "", "", fmt.Errorf("invalid Trakt list %q, use user/list-slug or the list's URL", list)
+}
+
+// do sends a request to the Trakt API and decodes a 2xx answer into out. The
+// status is returned for the caller to interpret.
+func (c *TraktClient) do(ctx context.Context, method, path string, body interface{}, token string, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, traktAPIURL+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("trakt-api-version", "2")
+	req.Header.Set("trakt-api-key", c.clientID)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("trakt request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil && resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("failed to decode the answer of %s: %v", path, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
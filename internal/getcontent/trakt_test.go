@@ -0,0 +1,35 @@
+package getcontent
+
+import "testing"
+
+func TestParseTraktList(t *testing.T) {
+	tests := []struct {
+		list    string
+		user    string
+		slug    string
+		wantErr bool
+	}{
+		{"sean/marvel", "sean", "marvel", false},
+		{"/sean/marvel/", "sean", "marvel", false},
+		{"https://trakt.tv/users/sean/lists/marvel", "sean", "marvel", false},
+		{"https://trakt.tv/users/sean/lists/marvel?sort=rank,asc", "sean", "marvel", false},
+		{"trakt.tv/users/sean/lists/marvel/", "sean", "marvel", false},
+		{"https://app.trakt.tv/users/sean/lists/marvel", "sean", "marvel", false},
+		{"sean", "", "", true},
+		{"https://trakt.tv/users/sean/watchlist", "", "", true},
+		{"https://trakt.tv/users/sean/lists", "", "", true},
+		{"a/b/c", "", "", true},
+		{"", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			user, slug, err := parseTraktList(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTraktList(%q) error = %v, want error %v", tt.list, err, tt.wantErr)
+			}
+			if user != tt.user || slug != tt.slug {
+				t.Errorf("parseTraktList(%q) = %q, %q, want %q, %q", tt.list, user, slug, tt.user, tt.slug)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package getcontent
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"mye-r/internal/config"
+	"mye-r/internal/database"
+	"mye-r/internal/logger"
+)
+
+// defaultTraktInterval is used when fetchers.trakt has no interval, in minutes
+const defaultTraktInterval = 30
+
+// TraktFetcher adds the movies and shows of a Trakt watchlist and lists.
+// Trakt gives their IMDb, TMDB and TVDB IDs, so the indexer never has to
+// search TMDB by title for them.
+type TraktFetcher struct {
+	cfg    *config.Config
+	db     *database.DB
+	log    *logger.Logger
+	client *TraktClient
+	stop   chan struct{}
+}
+
+func NewTraktFetcher(cfg *config.Config, db *database.DB) (*TraktFetcher, error) {
+	client, err := NewTraktClient(cfg, db)
+	if err != nil {
+		return nil, err
+	}
+	return &TraktFetcher{
+		cfg:    cfg,
+		db:     db,
+		log:    logger.New(),
+		client: client,
+		stop:   make(chan struct{}),
+	}, nil
+}
+
+func (f *TraktFetcher) Start(ctx context.Context) {
+	interval := f.cfg.Fetchers["trakt"].Interval
+	if interval <= 0 {
+		interval = defaultTraktInterval
+	}
+
+	if err := f.fetch(ctx); err != nil {
+		f.log.Error("TraktFetcher", "Start", err.Error())
+	}
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			if err := f.fetch(ctx); err != nil {
+				f.log.Error("TraktFetcher", "Start", err.Error())
+			}
+		}
+	}
+}
+
+// Fetch fetches the watchlist and every configured list once
+func (f *TraktFetcher) Fetch() error {
+	return f.fetch(context.Background())
+}
+
+func (f *TraktFetcher) Stop() {
+	close(f.stop)
+}
+
+func (f *TraktFetcher) fetch(ctx context.Context) error {
+	traktConfig := f.cfg.Fetchers["trakt"]
+
+	var sources, failed int
+	ingest := func(source string, items []traktListItem, err error) {
+		sources++
+		if err != nil {
+			f.log.Error("TraktFetcher", "fetch", fmt.Sprintf("Failed to fetch %s: %v", source, err))
+			failed++
+			return
+		}
+		added := 0
+		for _, entry := range items {
+			item, ok := traktItem(entry)
+			if !ok {
+				continue
+			}
+			created, err := f.ingest(item)
+			if err != nil {
+				f.log.Error("TraktFetcher", "fetch", fmt.Sprintf("Failed to add %s (%d) from %s: %v", item.Title, item.ItemYear.Int64, source, err))
+				continue
+			}
+			if created {
+				added++
+			}
+		}
+		f.log.Info("TraktFetcher", "fetch", fmt.Sprintf("%s: %d entries, %d new items", source, len(items), added))
+	}
+
+	if traktConfig.Watchlist {
+		items, err := f.client.watchlist(ctx)
+		ingest("watchlist", items, err)
+	}
+	for _, list := range traktConfig.Lists {
+		items, err := f.client.list(ctx, list)
+		ingest("list "+list, items, err)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d Trakt sources failed", failed, sources)
+	}
+	return nil
+}
+
+// traktItem turns a list entry into a watchlist item. Seasons and episodes
+// stand for their show; people are skipped.
+func traktItem(entry traktListItem) (*database.WatchlistItem, bool) {
+	media, mediaType, category := entry.Movie, "movie", "movie"
+	if entry.Type != "movie" {
+		media, mediaType, category = entry.Show, "tv", "show"
+	}
+	if media == nil || media.Title == "" {
+		return nil, false
+	}
+
+	item := &database.WatchlistItem{
+		Title:         media.Title,
+		ItemYear:      sql.NullInt64{Int64: int64(media.Year), Valid: media.Year > 0},
+		RequestedDate: entry.ListedAt.Truncate(time.Second),
+		ImdbID:        sql.NullString{String: media.IDs.IMDB, Valid: media.IDs.IMDB != ""},
+		TmdbID:        traktID(media.IDs.TMDB),
+		MediaType:     sql.NullString{String: mediaType, Valid: true},
+		Category:      sql.NullString{String: category, Valid: true},
+	}
+	if mediaType == "tv" {
+		item.TvdbID = traktID(media.IDs.TVDB)
+	}
+	if media.IDs.Slug != "" {
+		item.Link = sql.NullString{String: fmt.Sprintf("https://trakt.tv/%ss/%s", category, media.IDs.Slug), Valid: true}
+	}
+	return item, true
+}
+
+func traktID(id int) sql.NullString {
+	if id <= 0 {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: strconv.Itoa(id), Valid: true}
+}
+
+// ingest adds an item unless it is already on the watchlist, in which case
+// it only fills in IDs the existing item lacks
+func (f *TraktFetcher) ingest(item *database.WatchlistItem) (bool, error) {
+	existing, err := findExistingItem(f.db, item)
+	if err != nil {
+		return false, err
+	}
+	if existing == nil {
+		if err := createItem(f.db, item); err != nil {
+			return false, fmt.Errorf("error adding new item to database: %v", err)
+		}
+		f.log.Info("TraktFetcher", "ingest", fmt.Sprintf("Added %s (%d) as item %d", item.Title, item.ItemYear.Int64, item.ID))
+		return true, nil
+	}
+
+	updated := false
+	for _, id := range []struct{ from, to *sql.NullString }{
+		{&item.ImdbID, &existing.ImdbID},
+		{&item.TmdbID, &existing.TmdbID},
+		{&item.TvdbID, &existing.TvdbID},
+	} {
+		if id.from.Valid && !id.to.Valid {
+			*id.to = *id.from
+			updated = true
+		}
+	}
+	if updated {
+		if err := f.db.FetcherUpdateWatchlistItem(existing); err != nil {
+			return false, fmt.Errorf("error updating item in database: %v", err)
+		}
+	}
+	return false, nil
+}
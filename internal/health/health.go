@@ -0,0 +1,275 @@
+// Package health checks the services and paths mye-r depends on. The API
+// serves the results on /healthz and /readyz and mye-r doctor prints them.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"mye-r/internal/config"
+	"mye-r/internal/database"
+	"mye-r/internal/indexers"
+)
+
+// Statuses of a check
+const (
+	StatusOK      = "ok"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped" // the feature the check covers is turned off
+)
+
+const realDebridUserURL = "https://api.real-debrid.com/rest/1.0/user"
+
+// remoteTTL is how long TMDB and Real-Debrid results are reused, so frequent
+// probes do not spend API quota
+const remoteTTL = time.Minute
+
+// Result is the outcome of one check. Hint says how to fix a failure.
+type Result struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+// OK reports whether the check did not fail
+func (r Result) OK() bool {
+	return r.Status != StatusFailed
+}
+
+// Healthy reports whether none of the checks failed
+func Healthy(results []Result) bool {
+	for _, result := range results {
+		if !result.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// Checker runs the checks. db may be nil when no connection could be opened.
+type Checker struct {
+	cfg    *config.Config
+	db     *database.DB
+	client *http.Client
+
+	mu     sync.Mutex
+	cached map[string]cachedResult
+}
+
+type cachedResult struct {
+	result Result
+	at     time.Time
+}
+
+func New(cfg *config.Config, db *database.DB) *Checker {
+	return &Checker{
+		cfg:    cfg,
+		db:     db,
+		client: &http.Client{Timeout: 10 * time.Second},
+		cached: make(map[string]cachedResult),
+	}
+}
+
+// Local checks what mye-r needs on this host: the database, the rclone mount
+// and the library
+func (c *Checker) Local(ctx context.Context) []Result {
+	return []Result{
+		c.checkPostgres(ctx),
+		c.checkRclone(),
+		c.checkLibrary(),
+	}
+}
+
+// All runs the local checks and validates the TMDB key and the Real-Debrid
+// token
+func (c *Checker) All(ctx context.Context) []Result {
+	return append(c.Local(ctx),
+		c.remote("tmdb", func() Result { return c.checkTMDB(ctx) }),
+		c.remote("realdebrid", func() Result { return c.checkRealDebrid(ctx) }),
+	)
+}
+
+// remote reuses the last result of a provider check for remoteTTL
+func (c *Checker) remote(name string, check func() Result) Result {
+	c.mu.Lock()
+	cached, ok := c.cached[name]
+	c.mu.Unlock()
+	if ok && time.Since(cached.at) < remoteTTL {
+		return cached.result
+	}
+
+	result := check()
+	c.mu.Lock()
+	c.cached[name] = cachedResult{result: result, at: time.Now()}
+	c.mu.Unlock()
+	return result
+}
+
+func (c *Checker) checkPostgres(ctx context.Context) Result {
+	result := Result{Name: "postgres"}
+	if c.db == nil {
+		return failed(result, "no database connection", "Check database.url or DATABASE_URL")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := c.db.PingContext(ctx); err != nil {
+		return failed(result, err.Error(),
+			"Check that Postgres is running and reachable with database.url or DATABASE_URL")
+	}
+	result.Status, result.Message = StatusOK, "connected"
+	return result
+}
+
+func (c *Checker) checkTMDB(ctx context.Context) Result {
+	result := Result{Name: "tmdb"}
+	if !c.cfg.Programs.TMDBIndexer.Active {
+		result.Status, result.Message = StatusSkipped, "tmdb_indexer is not active"
+		return result
+	}
+	if c.cfg.TMDB.APIKey == "" {
+		return failed(result, "no API key", "Set TMDB_API_KEY in the env file")
+	}
+
+	// Authenticated the way the indexer does it
+	endpoint := fmt.Sprintf("%s/configuration?api_key=%s", indexers.APIURL, c.cfg.TMDB.APIKey)
+	resp, err := c.get(ctx, endpoint, c.cfg.TMDB.APIKey)
+	if err != nil {
+		return failed(result, err.Error(), "Check that this host can reach api.themoviedb.org")
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return failed(result, "the API key was rejected",
+			"Copy the key from https://www.themoviedb.org/settings/api into TMDB_API_KEY")
+	case resp.StatusCode != http.StatusOK:
+		return failed(result, fmt.Sprintf("TMDB answered %s", resp.Status), "Try again later")
+	}
+	result.Status, result.Message = StatusOK, "API key accepted"
+	return result
+}
+
+func (c *Checker) checkRealDebrid(ctx context.Context) Result {
+	result := Result{Name: "realdebrid"}
+	if !c.cfg.Programs.Downloader.Active {
+		result.Status, result.Message = StatusSkipped, "downloader is not active"
+		return result
+	}
+	if c.cfg.DebridAPI == "" {
+		return failed(result, "no API token",
+			"Copy the token from https://real-debrid.com/apitoken into DEBRID_API_KEY")
+	}
+
+	resp, err := c.get(ctx, realDebridUserURL, c.cfg.DebridAPI)
+	if err != nil {
+		return failed(result, err.Error(), "Check that this host can reach api.real-debrid.com")
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return failed(result, "the API token is invalid or expired",
+			"Copy a new token from https://real-debrid.com/apitoken into DEBRID_API_KEY")
+	case resp.StatusCode == http.StatusForbidden:
+		return failed(result, "the account is locked", "Log in to real-debrid.com to see why")
+	case resp.StatusCode != http.StatusOK:
+		return failed(result, fmt.Sprintf("Real-Debrid answered %s", resp.Status), "Try again later")
+	}
+
+	var user struct {
+		Username   string `json:"username"`
+		Type       string `json:"type"`
+		Expiration string `json:"expiration"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return failed(result, fmt.Sprintf("failed to decode the user: %v", err), "Try again later")
+	}
+	if user.Type != "premium" {
+		return failed(result, fmt.Sprintf("%s is a %s account", user.Username, user.Type),
+			"Real-Debrid only adds torrents for premium accounts, renew it on real-debrid.com")
+	}
+	result.Status = StatusOK
+	result.Message = fmt.Sprintf("%s, premium until %s", user.Username, user.Expiration)
+	return result
+}
+
+// get sends an authenticated GET request. Its errors leave out the URL, which
+// may hold the key.
+func (c *Checker) get(ctx context.Context, endpoint, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, errors.New("invalid request")
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.client.Do(req)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	return resp, err
+}
+
+// checkRclone lists general.rclone_path. An empty directory is taken for a
+// mount point whose mount is not running.
+func (c *Checker) checkRclone() Result {
+	result := Result{Name: "rclone"}
+	path := c.cfg.General.RclonePath
+	if path == "" {
+		return failed(result, "general.rclone_path is not set",
+			"Set general.rclone_path to the directory rclone mounts Real-Debrid on")
+	}
+
+	entries, err := os.ReadDir(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return failed(result, fmt.Sprintf("%s does not exist", path),
+			"Start the rclone mount or fix general.rclone_path")
+	case errors.Is(err, fs.ErrPermission):
+		return failed(result, fmt.Sprintf("%s can not be listed", path),
+			"Make the mount readable by the user mye-r runs as (rclone --allow-other, PUID/PGID)")
+	case err != nil:
+		return failed(result, err.Error(), "Restart the rclone mount")
+	case len(entries) == 0:
+		return failed(result, fmt.Sprintf("%s is empty", path),
+			"The rclone mount is probably not running, start it and check its logs")
+	}
+	result.Status, result.Message = StatusOK, fmt.Sprintf("%s lists %d entries", path, len(entries))
+	return result
+}
+
+// checkLibrary creates and removes a file in general.library_path
+func (c *Checker) checkLibrary() Result {
+	result := Result{Name: "library"}
+	path := c.cfg.General.LibraryPath
+	if path == "" {
+		return failed(result, "general.library_path is not set",
+			"Set general.library_path to the directory the symlinks are created in")
+	}
+
+	file, err := os.CreateTemp(path, ".mye-r-healthcheck-*")
+	if err != nil {
+		return failed(result, err.Error(),
+			"Create general.library_path and make it writable by the user mye-r runs as (PUID/PGID)")
+	}
+	file.Close()
+	os.Remove(file.Name())
+
+	result.Status, result.Message = StatusOK, fmt.Sprintf("%s is writable", path)
+	return result
+}
+
+func failed(result Result, message, hint string) Result {
+	result.Status, result.Message, result.Hint = StatusFailed, message, hint
+	return result
+}
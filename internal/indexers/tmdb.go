@@ -3,6 +3,7 @@ package indexers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -17,12 +18,17 @@ import (
 	"mye-r/internal/config"
 	"mye-r/internal/database"
 	"mye-r/internal/logger"
+	"mye-r/internal/metrics"
+	"mye-r/internal/pipeline"
 )
 
 const (
 	APIURL = "https://api.themoviedb.org/3"
 )
 
+// ErrNotFound is returned when TMDB has nothing with the ID that was looked up
+var ErrNotFound = errors.New("not found on TMDB")
+
 type TMDBIndexer struct {
 	config      *config.Config
 	db          *database.DB
@@ -30,6 +36,9 @@ type TMDBIndexer struct {
 	client      *http.Client
 	accessToken string
 	baseURL     string
+	pipeline    *pipeline.Machine
+	leaser      *pipeline.Leaser
+	retrier     *pipeline.Retrier
 }
 
 type ExternalIDs struct {
@@ -57,6 +66,9 @@ func NewTMDBIndexer(cfg *config.Config, db *database.DB, log *logger.Logger) *TM
 		client:      client,
 		accessToken: cfg.TMDB.APIKey,
 		baseURL:     APIURL,
+		pipeline:    pipeline.New(db),
+		leaser:      pipeline.NewLeaser(db, cfg.ProcessManagement.LeaseTTL),
+		retrier:     pipeline.NewRetrier(cfg, db),
 	}
 }
 
@@ -88,7 +100,10 @@ func (t *TMDBIndexer) makeRequest(url string) ([]byte, error) {
 
 	req.Header.Add("Authorization", "Bearer "+t.accessToken)
 
+	start := time.Now()
 	resp, err := t.client.Do(req)
+	metrics.TMDBRequestDuration.Observe(time.Since(start).Seconds())
+	metrics.TMDBRequests.Inc(metrics.Status(resp, err))
 	if err != nil {
 		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
@@ -96,6 +111,9 @@ func (t *TMDBIndexer) makeRequest(url string) ([]byte, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s, Body: %s", ErrNotFound, resp.Status, string(body))
+		}
 		return nil, fmt.Errorf("API returned non-200 status: %s, Body: %s", resp.Status, string(body))
 	}
 
@@ -238,10 +256,6 @@ func (t *TMDBIndexer) GetMovieDetails(item *database.WatchlistItem) error {
 	if !item.TmdbID.Valid || item.TmdbID.String == "" {
 		movieIDs, err := t.SearchMovies(item.Title, int(item.ItemYear.Int64))
 		if err != nil || len(movieIDs) == 0 {
-			item.Status = sql.NullString{String: "indexing_failed", Valid: true}
-			if err := t.db.UpdateWatchlistItem(item); err != nil {
-				t.log.Error("TMDBIndexer", "GetMovieDetails", fmt.Sprintf("Failed to update item status: %v", err))
-			}
 			return fmt.Errorf("no TMDB ID found for item '%s': %v", item.Title, err)
 		}
 		item.TmdbID = sql.NullString{String: strconv.Itoa(movieIDs[0]), Valid: true}
@@ -251,10 +265,6 @@ func (t *TMDBIndexer) GetMovieDetails(item *database.WatchlistItem) error {
 	url := fmt.Sprintf("%s/movie/%s?language=en-US", t.baseURL, item.TmdbID.String)
 	resp, err := t.makeRequest(url)
 	if err != nil {
-		item.Status = sql.NullString{String: "indexing_failed", Valid: true}
-		if err := t.db.UpdateWatchlistItem(item); err != nil {
-			t.log.Error("TMDBIndexer", "GetMovieDetails", fmt.Sprintf("Failed to update item status: %v", err))
-		}
 		return fmt.Errorf("failed to get movie details: %w", err)
 	}
 
@@ -269,10 +279,6 @@ func (t *TMDBIndexer) GetMovieDetails(item *database.WatchlistItem) error {
 	}
 
 	if err := json.Unmarshal(resp, &movieDetails); err != nil {
-		item.Status = sql.NullString{String: "indexing_failed", Valid: true}
-		if err := t.db.UpdateWatchlistItem(item); err != nil {
-			t.log.Error("TMDBIndexer", "GetMovieDetails", fmt.Sprintf("Failed to update item status: %v", err))
-		}
 		return fmt.Errorf("failed to decode movie details: %w", err)
 	}
 
@@ -293,9 +299,7 @@ func (t *TMDBIndexer) GetMovieDetails(item *database.WatchlistItem) error {
 		}
 	}
 
-	// Set status to indexed and update
-	item.Status = sql.NullString{String: "indexed", Valid: true}
-	item.CurrentStep = sql.NullString{String: "librarymatch_pending", Valid: true}
+	// Save the metadata; moving the item on is up to the pipeline
 	if err := t.db.UpdateWatchlistItem(item); err != nil {
 		t.log.Error("TMDBIndexer", "GetMovieDetails", fmt.Sprintf("Failed to update item: %v", err))
 		return fmt.Errorf("failed to update item: %w", err)
@@ -309,10 +313,6 @@ func (t *TMDBIndexer) GetTVDetails(item *database.WatchlistItem) (*database.Watc
 	if !item.TmdbID.Valid || item.TmdbID.String == "" {
 		tvIDs, err := t.SearchTVShows(item.Title, int(item.ItemYear.Int64))
 		if err != nil || len(tvIDs) == 0 {
-			item.Status = sql.NullString{String: "indexing_failed", Valid: true}
-			if err := t.db.UpdateWatchlistItem(item); err != nil {
-				t.log.Error("TMDBIndexer", "GetTVDetails", fmt.Sprintf("Failed to update item status: %v", err))
-			}
 			return nil, fmt.Errorf("no TMDB ID found for item '%s': %v", item.Title, err)
 		}
 		item.TmdbID = sql.NullString{String: strconv.Itoa(tvIDs[0]), Valid: true}
@@ -322,10 +322,6 @@ func (t *TMDBIndexer) GetTVDetails(item *database.WatchlistItem) (*database.Watc
 	url := fmt.Sprintf("%s/tv/%s?language=en-US", t.baseURL, item.TmdbID.String)
 	resp, err := t.makeRequest(url)
 	if err != nil {
-		item.Status = sql.NullString{String: "indexing_failed", Valid: true}
-		if err := t.db.UpdateWatchlistItem(item); err != nil {
-			t.log.Error("TMDBIndexer", "GetTVDetails", fmt.Sprintf("Failed to update item status: %v", err))
-		}
 		return nil, fmt.Errorf("failed to get show details: %w", err)
 	}
 
@@ -344,10 +340,6 @@ func (t *TMDBIndexer) GetTVDetails(item *database.WatchlistItem) (*database.Watc
 	}
 
 	if err := json.Unmarshal(resp, &showDetails); err != nil {
-		item.Status = sql.NullString{String: "indexing_failed", Valid: true}
-		if err := t.db.UpdateWatchlistItem(item); err != nil {
-			t.log.Error("TMDBIndexer", "GetTVDetails", fmt.Sprintf("Failed to update item status: %v", err))
-		}
 		return nil, fmt.Errorf("failed to parse show details: %w", err)
 	}
 
@@ -390,9 +382,7 @@ func (t *TMDBIndexer) GetTVDetails(item *database.WatchlistItem) (*database.Watc
 		}
 	}
 
-	// Set status to indexed and update
-	item.Status = sql.NullString{String: "indexed", Valid: true}
-	item.CurrentStep = sql.NullString{String: "librarymatch_pending", Valid: true}
+	// Save the metadata; moving the item on is up to the pipeline
 	if err := t.db.UpdateWatchlistItem(item); err != nil {
 		return nil, fmt.Errorf("failed to update item: %w", err)
 	}
@@ -853,7 +843,9 @@ func (t *TMDBIndexer) FindByID(externalID string, source string) (*database.Watc
 		movie := result.MovieResults[0]
 		item.Title = movie.Title
 		item.TmdbID = sql.NullString{String: fmt.Sprintf("%d", movie.ID), Valid: true}
-		item.ThumbnailURL = sql.NullString{String: movie.PosterPath, Valid: true}
+		if movie.PosterPath != "" {
+			item.ThumbnailURL = sql.NullString{String: "https://image.tmdb.org/t/p/w500" + movie.PosterPath, Valid: true}
+		}
 		item.Description = sql.NullString{String: movie.Overview, Valid: true}
 		item.MediaType = sql.NullString{String: "movie", Valid: true}
 
@@ -881,7 +873,9 @@ func (t *TMDBIndexer) FindByID(externalID string, source string) (*database.Watc
 		show := result.TVResults[0]
 		item.Title = show.Name
 		item.TmdbID = sql.NullString{String: fmt.Sprintf("%d", show.ID), Valid: true}
-		item.ThumbnailURL = sql.NullString{String: show.PosterPath, Valid: true}
+		if show.PosterPath != "" {
+			item.ThumbnailURL = sql.NullString{String: "https://image.tmdb.org/t/p/w500" + show.PosterPath, Valid: true}
+		}
 		item.Description = sql.NullString{String: show.Overview, Valid: true}
 		item.MediaType = sql.NullString{String: "tv", Valid: true}
 		item.ShowStatus = sql.NullString{String: show.Status, Valid: true}
@@ -903,17 +897,88 @@ func (t *TMDBIndexer) FindByID(externalID string, source string) (*database.Watc
 			}
 		}
 
-		// Get additional TV show details if needed
-		if err := t.updateTVShowData(&item); err != nil {
-			t.log.Warning("TMDBIndexer", "FindByID", fmt.Sprintf("Failed to get additional TV show details: %v", err))
+		// Seasons need a saved item, so they are left to the indexer
+		if err := t.AddExternalIDs(&item); err != nil {
+			t.log.Warning("TMDBIndexer", "FindByID", fmt.Sprintf("Failed to get external IDs: %v", err))
 		}
 	} else {
-		return nil, fmt.Errorf("no results found")
+		return nil, fmt.Errorf("%w: no results for %s %s", ErrNotFound, source, externalID)
 	}
 
 	return &item, nil
 }
 
+// LookupTMDBID builds an item from the TMDB movie or show with the given ID.
+// TMDB movie and show IDs overlap, so the media type is needed. Nothing is
+// written to the database.
+func (t *TMDBIndexer) LookupTMDBID(tmdbID, mediaType string) (*database.WatchlistItem, error) {
+	if mediaType != "movie" && mediaType != "tv" {
+		return nil, fmt.Errorf("media type must be movie or tv, got %q", mediaType)
+	}
+
+	resp, err := t.makeRequest(fmt.Sprintf("%s/%s/%s?language=en-US", t.baseURL, mediaType, tmdbID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s %s: %w", mediaType, tmdbID, err)
+	}
+
+	var details struct {
+		Title        string `json:"title"`
+		Name         string `json:"name"`
+		ReleaseDate  string `json:"release_date"`
+		FirstAirDate string `json:"first_air_date"`
+	}
+	if err := json.Unmarshal(resp, &details); err != nil {
+		return nil, fmt.Errorf("failed to parse %s %s: %w", mediaType, tmdbID, err)
+	}
+
+	item := &database.WatchlistItem{
+		Title:     details.Title,
+		TmdbID:    sql.NullString{String: tmdbID, Valid: true},
+		MediaType: sql.NullString{String: mediaType, Valid: true},
+	}
+	released := details.ReleaseDate
+	if mediaType == "tv" {
+		item.Title, released = details.Name, details.FirstAirDate
+	}
+	if item.Title == "" {
+		return nil, fmt.Errorf("%w: no %s with TMDB ID %s", ErrNotFound, mediaType, tmdbID)
+	}
+	if date, err := time.Parse("2006-01-02", released); err == nil {
+		item.ReleaseDate = sql.NullTime{Time: date, Valid: true}
+		item.ItemYear = sql.NullInt64{Int64: int64(date.Year()), Valid: true}
+	}
+
+	// The external IDs let the item be matched against existing ones; the
+	// rest of the metadata is filled in when it is indexed
+	if err := t.AddExternalIDs(item); err != nil {
+		t.log.Warning("TMDBIndexer", "LookupTMDBID", fmt.Sprintf("Failed to get external IDs of %s %s: %v", mediaType, tmdbID, err))
+	}
+	return item, nil
+}
+
+// AddExternalIDs sets the IMDb and TVDB IDs of an item from its TMDB ID
+func (t *TMDBIndexer) AddExternalIDs(item *database.WatchlistItem) error {
+	resp, err := t.makeRequest(fmt.Sprintf("%s/%s/%s/external_ids", t.baseURL, item.MediaType.String, item.TmdbID.String))
+	if err != nil {
+		return err
+	}
+
+	var ids struct {
+		IMDbID string `json:"imdb_id"`
+		TVDbID int    `json:"tvdb_id"`
+	}
+	if err := json.Unmarshal(resp, &ids); err != nil {
+		return fmt.Errorf("failed to parse external IDs: %w", err)
+	}
+	if ids.IMDbID != "" {
+		item.ImdbID = sql.NullString{String: ids.IMDbID, Valid: true}
+	}
+	if ids.TVDbID != 0 {
+		item.TvdbID = sql.NullString{String: strconv.Itoa(ids.TVDbID), Valid: true}
+	}
+	return nil
+}
+
 func (t *TMDBIndexer) GetExternalIDs(tmdbID string) (*ExternalIDs, error) {
 	t.log.Info("TMDBIndexer", "GetExternalIDs", fmt.Sprintf("Fetching external IDs for TMDB ID: %s", tmdbID))
 
@@ -1022,30 +1087,24 @@ func (t *TMDBIndexer) GetSeasonDetails(item *database.WatchlistItem) error {
 		}
 	}
 
-	// Mark item as indexed only after successfully adding episodes
-	item.Status = sql.NullString{String: "indexed", Valid: true}
-	if err := t.db.UpdateWatchlistItem(item); err != nil {
-		return fmt.Errorf("failed to mark item as indexed: %w", err)
-	}
-
 	return nil
 }
 
 func (t *TMDBIndexer) Start(ctx context.Context) error {
 	t.log.Info("TMDBIndexer", "Start", "Starting TMDB indexer")
 
-	// Main processing loop
+	wakeups := pipeline.ListenOrPoll(t.db, pipeline.StageIndexer)
+	defer wakeups.Close()
+
+	// Main processing loop, woken when new items are fetched
 	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		default:
-			if t.IsNeeded() {
-				if err := t.UpdateExistingItems(); err != nil {
-					t.log.Error("TMDBIndexer", "UpdateExistingItems", fmt.Sprintf("Error updating items: %v", err))
-				}
+		if t.IsNeeded() {
+			if err := t.indexPendingItems(); err != nil {
+				t.log.Error("TMDBIndexer", "Start", fmt.Sprintf("Error indexing items: %v", err))
 			}
-			time.Sleep(time.Minute) // Check every minute
+		}
+		if !wakeups.Wait(ctx, pipeline.StageIndexer, pipeline.CheckInterval(t.config, pipeline.StageIndexer)) {
+			return nil
 		}
 	}
 }
@@ -1059,9 +1118,8 @@ func (t *TMDBIndexer) IsNeeded() bool {
 	var count int
 	err := t.db.QueryRow(`
 		SELECT COUNT(*) 
-		FROM watchlist 
-		WHERE status = 'new' 
-		AND (tmdb_id IS NULL OR tmdb_id = '')
+		FROM watchlistitem 
+		WHERE current_step = 'indexing_pending'
 	`).Scan(&count)
 
 	return err == nil && count > 0
@@ -1071,6 +1129,59 @@ func (t *TMDBIndexer) Name() string {
 	return "TMDBIndexer"
 }
 
+// Index fetches TMDB metadata for an item waiting in indexing_pending and
+// hands it on to the library matcher. The item is leased while it is indexed.
+func (t *TMDBIndexer) Index(item *database.WatchlistItem) error {
+	return t.leaser.Run(item.ID, pipeline.StageIndexer, func(ctx context.Context) error {
+		return t.index(ctx, item)
+	})
+}
+
+func (t *TMDBIndexer) index(ctx context.Context, item *database.WatchlistItem) error {
+	if err := t.pipeline.Transition(item.ID, pipeline.StateIndexingPending, pipeline.StateIndexing, "indexing started"); err != nil {
+		return err
+	}
+
+	_, err := t.UpdateItemWithTMDBData(item)
+	// The item is another worker's once the lease is lost
+	if lerr := pipeline.LeaseLost(ctx); lerr != nil {
+		return lerr
+	}
+	if err != nil {
+		if terr := t.retrier.Fail(item.ID, pipeline.StageIndexer, err); terr != nil {
+			t.log.Error("TMDBIndexer", "Index", fmt.Sprintf("Failed to record failure of item %d: %v", item.ID, terr))
+		}
+		return err
+	}
+
+	if err := t.pipeline.Transition(item.ID, pipeline.StateIndexing, pipeline.StateLibraryMatchPending, "indexed"); err != nil {
+		return err
+	}
+	t.retrier.Succeed(item.ID, pipeline.StageIndexer)
+	return nil
+}
+
+// ProcessItem indexes one item for the RunManager worker pool
+func (t *TMDBIndexer) ProcessItem(ctx context.Context, item *database.WatchlistItem) error {
+	return t.Index(item)
+}
+
+// indexPendingItems indexes every item waiting in indexing_pending
+func (t *TMDBIndexer) indexPendingItems() error {
+	items, err := t.db.GetItemsByStep(string(pipeline.StateIndexingPending))
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if err := t.Index(item); err != nil {
+			t.log.Error("TMDBIndexer", "indexPendingItems", fmt.Sprintf("Failed to index item %d (%s): %v", item.ID, item.Title, err))
+		}
+	}
+
+	return nil
+}
+
 func (t *TMDBIndexer) UpdateExistingItems() error {
 	items, err := t.db.GetAllWatchlistItems()
 	if err != nil {
@@ -1102,10 +1213,6 @@ func (t *TMDBIndexer) UpdateItemWithTMDBData(item *database.WatchlistItem) (*dat
 			updatedItem, err := t.Search(item)
 			if err != nil {
 				t.log.Warning("TMDBIndexer", "UpdateItemWithTMDBData", fmt.Sprintf("Title search failed: %v", err))
-				item.Status = sql.NullString{String: "indexing_failed", Valid: true}
-				if err := t.db.UpdateWatchlistItem(item); err != nil {
-					t.log.Error("TMDBIndexer", "UpdateItemWithTMDBData", fmt.Sprintf("Failed to update item status: %v", err))
-				}
 				return nil, fmt.Errorf("failed to find item: %w", err)
 			}
 			if updatedItem != nil {
@@ -1119,10 +1226,6 @@ func (t *TMDBIndexer) UpdateItemWithTMDBData(item *database.WatchlistItem) (*dat
 		item.MediaType = sql.NullString{String: "movie", Valid: true}
 		if err := t.GetMovieDetails(item); err != nil {
 			t.log.Warning("TMDBIndexer", "UpdateItemWithTMDBData", fmt.Sprintf("Failed to get movie details: %v", err))
-			item.Status = sql.NullString{String: "indexing_failed", Valid: true}
-			if err := t.db.UpdateWatchlistItem(item); err != nil {
-				t.log.Error("TMDBIndexer", "UpdateItemWithTMDBData", fmt.Sprintf("Failed to update item status: %v", err))
-			}
 			return nil, fmt.Errorf("failed to get movie details: %w", err)
 		}
 	} else {
@@ -1130,10 +1233,6 @@ func (t *TMDBIndexer) UpdateItemWithTMDBData(item *database.WatchlistItem) (*dat
 		updatedItem, err := t.GetTVDetails(item)
 		if err != nil {
 			t.log.Warning("TMDBIndexer", "UpdateItemWithTMDBData", fmt.Sprintf("Failed to get TV details: %v", err))
-			item.Status = sql.NullString{String: "indexing_failed", Valid: true}
-			if err := t.db.UpdateWatchlistItem(item); err != nil {
-				t.log.Error("TMDBIndexer", "UpdateItemWithTMDBData", fmt.Sprintf("Failed to update item status: %v", err))
-			}
 			return nil, fmt.Errorf("failed to get TV details: %w", err)
 		}
 		item = updatedItem
@@ -1141,19 +1240,10 @@ func (t *TMDBIndexer) UpdateItemWithTMDBData(item *database.WatchlistItem) (*dat
 		// Update seasons and episodes
 		if err := t.updateTVShowData(item); err != nil {
 			t.log.Warning("TMDBIndexer", "UpdateItemWithTMDBData", fmt.Sprintf("Failed to update TV show data: %v", err))
-			item.Status = sql.NullString{String: "indexing_failed", Valid: true}
-			if err := t.db.UpdateWatchlistItem(item); err != nil {
-				t.log.Error("TMDBIndexer", "UpdateItemWithTMDBData", fmt.Sprintf("Failed to update item status: %v", err))
-			}
+			return nil, fmt.Errorf("failed to update TV show data: %w", err)
 		}
 	}
 
-	// Set final status (only if not already failed)
-	if item.Status.String != "indexing_failed" {
-		item.Status = sql.NullString{String: "indexed", Valid: true}
-		item.CurrentStep = sql.NullString{String: "librarymatch_pending", Valid: true}
-	}
-
 	// Final update to ensure all fields are saved
 	if err := t.db.UpdateWatchlistItem(item); err != nil {
 		t.log.Warning("TMDBIndexer", "UpdateItemWithTMDBData", fmt.Sprintf("Failed to update item: %v", err))
@@ -5,24 +5,30 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
-	"time"
 
 	"mye-r/internal/config"
 	"mye-r/internal/database"
 	"mye-r/internal/logger"
+	"mye-r/internal/pipeline"
 )
 
 type LibraryMatcher struct {
-	db     *database.DB
-	log    *logger.Logger
-	config *config.Config
+	db       *database.DB
+	log      *logger.Logger
+	config   *config.Config
+	pipeline *pipeline.Machine
+	leaser   *pipeline.Leaser
+	retrier  *pipeline.Retrier
 }
 
 func NewLibraryMatcher(cfg *config.Config, db *database.DB) *LibraryMatcher {
 	return &LibraryMatcher{
-		db:     db,
-		log:    logger.New(),
-		config: cfg,
+		db:       db,
+		log:      logger.New(),
+		config:   cfg,
+		pipeline: pipeline.New(db),
+		leaser:   pipeline.NewLeaser(db, cfg.ProcessManagement.LeaseTTL),
+		retrier:  pipeline.NewRetrier(cfg, db),
 	}
 }
 
@@ -33,13 +39,15 @@ func New(cfg *config.Config, db *database.DB) *LibraryMatcher {
 func (lm *LibraryMatcher) Start(ctx context.Context) error {
 	lm.log.Info("LibraryMatcher", "Start", "Starting LibraryMatcher")
 	go func() {
+		wakeups := pipeline.ListenOrPoll(lm.db, pipeline.StageLibraryMatcher)
+		defer wakeups.Close()
+
 		for {
-			select {
-			case <-ctx.Done():
+			// Work through the queue, then wait for the next item to arrive
+			for lm.ProcessNextItem() {
+			}
+			if !wakeups.Wait(ctx, pipeline.StageLibraryMatcher, pipeline.CheckInterval(lm.config, pipeline.StageLibraryMatcher)) {
 				return
-			default:
-				lm.ProcessNextItem()
-				time.Sleep(5 * time.Second) // Adjust this delay as needed
 			}
 		}
 	}()
@@ -60,48 +68,54 @@ func (lm *LibraryMatcher) IsNeeded() bool {
 	err := lm.db.QueryRow(`
         SELECT COUNT(*) 
         FROM watchlistitem 
-        WHERE status = 'new' 
-        AND current_step = 'match_pending'
+        WHERE current_step = 'librarymatch_pending'
     `).Scan(&count)
 
 	return err == nil && count > 0
 }
 
-func (lm *LibraryMatcher) ProcessNextItem() {
-	item, err := lm.db.GetNextItemForLibraryMatching()
+// ProcessNextItem matches the next queued item. It returns false when there
+// was nothing to do.
+func (lm *LibraryMatcher) ProcessNextItem() bool {
+	lease, err := lm.leaser.ClaimNext(pipeline.StageLibraryMatcher)
 	if err != nil {
 		lm.log.Error("LibraryMatcher", "ProcessNextItem", fmt.Sprintf("Error getting next item for library matching: %v", err))
-		return
+		return false
 	}
 
-	if item == nil {
-		lm.log.Info("LibraryMatcher", "ProcessNextItem", "No items available for library matching")
-		return
+	if lease == nil {
+		lm.log.Debug("LibraryMatcher", "ProcessNextItem", "No items available for library matching")
+		return false
 	}
+	defer lease.Release()
 
-	lm.log.Info("LibraryMatcher", "ProcessNextItem", fmt.Sprintf("Matching library for item: %s", item.Title))
-
-	// Perform library matching
-	matchedLibraries := lm.matchLibraries(item)
-	if len(matchedLibraries) > 0 {
-		item.CustomLibrary = sql.NullString{String: strings.Join(matchedLibraries, ","), Valid: true}
-		item.Status = sql.NullString{String: "library_matched", Valid: true}
-		lm.log.Info("LibraryMatcher", "ProcessNextItem", fmt.Sprintf("Matched item to custom libraries: %s", strings.Join(matchedLibraries, ", ")))
-	} else {
-		item.CustomLibrary = sql.NullString{String: "", Valid: false}
-		item.Status = sql.NullString{String: "ready_for_scraping", Valid: true}
-		lm.log.Info("LibraryMatcher", "ProcessNextItem", "No custom library match found for item, proceeding to next step")
+	item, err := lm.db.GetWatchlistItem(lease.ItemID)
+	if err != nil {
+		lm.log.Error("LibraryMatcher", "ProcessNextItem", fmt.Sprintf("Error getting item %d: %v", lease.ItemID, err))
+		return true
 	}
 
-	// Update item in database
-	err = lm.db.UpdateWatchlistItemForLibraryMatching(item)
-	if err != nil {
-		lm.log.Error("LibraryMatcher", "ProcessNextItem", fmt.Sprintf("Error updating item after library matching: %v", err))
-		return
+	if err := lm.match(lease.Context(), item); err != nil {
+		lm.log.Error("LibraryMatcher", "ProcessNextItem", fmt.Sprintf("Error matching item %d: %v", item.ID, err))
 	}
+	return true
+}
+
+// ProcessItem matches one item for the RunManager worker pool
+func (lm *LibraryMatcher) ProcessItem(ctx context.Context, item *database.WatchlistItem) error {
+	return lm.Match(item)
 }
 
 func (lm *LibraryMatcher) matchLibraries(item *database.WatchlistItem) []string {
+	// Items of a feed routed to a library go there whatever its filters say
+	if item.ForcedLibrary.Valid && item.ForcedLibrary.String != "" {
+		if lib, ok := lm.config.CustomLibrary(item.ForcedLibrary.String); ok {
+			lm.log.Info("LibraryMatcher", "matchLibraries", fmt.Sprintf("Item routed to custom library: %s", lib.Name))
+			return []string{lib.Name}
+		}
+		lm.log.Warning("LibraryMatcher", "matchLibraries", fmt.Sprintf("Item %s is routed to unknown library %s, matching it by filters", item.Title, item.ForcedLibrary.String))
+	}
+
 	matchedLibraries := []string{}
 	for _, lib := range lm.config.CustomLibraries {
 		if lib.Active && lm.itemMatchesLibrary(item, lib) {
@@ -220,46 +234,24 @@ func (lm *LibraryMatcher) ProcessItemByID(itemID int) {
 		return
 	}
 
-	lm.log.Info("LibraryMatcher", "ProcessItemByID", fmt.Sprintf("Matching library for item: %s", item.Title))
-
-	// Perform library matching
-	matchedLibraries := lm.matchLibraries(item)
-	if len(matchedLibraries) > 0 {
-		item.CustomLibrary = sql.NullString{String: strings.Join(matchedLibraries, ","), Valid: true}
-		item.Status = sql.NullString{String: "library_matched", Valid: true}
-		item.CurrentStep = sql.NullString{String: "scraping_pending", Valid: true}
-		lm.log.Info("LibraryMatcher", "ProcessItemByID", fmt.Sprintf("Matched item to custom libraries: %s", strings.Join(matchedLibraries, ", ")))
-
-		// Check if any matched library requires duplication in the main library
-		duplicateInMainLibrary := false
-		for _, libName := range matchedLibraries {
-			for _, lib := range lm.config.CustomLibraries {
-				if lib.Name == libName && lib.DuplicateInMainLibrary {
-					duplicateInMainLibrary = true
-					break
-				}
-			}
-		}
-		item.MainLibraryPath = sql.NullString{String: fmt.Sprintf("%t", duplicateInMainLibrary), Valid: true}
-		lm.log.Debug("LibraryMatcher", "ProcessItemByID", fmt.Sprintf("Setting MainLibraryPath to: %s", item.MainLibraryPath.String))
-	} else {
-		item.CustomLibrary = sql.NullString{String: "", Valid: false}
-		item.Status = sql.NullString{String: "matcher_failed", Valid: true}
-		item.CurrentStep = sql.NullString{String: "matching", Valid: true}
-		item.MainLibraryPath = sql.NullString{String: "false", Valid: true}
-		lm.log.Debug("LibraryMatcher", "ProcessItemByID", "No match found, setting MainLibraryPath to: false")
-	}
-
-	// Update item in database
-	err = lm.db.UpdateWatchlistItemForLibraryMatching(item)
-	if err != nil {
-		lm.log.Error("LibraryMatcher", "ProcessItemByID", fmt.Sprintf("Error updating item after library matching: %v", err))
-		return
+	if err := lm.Match(item); err != nil {
+		lm.log.Error("LibraryMatcher", "ProcessItemByID", fmt.Sprintf("Error matching item %d: %v", itemID, err))
 	}
 }
 
-// Match processes a single item for library matching
+// Match processes a single item for library matching. The item is leased
+// while it is matched.
 func (lm *LibraryMatcher) Match(item *database.WatchlistItem) error {
+	return lm.leaser.Run(item.ID, pipeline.StageLibraryMatcher, func(ctx context.Context) error {
+		return lm.match(ctx, item)
+	})
+}
+
+func (lm *LibraryMatcher) match(ctx context.Context, item *database.WatchlistItem) error {
+	if err := lm.pipeline.Transition(item.ID, pipeline.StateLibraryMatchPending, pipeline.StateMatching, "library matching started"); err != nil {
+		return err
+	}
+
 	lm.log.Info("LibraryMatcher", "Match", fmt.Sprintf("Matching library for item: %s", item.Title))
 
 	// Perform library matching
@@ -275,34 +267,42 @@ func (lm *LibraryMatcher) Match(item *database.WatchlistItem) error {
 		}
 
 		item.CustomLibrary = sql.NullString{String: strings.Join(matchedLibraries, ","), Valid: true}
-		item.Status = sql.NullString{String: "library_matched", Valid: true}
-		item.CurrentStep = sql.NullString{String: "scraping_pending", Valid: true}
-		
+
 		// Set main_library_path based on duplicate_in_main_library setting
 		mainLibraryPath := "false"
 		if duplicateInMainLibrary {
 			mainLibraryPath = "true"
 		}
 		item.MainLibraryPath = sql.NullString{String: mainLibraryPath, Valid: true}
-		
-		lm.log.Info("LibraryMatcher", "Match", fmt.Sprintf("Matched item to custom libraries: %s (main_library_path: %s)", 
+
+		lm.log.Info("LibraryMatcher", "Match", fmt.Sprintf("Matched item to custom libraries: %s (main_library_path: %s)",
 			strings.Join(matchedLibraries, ", "), mainLibraryPath))
 	} else {
 		item.CustomLibrary = sql.NullString{String: "", Valid: false}
-		item.Status = sql.NullString{String: "library_matched", Valid: true}
-		item.CurrentStep = sql.NullString{String: "scraping_pending", Valid: true}
-		
+
 		// If no custom libraries matched, set main_library_path to true
 		item.MainLibraryPath = sql.NullString{String: "true", Valid: true}
-		
+
 		lm.log.Info("LibraryMatcher", "Match", "No custom library match found for item, proceeding to scraping")
 	}
 
+	// Another worker may have claimed the item while it was matched
+	if lerr := pipeline.LeaseLost(ctx); lerr != nil {
+		return lerr
+	}
+
 	// Update item in database
-	err := lm.db.UpdateWatchlistItemForLibraryMatching(item)
-	if err != nil {
-		return fmt.Errorf("error updating item after library matching: %v", err)
+	if err := lm.db.UpdateWatchlistItemForLibraryMatching(item); err != nil {
+		err = fmt.Errorf("error updating item after library matching: %v", err)
+		if terr := lm.retrier.Fail(item.ID, pipeline.StageLibraryMatcher, err); terr != nil {
+			lm.log.Error("LibraryMatcher", "Match", fmt.Sprintf("Failed to record failure of item %d: %v", item.ID, terr))
+		}
+		return err
 	}
 
+	if err := lm.pipeline.Transition(item.ID, pipeline.StateMatching, pipeline.StateScrapePending, "library matched"); err != nil {
+		return err
+	}
+	lm.retrier.Succeed(item.ID, pipeline.StageLibraryMatcher)
 	return nil
 }
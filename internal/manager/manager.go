@@ -1,30 +1,35 @@
 package manager
 
 import (
-	"database/sql"
 	"fmt"
 	"log"
-	"time"
 
 	"github.com/robfig/cron/v3"
+	"mye-r/internal/config"
 	"mye-r/internal/database"
 	"mye-r/internal/indexers"
+	"mye-r/internal/notify"
+	"mye-r/internal/pipeline"
 	"mye-r/internal/scraper"
 )
 
 type Manager struct {
-	db      *database.DB
-	indexer *indexers.TMDBIndexer
-	scraper *scraper.Scraper
-	cron    *cron.Cron
+	db       *database.DB
+	indexer  *indexers.TMDBIndexer
+	scraper  *scraper.Scraper
+	cron     *cron.Cron
+	pipeline *pipeline.Machine
+	notifier *notify.Dispatcher
 }
 
-func New(db *database.DB, indexer *indexers.TMDBIndexer, scraper *scraper.Scraper) *Manager {
+func New(cfg *config.Config, db *database.DB, indexer *indexers.TMDBIndexer, scraper *scraper.Scraper) *Manager {
 	return &Manager{
-		db:      db,
-		indexer: indexer,
-		scraper: scraper,
-		cron:    cron.New(),
+		db:       db,
+		indexer:  indexer,
+		scraper:  scraper,
+		cron:     cron.New(),
+		pipeline: pipeline.New(db),
+		notifier: notify.New(cfg, db),
 	}
 }
 
@@ -53,17 +58,20 @@ func (m *Manager) checkForNewEpisodes() {
 	}
 
 	for _, item := range items {
-		// Reset the status to trigger re-indexing
-		item.Status = sql.NullString{String: "new", Valid: true}
-		item.CurrentStep = sql.NullString{String: "indexing_pending", Valid: true}
-		item.LastScrapedDate = sql.NullTime{Time: time.Now(), Valid: true}
+		// Only finished series go back through the pipeline; anything else is
+		// already on its way and will pick up the new episodes anyway
+		if item.CurrentStep.String != string(pipeline.StateCompleted) {
+			log.Printf("Skipping series %s, current step is %s", item.Title, item.CurrentStep.String)
+			continue
+		}
 
-		err = m.db.UpdateWatchlistItem(item)
+		err = m.pipeline.Transition(item.ID, pipeline.StateCompleted, pipeline.StateIndexingPending, "new episodes aired")
 		if err != nil {
-			log.Printf("Error updating watchlist item %d: %v", item.ID, err)
+			log.Printf("Error requeueing watchlist item %d: %v", item.ID, err)
 			continue
 		}
 
 		log.Printf("Found new episodes for series: %s", item.Title)
+		m.notifier.Send(notify.ItemEvent(config.NotifyNewEpisodes, item))
 	}
 }
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Default is the registry served on /metrics
+var Default = NewRegistry()
+
+// The metrics mye-r keeps. Providers are counted by status code, or "error"
+// when no response came back.
+var (
+	StageDuration = Default.NewHistogram("mye_r_stage_duration_seconds",
+		"Time a stage spent on one item",
+		[]float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600, 1800},
+		"stage", "outcome")
+
+	TorrentioRequests = Default.NewCounter("mye_r_torrentio_requests_total",
+		"Requests made to Torrentio, retries included",
+		"status")
+
+	TMDBRequests = Default.NewCounter("mye_r_tmdb_requests_total",
+		"Requests made to TMDB",
+		"status")
+
+	TMDBRequestDuration = Default.NewHistogram("mye_r_tmdb_request_duration_seconds",
+		"Time TMDB took to answer",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5})
+
+	RealDebridRequests = Default.NewCounter("mye_r_realdebrid_requests_total",
+		"Calls made to the Real-Debrid API",
+		"endpoint", "status")
+
+	Symlinks = Default.NewCounter("mye_r_symlinks_total",
+		"Library symlinks the symlinker created, failed to create or removed",
+		"result")
+)
+
+// Status is the status label of a provider request
+func Status(resp *http.Response, err error) string {
+	if err != nil || resp == nil {
+		return "error"
+	}
+	return strconv.Itoa(resp.StatusCode)
+}
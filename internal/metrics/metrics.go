@@ -0,0 +1,303 @@
+// Package metrics keeps the counters and histograms of mye-r and serves them
+// in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// Registry holds metric families in memory
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	Labels []string `json:"labels"`
+	Value  float64  `json:"value,omitempty"`
+	Counts []uint64 `json:"counts,omitempty"`
+	Sum    float64  `json:"sum,omitempty"`
+	Count  uint64   `json:"count,omitempty"`
+}
+
+func (r *Registry) register(name, help, kind string, labels []string, buckets []float64) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.families[name]; exists {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+	f := &family{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: make(map[string]*series)}
+	r.families[name] = f
+	return f
+}
+
+// get returns the series of the label values, creating it. r.mu must be held.
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{Labels: append([]string(nil), values...)}
+		if f.kind == kindHistogram {
+			s.Counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Counter is a family of counters told apart by their label values
+type Counter struct {
+	r *Registry
+	f *family
+}
+
+// NewCounter registers a counter family
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r: r, f: r.register(name, help, kindCounter, labels, nil)}
+}
+
+// Inc adds one to the counter of the label values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v to the counter of the label values
+func (c *Counter) Add(v float64, values ...string) {
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+	c.f.get(values).Value += v
+}
+
+// Histogram is a family of histograms told apart by their label values
+type Histogram struct {
+	r *Registry
+	f *family
+}
+
+// NewHistogram registers a histogram family with the given upper bounds
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{r: r, f: r.register(name, help, kindHistogram, labels, buckets)}
+}
+
+// Observe adds v to the histogram of the label values
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.r.mu.Lock()
+	defer h.r.mu.Unlock()
+	s := h.f.get(values)
+	for i, bound := range h.f.buckets {
+		if v <= bound {
+			s.Counts[i]++
+		}
+	}
+	s.Sum += v
+	s.Count++
+}
+
+// Gauge is a family of values read when the metrics are served
+type Gauge struct {
+	Name    string
+	Help    string
+	Labels  []string
+	Samples []Sample
+}
+
+// Sample is one value of a gauge
+type Sample struct {
+	Values []string
+	Value  float64
+}
+
+// Collector reads gauges when the metrics are served
+type Collector func() ([]Gauge, error)
+
+// Handler serves the registry and the gauges of the collectors. It fails the
+// whole scrape when a collector does, so Prometheus reports the target down.
+func (r *Registry) Handler(collectors ...Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var gauges []Gauge
+		for _, collect := range collectors {
+			collected, err := collect()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed to collect metrics: %v", err), http.StatusInternalServerError)
+				return
+			}
+			gauges = append(gauges, collected...)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w, gauges...)
+	})
+}
+
+// Write writes the registry and the gauges in the Prometheus text format
+func (r *Registry) Write(w io.Writer, gauges ...Gauge) error {
+	families := make([]*family, 0, len(gauges))
+	for _, gauge := range gauges {
+		f := &family{name: gauge.Name, help: gauge.Help, kind: kindGauge, labels: gauge.Labels, series: make(map[string]*series)}
+		for _, sample := range gauge.Samples {
+			f.get(sample.Values).Value += sample.Value
+		}
+		families = append(families, f)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	out := bufio.NewWriter(w)
+	for _, f := range families {
+		fmt.Fprintf(out, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(out, "# TYPE %s %s\n", f.name, f.kind)
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			f.writeSeries(out, f.series[key])
+		}
+	}
+	return out.Flush()
+}
+
+func (f *family) writeSeries(w io.Writer, s *series) {
+	if f.kind != kindHistogram {
+		fmt.Fprintf(w, "%s%s %s\n", f.name, labelPairs(f.labels, s.Labels, ""), formatFloat(s.Value))
+		return
+	}
+	for i, bound := range f.buckets {
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelPairs(f.labels, s.Labels, formatFloat(bound)), s.Counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelPairs(f.labels, s.Labels, "+Inf"), s.Count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labelPairs(f.labels, s.Labels, ""), formatFloat(s.Sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", f.name, labelPairs(f.labels, s.Labels, ""), s.Count)
+}
+
+// labelPairs formats the labels of a series, adding le for histogram buckets
+func labelPairs(names, values []string, le string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabel(values[i])))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf(`le="%s"`, le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// WriteFile saves the counters and histograms so another process can merge
+// them into its registry. Stage commands run by serve use it to hand over what
+// they counted.
+func (r *Registry) WriteFile(path string) error {
+	r.mu.Lock()
+	snapshot := make(map[string][]*series, len(r.families))
+	for name, f := range r.families {
+		for _, s := range f.series {
+			snapshot[name] = append(snapshot[name], s)
+		}
+	}
+	data, err := json.Marshal(snapshot)
+	r.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode metrics: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write metrics file: %v", err)
+	}
+	return nil
+}
+
+// MergeFile adds the counters and histograms saved by WriteFile to the
+// registry. Families it does not know are ignored.
+func (r *Registry) MergeFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read metrics file: %v", err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	var snapshot map[string][]*series
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to decode metrics file: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, saved := range snapshot {
+		f, ok := r.families[name]
+		if !ok {
+			continue
+		}
+		for _, s := range saved {
+			if len(s.Labels) != len(f.labels) || len(s.Counts) != len(f.buckets) {
+				continue
+			}
+			merged := f.get(s.Labels)
+			merged.Value += s.Value
+			for i, count := range s.Counts {
+				merged.Counts[i] += count
+			}
+			merged.Sum += s.Sum
+			merged.Count += s.Count
+		}
+	}
+	return nil
+}
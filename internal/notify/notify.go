@@ -0,0 +1,197 @@
+// Package notify tells people about what happened to their items. Events are
+// routed to the sinks configured under notifications and rendered with each
+// event's template.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+	"time"
+
+	"mye-r/internal/config"
+	"mye-r/internal/database"
+	"mye-r/internal/logger"
+)
+
+// sendTimeout bounds how long one sink may take to deliver
+const sendTimeout = 15 * time.Second
+
+// queueSize is how many events may wait for delivery before new ones are
+// dropped
+const queueSize = 100
+
+// Event is something that happened to an item. Its fields are what templates
+// can use.
+type Event struct {
+	Type      string    `json:"event"`
+	ItemID    int       `json:"item_id"`
+	Title     string    `json:"title"`
+	Year      int       `json:"year,omitempty"`
+	MediaType string    `json:"media_type,omitempty"`
+	Library   string    `json:"library,omitempty"`
+	Stage     string    `json:"stage,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// ItemEvent creates an event about an item
+func ItemEvent(eventType string, item *database.WatchlistItem) Event {
+	return Event{
+		Type:      eventType,
+		ItemID:    item.ID,
+		Title:     item.Title,
+		Year:      int(item.ItemYear.Int64),
+		MediaType: item.MediaType.String,
+		Library:   item.CustomLibrary.String,
+		Time:      time.Now(),
+	}
+}
+
+// Subject is a one line summary of the event, used where a sink has a title
+func (e Event) Subject() string {
+	return fmt.Sprintf("mye-r %s: %s", e.Type, e.Title)
+}
+
+// Notifier delivers a rendered message about an event
+type Notifier interface {
+	Notify(ctx context.Context, event Event, message string) error
+}
+
+// defaultTemplates render events without a configured template
+var defaultTemplates = map[string]string{
+	config.NotifyCompleted:   `{{.Title}}{{if .Year}} ({{.Year}}){{end}} is in the library{{if .Library}} ({{.Library}}){{end}}`,
+	config.NotifyFailed:      `{{.Title}}{{if .Year}} ({{.Year}}){{end}} failed in {{.Stage}}: {{.Reason}}`,
+	config.NotifyNoStreams:   `No streams found for {{.Title}}{{if .Year}} ({{.Year}}){{end}}, it will be scraped again later`,
+	config.NotifyNewEpisodes: `New episodes of {{.Title}} aired, they are on their way`,
+}
+
+// ItemStore looks up the item of events that only carry its ID
+type ItemStore interface {
+	GetWatchlistItem(id int) (*database.WatchlistItem, error)
+}
+
+// Dispatcher sends events to the sinks their route names
+type Dispatcher struct {
+	cfg    *config.Config
+	items  ItemStore
+	log    *logger.Logger
+	routes map[string]route
+	queue  chan Event
+}
+
+type route struct {
+	sinks    map[string]Notifier
+	template *template.Template
+}
+
+// New builds the sinks and routes of the notifications config and starts
+// delivering in the background. items may be nil; events then keep the title
+// they were sent with.
+func New(cfg *config.Config, items ItemStore) *Dispatcher {
+	d := &Dispatcher{
+		cfg:    cfg,
+		items:  items,
+		log:    logger.New(),
+		routes: make(map[string]route),
+	}
+
+	sinks := make(map[string]Notifier)
+	for name, sink := range cfg.Notifications.Sinks {
+		notifier, err := newSink(sink)
+		if err != nil {
+			d.log.Error("Notify", "New", fmt.Sprintf("Sink %s: %v", name, err))
+			continue
+		}
+		sinks[name] = notifier
+	}
+
+	for event, eventConfig := range cfg.Notifications.Events {
+		text := eventConfig.Template
+		if text == "" {
+			text = defaultTemplates[event]
+		}
+		tmpl, err := template.New(event).Parse(text)
+		if err != nil {
+			d.log.Error("Notify", "New", fmt.Sprintf("Event %s: invalid template: %v", event, err))
+			continue
+		}
+
+		r := route{sinks: make(map[string]Notifier), template: tmpl}
+		for _, name := range eventConfig.Sinks {
+			if notifier, ok := sinks[name]; ok {
+				r.sinks[name] = notifier
+			}
+		}
+		if len(r.sinks) > 0 {
+			d.routes[event] = r
+		}
+	}
+
+	if len(d.routes) > 0 {
+		d.queue = make(chan Event, queueSize)
+		go d.run()
+	}
+	return d
+}
+
+// Send queues an event for delivery to the sinks of its route and returns
+// right away, so a slow sink never holds up the stage that sent it. Events
+// are dropped when the queue is full or still queued when the process exits,
+// and failures are logged: a lost notification must not fail the item it is
+// about.
+func (d *Dispatcher) Send(event Event) {
+	if _, ok := d.routes[event.Type]; !ok {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	select {
+	case d.queue <- event:
+	default:
+		d.log.Warning("Notify", "Send", fmt.Sprintf("Queue is full, dropping %s for item %d", event.Type, event.ItemID))
+	}
+}
+
+// run delivers queued events one at a time
+func (d *Dispatcher) run() {
+	for event := range d.queue {
+		d.deliver(event)
+	}
+}
+
+// deliver renders an event and sends it to the sinks of its route
+func (d *Dispatcher) deliver(event Event) {
+	r := d.routes[event.Type]
+
+	if event.Title == "" && event.ItemID > 0 && d.items != nil {
+		if item, err := d.items.GetWatchlistItem(event.ItemID); err == nil {
+			filled := ItemEvent(event.Type, item)
+			filled.Stage, filled.Reason, filled.Time = event.Stage, event.Reason, event.Time
+			event = filled
+		}
+	}
+
+	var message bytes.Buffer
+	if err := r.template.Execute(&message, event); err != nil {
+		d.log.Error("Notify", "deliver", fmt.Sprintf("Failed to render %s for item %d: %v", event.Type, event.ItemID, err))
+		return
+	}
+
+	if d.cfg.DryRun {
+		d.log.Info("Notify", "deliver", fmt.Sprintf("Dry run, not sending %s: %s", event.Type, message.String()))
+		return
+	}
+
+	for name, notifier := range r.sinks {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err := notifier.Notify(ctx, event, message.String())
+		cancel()
+		if err != nil {
+			d.log.Error("Notify", "deliver", fmt.Sprintf("Failed to send %s for item %d to %s: %v", event.Type, event.ItemID, name, err))
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"mye-r/internal/config"
+)
+
+// discordMaxLength is the longest message Discord accepts
+const discordMaxLength = 2000
+
+var httpClient = &http.Client{Timeout: sendTimeout}
+
+// newSink creates the notifier of a configured sink
+func newSink(sink config.SinkConfig) (Notifier, error) {
+	switch sink.Type {
+	case config.SinkDiscord:
+		return &Discord{URL: sink.URL}, nil
+	case config.SinkNtfy:
+		return &Ntfy{URL: sink.URL, Token: sink.Token}, nil
+	case config.SinkSMTP:
+		port := sink.Port
+		if port == 0 {
+			port = 587
+		}
+		return &SMTP{
+			Addr:     net.JoinHostPort(sink.Host, strconv.Itoa(port)),
+			Host:     sink.Host,
+			Username: sink.Username,
+			Password: sink.Password,
+			From:     sink.From,
+			To:       sink.To,
+		}, nil
+	case config.SinkWebhook:
+		return &Webhook{URL: sink.URL, Token: sink.Token}, nil
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", sink.Type)
+	}
+}
+
+// Discord posts messages to a Discord channel webhook
+type Discord struct {
+	URL string
+}
+
+func (d *Discord) Notify(ctx context.Context, event Event, message string) error {
+	if runes := []rune(message); len(runes) > discordMaxLength {
+		message = string(runes[:discordMaxLength-3]) + "..."
+	}
+	body, err := json.Marshal(map[string]string{"username": "mye-r", "content": message})
+	if err != nil {
+		return err
+	}
+	return post(ctx, d.URL, "application/json", "", bytes.NewReader(body), nil)
+}
+
+// Ntfy publishes messages to an ntfy topic URL, with the event's subject as
+// the title
+type Ntfy struct {
+	URL   string
+	Token string
+}
+
+// ntfyTags are the emoji ntfy shows next to each event
+var ntfyTags = map[string]string{
+	config.NotifyCompleted:   "white_check_mark",
+	config.NotifyFailed:      "x",
+	config.NotifyNoStreams:   "mag",
+	config.NotifyNewEpisodes: "tv",
+}
+
+func (n *Ntfy) Notify(ctx context.Context, event Event, message string) error {
+	headers := map[string]string{"Title": event.Subject()}
+	if tag, ok := ntfyTags[event.Type]; ok {
+		headers["Tags"] = tag
+	}
+	return post(ctx, n.URL, "text/plain; charset=utf-8", n.Token, strings.NewReader(message), headers)
+}
+
+// SMTP sends messages by email. The connection is upgraded with STARTTLS
+// when the server offers it, and authenticated when Username is set.
+type SMTP struct {
+	Addr     string
+	Host     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (s *SMTP) Notify(ctx context.Context, event Event, message string) error {
+	var mail bytes.Buffer
+	fmt.Fprintf(&mail, "From: %s\r\n", s.From)
+	fmt.Fprintf(&mail, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&mail, "Subject: %s\r\n", event.Subject())
+	fmt.Fprintf(&mail, "Date: %s\r\n", event.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&mail, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	mail.WriteString(strings.ReplaceAll(message, "\n", "\r\n"))
+	mail.WriteString("\r\n")
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	// net/smtp takes no context, so the deadline is enforced around it
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.Addr, auth, s.From, s.To, mail.Bytes())
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Webhook posts the event and its message as JSON, for anything that is not
+// covered by the other sinks
+type Webhook struct {
+	URL   string
+	Token string
+}
+
+func (w *Webhook) Notify(ctx context.Context, event Event, message string) error {
+	body, err := json.Marshal(struct {
+		Event
+		Message string `json:"message"`
+	}{event, message})
+	if err != nil {
+		return err
+	}
+	return post(ctx, w.URL, "application/json", w.Token, bytes.NewReader(body), nil)
+}
+
+// post sends a notification and fails on any status but 2xx
+func post(ctx context.Context, endpoint, contentType, token string, body io.Reader, headers map[string]string) error {
+	// Webhook URLs carry their secret, so errors leave them out
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return errors.New("invalid url")
+	}
+	req.Header.Set("Content-Type", contentType)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := httpClient.Do(req)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		text, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(text)))
+	}
+	return nil
+}
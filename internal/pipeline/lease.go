@@ -0,0 +1,196 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mye-r/internal/logger"
+)
+
+// DefaultLeaseTTL is used when process_management.lease_ttl is not set
+const DefaultLeaseTTL = 2 * time.Minute
+
+// ErrLeased is returned when another worker holds the lease on an item
+var ErrLeased = errors.New("item is leased by another worker")
+
+// ErrLeaseLost is the cause of a lease's context once the lease could not be
+// renewed and another worker may have claimed the item
+var ErrLeaseLost = errors.New("lease on item lost")
+
+// LeaseLost returns ErrLeaseLost once the lease behind ctx is lost. Stages
+// check it before they move an item on, so an item another worker claimed in
+// the meantime is not moved twice.
+func LeaseLost(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return nil
+}
+
+// LeaseStore is the part of the database the leaser needs
+type LeaseStore interface {
+	Store
+	ClaimItemLease(itemID int, stage, workerID string, ttl time.Duration) (bool, error)
+	ClaimNextItemLease(stage, step, workerID string, ttl time.Duration) (int, error)
+	RenewItemLease(itemID int, stage, workerID string, ttl time.Duration) (bool, error)
+	ReleaseItemLease(itemID int, stage, workerID string) error
+}
+
+// dryRunner is implemented by stores that never commit what they write
+type dryRunner interface {
+	IsDryRun() bool
+}
+
+// leaseCounter makes worker IDs unique within a process, so two goroutines of
+// the same instance can not both hold a lease on one item
+var leaseCounter int64
+
+// Leaser hands out leases on items for a stage. A lease is renewed by a
+// heartbeat while the work runs; if the worker dies the lease expires and the
+// item can be claimed again.
+type Leaser struct {
+	db     LeaseStore
+	ttl    time.Duration
+	prefix string
+	log    *logger.Logger
+}
+
+func NewLeaser(db LeaseStore, ttl time.Duration) *Leaser {
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return &Leaser{
+		db:     db,
+		ttl:    ttl,
+		prefix: fmt.Sprintf("%s:%d", hostname, os.Getpid()),
+		log:    logger.New(),
+	}
+}
+
+func (l *Leaser) newWorkerID() string {
+	return fmt.Sprintf("%s:%d", l.prefix, atomic.AddInt64(&leaseCounter, 1))
+}
+
+// Claim leases one item for a stage and starts its heartbeat. It returns
+// ErrLeased when another worker holds the item.
+func (l *Leaser) Claim(itemID int, stage Stage) (*Lease, error) {
+	workerID := l.newWorkerID()
+	claimed, err := l.db.ClaimItemLease(itemID, string(stage), workerID, l.ttl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lease item %d for %s: %v", itemID, stage, err)
+	}
+	if !claimed {
+		return nil, fmt.Errorf("%w: item %d, stage %s", ErrLeased, itemID, stage)
+	}
+	return l.start(itemID, stage, workerID), nil
+}
+
+// ClaimNext leases the next item waiting in the stage's pending state. It
+// returns a nil lease when there is nothing to do.
+func (l *Leaser) ClaimNext(stage Stage) (*Lease, error) {
+	workerID := l.newWorkerID()
+	itemID, err := l.db.ClaimNextItemLease(string(stage), string(stage.PendingState()), workerID, l.ttl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lease next item for %s: %v", stage, err)
+	}
+	if itemID == 0 {
+		return nil, nil
+	}
+	return l.start(itemID, stage, workerID), nil
+}
+
+// Run claims an item, runs fn while holding the lease and releases it again.
+// The context given to fn is cancelled when the lease is lost.
+func (l *Leaser) Run(itemID int, stage Stage, fn func(ctx context.Context) error) error {
+	lease, err := l.Claim(itemID, stage)
+	if err != nil {
+		return err
+	}
+	defer lease.Release()
+	return fn(lease.Context())
+}
+
+func (l *Leaser) start(itemID int, stage Stage, workerID string) *Lease {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	lease := &Lease{
+		ItemID:   itemID,
+		Stage:    stage,
+		WorkerID: workerID,
+		leaser:   l,
+		stop:     make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	// Nobody else can see the lease of a dry run, so it does not need renewing
+	if dr, ok := l.db.(dryRunner); !ok || !dr.IsDryRun() {
+		go lease.heartbeat()
+	}
+	return lease
+}
+
+// Lease is a claim on one item for one stage
+type Lease struct {
+	ItemID   int
+	Stage    Stage
+	WorkerID string
+
+	leaser *Leaser
+	stop   chan struct{}
+	once   sync.Once
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+}
+
+// Context is cancelled with ErrLeaseLost when the lease is lost, and when it
+// is released
+func (lease *Lease) Context() context.Context {
+	return lease.ctx
+}
+
+// heartbeat renews the lease until it is released. The lease is lost when
+// another worker took it over or it could not be renewed before it expired.
+func (lease *Lease) heartbeat() {
+	ticker := time.NewTicker(lease.leaser.ttl / 3)
+	defer ticker.Stop()
+
+	renewedAt := time.Now()
+	for {
+		select {
+		case <-lease.stop:
+			return
+		case <-ticker.C:
+			renewed, err := lease.leaser.db.RenewItemLease(lease.ItemID, string(lease.Stage), lease.WorkerID, lease.leaser.ttl)
+			switch {
+			case err == nil && renewed:
+				renewedAt = time.Now()
+				continue
+			case err != nil && time.Since(renewedAt) < lease.leaser.ttl:
+				lease.leaser.log.Warning("Leaser", "heartbeat", fmt.Sprintf("Failed to renew lease on item %d for %s: %v", lease.ItemID, lease.Stage, err))
+				continue
+			}
+			lease.leaser.log.Warning("Leaser", "heartbeat", fmt.Sprintf("Lost lease on item %d for %s", lease.ItemID, lease.Stage))
+			lease.cancel(fmt.Errorf("%w: item %d, stage %s", ErrLeaseLost, lease.ItemID, lease.Stage))
+			return
+		}
+	}
+}
+
+// Release stops the heartbeat and drops the lease. It is safe to call twice.
+func (lease *Lease) Release() {
+	lease.once.Do(func() {
+		close(lease.stop)
+		lease.cancel(context.Canceled)
+		if err := lease.leaser.db.ReleaseItemLease(lease.ItemID, string(lease.Stage), lease.WorkerID); err != nil {
+			lease.leaser.log.Error("Leaser", "Release", fmt.Sprintf("Failed to release lease on item %d for %s: %v", lease.ItemID, lease.Stage, err))
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package pipeline
+
+import (
+	"fmt"
+
+	"mye-r/internal/database"
+	"mye-r/internal/logger"
+)
+
+// Store is the part of the database the state machine needs
+type Store interface {
+	TransitionItemState(itemID int, fromStep, toStep, toStatus, reason string) (bool, error)
+}
+
+// Machine applies state transitions to watchlist items
+type Machine struct {
+	db  Store
+	log *logger.Logger
+}
+
+func New(db Store) *Machine {
+	return &Machine{
+		db:  db,
+		log: logger.New(),
+	}
+}
+
+// Transition moves an item from one state to another. It fails if the
+// transition is not allowed or if the item is no longer in the from state.
+func (m *Machine) Transition(itemID int, from, to State, reason string) error {
+	if !from.Valid() {
+		return fmt.Errorf("%w: %q", ErrUnknownState, from)
+	}
+	if !to.Valid() {
+		return fmt.Errorf("%w: %q", ErrUnknownState, to)
+	}
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, from, to)
+	}
+
+	applied, err := m.db.TransitionItemState(itemID, string(from), string(to), to.Status(), reason)
+	if err != nil {
+		return fmt.Errorf("failed to move item %d from %s to %s: %v", itemID, from, to, err)
+	}
+	if !applied {
+		return fmt.Errorf("%w: item %d is not %s", ErrStateMismatch, itemID, from)
+	}
+
+	m.log.Info("Pipeline", "Transition", fmt.Sprintf("Item %d: %s -> %s (%s)", itemID, from, to, reason))
+	return nil
+}
+
+// CheckStates moves items whose current_step is a value written by an older
+// version onto the state it meant, recording the transition, and reports the
+// items whose state it cannot tell. It is run at startup so stranded rows are
+// picked up again or at least visible instead of silently ignored.
+func CheckStates(db *database.DB) ([]database.ItemState, error) {
+	log := logger.New()
+
+	known := make([]string, 0, len(statusByState))
+	for _, state := range States() {
+		known = append(known, string(state))
+	}
+
+	items, err := db.GetItemsInUnknownState(known)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check item states: %v", err)
+	}
+
+	var stranded []database.ItemState
+	for _, item := range items {
+		if state, ok := SuggestState(item.CurrentStep); ok {
+			applied, err := db.TransitionItemState(item.ID, item.CurrentStep, string(state), state.Status(), "migrated from a legacy state")
+			if err != nil {
+				return nil, fmt.Errorf("failed to migrate item %d: %v", item.ID, err)
+			}
+			if applied {
+				log.Info("Pipeline", "CheckStates", fmt.Sprintf("Item %d (%s): legacy %s -> %s", item.ID, item.Title, item.CurrentStep, state))
+				continue
+			}
+		}
+
+		message := fmt.Sprintf("Item %d (%s) has unknown state: status=%q current_step=%q", item.ID, item.Title, item.Status, item.CurrentStep)
+		if suggestion, ok := SuggestState(item.Status); ok {
+			message += fmt.Sprintf(", probably %s", suggestion)
+		}
+		log.Warning("Pipeline", "CheckStates", message)
+		stranded = append(stranded, item)
+	}
+	if len(stranded) == 0 {
+		log.Info("Pipeline", "CheckStates", "All items are in known pipeline states")
+	}
+
+	return stranded, nil
+}
+
+// ManualTargets returns where an operator may move an item waiting in or
+// failed by a stage: back to the stage's pending state to retry it, or on to
+// the next stage's pending state to skip the stage. Items a stage is working
+// on have no manual targets.
+func ManualTargets(state State) (retry, skip State, ok bool) {
+	stage, ok := StageFor(state)
+	if !ok || state == stage.WorkingState() {
+		return "", "", false
+	}
+
+	skip = StateCompleted
+	stages := Stages()
+	for i, s := range stages {
+		if s == stage && i+1 < len(stages) {
+			skip = stages[i+1].PendingState()
+		}
+	}
+	return stage.PendingState(), skip, true
+}
+
+// Approve lets an item held in approval_pending into the pipeline
+func (m *Machine) Approve(itemID int, reason string) error {
+	return m.Transition(itemID, StateApprovalPending, StateIndexingPending, reason)
+}
+
+// Override moves an item to a state chosen by an operator. Only the targets
+// returned by ManualTargets are allowed.
+func (m *Machine) Override(itemID int, from, to State, reason string) error {
+	retry, skip, ok := ManualTargets(from)
+	if !ok || (to != retry && to != skip) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, from, to)
+	}
+
+	applied, err := m.db.TransitionItemState(itemID, string(from), string(to), to.Status(), reason)
+	if err != nil {
+		return fmt.Errorf("failed to move item %d from %s to %s: %v", itemID, from, to, err)
+	}
+	if !applied {
+		return fmt.Errorf("%w: item %d is not %s", ErrStateMismatch, itemID, from)
+	}
+
+	m.log.Info("Pipeline", "Override", fmt.Sprintf("Item %d: %s -> %s (%s)", itemID, from, to, reason))
+	return nil
+}
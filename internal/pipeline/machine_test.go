@@ -0,0 +1,53 @@
+package pipeline
+
+import "testing"
+
+func TestManualTargets(t *testing.T) {
+	tests := []struct {
+		state State
+		retry State
+		skip  State
+		ok    bool
+	}{
+		{StateIndexingPending, StateIndexingPending, StateLibraryMatchPending, true},
+		{StateIndexingFailed, StateIndexingPending, StateLibraryMatchPending, true},
+		{StateMatchFailed, StateLibraryMatchPending, StateScrapePending, true},
+		{StateScrapeFailed, StateScrapePending, StateDownloadPending, true},
+		{StateDownloadFailed, StateDownloadPending, StateSymlinkPending, true},
+		{StateSymlinkPending, StateSymlinkPending, StateCompleted, true},
+		{StateSymlinkFailed, StateSymlinkPending, StateCompleted, true},
+		{StateIndexing, "", "", false},
+		{StateScraping, "", "", false},
+		{StateDownloading, "", "", false},
+		{StateApprovalPending, "", "", false},
+		{StateCompleted, "", "", false},
+		{State("ready_for_scraping"), "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.state), func(t *testing.T) {
+			retry, skip, ok := ManualTargets(tt.state)
+			if retry != tt.retry || skip != tt.skip || ok != tt.ok {
+				t.Errorf("ManualTargets(%s) = %q, %q, %v, want %q, %q, %v", tt.state, retry, skip, ok, tt.retry, tt.skip, tt.ok)
+			}
+		})
+	}
+}
+
+func TestManualTargetsAreTransitions(t *testing.T) {
+	// Overrides bypass the transition table, but the pending states they
+	// lead to must still be places the stages pick items up from
+	for _, state := range States() {
+		retry, skip, ok := ManualTargets(state)
+		if !ok {
+			continue
+		}
+		for _, to := range []State{retry, skip} {
+			if !to.Valid() {
+				t.Errorf("ManualTargets(%s) leads to unknown state %q", state, to)
+			}
+			if stage, ok := StageFor(to); ok && to != stage.PendingState() {
+				t.Errorf("ManualTargets(%s) leads to %s, which is not a pending state", state, to)
+			}
+		}
+	}
+}
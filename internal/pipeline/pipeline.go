@@ -0,0 +1,173 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// State is the value stored in watchlistitem.current_step. Every stage of the
+// pipeline reads and writes one of these and nothing else.
+type State string
+
+const (
+	StateApprovalPending     State = "approval_pending" // held until an operator approves it
+	StateIndexingPending     State = "indexing_pending"
+	StateIndexing            State = "indexing"
+	StateIndexingFailed      State = "indexing_failed"
+	StateLibraryMatchPending State = "librarymatch_pending"
+	StateMatching            State = "matching"
+	StateMatchFailed         State = "match_failed"
+	StateScrapePending       State = "scrape_pending"
+	StateScraping            State = "scraping"
+	StateScrapeFailed        State = "scrape_failed"
+	StateDownloadPending     State = "download_pending"
+	StateDownloading         State = "downloading"
+	StateDownloadFailed      State = "download_failed"
+	StateSymlinkPending      State = "symlink_pending"
+	StateSymlinking          State = "symlinking"
+	StateSymlinkFailed       State = "symlink_failed"
+	StateCompleted           State = "completed"
+)
+
+// Coarse values stored in watchlistitem.status. The status column is derived
+// from the state so that it can never disagree with current_step.
+const (
+	StatusRequested   = "requested"
+	StatusNew         = "new"
+	StatusIndexing    = "indexing"
+	StatusIndexed     = "indexed"
+	StatusMatching    = "matching"
+	StatusMatched     = "library_matched"
+	StatusScraping    = "scraping"
+	StatusScraped     = "scraped"
+	StatusDownloading = "downloading"
+	StatusDownloaded  = "downloaded"
+	StatusSymlinking  = "symlinking"
+	StatusCompleted   = "completed"
+	StatusFailed      = "failed"
+)
+
+var (
+	// ErrIllegalTransition is returned when the state machine does not allow
+	// moving from one state to another.
+	ErrIllegalTransition = errors.New("illegal state transition")
+	// ErrStateMismatch is returned when the item is no longer in the state the
+	// caller expected, usually because another worker moved it first.
+	ErrStateMismatch = errors.New("item is not in the expected state")
+	// ErrUnknownState is returned for values that are not pipeline states.
+	ErrUnknownState = errors.New("unknown pipeline state")
+)
+
+var statusByState = map[State]string{
+	StateApprovalPending:     StatusRequested,
+	StateIndexingPending:     StatusNew,
+	StateIndexing:            StatusIndexing,
+	StateIndexingFailed:      StatusFailed,
+	StateLibraryMatchPending: StatusIndexed,
+	StateMatching:            StatusMatching,
+	StateMatchFailed:         StatusFailed,
+	StateScrapePending:       StatusMatched,
+	StateScraping:            StatusScraping,
+	StateScrapeFailed:        StatusFailed,
+	StateDownloadPending:     StatusScraped,
+	StateDownloading:         StatusDownloading,
+	StateDownloadFailed:      StatusFailed,
+	StateSymlinkPending:      StatusDownloaded,
+	StateSymlinking:          StatusSymlinking,
+	StateSymlinkFailed:       StatusFailed,
+	StateCompleted:           StatusCompleted,
+}
+
+// transitions lists, for every state, the states it may move to.
+var transitions = map[State][]State{
+	StateApprovalPending:     {StateIndexingPending},
+	StateIndexingPending:     {StateIndexing},
+	StateIndexing:            {StateLibraryMatchPending, StateIndexingFailed, StateIndexingPending},
+	StateIndexingFailed:      {StateIndexingPending},
+	StateLibraryMatchPending: {StateMatching},
+	StateMatching:            {StateScrapePending, StateMatchFailed, StateLibraryMatchPending},
+	StateMatchFailed:         {StateLibraryMatchPending},
+	StateScrapePending:       {StateScraping},
+	StateScraping:            {StateDownloadPending, StateScrapeFailed, StateScrapePending},
+	StateScrapeFailed:        {StateScrapePending},
+	StateDownloadPending:     {StateDownloading, StateScrapePending},
+	StateDownloading:         {StateSymlinkPending, StateDownloadFailed, StateDownloadPending, StateScrapePending},
+	StateDownloadFailed:      {StateDownloadPending, StateScrapePending},
+	StateSymlinkPending:      {StateSymlinking, StateDownloadPending},
+	StateSymlinking:          {StateCompleted, StateSymlinkFailed, StateSymlinkPending},
+	StateSymlinkFailed:       {StateSymlinkPending, StateDownloadPending},
+	StateCompleted:           {StateIndexingPending, StateDownloadPending},
+}
+
+// legacyStates maps values written by older versions of the stages onto the
+// state they meant, so the startup check can move items stranded in them.
+var legacyStates = map[string]State{
+	"new":                StateIndexingPending,
+	"fetch_pending":      StateIndexingPending,
+	"indexed":            StateLibraryMatchPending,
+	"match_pending":      StateLibraryMatchPending,
+	"ready_for_matching": StateLibraryMatchPending,
+	"library_matched":    StateScrapePending,
+	"ready_for_scraping": StateScrapePending,
+	"scraping_pending":   StateScrapePending,
+	"ready_for_download": StateDownloadPending,
+	"downloaded":         StateSymlinkPending,
+	"symlinked":          StateCompleted,
+}
+
+// States returns every known state in a stable order.
+func States() []State {
+	states := make([]State, 0, len(statusByState))
+	for s := range statusByState {
+		states = append(states, s)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i] < states[j] })
+	return states
+}
+
+// Valid reports whether s is a known state.
+func (s State) Valid() bool {
+	_, ok := statusByState[s]
+	return ok
+}
+
+// Status returns the watchlistitem.status value that belongs to s.
+func (s State) Status() string {
+	return statusByState[s]
+}
+
+// Failed reports whether s is one of the terminal failure states.
+func (s State) Failed() bool {
+	return statusByState[s] == StatusFailed
+}
+
+// ParseState converts a stored current_step value into a State.
+func ParseState(value string) (State, error) {
+	s := State(value)
+	if !s.Valid() {
+		return "", fmt.Errorf("%w: %q", ErrUnknownState, value)
+	}
+	return s, nil
+}
+
+// CanTransition reports whether the state machine allows from -> to.
+func CanTransition(from, to State) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns the states that from may move to.
+func Next(from State) []State {
+	return append([]State(nil), transitions[from]...)
+}
+
+// SuggestState returns the state a legacy or misspelt value most likely meant.
+func SuggestState(value string) (State, bool) {
+	s, ok := legacyStates[value]
+	return s, ok
+}
@@ -0,0 +1,39 @@
+package pipeline
+
+import (
+	"mye-r/internal/database"
+)
+
+// QueueCounts is how many items sit in each state of a stage
+type QueueCounts struct {
+	Stage   Stage `json:"stage"`
+	Pending int   `json:"pending"`
+	Ready   int   `json:"ready"` // pending items that are not leased or backing off
+	Working int   `json:"working"`
+	Failed  int   `json:"failed"`
+}
+
+// Queues counts the items of every stage, in processing order, and the
+// items that made it through the pipeline
+func Queues(db *database.DB) ([]QueueCounts, int, error) {
+	byStep, err := db.CountItemsByStep()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	queues := make([]QueueCounts, 0, len(Stages()))
+	for _, stage := range Stages() {
+		ready, err := db.CountQueuedItems(string(stage), string(stage.PendingState()))
+		if err != nil {
+			return nil, 0, err
+		}
+		queues = append(queues, QueueCounts{
+			Stage:   stage,
+			Pending: byStep[string(stage.PendingState())],
+			Ready:   ready,
+			Working: byStep[string(stage.WorkingState())],
+			Failed:  byStep[string(stage.FailedState())],
+		})
+	}
+	return queues, byStep[string(StateCompleted)], nil
+}
@@ -0,0 +1,166 @@
+package pipeline
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"mye-r/internal/config"
+	"mye-r/internal/logger"
+	"mye-r/internal/notify"
+)
+
+// Defaults used when neither the program nor process_management configure them
+const (
+	DefaultMaxRetries     = 3
+	DefaultRetryBaseDelay = time.Minute
+	DefaultRetryMaxDelay  = time.Hour
+)
+
+// RetryStore is the part of the database the retrier needs
+type RetryStore interface {
+	Store
+	RecordItemFailure(itemID int, stage, lastError string) (int, error)
+	SetItemNextAttempt(itemID int, stage string, next time.Time) error
+	ClearItemRetry(itemID int, stage string) error
+}
+
+// RetryPolicy decides how often and how late a failed item is retried
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// PolicyFor builds the retry policy of a stage. The program's max_retries wins
+// over process_management.default_max_retries; the delays come from
+// process_management.
+func PolicyFor(cfg *config.Config, stage Stage) RetryPolicy {
+	policy := RetryPolicy{
+		MaxRetries: cfg.ProcessManagement.DefaultMaxRetries,
+		BaseDelay:  cfg.ProcessManagement.RetryBaseDelay,
+		MaxDelay:   cfg.ProcessManagement.DefaultRetryWaitTime,
+	}
+
+	if program, ok := programFor(cfg, stage); ok && program.MaxRetries > 0 {
+		policy.MaxRetries = program.MaxRetries
+	}
+
+	if policy.MaxRetries <= 0 {
+		policy.MaxRetries = DefaultMaxRetries
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = DefaultRetryBaseDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = DefaultRetryMaxDelay
+	}
+	if policy.MaxDelay < policy.BaseDelay {
+		policy.MaxDelay = policy.BaseDelay
+	}
+	return policy
+}
+
+// programFor returns the programs block that configures a stage
+func programFor(cfg *config.Config, stage Stage) (config.ProgramStatus, bool) {
+	return cfg.Programs.Program(string(stage))
+}
+
+// Backoff returns how long to wait before the next attempt after the given
+// number of failed attempts. The delay doubles with every attempt up to
+// MaxDelay, and a random half of it is jittered so items that failed together
+// do not all come back at once.
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// Retrier records stage failures and either schedules another attempt or
+// gives up on the item
+type Retrier struct {
+	db       RetryStore
+	cfg      *config.Config
+	machine  *Machine
+	log      *logger.Logger
+	notifier *notify.Dispatcher
+}
+
+func NewRetrier(cfg *config.Config, db RetryStore) *Retrier {
+	// The database also names the item in failure notifications
+	items, _ := db.(notify.ItemStore)
+	return &Retrier{
+		db:       db,
+		cfg:      cfg,
+		machine:  New(db),
+		log:      logger.New(),
+		notifier: notify.New(cfg, items),
+	}
+}
+
+// Fail handles a failed attempt of an item in the stage's working state. The
+// item goes back to the stage's queue with a backoff until the stage's max
+// retries are used up, after which it moves to the failed state.
+func (r *Retrier) Fail(itemID int, stage Stage, cause error) error {
+	_, err := r.FailAttempt(itemID, stage, cause)
+	return err
+}
+
+// FailAttempt is Fail that also returns which attempt failed when the item
+// went back to the queue for another one, and 0 when it did not
+func (r *Retrier) FailAttempt(itemID int, stage Stage, cause error) (int, error) {
+	policy := PolicyFor(r.cfg, stage)
+
+	attempts, err := r.db.RecordItemFailure(itemID, string(stage), cause.Error())
+	if err != nil {
+		// No attempt was counted, so the item is not given up on. It goes back
+		// to the queue, or is requeued by the reconciler if that fails too.
+		err = fmt.Errorf("failed to record failure of item %d in %s: %v", itemID, stage, err)
+		reason := fmt.Sprintf("attempt failed and could not be counted: %v", cause)
+		if terr := r.machine.Transition(itemID, stage.WorkingState(), stage.PendingState(), reason); terr != nil {
+			r.log.Error("Retrier", "Fail", fmt.Sprintf("Failed to requeue item %d in %s: %v", itemID, stage, terr))
+		}
+		return 0, err
+	}
+
+	if attempts >= policy.MaxRetries {
+		return 0, r.giveUp(itemID, stage, fmt.Sprintf("giving up after %d attempts: %v", attempts, cause))
+	}
+
+	next := time.Now().Add(policy.Backoff(attempts))
+	if err := r.db.SetItemNextAttempt(itemID, string(stage), next); err != nil {
+		r.log.Error("Retrier", "Fail", fmt.Sprintf("Failed to schedule retry of item %d in %s: %v", itemID, stage, err))
+	}
+
+	reason := fmt.Sprintf("attempt %d/%d failed, retrying after %s: %v", attempts, policy.MaxRetries, next.Format(time.RFC3339), cause)
+	if err := r.machine.Transition(itemID, stage.WorkingState(), stage.PendingState(), reason); err != nil {
+		return 0, err
+	}
+	return attempts, nil
+}
+
+// giveUp moves an item to the stage's failed state and notifies about it
+func (r *Retrier) giveUp(itemID int, stage Stage, reason string) error {
+	if err := r.machine.Transition(itemID, stage.WorkingState(), stage.FailedState(), reason); err != nil {
+		return err
+	}
+	r.notifier.Send(notify.Event{Type: config.NotifyFailed, ItemID: itemID, Stage: string(stage), Reason: reason})
+	return nil
+}
+
+// Succeed forgets the retry state of an item once a stage has finished it
+func (r *Retrier) Succeed(itemID int, stage Stage) {
+	if err := r.db.ClearItemRetry(itemID, string(stage)); err != nil {
+		r.log.Warning("Retrier", "Succeed", fmt.Sprintf("Failed to clear retries of item %d in %s: %v", itemID, stage, err))
+	}
+}
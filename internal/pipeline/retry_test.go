@@ -0,0 +1,38 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 5, BaseDelay: time.Minute, MaxDelay: time.Hour}
+	tests := []struct {
+		attempts int
+		max      time.Duration
+	}{
+		{0, time.Minute},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{6, 32 * time.Minute},
+		{7, time.Hour},
+		{1000, time.Hour},
+	}
+	for _, tt := range tests {
+		// The jitter is random, so every delay must land in the upper half
+		for i := 0; i < 100; i++ {
+			got := policy.Backoff(tt.attempts)
+			if got < tt.max/2 || got > tt.max {
+				t.Fatalf("Backoff(%d) = %s, want between %s and %s", tt.attempts, got, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+func TestRetryPolicyBackoffWithoutDelay(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 3}
+	if got := policy.Backoff(2); got != 0 {
+		t.Errorf("Backoff(2) = %s, want 0", got)
+	}
+}
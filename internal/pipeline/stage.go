@@ -0,0 +1,63 @@
+package pipeline
+
+// Stage names a processor that moves items through the pipeline. The values
+// match the names used in RunManager and for the stage binaries.
+type Stage string
+
+const (
+	StageIndexer        Stage = "tmdb_indexer"
+	StageLibraryMatcher Stage = "librarymatcher"
+	StageScraper        Stage = "scraper"
+	StageDownloader     Stage = "downloader"
+	StageSymlinker      Stage = "symlinker"
+)
+
+type stageStates struct {
+	pending State
+	working State
+	failed  State
+}
+
+var stageTable = map[Stage]stageStates{
+	StageIndexer:        {StateIndexingPending, StateIndexing, StateIndexingFailed},
+	StageLibraryMatcher: {StateLibraryMatchPending, StateMatching, StateMatchFailed},
+	StageScraper:        {StateScrapePending, StateScraping, StateScrapeFailed},
+	StageDownloader:     {StateDownloadPending, StateDownloading, StateDownloadFailed},
+	StageSymlinker:      {StateSymlinkPending, StateSymlinking, StateSymlinkFailed},
+}
+
+// Stages returns the item stages in processing order.
+func Stages() []Stage {
+	return []Stage{StageIndexer, StageLibraryMatcher, StageScraper, StageDownloader, StageSymlinker}
+}
+
+// Valid reports whether s is a known stage.
+func (s Stage) Valid() bool {
+	_, ok := stageTable[s]
+	return ok
+}
+
+// PendingState is the state items wait in until the stage picks them up.
+func (s Stage) PendingState() State {
+	return stageTable[s].pending
+}
+
+// WorkingState is the state an item is in while the stage works on it.
+func (s Stage) WorkingState() State {
+	return stageTable[s].working
+}
+
+// FailedState is the state an item ends up in when the stage gives up on it.
+func (s Stage) FailedState() State {
+	return stageTable[s].failed
+}
+
+// StageFor returns the stage that owns state, if any.
+func StageFor(state State) (Stage, bool) {
+	for stage, states := range stageTable {
+		if state == states.pending || state == states.working || state == states.failed {
+			return stage, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,152 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"mye-r/internal/config"
+	"mye-r/internal/logger"
+)
+
+// DefaultSweepInterval is used when process_management.sweep_interval is not set
+const DefaultSweepInterval = time.Minute
+
+// channelPrefix is prepended to the pending step to form a stage's channel.
+// The notify_pending_step trigger in init.sql uses the same naming.
+const channelPrefix = "mye_r_"
+
+// ListenStore is the part of the database wakeups need
+type ListenStore interface {
+	NewListener(channels ...string) (*pq.Listener, error)
+}
+
+// Channel is the NOTIFY channel an item lands on when it enters the stage's
+// pending state
+func (s Stage) Channel() string {
+	return channelPrefix + string(s.PendingState())
+}
+
+// Wakeups turns notifications on the stage channels into per-stage wakeups.
+// Wakeups that arrive while nobody is waiting are merged into one, so a burst
+// of new items costs a single queue read.
+type Wakeups struct {
+	listener *pq.Listener
+	stages   map[Stage]chan struct{}
+	log      *logger.Logger
+}
+
+// Listen starts listening for new work on the given stages
+func Listen(db ListenStore, stages ...Stage) (*Wakeups, error) {
+	w := &Wakeups{
+		stages: make(map[Stage]chan struct{}),
+		log:    logger.New(),
+	}
+
+	channels := make([]string, 0, len(stages))
+	for _, stage := range stages {
+		if !stage.Valid() {
+			return nil, fmt.Errorf("unknown pipeline stage: %s", stage)
+		}
+		w.stages[stage] = make(chan struct{}, 1)
+		channels = append(channels, stage.Channel())
+	}
+
+	listener, err := db.NewListener(channels...)
+	if err != nil {
+		return nil, err
+	}
+	w.listener = listener
+
+	go w.run()
+	return w, nil
+}
+
+func (w *Wakeups) run() {
+	for n := range w.listener.Notify {
+		if n == nil {
+			// The connection was re-established and notifications may have
+			// been missed, so every stage has a look at its queue
+			w.log.Info("Wakeups", "run", "Listener reconnected, waking all stages")
+			for stage := range w.stages {
+				w.wake(stage)
+			}
+			continue
+		}
+
+		for stage := range w.stages {
+			if n.Channel == stage.Channel() {
+				w.wake(stage)
+			}
+		}
+	}
+}
+
+func (w *Wakeups) wake(stage Stage) {
+	select {
+	case w.stages[stage] <- struct{}{}:
+	default:
+		// A wakeup is already waiting
+	}
+}
+
+// Wait blocks until the stage is notified of new work or timeout passes. It
+// returns false when ctx is done. A nil Wakeups only waits for the timeout,
+// so callers can fall back to polling when listening is not possible.
+func (w *Wakeups) Wait(ctx context.Context, stage Stage, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var wake chan struct{}
+	if w != nil {
+		wake = w.stages[stage]
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-wake:
+		return true
+	case <-timer.C:
+		return true
+	}
+}
+
+// Close stops listening
+func (w *Wakeups) Close() error {
+	if w == nil {
+		return nil
+	}
+	return w.listener.Close()
+}
+
+// SweepInterval is how often stages look at their queue without being
+// notified. It is a safety net for notifications that were missed.
+func SweepInterval(cfg *config.Config) time.Duration {
+	if cfg.ProcessManagement.SweepInterval > 0 {
+		return cfg.ProcessManagement.SweepInterval
+	}
+	return DefaultSweepInterval
+}
+
+// CheckInterval is how often a stage looks at its queue without being
+// notified: the program's check_interval, or the sweep interval.
+func CheckInterval(cfg *config.Config, stage Stage) time.Duration {
+	if program, ok := programFor(cfg, stage); ok && program.CheckInterval > 0 {
+		return program.CheckInterval
+	}
+	return SweepInterval(cfg)
+}
+
+// ListenOrPoll starts wakeups for one stage. When listening is not possible
+// it logs why and returns nil, which makes Wait fall back to polling.
+func ListenOrPoll(db ListenStore, stage Stage) *Wakeups {
+	w, err := Listen(db, stage)
+	if err != nil {
+		logger.New().Warning("Wakeups", "Listen", fmt.Sprintf("Falling back to polling for %s: %v", stage, err))
+		return nil
+	}
+	return w
+}
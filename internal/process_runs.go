@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"mye-r/internal/database"
+	"mye-r/internal/logger"
+	"mye-r/internal/metrics"
+	"mye-r/internal/pipeline"
+)
+
+// Item outcomes recorded in process_run_items
+const (
+	OutcomeSuccess = "success"
+	OutcomeSkipped = "skipped" // another worker had the item, or it had moved on
+	OutcomeFailed  = "failed"
+)
+
+// Run statuses recorded in process_runs
+const (
+	RunStatusRunning   = "running"
+	RunStatusSucceeded = "succeeded"
+	RunStatusFailed    = "failed"
+)
+
+// maxRunOutput caps how much command output is kept with a run
+const maxRunOutput = 64 * 1024
+
+// Outcome classifies the error a stage returned for an item
+func Outcome(err error) string {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, pipeline.ErrStateMismatch), errors.Is(err, pipeline.ErrLeased):
+		return OutcomeSkipped
+	default:
+		return OutcomeFailed
+	}
+}
+
+// RunRecorder writes one process run and the outcomes of its items. Failing
+// to record is logged but never stops the run itself.
+type RunRecorder struct {
+	db     *database.DB
+	id     int
+	log    *logger.Logger
+	mu     sync.Mutex
+	failed int
+}
+
+// StartRun records the start of a stage run on the given items
+func StartRun(db *database.DB, stage, mode string, itemIDs []int) *RunRecorder {
+	r := &RunRecorder{db: db, log: logger.New()}
+	id, err := db.CreateProcessRun(stage, mode, itemIDs)
+	if err != nil {
+		r.log.Error("RunRecorder", "StartRun", fmt.Sprintf("Failed to record %s run: %v", stage, err))
+		return r
+	}
+	r.id = id
+	return r
+}
+
+// ResumeRun records into a run that was started by another process, like the
+// RunManager that started a stage command
+func ResumeRun(db *database.DB, runID int) *RunRecorder {
+	return &RunRecorder{db: db, id: runID, log: logger.New()}
+}
+
+// ID returns the run ID, or 0 if the run could not be recorded
+func (r *RunRecorder) ID() int {
+	return r.id
+}
+
+// Record stores the outcome of one item
+func (r *RunRecorder) Record(result ItemResult) {
+	outcome := Outcome(result.Err)
+	errText := ""
+	if result.Err != nil {
+		errText = result.Err.Error()
+	}
+
+	r.mu.Lock()
+	if outcome == OutcomeFailed {
+		r.failed++
+	}
+	r.mu.Unlock()
+	metrics.StageDuration.Observe(result.Duration.Seconds(), result.Stage, outcome)
+
+	if r.id == 0 {
+		return
+	}
+	if err := r.db.RecordProcessRunItem(r.id, result.ItemID, outcome, errText, result.Duration); err != nil {
+		r.log.Error("RunRecorder", "Record", fmt.Sprintf("Failed to record item %d of run %d: %v", result.ItemID, r.id, err))
+	}
+}
+
+// Failed returns how many recorded items failed
+func (r *RunRecorder) Failed() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failed
+}
+
+// Finish records how the run ended. exitCode is nil for in-process runs.
+func (r *RunRecorder) Finish(status string, exitCode *int, runErr error, output string) {
+	if r.id == 0 {
+		return
+	}
+
+	errText := ""
+	if runErr != nil {
+		errText = runErr.Error()
+	}
+	if len(output) > maxRunOutput {
+		// The end of the output is where the error usually is
+		output = output[len(output)-maxRunOutput:]
+	}
+
+	if err := r.db.FinishProcessRun(r.id, status, exitCode, errText, output); err != nil {
+		r.log.Error("RunRecorder", "Finish", fmt.Sprintf("Failed to finish run %d: %v", r.id, err))
+	}
+}
@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"mye-r/internal/config"
+	"mye-r/internal/database"
+	"mye-r/internal/logger"
+	"mye-r/internal/pipeline"
+)
+
+// DefaultStaleTimeout is used when general.timeout is not set
+const DefaultStaleTimeout = time.Hour
+
+// Recoverer is implemented by stages whose work has effects outside the
+// database. It works out where an item left in the stage's working state by
+// a crashed worker belongs; other stages just start the item over.
+type Recoverer interface {
+	Recover(item *database.WatchlistItem) (pipeline.State, string, error)
+}
+
+// ResultRecoverer settles a scrape result left in downloading
+type ResultRecoverer interface {
+	RecoverResult(result *database.ScrapeResult) error
+}
+
+// Reconciler moves items and scrape results that were left in flight by a
+// crashed or restarted worker to where their real state says they belong
+type Reconciler struct {
+	db         *database.DB
+	log        *logger.Logger
+	leaser     *pipeline.Leaser
+	machine    *pipeline.Machine
+	timeout    time.Duration
+	recoverers map[pipeline.Stage]Recoverer
+	results    ResultRecoverer
+}
+
+func NewReconciler(cfg *config.Config, db *database.DB) *Reconciler {
+	return &Reconciler{
+		db:         db,
+		log:        logger.New(),
+		leaser:     pipeline.NewLeaser(db, cfg.ProcessManagement.LeaseTTL),
+		machine:    pipeline.New(db),
+		timeout:    StaleTimeout(cfg),
+		recoverers: make(map[pipeline.Stage]Recoverer),
+	}
+}
+
+// StaleTimeout is how long an item may sit in a working state untouched
+// before it is considered abandoned
+func StaleTimeout(cfg *config.Config) time.Duration {
+	if cfg.General.Timeout > 0 {
+		return time.Duration(cfg.General.Timeout) * time.Second
+	}
+	return DefaultStaleTimeout
+}
+
+// Register lets the reconciler ask a stage's process about abandoned work,
+// if it knows how to answer
+func (r *Reconciler) Register(stage pipeline.Stage, process Process) {
+	if rec, ok := process.(Recoverer); ok {
+		r.recoverers[stage] = rec
+	}
+	if rec, ok := process.(ResultRecoverer); ok {
+		r.results = rec
+	}
+}
+
+// Run makes one reconciliation pass over every stage
+func (r *Reconciler) Run() {
+	for _, stage := range pipeline.Stages() {
+		itemIDs, err := r.db.GetStaleItems(string(stage), string(stage.WorkingState()), r.timeout)
+		if err != nil {
+			r.log.Error("Reconciler", "Run", fmt.Sprintf("Failed to find stale %s items: %v", stage, err))
+			continue
+		}
+		for _, itemID := range itemIDs {
+			err := r.leaser.Run(itemID, stage, func(ctx context.Context) error {
+				return r.recoverItem(ctx, itemID, stage)
+			})
+			if err != nil && !errors.Is(err, pipeline.ErrLeased) && !errors.Is(err, pipeline.ErrStateMismatch) {
+				r.log.Error("Reconciler", "Run", fmt.Sprintf("Failed to recover item %d from %s: %v", itemID, stage.WorkingState(), err))
+			}
+		}
+	}
+
+	r.recoverResults()
+	r.abandonRuns()
+}
+
+// abandonRuns closes the process runs a crashed or restarted process left
+// running, so run history does not show them as still going
+func (r *Reconciler) abandonRuns() {
+	abandoned, err := r.db.AbandonProcessRuns(r.timeout)
+	if err != nil {
+		r.log.Error("Reconciler", "abandonRuns", err.Error())
+		return
+	}
+	if abandoned > 0 {
+		r.log.Warning("Reconciler", "abandonRuns", fmt.Sprintf("Marked %d process runs that stopped reporting as failed", abandoned))
+	}
+}
+
+// recoverItem moves one abandoned item on. The caller holds its lease.
+func (r *Reconciler) recoverItem(ctx context.Context, itemID int, stage pipeline.Stage) error {
+	to, reason := stage.PendingState(), "abandoned, starting over"
+	if rec, ok := r.recoverers[stage]; ok {
+		item, err := r.db.GetWatchlistItem(itemID)
+		if err != nil {
+			return fmt.Errorf("failed to get item: %v", err)
+		}
+		if to, reason, err = rec.Recover(item); err != nil {
+			// Leave it for the next pass rather than guess
+			return err
+		}
+	}
+	if err := pipeline.LeaseLost(ctx); err != nil {
+		return err
+	}
+	return r.machine.Transition(itemID, stage.WorkingState(), to, "recovered: "+reason)
+}
+
+// recoverResults settles scrape results stuck in downloading whose item has
+// already moved on
+func (r *Reconciler) recoverResults() {
+	if r.results == nil {
+		return
+	}
+
+	results, err := r.db.GetStaleScrapeResults("downloading", r.timeout)
+	if err != nil {
+		r.log.Error("Reconciler", "recoverResults", err.Error())
+		return
+	}
+	for i := range results {
+		if err := r.results.RecoverResult(&results[i]); err != nil {
+			r.log.Error("Reconciler", "recoverResults", fmt.Sprintf("Failed to recover scrape result %d: %v", results[i].ID, err))
+		}
+	}
+}
@@ -6,14 +6,15 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
-	"runtime"
+	"strconv"
 	"sync"
 	"time"
 
 	"mye-r/internal/config"
 	"mye-r/internal/database"
 	"mye-r/internal/logger"
+	"mye-r/internal/metrics"
+	"mye-r/internal/pipeline"
 
 	"os/exec"
 )
@@ -26,22 +27,35 @@ type Process interface {
 }
 
 type RunManager struct {
-	processes map[string]*ProcessInfo
-	db        *database.DB
-	log       *logger.Logger
-	ctx       context.Context
-	mutex     sync.Mutex
-	cfg       *config.Config
-	binaries  map[string]string // Cache for compiled binaries
+	processes  map[string]*ProcessInfo
+	db         *database.DB
+	log        *logger.Logger
+	ctx        context.Context
+	mutex      sync.Mutex
+	cfg        *config.Config
+	executable string // mye-r binary that runs stages in exec mode
+	configFile string // passed on to stage commands
+	envFile    string
+	pools      map[string]*WorkerPool // Worker pools per stage in inprocess mode
+	reconciler *Reconciler
+	wakeups    *pipeline.Wakeups // nil when LISTEN is not available
+	slots      *stageSlots       // limits how many stages run at once
 }
 
+// pollInterval is how often stages look at their queue when notifications
+// can not be received
+const pollInterval = 5 * time.Second
+
 func NewRunManager(cfg *config.Config, db *database.DB) *RunManager {
 	return &RunManager{
-		processes: make(map[string]*ProcessInfo),
-		db:        db,
-		log:       logger.New(),
-		cfg:       cfg,
-		binaries:  make(map[string]string),
+		processes:  make(map[string]*ProcessInfo),
+		db:         db,
+		log:        logger.New(),
+		cfg:        cfg,
+		configFile: "config.yaml",
+		envFile:    ".env",
+		pools:      make(map[string]*WorkerPool),
+		reconciler: NewReconciler(cfg, db),
 	}
 }
 
@@ -56,23 +70,53 @@ func (rm *RunManager) Start(ctx context.Context) error {
 	rm.ctx = ctx
 	rm.log.Info("RunManager", "Start", "Starting RunManager")
 
-	// Build all binaries at startup
-	if err := rm.buildBinaries(); err != nil {
-		return fmt.Errorf("failed to build binaries: %v", err)
+	if rm.inProcess() {
+		// Call the stages directly instead of exec'ing stage commands
+		if err := rm.startWorkerPools(ctx); err != nil {
+			return fmt.Errorf("failed to start worker pools: %v", err)
+		}
+	} else {
+		// Stages run as subcommands of this binary
+		if err := rm.findExecutable(); err != nil {
+			return fmt.Errorf("failed to find executable: %v", err)
+		}
+	}
+
+	// The content fetcher has no item queue, so it is started directly
+	rm.startFetchers(ctx)
+
+	// Settle whatever the last run left in flight before the stages start
+	for name, proc := range rm.processes {
+		rm.reconciler.Register(pipeline.Stage(name), proc.Process)
 	}
+	rm.reconciler.Run()
 
 	// Initial queue status check
 	rm.logQueueStatus()
 
-	// Start the main processing loop
+	// Stages are woken by the NOTIFY sent when an item enters their queue
+	wakeups, err := pipeline.Listen(rm.db, pipeline.Stages()...)
+	if err != nil {
+		rm.log.Warning("RunManager", "Start", fmt.Sprintf("Failed to listen for stage notifications, polling every %v: %v", pollInterval, err))
+	}
+	rm.wakeups = wakeups
+
+	rm.slots = newStageSlots(rm.maxParallelStages())
+	for _, stage := range stagesByPriority(rm.cfg) {
+		go rm.runStageLoop(ctx, stage)
+	}
+
+	// The sweep requeues abandoned items and catches anything a notification
+	// missed, e.g. items whose retry became due
 	go func() {
+		ticker := time.NewTicker(pipeline.SweepInterval(rm.cfg))
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			default:
+			case <-ticker.C:
 				rm.checkAndRunProcesses()
-				time.Sleep(5 * time.Second)
 			}
 		}
 	}()
@@ -80,228 +124,370 @@ func (rm *RunManager) Start(ctx context.Context) error {
 	return nil
 }
 
+// runStageLoop runs a stage whenever it is woken up, or at its
+// check_interval
+func (rm *RunManager) runStageLoop(ctx context.Context, stage pipeline.Stage) {
+	interval := rm.checkIntervalFor(stage)
+	rm.log.Debug("RunManager", string(stage), fmt.Sprintf("Checking queue every %v", interval))
+
+	for {
+		rm.runStage(stage)
+		if !rm.wakeups.Wait(ctx, stage, interval) {
+			return
+		}
+	}
+}
+
+// checkIntervalFor returns how often a stage looks at its queue when it is not
+// notified of new items
+func (rm *RunManager) checkIntervalFor(stage pipeline.Stage) time.Duration {
+	if program, ok := rm.cfg.Programs.Program(string(stage)); ok && program.CheckInterval > 0 {
+		return program.CheckInterval
+	}
+	if rm.wakeups == nil {
+		return pollInterval
+	}
+	return pipeline.SweepInterval(rm.cfg)
+}
+
+// maxParallelStages returns how many stages may run at once. Stage commands
+// run one at a time by default, like they always did; in-process stages share
+// the worker pools and all run side by side.
+func (rm *RunManager) maxParallelStages() int {
+	if rm.cfg.ProcessManagement.MaxParallelStages > 0 {
+		return rm.cfg.ProcessManagement.MaxParallelStages
+	}
+	if rm.inProcess() {
+		return len(pipeline.Stages())
+	}
+	return 1
+}
+
 func (rm *RunManager) logQueueStatus() {
-	itemsByProcess := rm.getAllItemsToProcess()
+	queues, _, err := pipeline.Queues(rm.db)
+	if err != nil {
+		rm.log.Error("RunManager", "Status", fmt.Sprintf("Failed to count queued items: %v", err))
+		return
+	}
 
-	// Only log status if there are items to process
+	ready := make(map[pipeline.Stage]int, len(queues))
 	hasItems := false
-	for _, items := range itemsByProcess {
-		if len(items) > 0 {
-			hasItems = true
-			break
-		}
+	for _, queue := range queues {
+		ready[queue.Stage] = queue.Ready
+		rm.log.Debug("RunManager", "Status", fmt.Sprintf("Found %d items in %s", queue.Ready, queue.Stage.PendingState()))
+		hasItems = hasItems || queue.Ready > 0
 	}
 
+	// Only log status if there are items to process
 	if hasItems {
 		rm.log.Info("RunManager", "Status", "=== Current Processing Queue ===")
-		for _, name := range []string{"getcontent", "tmdb_indexer", "librarymatcher", "scraper", "downloader", "symlinker"} {
-			if items, exists := itemsByProcess[name]; exists {
-				if len(items) > 0 {
-					rm.log.Info("RunManager", "Status", fmt.Sprintf("%s: %d items pending", name, len(items)))
-				}
+		for _, stage := range stagesByPriority(rm.cfg) {
+			if ready[stage] > 0 {
+				rm.log.Info("RunManager", "Status", fmt.Sprintf("%s: %d items pending", stage, ready[stage]))
 			}
 		}
 		rm.log.Info("RunManager", "Status", "===============================")
 	}
 }
 
-func (rm *RunManager) buildBinaries() error {
-	cwd, err := os.Getwd()
+// StageCommands maps every pipeline stage to the mye-r subcommand that runs it
+var StageCommands = map[pipeline.Stage]string{
+	pipeline.StageIndexer:        "index",
+	pipeline.StageLibraryMatcher: "match",
+	pipeline.StageScraper:        "scrape",
+	pipeline.StageDownloader:     "download",
+	pipeline.StageSymlinker:      "symlink",
+}
+
+// UseFiles sets the config and env files stage commands are started with
+func (rm *RunManager) UseFiles(configFile, envFile string) {
+	rm.configFile = configFile
+	rm.envFile = envFile
+}
+
+// findExecutable looks up the running binary, which runs the stages in exec mode
+func (rm *RunManager) findExecutable() error {
+	path, err := os.Executable()
 	if err != nil {
-		return fmt.Errorf("failed to get working directory: %v", err)
+		return err
 	}
+	rm.executable = path
+	rm.log.Info("RunManager", "findExecutable", fmt.Sprintf("Running stages with %s", path))
+	return nil
+}
 
-	processOrder := []string{
-		"getcontent",
-		"tmdb_indexer",
-		"librarymatcher",
-		"scraper",
-		"downloader",
-		"symlinker",
+// checkAndRunProcesses is the safety-net sweep. It reconciles items left
+// behind by crashed workers; the stage loops pick them up on their next round.
+func (rm *RunManager) checkAndRunProcesses() {
+	rm.reconciler.Run()
+	rm.logQueueStatus()
+}
+
+// runStage works through the items currently queued for a stage
+func (rm *RunManager) runStage(stage pipeline.Stage) {
+	name := string(stage)
+	items, err := rm.db.GetQueuedItems(name, string(stage.PendingState()))
+	if err != nil {
+		rm.log.Error("RunManager", name, fmt.Sprintf("Failed to get queued items: %v", err))
+		return
+	}
+	if len(items) == 0 {
+		return
+	}
+	if !rm.isProcessEnabled(name) {
+		rm.log.Debug("RunManager", name, fmt.Sprintf("Process is disabled, skipping %d items", len(items)))
+		return
 	}
 
-	for _, name := range processOrder {
-		binPath := filepath.Join(cwd, name)
-		if runtime.GOOS == "windows" {
-			binPath += ".exe"
-		}
+	// Wait for our turn; busy stages with a better priority go first
+	if !rm.slots.acquire(rm.ctx, priorityOf(rm.cfg, name)) {
+		return
+	}
+	defer rm.slots.release()
 
-		// Check if binary exists
-		if _, err := os.Stat(binPath); err != nil {
-			return fmt.Errorf("binary not found for %s: %v", name, err)
+	if rm.inProcess() {
+		rm.dispatchStage(name, items)
+	} else {
+		rm.execStage(name, items)
+	}
+}
+
+// execStage runs the stage command on the items in batches. Up to the stage's
+// concurrency batches run at the same time.
+func (rm *RunManager) execStage(name string, items []*database.WatchlistItem) {
+	// Process items in smaller batches
+	batchSize := rm.batchSizeFor(name)
+	running := make(chan struct{}, rm.concurrencyFor(name))
+	var wg sync.WaitGroup
+
+	for i := 0; i < len(items); i += batchSize {
+		end := i + batchSize
+		if end > len(items) {
+			end = len(items)
 		}
 
-		rm.binaries[name] = binPath
-		rm.log.Info("RunManager", "Build", fmt.Sprintf("Found binary for %s", name))
+		rm.log.Info("RunManager", name, fmt.Sprintf("Starting %s processor for batch %d-%d of %d items",
+			name, i+1, end, len(items)))
+
+		running <- struct{}{}
+		wg.Add(1)
+		go func(batch []*database.WatchlistItem) {
+			defer wg.Done()
+			defer func() { <-running }()
+			rm.execBatch(name, batch)
+		}(items[i:end])
+
+		// Small delay between batches to prevent resource exhaustion
+		time.Sleep(500 * time.Millisecond)
 	}
 
-	return nil
+	wg.Wait()
 }
 
-func (rm *RunManager) checkAndRunProcesses() {
-	itemsByProcess := rm.getAllItemsToProcess()
-    
-    processOrder := []string{
-        "getcontent",
-        "tmdb_indexer",
-        "librarymatcher",
-        "scraper",
-        "downloader",
-        "symlinker",
-    }
-
-    // Get working directory once
-    cwd, err := os.Getwd()
-    if err != nil {
-        rm.log.Error("RunManager", "checkAndRunProcesses", fmt.Sprintf("Failed to get working directory: %v", err))
-        return
-    }
-
-    // Get config file path
-    configPath := filepath.Join(cwd, "config.yaml")
-    envPath := filepath.Join(cwd, ".env")
-
-    for _, name := range processOrder {
-        if items, exists := itemsByProcess[name]; exists && len(items) > 0 {
-            if !rm.isProcessEnabled(name) {
-                rm.log.Debug("RunManager", name, fmt.Sprintf("Process is disabled, skipping %d items", len(items)))
-                continue
-            }
-
-            // Process items in smaller batches
-            batchSize := 10
-            if name == "librarymatcher" {
-                batchSize = 20 // Library matcher can handle more items
-            }
-
-            for i := 0; i < len(items); i += batchSize {
-                end := i + batchSize
-                if end > len(items) {
-                    end = len(items)
-                }
-                batch := items[i:end]
-
-                rm.log.Info("RunManager", name, fmt.Sprintf("Starting %s processor for batch %d-%d of %d items", 
-                    name, i+1, end, len(items)))
-
-                // Create a temporary file with the item IDs
-                tempFile, err := os.CreateTemp("", "items_*.json")
-                if err != nil {
-                    rm.log.Error("RunManager", name, fmt.Sprintf("Failed to create temp file: %v", err))
-                    continue
-                }
-                defer os.Remove(tempFile.Name())
-
-                // Write item IDs to temp file
-                itemIDs := make([]int, len(batch))
-                for j, item := range batch {
-                    itemIDs[j] = item.ID
-                    rm.log.Info("RunManager", name, fmt.Sprintf("Processing item %d: %s", item.ID, item.Title))
-                }
-
-                if err := json.NewEncoder(tempFile).Encode(itemIDs); err != nil {
-                    rm.log.Error("RunManager", name, fmt.Sprintf("Failed to write to temp file: %v", err))
-                    continue
-                }
-                tempFile.Close()
-
-                // Run the pre-built binary
-                binPath, exists := rm.binaries[name]
-                if !exists {
-                    rm.log.Error("RunManager", name, "Binary not found")
-                    continue
-                }
-
-                cmd := exec.Command(binPath, 
-                    "--items", tempFile.Name(),
-                    "--config", configPath,
-                    "--env", envPath)
-                cmd.Dir = filepath.Dir(binPath)
-                cmd.Env = os.Environ()
-
-                output, err := cmd.CombinedOutput()
-                if err != nil {
-                    rm.log.Error("RunManager", name, fmt.Sprintf("Process failed for items: %v", itemIDs))
-                    rm.log.Error("RunManager", name, fmt.Sprintf("Error: %v", err))
-                    if len(output) > 0 {
-                        rm.log.Error("RunManager", name, fmt.Sprintf("Output: %s", string(output)))
-                    }
-                    continue
-                }
-
-                if len(output) > 0 {
-                    rm.log.Debug("RunManager", name, fmt.Sprintf("Process output:\n%s", string(output)))
-                }
-                rm.log.Info("RunManager", name, fmt.Sprintf("Completed processing batch of %d items", len(batch)))
-
-                // Log updated queue status after each batch
-                rm.logQueueStatus()
-
-                // Small delay between batches to prevent resource exhaustion
-                time.Sleep(500 * time.Millisecond)
-            }
-        }
-    }
-}
+// execBatch runs the stage command once for a batch of items
+func (rm *RunManager) execBatch(name string, batch []*database.WatchlistItem) {
+	// Create a temporary file with the item IDs
+	tempFile, err := os.CreateTemp("", "items_*.json")
+	if err != nil {
+		rm.log.Error("RunManager", name, fmt.Sprintf("Failed to create temp file: %v", err))
+		return
+	}
+	defer os.Remove(tempFile.Name())
 
-func (rm *RunManager) getAllItemsToProcess() map[string][]*database.WatchlistItem {
-	items := make(map[string][]*database.WatchlistItem)
+	// Write item IDs to temp file
+	itemIDs := make([]int, len(batch))
+	for j, item := range batch {
+		itemIDs[j] = item.ID
+		rm.log.Info("RunManager", name, fmt.Sprintf("Processing item %d: %s", item.ID, item.Title))
+	}
 
-	// Get items for content fetcher
-	newItems, err := rm.db.GetItemsByStatus("new")
-	if err != nil {
-		rm.log.Error("RunManager", "GetItems", fmt.Sprintf("Failed to get new items: %v", err))
-	} else if len(newItems) > 0 {
-		items["getcontent"] = newItems
-		rm.log.Debug("RunManager", "GetItems", fmt.Sprintf("Found %d new items for content fetcher", len(newItems)))
+	if err := json.NewEncoder(tempFile).Encode(itemIDs); err != nil {
+		rm.log.Error("RunManager", name, fmt.Sprintf("Failed to write to temp file: %v", err))
+		tempFile.Close()
+		return
 	}
+	tempFile.Close()
 
-	// Get items for TMDB indexer
-	indexingItems, err := rm.db.GetItemsByStatus("indexing_pending")
-	if err != nil {
-		rm.log.Error("RunManager", "GetItems", fmt.Sprintf("Failed to get items for indexing: %v", err))
-	} else if len(indexingItems) > 0 {
-		items["tmdb_indexer"] = indexingItems
-		rm.log.Debug("RunManager", "GetItems", fmt.Sprintf("Found %d items pending indexing", len(indexingItems)))
+	command, exists := StageCommands[pipeline.Stage(name)]
+	if !exists {
+		rm.log.Error("RunManager", name, "No command runs this stage")
+		return
 	}
 
-	// Get items for library matcher
-	libraryMatchItems, err := rm.db.GetItemsByStatus("librarymatch_pending")
-	if err != nil {
-		rm.log.Error("RunManager", "GetItems", fmt.Sprintf("Failed to get items for library matching: %v", err))
-	} else if len(libraryMatchItems) > 0 {
-		items["librarymatcher"] = libraryMatchItems
-		rm.log.Debug("RunManager", "GetItems", fmt.Sprintf("Found %d items pending library matching", len(libraryMatchItems)))
+	// The command records the outcome of every item under this run
+	run := StartRun(rm.db, name, config.RunModeExec, itemIDs)
+	args := []string{command,
+		"--items-file", tempFile.Name(),
+		"--config", rm.configFile,
+		"--env", rm.envFile}
+	if run.ID() != 0 {
+		args = append(args, "--run-id", strconv.Itoa(run.ID()))
 	}
 
-	// Get items for scraper
-	scrapingItems, err := rm.db.GetItemsByStatus("scraping_pending")
+	// The command hands back what it counted so /metrics covers its work
+	metricsFile, err := os.CreateTemp("", "metrics_*.json")
 	if err != nil {
-		rm.log.Error("RunManager", "GetItems", fmt.Sprintf("Failed to get items for scraping: %v", err))
-	} else if len(scrapingItems) > 0 {
-		items["scraper"] = scrapingItems
-		rm.log.Debug("RunManager", "GetItems", fmt.Sprintf("Found %d items pending scraping", len(scrapingItems)))
+		rm.log.Warning("RunManager", name, fmt.Sprintf("Failed to create metrics file: %v", err))
+	} else {
+		metricsFile.Close()
+		defer os.Remove(metricsFile.Name())
+		args = append(args, "--metrics-file", metricsFile.Name())
 	}
 
-	// Get items for downloader
-	downloadItems, err := rm.db.GetItemsByStatus("download_pending")
+	cmd := exec.Command(rm.executable, args...)
+	cmd.Env = os.Environ()
+
+	output, err := cmd.CombinedOutput()
+	exitCode := cmd.ProcessState.ExitCode()
+	if metricsFile != nil {
+		if err := metrics.Default.MergeFile(metricsFile.Name()); err != nil {
+			rm.log.Warning("RunManager", name, fmt.Sprintf("Failed to merge metrics: %v", err))
+		}
+	}
 	if err != nil {
-		rm.log.Error("RunManager", "GetItems", fmt.Sprintf("Failed to get items for download: %v", err))
-	} else if len(downloadItems) > 0 {
-		items["downloader"] = downloadItems
-		rm.log.Debug("RunManager", "GetItems", fmt.Sprintf("Found %d items pending download", len(downloadItems)))
+		rm.log.Error("RunManager", name, fmt.Sprintf("Process failed for items: %v (run %d)", itemIDs, run.ID()))
+		rm.log.Error("RunManager", name, fmt.Sprintf("Error: %v", err))
+		if len(output) > 0 {
+			rm.log.Error("RunManager", name, fmt.Sprintf("Output: %s", string(output)))
+		}
+		run.Finish(RunStatusFailed, &exitCode, err, string(output))
+		return
 	}
 
-	// Get items for symlinker
-	symlinkItems, err := rm.db.GetItemsByStatus("symlink_pending")
-	if err != nil {
-		rm.log.Error("RunManager", "GetItems", fmt.Sprintf("Failed to get items for symlinking: %v", err))
-	} else if len(symlinkItems) > 0 {
-		items["symlinker"] = symlinkItems
-		rm.log.Debug("RunManager", "GetItems", fmt.Sprintf("Found %d items pending symlinking", len(symlinkItems)))
+	if len(output) > 0 {
+		rm.log.Debug("RunManager", name, fmt.Sprintf("Process output:\n%s", string(output)))
+	}
+	run.Finish(RunStatusSucceeded, &exitCode, nil, string(output))
+	rm.log.Info("RunManager", name, fmt.Sprintf("Completed processing batch of %d items", len(batch)))
+}
+
+// batchSizeFor returns how many items of a stage are handed over at once
+func (rm *RunManager) batchSizeFor(name string) int {
+	if program, ok := rm.cfg.Programs.Program(name); ok && program.BatchSize > 0 {
+		return program.BatchSize
+	}
+	return 10
+}
+
+// concurrencyFor returns how many items of a stage are worked on at the same
+// time: workers of its pool in inprocess mode, parallel commands in exec mode
+func (rm *RunManager) concurrencyFor(name string) int {
+	if program, ok := rm.cfg.Programs.Program(name); ok && program.Concurrency > 0 {
+		return program.Concurrency
+	}
+	if !rm.inProcess() {
+		return 1
+	}
+	if rm.cfg.ProcessManagement.Workers > 0 {
+		return rm.cfg.ProcessManagement.Workers
+	}
+	return 4
+}
+
+func (rm *RunManager) inProcess() bool {
+	return rm.cfg.ProcessManagement.Mode == config.RunModeInProcess
+}
+
+// startWorkerPools creates a worker pool for every registered stage that can
+// process items in-process
+func (rm *RunManager) startWorkerPools(ctx context.Context) error {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	for _, stage := range pipeline.Stages() {
+		name := string(stage)
+		proc, exists := rm.processes[name]
+		if !exists {
+			continue
+		}
+		processor, ok := proc.Process.(ItemProcessor)
+		if !ok {
+			return fmt.Errorf("process %s cannot process items in-process", name)
+		}
+
+		pool := NewWorkerPool(name, processor, rm.concurrencyFor(name))
+		pool.Start(ctx)
+		rm.pools[name] = pool
 	}
 
-	return items
+	return nil
+}
+
+// startFetchers starts the registered processes that are not pipeline stages
+func (rm *RunManager) startFetchers(ctx context.Context) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	for name, proc := range rm.processes {
+		if pipeline.Stage(name).Valid() || !rm.isProcessEnabled(name) {
+			continue
+		}
+		rm.log.Info("RunManager", "startFetchers", fmt.Sprintf("Starting process: %s", name))
+		if err := proc.Start(ctx); err != nil {
+			rm.log.Error("RunManager", "startFetchers", fmt.Sprintf("Failed to start %s: %v", name, err))
+		}
+	}
+}
+
+// dispatchStage hands the queued items of a stage to its worker pool and
+// waits until they are done
+func (rm *RunManager) dispatchStage(name string, items []*database.WatchlistItem) {
+	pool, exists := rm.pools[name]
+	if !exists {
+		rm.log.Debug("RunManager", name, fmt.Sprintf("No worker pool registered, skipping %d items", len(items)))
+		return
+	}
+	if !pool.TryAcquire() {
+		rm.log.Debug("RunManager", name, "Worker pool is still busy with the previous batch")
+		return
+	}
+	defer pool.Release()
+
+	batchSize := rm.batchSizeFor(name)
+	for i := 0; i < len(items); i += batchSize {
+		end := i + batchSize
+		if end > len(items) {
+			end = len(items)
+		}
+
+		batch := items[i:end]
+		itemIDs := make([]int, len(batch))
+		for j, item := range batch {
+			itemIDs[j] = item.ID
+		}
+
+		rm.log.Info("RunManager", name, fmt.Sprintf("Submitting batch %d-%d of %d items", i+1, end, len(items)))
+		run := StartRun(rm.db, name, config.RunModeInProcess, itemIDs)
+		for result := range pool.Submit(rm.ctx, batch) {
+			rm.logResult(result)
+			run.Record(result)
+		}
+		failed := run.Failed()
+		rm.log.Info("RunManager", name, fmt.Sprintf("Completed batch of %d items, %d failed", end-i, failed))
+
+		if err := rm.ctx.Err(); err != nil {
+			run.Finish(RunStatusFailed, nil, err, "")
+			return
+		}
+		if failed > 0 {
+			run.Finish(RunStatusFailed, nil, fmt.Errorf("%d of %d items failed", failed, len(batch)), "")
+		} else {
+			run.Finish(RunStatusSucceeded, nil, nil, "")
+		}
+	}
+}
+
+// logResult logs the outcome of one item
+func (rm *RunManager) logResult(result ItemResult) {
+	switch Outcome(result.Err) {
+	case OutcomeSuccess:
+		rm.log.Info("RunManager", result.Stage, fmt.Sprintf("Processed item %d (%s) in %v", result.ItemID, result.Title, result.Duration))
+	case OutcomeSkipped:
+		// Another worker got to the item first
+		rm.log.Debug("RunManager", result.Stage, fmt.Sprintf("Skipped item %d: %v", result.ItemID, result.Err))
+	default:
+		rm.log.Error("RunManager", result.Stage, fmt.Sprintf("Failed item %d (%s) after %v: %v", result.ItemID, result.Title, result.Duration, result.Err))
+	}
 }
 
 func (rm *RunManager) Stop() {
@@ -311,6 +497,15 @@ func (rm *RunManager) Stop() {
 	for name, proc := range rm.processes {
 		rm.stopProcess(name, proc)
 	}
+
+	// Let in-flight items finish before returning
+	for _, pool := range rm.pools {
+		pool.Wait()
+	}
+
+	if err := rm.wakeups.Close(); err != nil {
+		rm.log.Error("RunManager", "Stop", fmt.Sprintf("Failed to stop listening: %v", err))
+	}
 }
 
 func (rm *RunManager) stopProcess(name string, proc *ProcessInfo) {
@@ -322,22 +517,14 @@ func (rm *RunManager) stopProcess(name string, proc *ProcessInfo) {
 }
 
 func (rm *RunManager) isProcessEnabled(name string) bool {
-	switch name {
-	case "getcontent":
-		return rm.cfg.Programs.ContentFetcher.Active
-	case "tmdb_indexer":
-		return rm.cfg.TMDB.Enabled
-	case "scraper":
-		return rm.cfg.Programs.Scraper.Active
-	case "downloader":
-		return rm.cfg.Programs.Downloader.Active
-	case "librarymatcher":
-		return rm.cfg.Programs.LibraryMatcher.Active
-	case "symlinker":
-		return rm.cfg.Programs.Symlinker.Active
-	default:
+	program, ok := rm.cfg.Programs.Program(name)
+	if !ok {
+		return false
+	}
+	if name == "tmdb_indexer" && !rm.cfg.TMDB.Enabled {
 		return false
 	}
+	return program.Active
 }
 
 // ProcessInfo implements the Process interface for simple process management
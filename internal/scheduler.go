@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+
+	"mye-r/internal/config"
+	"mye-r/internal/pipeline"
+)
+
+// stageSlots limits how many stages run at the same time. When stages are
+// waiting for a slot, the one with the best priority gets the next free one.
+type stageSlots struct {
+	mu      sync.Mutex
+	free    int
+	waiting []*slotWaiter
+}
+
+type slotWaiter struct {
+	priority int
+	ready    chan struct{}
+}
+
+func newStageSlots(size int) *stageSlots {
+	if size < 1 {
+		size = 1
+	}
+	return &stageSlots{free: size}
+}
+
+// acquire blocks until a slot is free. It returns false when ctx is done first.
+func (s *stageSlots) acquire(ctx context.Context, priority int) bool {
+	s.mu.Lock()
+	if s.free > 0 && len(s.waiting) == 0 {
+		s.free--
+		s.mu.Unlock()
+		return true
+	}
+
+	w := &slotWaiter{priority: priority, ready: make(chan struct{})}
+	// Keep the queue sorted; stages of equal priority are served in order
+	i := sort.Search(len(s.waiting), func(i int) bool {
+		return s.waiting[i].priority > priority
+	})
+	s.waiting = append(s.waiting, nil)
+	copy(s.waiting[i+1:], s.waiting[i:])
+	s.waiting[i] = w
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return true
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, other := range s.waiting {
+			if other == w {
+				s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+				return false
+			}
+		}
+		// The slot was handed over while ctx was cancelled, pass it on
+		s.releaseLocked()
+		return false
+	}
+}
+
+// release frees a slot, handing it to the best waiting stage if there is one
+func (s *stageSlots) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked()
+}
+
+func (s *stageSlots) releaseLocked() {
+	if len(s.waiting) == 0 {
+		s.free++
+		return
+	}
+	w := s.waiting[0]
+	s.waiting = s.waiting[1:]
+	close(w.ready)
+}
+
+// priorityOf returns the scheduling priority of a process. Lower numbers run
+// first; programs without a priority go last.
+func priorityOf(cfg *config.Config, name string) int {
+	program, ok := cfg.Programs.Program(name)
+	if !ok || program.Priority <= 0 {
+		return math.MaxInt
+	}
+	return program.Priority
+}
+
+// stagesByPriority returns the pipeline stages ordered by their configured
+// priority. Stages with the same priority keep their pipeline order.
+func stagesByPriority(cfg *config.Config) []pipeline.Stage {
+	stages := pipeline.Stages()
+	sort.SliceStable(stages, func(i, j int) bool {
+		return priorityOf(cfg, string(stages[i])) < priorityOf(cfg, string(stages[j]))
+	})
+	return stages
+}
@@ -2,7 +2,7 @@ package scraper
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
 	"sort"
 	"time"
@@ -10,6 +10,8 @@ import (
 	"mye-r/internal/config"
 	"mye-r/internal/database"
 	"mye-r/internal/logger"
+	"mye-r/internal/notify"
+	"mye-r/internal/pipeline"
 	"mye-r/internal/utils"
 )
 
@@ -23,22 +25,50 @@ type ScraperManager struct {
 	db       *database.DB
 	log      *logger.Logger
 	scrapers []Scraper
+	profiles map[string][]Scraper // scrapers scoring with each quality profile
+	pipeline *pipeline.Machine
+	leaser   *pipeline.Leaser
+	retrier  *pipeline.Retrier
+	notifier *notify.Dispatcher
 }
 
+// ErrNoStreams is returned when the scrapers found nothing for an item
+var ErrNoStreams = errors.New("no streams found")
+
 func NewScraperManager(cfg *config.Config, db *database.DB) *ScraperManager {
 	log := logger.New()
 	manager := &ScraperManager{
-		config: cfg,
-		db:     db,
-		log:    log,
+		config:   cfg,
+		db:       db,
+		log:      log,
+		pipeline: pipeline.New(db),
+		leaser:   pipeline.NewLeaser(db, cfg.ProcessManagement.LeaseTTL),
+		retrier:  pipeline.NewRetrier(cfg, db),
+		notifier: notify.New(cfg, nil),
 	}
 
-	// Initialize scrapers
+	manager.scrapers = newScrapers(cfg, db, log)
+
+	// Items routed to a quality profile are scored with it instead of
+	// ranking.scoring
+	manager.profiles = make(map[string][]Scraper)
+	for name, scoring := range cfg.Scraping.Profiles {
+		profileCfg := *cfg
+		profileCfg.Scraping.Ranking.Scoring = scoring
+		manager.profiles[name] = newScrapers(&profileCfg, db, log)
+	}
+
+	return manager
+}
+
+// newScrapers creates the enabled scrapers, sorted by priority
+func newScrapers(cfg *config.Config, db *database.DB, log *logger.Logger) []Scraper {
+	var scrapers []Scraper
 	for scraperName, scraperConfig := range cfg.Scraping.Scrapers {
 		if scraperConfig.Enabled {
 			switch scraperName {
 			case "torrentio":
-				manager.scrapers = append(manager.scrapers, NewTorrentioScraper(cfg, db, scraperName, scraperConfig))
+				scrapers = append(scrapers, NewTorrentioScraper(cfg, db, scraperName, scraperConfig))
 			// Add cases for other scrapers as they are implemented
 			default:
 				log.Warning("ScraperManager", "NewScraperManager", fmt.Sprintf("Unknown scraper type: %s", scraperName))
@@ -47,16 +77,30 @@ func NewScraperManager(cfg *config.Config, db *database.DB) *ScraperManager {
 	}
 
 	// Sort scrapers by priority
-	sort.Slice(manager.scrapers, func(i, j int) bool {
-		return manager.config.Scraping.Scrapers[manager.scrapers[i].Name()].Priority <
-			manager.config.Scraping.Scrapers[manager.scrapers[j].Name()].Priority
+	sort.Slice(scrapers, func(i, j int) bool {
+		return cfg.Scraping.Scrapers[scrapers[i].Name()].Priority <
+			cfg.Scraping.Scrapers[scrapers[j].Name()].Priority
 	})
+	return scrapers
+}
 
-	return manager
+// scrapersFor returns the scrapers of the item's quality profile, or the
+// default ones
+func (sm *ScraperManager) scrapersFor(item *database.WatchlistItem) []Scraper {
+	if item.QualityProfile.Valid && item.QualityProfile.String != "" {
+		if scrapers, ok := sm.profiles[item.QualityProfile.String]; ok {
+			return scrapers
+		}
+		sm.log.Warning("ScraperManager", "scrapersFor", fmt.Sprintf("Item %d has unknown quality profile %s, using the default scoring", item.ID, item.QualityProfile.String))
+	}
+	return sm.scrapers
 }
 
 func (sm *ScraperManager) RunScrapers(ctx context.Context) {
 	sm.log.Info("ScraperManager", "RunScrapers", "Starting scraper manager")
+	wakeups := pipeline.ListenOrPoll(sm.db, pipeline.StageScraper)
+	defer wakeups.Close()
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -64,65 +108,31 @@ func (sm *ScraperManager) RunScrapers(ctx context.Context) {
 			return
 		default:
 			sm.log.Debug("ScraperManager", "RunScrapers", "Fetching next item for scraping")
-			item, err := sm.db.GetNextItemForScraping()
+			lease, err := sm.leaser.ClaimNext(pipeline.StageScraper)
 			if err != nil {
 				sm.log.Error("ScraperManager", "RunScrapers", fmt.Sprintf("Error getting next item for scraping: %v", err))
 				time.Sleep(5 * time.Second)
 				continue
 			}
 
-			if item == nil {
+			if lease == nil {
 				sm.log.Debug("ScraperManager", "RunScrapers", "No items to scrape, waiting...")
-				time.Sleep(5 * time.Minute)
+				wakeups.Wait(ctx, pipeline.StageScraper, pipeline.CheckInterval(sm.config, pipeline.StageScraper))
 				continue
-			} else {
-				sm.log.Debug("ScraperManager", "RunScrapers", fmt.Sprintf("Found item to scrape: %s (ID: %d)", item.Title, item.ID))
 			}
 
-			sm.log.Info("ScraperManager", "RunScrapers", fmt.Sprintf("Scraping item: %s", item.Title))
-
-			for _, scraper := range sm.scrapers {
-				scraperConfig := sm.config.Scraping.Scrapers[scraper.Name()]
-
-				// Check if the scraper is restricted to specific custom libraries
-				if len(scraperConfig.OnlyForCustomLibrary) > 0 && !utils.Contains(scraperConfig.OnlyForCustomLibrary, item.CustomLibrary.String) {
-					continue
-				}
-
-				err := scraper.Scrape(item)
-				if err != nil {
-					sm.log.Error("ScraperManager", "RunScrapers", fmt.Sprintf("Error scraping item %d with %s: %v", item.ID, scraper.Name(), err))
-					continue
-				}
-
-				sm.log.Info("ScraperManager", "RunScrapers", fmt.Sprintf("Successfully scraped item %d with %s", item.ID, scraper.Name()))
-				break // Stop after first successful scrape
+			item, err := sm.db.GetWatchlistItem(lease.ItemID)
+			if err != nil {
+				sm.log.Error("ScraperManager", "RunScrapers", fmt.Sprintf("Error getting item %d: %v", lease.ItemID, err))
+				lease.Release()
+				continue
 			}
+			sm.log.Debug("ScraperManager", "RunScrapers", fmt.Sprintf("Found item to scrape: %s (ID: %d)", item.Title, item.ID))
 
-			// Update item status
-			result, err := sm.db.GetLatestScrapeResult(item.ID)
-			if err == nil && result != nil && result.ScrapedFilename.Valid && result.ScrapedFilename.String != "" {
-				// Set status based on scrape result status
-				switch result.StatusResults.String {
-				case "scraped", "pending_download":
-					item.Status = sql.NullString{String: "ready_for_download", Valid: true}
-					item.CurrentStep = sql.NullString{String: "download_pending", Valid: true}
-				case "scraping_failed", "ignored_hash":
-					item.Status = sql.NullString{String: "scrape_failed", Valid: true}
-					item.CurrentStep = sql.NullString{String: "scrape_pending", Valid: true}
-				default:
-					item.Status = sql.NullString{String: "scrape_failed", Valid: true}
-					item.CurrentStep = sql.NullString{String: "scrape_pending", Valid: true}
-				}
-			} else {
-				item.Status = sql.NullString{String: "scrape_failed", Valid: true}
-				item.CurrentStep = sql.NullString{String: "scrape_pending", Valid: true}
-			}
-			if err = sm.db.UpdateWatchlistItem(item); err != nil {
-				sm.log.Error("ScraperManager", "RunScrapers", fmt.Sprintf("Error updating item status: %v", err))
-			} else {
-				sm.log.Debug("ScraperManager", "RunScrapers", fmt.Sprintf("Successfully updated item status: %s", item.Status.String))
+			if err := sm.scrape(lease.Context(), item); err != nil {
+				sm.log.Error("ScraperManager", "RunScrapers", fmt.Sprintf("Error scraping item %d: %v", item.ID, err))
 			}
+			lease.Release()
 
 			// Implement rate limiting if configured
 			if sm.config.Scraping.Scrapers["torrentio"].Ratelimit {
@@ -152,8 +162,7 @@ func (sm *ScraperManager) IsNeeded() bool {
 	err := sm.db.QueryRow(`
         SELECT COUNT(*) 
         FROM watchlistitem 
-        WHERE status = 'new' 
-        AND current_step = 'scrape_pending'
+        WHERE current_step = 'scrape_pending'
     `).Scan(&count)
 
 	return err == nil && count > 0
@@ -165,12 +174,128 @@ func (sm *ScraperManager) ScrapeSingle(itemID int) error {
 		return fmt.Errorf("failed to get item: %v", err)
 	}
 
+	return sm.scrapeItem(item)
+}
+
+// ProcessItem scrapes one item for the RunManager worker pool
+func (sm *ScraperManager) ProcessItem(ctx context.Context, item *database.WatchlistItem) error {
+	return sm.scrapeItem(item)
+}
+
+// scrapeItem leases an item and scrapes it
+func (sm *ScraperManager) scrapeItem(item *database.WatchlistItem) error {
+	return sm.leaser.Run(item.ID, pipeline.StageScraper, func(ctx context.Context) error {
+		return sm.scrape(ctx, item)
+	})
+}
+
+// scrape scrapes an item waiting in scrape_pending and moves it on to the
+// downloader. When no usable result was found the item is retried later, and
+// ends up in scrape_failed once its retries are used up.
+func (sm *ScraperManager) scrape(ctx context.Context, item *database.WatchlistItem) error {
+	if err := sm.pipeline.Transition(item.ID, pipeline.StateScrapePending, pipeline.StateScraping, "scraping started"); err != nil {
+		return err
+	}
+
+	filename, result, err := sm.scrapeResult(item)
+	// Leave the item to whoever claimed it after the lease was lost
+	if lerr := pipeline.LeaseLost(ctx); lerr != nil {
+		return lerr
+	}
+	sm.publishDecision(item, result, err)
+	if err != nil {
+		attempt, terr := sm.retrier.FailAttempt(item.ID, pipeline.StageScraper, err)
+		if terr != nil {
+			sm.log.Error("ScraperManager", "scrapeItem", fmt.Sprintf("Failed to record failure of item %d: %v", item.ID, terr))
+		}
+		// Said once, when the item is first put off; giving up sends failed
+		if attempt == 1 && errors.Is(err, ErrNoStreams) {
+			sm.notifier.Send(notify.ItemEvent(config.NotifyNoStreams, item))
+		}
+		return err
+	}
+
+	if err := sm.pipeline.Transition(item.ID, pipeline.StateScraping, pipeline.StateDownloadPending, fmt.Sprintf("scraped %s", filename)); err != nil {
+		return err
+	}
+	sm.retrier.Succeed(item.ID, pipeline.StageScraper)
+	return nil
+}
+
+// publishDecision tells event listeners which release the scraper chose for
+// an item, or why it chose none
+func (sm *ScraperManager) publishDecision(item *database.WatchlistItem, result *database.ScrapeResult, err error) {
+	event := database.Event{Type: database.EventScrape, ItemID: item.ID, Stage: string(pipeline.StageScraper)}
+	if err != nil {
+		event.Reason = err.Error()
+		event.Data = map[string]interface{}{"chosen": false, "no_streams": errors.Is(err, ErrNoStreams)}
+	} else {
+		event.Data = map[string]interface{}{
+			"chosen":           true,
+			"scrape_result_id": result.ID,
+			"filename":         result.ScrapedFilename.String,
+			"resolution":       result.ScrapedResolution.String,
+			"score":            result.ScrapedScore.Int32,
+			"pinned":           result.Pinned,
+		}
+	}
+	if perr := sm.db.PublishEvent(event); perr != nil {
+		sm.log.Warning("ScraperManager", "publishDecision", perr.Error())
+	}
+}
+
+// scrapeResult runs the scrapers and returns the filename of the result the
+// downloader should pick up, along with the result
+func (sm *ScraperManager) scrapeResult(item *database.WatchlistItem) (string, *database.ScrapeResult, error) {
+	if err := sm.runScrapers(item); err != nil {
+		return "", nil, err
+	}
+
+	result, err := sm.db.GetLatestScrapeResult(item.ID)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get latest scrape result: %v", err)
+	}
+	if result == nil {
+		return "", nil, fmt.Errorf("no usable scrape result")
+	}
+
+	// A release pasted by hand is only named once it is added to Real-Debrid
+	name := result.ScrapedFilename.String
+	if name == "" && result.Pinned {
+		name = result.InfoHash.String
+	}
+	if name == "" {
+		return "", nil, fmt.Errorf("no usable scrape result")
+	}
+
+	switch result.StatusResults.String {
+	case "scraped", "pending_download", "ready_for_download":
+		return name, result, nil
+	default:
+		if result.Pinned {
+			return "", nil, fmt.Errorf("pinned release is %s", result.StatusResults.String)
+		}
+		return "", nil, fmt.Errorf("latest scrape result is %s", result.StatusResults.String)
+	}
+}
+
+// runScrapers runs the configured scrapers for an item until one succeeds.
+// Nothing is scraped when the item already has usable results or a release
+// was pinned by hand.
+func (sm *ScraperManager) runScrapers(item *database.WatchlistItem) error {
 	// Get existing scrape results
-	existingResults, err := sm.db.GetScrapeResultsForItem(itemID)
+	existingResults, err := sm.db.GetScrapeResultsForItem(item.ID)
 	if err != nil {
 		return fmt.Errorf("failed to get existing scrape results: %v", err)
 	}
 
+	for _, result := range existingResults {
+		if result.Pinned {
+			sm.log.Info("ScraperManager", "runScrapers", fmt.Sprintf("Item %d has a pinned release, not scraping", item.ID))
+			return nil
+		}
+	}
+
 	// Check if we need to find more results
 	needsMoreResults := true
 	if len(existingResults) > 0 {
@@ -178,18 +303,30 @@ func (sm *ScraperManager) ScrapeSingle(itemID int) error {
 		for _, result := range existingResults {
 			// If any result is in these states, we need more results
 			switch result.StatusResults.String {
-			case "scraping_failed", "downloader_ignored_hash", "download_failed":
+			case "scraping_failed", "downloader_ignored_hash", "download_failed", "re-scrape":
 				needsMoreResults = true
 			}
 		}
 	}
 
+	// A series sent back for newly aired episodes still has results for the
+	// old ones, so check the episodes themselves
+	if !needsMoreResults && item.MediaType.String == "tv" {
+		needsMoreResults, err = sm.db.HasUnscrapedEpisodes(item.ID)
+		if err != nil {
+			return err
+		}
+	}
+
 	if !needsMoreResults {
-		sm.log.Info("ScraperManager", "ScrapeSingle", fmt.Sprintf("Item %d already has valid results", itemID))
+		sm.log.Info("ScraperManager", "runScrapers", fmt.Sprintf("Item %d already has valid results", item.ID))
 		return nil
 	}
 
-	for _, scraper := range sm.scrapers {
+	sm.log.Info("ScraperManager", "runScrapers", fmt.Sprintf("Scraping item: %s", item.Title))
+
+	noStreams := false
+	for _, scraper := range sm.scrapersFor(item) {
 		scraperConfig := sm.config.Scraping.Scrapers[scraper.Name()]
 
 		// Check if the scraper is restricted to specific custom libraries
@@ -199,13 +336,17 @@ func (sm *ScraperManager) ScrapeSingle(itemID int) error {
 
 		err := scraper.Scrape(item)
 		if err != nil {
-			sm.log.Error("ScraperManager", "ScrapeSingle", fmt.Sprintf("Error scraping item %d with %s: %v", item.ID, scraper.Name(), err))
+			sm.log.Error("ScraperManager", "runScrapers", fmt.Sprintf("Error scraping item %d with %s: %v", item.ID, scraper.Name(), err))
+			noStreams = noStreams || errors.Is(err, ErrNoStreams)
 			continue
 		}
 
-		sm.log.Info("ScraperManager", "ScrapeSingle", fmt.Sprintf("Successfully scraped item %d with %s", item.ID, scraper.Name()))
+		sm.log.Info("ScraperManager", "runScrapers", fmt.Sprintf("Successfully scraped item %d with %s", item.ID, scraper.Name()))
 		return nil
 	}
 
+	if noStreams {
+		return fmt.Errorf("failed to scrape item with any available scraper: %w", ErrNoStreams)
+	}
 	return fmt.Errorf("failed to scrape item with any available scraper")
 }
@@ -15,6 +15,7 @@ import (
 	"mye-r/internal/config"
 	"mye-r/internal/database"
 	"mye-r/internal/logger"
+	"mye-r/internal/metrics"
 )
 
 type TorrentioScraper struct {
@@ -154,7 +155,7 @@ func (s *TorrentioScraper) Scrape(item *database.WatchlistItem) error {
 		}
 
 		if len(filteredStreams) == 0 {
-			return fmt.Errorf("no valid streams found after filtering")
+			return fmt.Errorf("%w after filtering", ErrNoStreams)
 		}
 
 		// Proceed with filtered streams
@@ -299,7 +300,7 @@ func (s *TorrentioScraper) scrapeTVShow(item *database.WatchlistItem) error {
 	}
 
 	if !foundAny {
-		return fmt.Errorf("failed to scrape any episodes")
+		return fmt.Errorf("failed to scrape any episodes: %w", ErrNoStreams)
 	}
 
 	return nil
@@ -341,7 +342,7 @@ func (s *TorrentioScraper) scrapeIndividualEpisodes(item *database.WatchlistItem
 		if len(response.Streams) == 0 {
 			s.log.Warning("TorrentioScraper", "scrapeIndividualEpisodes",
 				fmt.Sprintf("No streams found for episode %d", episode.EpisodeNumber))
-			lastErr = fmt.Errorf("no streams found for episode %d", episode.EpisodeNumber)
+			lastErr = fmt.Errorf("%w for episode %d", ErrNoStreams, episode.EpisodeNumber)
 			continue
 		}
 
@@ -393,7 +394,7 @@ func (s *TorrentioScraper) scrapeIndividualEpisodes(item *database.WatchlistItem
 	}
 
 	if !foundAny && lastErr != nil {
-		return fmt.Errorf("failed to scrape any episodes: %v", lastErr)
+		return fmt.Errorf("failed to scrape any episodes: %w", lastErr)
 	}
 
 	return nil
@@ -461,7 +462,7 @@ func (s *TorrentioScraper) processSeasonPack(stream Stream, item *database.Watch
 // Add this new helper function to process the streams
 func (s *TorrentioScraper) processStreams(streams []Stream, item *database.WatchlistItem) error {
 	if len(streams) == 0 {
-		return fmt.Errorf("no streams found")
+		return ErrNoStreams
 	}
 
 	s.log.Info("TorrentioScraper", "Scrape", fmt.Sprintf("Found %d total streams for %s", len(streams), item.Title))
@@ -999,6 +1000,7 @@ func (s *TorrentioScraper) makeRequest(url string) (*http.Response, error) {
     // Make the request
     resp, err := s.client.Get(url)
     s.lastRequest = time.Now()
+    metrics.TorrentioRequests.Inc(metrics.Status(resp, err))
 
     // Handle rate limiting and server errors
     if resp != nil {
@@ -1015,6 +1017,7 @@ func (s *TorrentioScraper) makeRequest(url string) (*http.Response, error) {
             time.Sleep(retryWait)
             resp, err = s.client.Get(url)
             s.lastRequest = time.Now()
+            metrics.TorrentioRequests.Inc(metrics.Status(resp, err))
         } else if resp.StatusCode >= 500 {
             // Server error, wait a bit and retry once
             retryWait := 5 * time.Second
@@ -1024,6 +1027,7 @@ func (s *TorrentioScraper) makeRequest(url string) (*http.Response, error) {
             time.Sleep(retryWait)
             resp, err = s.client.Get(url)
             s.lastRequest = time.Now()
+            metrics.TorrentioRequests.Inc(metrics.Status(resp, err))
         }
     }
 
@@ -1086,5 +1090,5 @@ func (s *TorrentioScraper) searchTorrentio(item *database.WatchlistItem, query s
 		return nil, fmt.Errorf("failed to search torrentio: %v", lastErr)
 	}
 
-	return nil, fmt.Errorf("no streams found")
+	return nil, ErrNoStreams
 }
@@ -7,6 +7,9 @@ import (
 	"log"
 	"mye-r/internal/config"
 	"mye-r/internal/database"
+	"mye-r/internal/metrics"
+	"mye-r/internal/notify"
+	"mye-r/internal/pipeline"
 	"os"
 	"path/filepath"
 	"strings"
@@ -14,6 +17,8 @@ import (
 
 // DBInterface defines the database methods needed by the symlinker
 type DBInterface interface {
+	pipeline.LeaseStore
+	pipeline.RetryStore
 	GetNextItemForSymlinking() (*database.WatchlistItem, error)
 	UpdateWatchlistItem(*database.WatchlistItem) error
 	GetLatestScrapeResult(int) (*database.ScrapeResult, error)
@@ -22,8 +27,12 @@ type DBInterface interface {
 }
 
 type Symlinker struct {
-	config *config.Config
-	db     DBInterface
+	config   *config.Config
+	db       DBInterface
+	pipeline *pipeline.Machine
+	leaser   *pipeline.Leaser
+	retrier  *pipeline.Retrier
+	notifier *notify.Dispatcher
 }
 
 func New(cfg *config.Config, db DBInterface) *Symlinker {
@@ -32,8 +41,12 @@ func New(cfg *config.Config, db DBInterface) *Symlinker {
 
 func NewSymlinker(cfg *config.Config, db DBInterface) *Symlinker {
 	return &Symlinker{
-		config: cfg,
-		db:     db,
+		config:   cfg,
+		db:       db,
+		pipeline: pipeline.New(db),
+		leaser:   pipeline.NewLeaser(db, cfg.ProcessManagement.LeaseTTL),
+		retrier:  pipeline.NewRetrier(cfg, db),
+		notifier: notify.New(cfg, nil),
 	}
 }
 
@@ -50,13 +63,12 @@ func (s *Symlinker) Start(ctx context.Context) error {
 		return err
 	}
 	if item != nil {
-		log.Printf("Symlinking item: %s", item.Title)
-		if err := s.symlinkItem(item); err != nil {
+		if err := s.Symlink(item); err != nil {
 			log.Printf("Error symlinking item: %v", err)
 			return err
 		}
 	} else {
-		log.Printf("No items to process (status='downloaded' and current_step='symlink_pending')")
+		log.Printf("No items to process (current_step='symlink_pending')")
 	}
 
 	return nil
@@ -72,8 +84,7 @@ func (s *Symlinker) IsNeeded() bool {
 	err := s.db.QueryRow(`
         SELECT COUNT(*) 
         FROM watchlistitem 
-        WHERE status = 'downloaded' 
-        AND current_step = 'symlink_pending'
+        WHERE current_step = 'symlink_pending'
     `).Scan(&count)
 
 	return err == nil && count > 0
@@ -90,30 +101,48 @@ func (s *Symlinker) processNextItem() {
 		return // No items to process
 	}
 
-	log.Printf("Symlinking item: %s", item.Title)
+	if err := s.Symlink(item); err != nil {
+		log.Printf("Error symlinking item: %v", err)
+	}
+}
 
-	// Update item status to "symlinking"
-	item.Status = sql.NullString{String: "symlinking", Valid: true}
-	err = s.db.UpdateWatchlistItem(item)
-	if err != nil {
-		log.Printf("Error updating item status: %v", err)
-		return
+// Symlink links a downloaded item waiting in symlink_pending into its
+// libraries and marks it completed. The item is leased while it is linked.
+func (s *Symlinker) Symlink(item *database.WatchlistItem) error {
+	return s.leaser.Run(item.ID, pipeline.StageSymlinker, func(ctx context.Context) error {
+		return s.symlink(ctx, item)
+	})
+}
+
+func (s *Symlinker) symlink(ctx context.Context, item *database.WatchlistItem) error {
+	if err := s.pipeline.Transition(item.ID, pipeline.StateSymlinkPending, pipeline.StateSymlinking, "symlinking started"); err != nil {
+		return err
 	}
 
-	// Perform symlinking
-	err = s.symlinkItem(item)
+	log.Printf("Symlinking item: %s", item.Title)
+
+	err := s.symlinkItem(item)
+	if lerr := pipeline.LeaseLost(ctx); lerr != nil {
+		return lerr
+	}
 	if err != nil {
-		log.Printf("Error symlinking item: %v", err)
-		item.Status = sql.NullString{String: "symlink_failed", Valid: true}
-	} else {
-		item.Status = sql.NullString{String: "completed", Valid: true}
+		if terr := s.retrier.Fail(item.ID, pipeline.StageSymlinker, err); terr != nil {
+			log.Printf("Error recording failure of item %d: %v", item.ID, terr)
+		}
+		return err
 	}
 
-	// Update item in database
-	err = s.db.UpdateWatchlistItem(item)
-	if err != nil {
-		log.Printf("Error updating item after symlinking: %v", err)
+	if err := s.pipeline.Transition(item.ID, pipeline.StateSymlinking, pipeline.StateCompleted, "symlinked"); err != nil {
+		return err
 	}
+	s.retrier.Succeed(item.ID, pipeline.StageSymlinker)
+	s.notifier.Send(notify.ItemEvent(config.NotifyCompleted, item))
+	return nil
+}
+
+// ProcessItem symlinks one item for the RunManager worker pool
+func (s *Symlinker) ProcessItem(ctx context.Context, item *database.WatchlistItem) error {
+	return s.Symlink(item)
 }
 
 func (s *Symlinker) findDownloadedFile(filename string) (string, error) {
@@ -163,13 +192,129 @@ func (s *Symlinker) formatDestinationName(item *database.WatchlistItem) string {
 	return baseName
 }
 
+// Recover works out where an item left in symlinking belongs by checking
+// whether all of its links exist
+func (s *Symlinker) Recover(item *database.WatchlistItem) (pipeline.State, string, error) {
+	sourcePath, destPaths, err := s.linkPaths(item)
+	if err != nil {
+		return pipeline.StateSymlinkPending, fmt.Sprintf("links not checked: %v", err), nil
+	}
+	for _, destPath := range destPaths {
+		if !isLinked(destPath, sourcePath) {
+			return pipeline.StateSymlinkPending, fmt.Sprintf("symlink %s missing", destPath), nil
+		}
+	}
+	return pipeline.StateCompleted, "symlinks exist", nil
+}
+
+// Link is a library path an item is linked to
+type Link struct {
+	Path   string `json:"path"`
+	Target string `json:"target"`
+	Exists bool   `json:"exists"`
+}
+
+// Links returns the library paths of an item and whether each link exists
+func (s *Symlinker) Links(item *database.WatchlistItem) ([]Link, error) {
+	sourcePath, destPaths, err := s.linkPaths(item)
+	if err != nil {
+		return nil, err
+	}
+	links := make([]Link, 0, len(destPaths))
+	for _, destPath := range destPaths {
+		links = append(links, Link{
+			Path:   destPath,
+			Target: sourcePath,
+			Exists: isLinked(destPath, sourcePath),
+		})
+	}
+	return links, nil
+}
+
+// Unlink removes the library links of an item, and the folders they were made
+// in once nothing else is left there. Paths that are not links to the item's
+// file are left alone.
+func (s *Symlinker) Unlink(item *database.WatchlistItem) error {
+	sourcePath, destPaths, err := s.linkPaths(item)
+	if err != nil {
+		return err
+	}
+	for _, destPath := range destPaths {
+		if !isLinked(destPath, sourcePath) {
+			continue
+		}
+		if s.config.DryRun {
+			fmt.Printf("rm %s\n", destPath)
+			continue
+		}
+		if err := os.Remove(destPath); err != nil {
+			return fmt.Errorf("failed to remove symlink %s: %v", destPath, err)
+		}
+		metrics.Symlinks.Inc("removed")
+		log.Printf("Removed symlink: %s -> %s", destPath, sourcePath)
+
+		// Fails while the folder holds anything else, like subtitles
+		os.Remove(filepath.Dir(destPath))
+	}
+	return nil
+}
+
+// isLinked reports whether path is a symlink to target
+func isLinked(path, target string) bool {
+	linked, err := os.Readlink(path)
+	return err == nil && linked == target
+}
+
 func (s *Symlinker) symlinkItem(item *database.WatchlistItem) error {
+	sourcePath, destPaths, err := s.linkPaths(item)
+	if err != nil {
+		metrics.Symlinks.Inc("failed")
+		return err
+	}
+
+	// Create symlinks
+	for _, destPath := range destPaths {
+		if s.config.DryRun {
+			fmt.Printf("%s -> %s\n", destPath, sourcePath)
+			continue
+		}
+		if isLinked(destPath, sourcePath) {
+			// Left from an earlier run that did not finish
+			log.Printf("Symlink already exists: %s -> %s", destPath, sourcePath)
+			continue
+		}
+
+		// Create the destination directory if it doesn't exist
+		destDir := filepath.Dir(destPath)
+		err := os.MkdirAll(destDir, 0755)
+		if err != nil {
+			metrics.Symlinks.Inc("failed")
+			return fmt.Errorf("failed to create destination directory %s: %v", destDir, err)
+		}
+
+		// Create the symlink
+		err = os.Symlink(sourcePath, destPath)
+		if err != nil {
+			metrics.Symlinks.Inc("failed")
+			return fmt.Errorf("failed to create symlink %s -> %s: %v", destPath, sourcePath, err)
+		}
+		metrics.Symlinks.Inc("created")
+
+		log.Printf("Created symlink: %s -> %s", destPath, sourcePath)
+	}
+
+	return nil
+}
+
+// linkPaths finds the downloaded file of an item and the library paths it is
+// linked to
+func (s *Symlinker) linkPaths(item *database.WatchlistItem) (string, []string, error) {
 	scrapeResult, err := s.db.GetLatestScrapeResult(item.ID)
 	if err != nil {
-		return fmt.Errorf("failed to get scrape result: %v", err)
+		return "", nil, fmt.Errorf("failed to get scrape result: %v", err)
 	}
 	if scrapeResult == nil || !scrapeResult.ScrapedFilename.Valid {
-		return fmt.Errorf("no valid scrape result found")
+		return "", nil, fmt.Errorf("no valid scrape result found")
 	}
 
 	log.Printf("Got scrape result for item %d: %+v", item.ID, scrapeResult)
@@ -178,7 +323,7 @@ func (s *Symlinker) symlinkItem(item *database.WatchlistItem) error {
 	// Find the actual file
 	sourcePath, err := s.findDownloadedFile(scrapeResult.ScrapedFilename.String)
 	if err != nil {
-		return fmt.Errorf("failed to find source file: %v", err)
+		return "", nil, fmt.Errorf("failed to find source file: %v", err)
 	}
 
 	// Get the file extension
@@ -222,41 +367,19 @@ func (s *Symlinker) symlinkItem(item *database.WatchlistItem) error {
 		}
 	}
 
-	// Create symlinks
-	for _, destPath := range destPaths {
-		// Create the destination directory if it doesn't exist
-		destDir := filepath.Dir(destPath)
-		err := os.MkdirAll(destDir, 0755)
-		if err != nil {
-			return fmt.Errorf("failed to create destination directory %s: %v", destDir, err)
-		}
-
-		// Create the symlink
-		err = os.Symlink(sourcePath, destPath)
-		if err != nil {
-			return fmt.Errorf("failed to create symlink %s -> %s: %v", destPath, sourcePath, err)
-		}
-
-		log.Printf("Created symlink: %s -> %s", destPath, sourcePath)
-	}
-
-	// Update item status and current_step
-	_, err = s.db.Exec(`
-        UPDATE watchlistitem 
-        SET status = 'completed', current_step = 'symlinked'
-        WHERE id = $1
-    `, item.ID)
-	if err != nil {
-		return fmt.Errorf("failed to update item status: %v", err)
-	}
-	log.Printf("Updated item %d status to completed and current_step to symlinked", item.ID)
-
-	return nil
+	return sourcePath, destPaths, nil
 }
 
 func (s *Symlinker) itemMatchesCustomLibrary(item *database.WatchlistItem, lib config.CustomLibrary) bool {
 	log.Printf("Checking if item matches custom library: %s", lib.Name)
 
+	// Items of a feed routed to a library go there whatever its filters say
+	if item.ForcedLibrary.Valid && item.ForcedLibrary.String != "" {
+		if _, ok := s.config.CustomLibrary(item.ForcedLibrary.String); ok {
+			return lib.Name == item.ForcedLibrary.String
+		}
+	}
+
 	// Check include filters
 	for _, filter := range lib.Filters.Include {
 		if !s.checkFilter(item, filter) {
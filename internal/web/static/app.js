@@ -0,0 +1,449 @@
+'use strict';
+
+// The dashboard is a single page with three views, picked by the URL hash:
+//   #/           queues and the item list
+//   #/items/{id} one item with its metadata, episodes, candidates and links
+//   #/key        the API key form, also shown whenever the API answers 401
+
+const pageSize = 48;
+const pollInterval = 5000;
+
+const view = document.getElementById('view');
+const updated = document.getElementById('updated');
+let pollTimer = null;
+let events = null; // stream of the item being shown
+
+// The API key is kept in the browser and sent with every request
+const keyStorage = 'mye-r-api-key';
+
+// el builds an element. Text is always set through text nodes so titles and
+// filenames can never inject markup.
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  for (const [key, value] of Object.entries(attrs || {})) {
+    if (value === null || value === undefined || value === false) continue;
+    if (key.startsWith('on')) {
+      node.addEventListener(key.slice(2), value);
+    } else {
+      node.setAttribute(key, value === true ? '' : value);
+    }
+  }
+  for (const child of children.flat()) {
+    if (child === null || child === undefined || child === false) continue;
+    node.append(child instanceof Node ? child : document.createTextNode(String(child)));
+  }
+  return node;
+}
+
+async function api(path, options) {
+  const headers = { ...(options && options.headers) };
+  const key = localStorage.getItem(keyStorage);
+  if (key) headers.Authorization = 'Bearer ' + key;
+
+  const response = await fetch(path, { ...options, headers });
+  const body = await response.json().catch(() => ({}));
+  if (response.status === 401) askForKey(body.error);
+  if (!response.ok) {
+    throw new Error(body.error || response.statusText);
+  }
+  return body;
+}
+
+// askForKey replaces the page with a form for an API key, created with
+// 'mye-r apikey create'
+function askForKey(reason) {
+  stop();
+  const input = el('input', { type: 'password', name: 'key', placeholder: 'myer_…', autocomplete: 'off', required: true });
+  view.replaceChildren(
+    el('h2', {}, 'API key'),
+    el('p', { class: 'muted' }, reason || 'Enter an API key.',
+      ' Create one with ', el('code', {}, 'mye-r apikey create --name <name> --scope admin'), '.'),
+    el('form', {
+      class: 'filters',
+      onsubmit: (e) => {
+        e.preventDefault();
+        localStorage.setItem(keyStorage, input.value.trim());
+        if (location.hash === '#/key') {
+          location.hash = '#/';
+        } else {
+          route();
+        }
+      },
+    }, input, el('button', { type: 'submit' }, 'Save')),
+  );
+}
+
+function date(value) {
+  return value ? new Date(value).toLocaleString() : '';
+}
+
+function day(value) {
+  return value ? new Date(value).toLocaleDateString() : '';
+}
+
+function stepClass(step) {
+  if (!step) return 'step';
+  if (step === 'completed') return 'step done';
+  if (step.endsWith('_failed')) return 'step failed';
+  if (step.endsWith('_pending')) return 'step pending';
+  return 'step working';
+}
+
+function poster(item) {
+  if (item.thumbnail_url) {
+    return el('img', { src: item.thumbnail_url, alt: '', loading: 'lazy' });
+  }
+  return el('div', { class: 'no-poster' }, item.title.slice(0, 1));
+}
+
+function showError(container, err) {
+  container.replaceChildren(el('p', { class: 'error' }, err.message));
+}
+
+// Home: queues and the item list
+
+async function renderQueues(container) {
+  try {
+    const data = await api('/api/queues');
+    const rows = data.stages.map((queue) => el('tr', {},
+      el('td', {}, queue.stage),
+      el('td', { class: 'num' }, queue.pending),
+      el('td', { class: 'num' }, queue.ready),
+      el('td', { class: 'num' }, queue.working),
+      el('td', { class: queue.failed > 0 ? 'num failed' : 'num' }, queue.failed),
+    ));
+    container.replaceChildren(
+      el('table', { class: 'queues' },
+        el('thead', {}, el('tr', {},
+          el('th', {}, 'Stage'),
+          el('th', { class: 'num' }, 'Pending'),
+          el('th', { class: 'num', title: 'Pending items that are not leased or backing off' }, 'Ready'),
+          el('th', { class: 'num' }, 'Working'),
+          el('th', { class: 'num' }, 'Failed'),
+        )),
+        el('tbody', {}, rows),
+        el('tfoot', {}, el('tr', {},
+          el('td', {}, 'completed'),
+          el('td', { class: 'num', colspan: 4 }, data.completed),
+        )),
+      ),
+    );
+    updated.textContent = 'Updated ' + new Date().toLocaleTimeString();
+  } catch (err) {
+    showError(container, err);
+  }
+}
+
+function itemCard(item) {
+  return el('a', { class: 'card', href: '#/items/' + item.id },
+    poster(item),
+    el('div', { class: 'card-body' },
+      el('div', { class: 'card-title' }, item.title),
+      el('div', { class: 'muted' }, [item.year, item.media_type].filter(Boolean).join(' · ')),
+      el('span', { class: stepClass(item.current_step) }, item.current_step || 'unknown'),
+    ),
+  );
+}
+
+function renderHome() {
+  const queues = el('section', {}, el('p', { class: 'muted' }, 'Loading queues…'));
+  const grid = el('div', { class: 'grid' });
+  const more = el('button', { type: 'button', hidden: true }, 'Load more');
+  const status = el('p', { class: 'muted' });
+
+  const search = el('input', { type: 'search', name: 'q', placeholder: 'Search titles' });
+  const step = el('input', { type: 'text', name: 'step', placeholder: 'Step, e.g. scrape_failed' });
+  const mediaType = el('select', { name: 'media_type' },
+    el('option', { value: '' }, 'All media'),
+    el('option', { value: 'movie' }, 'Movies'),
+    el('option', { value: 'tv' }, 'Shows'),
+  );
+
+  let offset = 0;
+  let loading = 0;
+
+  async function load(reset) {
+    if (reset) offset = 0;
+    const request = ++loading;
+    const params = new URLSearchParams({ limit: pageSize, offset });
+    if (search.value.trim()) params.set('q', search.value.trim());
+    if (step.value.trim()) params.set('step', step.value.trim());
+    if (mediaType.value) params.set('media_type', mediaType.value);
+
+    try {
+      const data = await api('/api/items?' + params);
+      if (request !== loading) return; // a newer search won
+      if (reset) grid.replaceChildren();
+      grid.append(...data.items.map(itemCard));
+      offset += data.items.length;
+      more.hidden = data.items.length < pageSize;
+      status.textContent = offset === 0 ? 'No items match.' : '';
+    } catch (err) {
+      if (request === loading) showError(status, err);
+    }
+  }
+
+  let debounce = null;
+  const reload = () => {
+    clearTimeout(debounce);
+    debounce = setTimeout(() => load(true), 250);
+  };
+  search.addEventListener('input', reload);
+  step.addEventListener('input', reload);
+  mediaType.addEventListener('change', reload);
+  more.addEventListener('click', () => load(false));
+
+  view.replaceChildren(
+    el('h2', {}, 'Queues'),
+    queues,
+    el('h2', {}, 'Items'),
+    el('form', { class: 'filters', onsubmit: (e) => { e.preventDefault(); load(true); } }, search, step, mediaType),
+    status,
+    grid,
+    more,
+  );
+
+  renderQueues(queues);
+  pollTimer = setInterval(() => renderQueues(queues), pollInterval);
+  load(true);
+}
+
+// Item detail
+
+function field(label, value) {
+  if (value === null || value === undefined || value === '') return null;
+  return [el('dt', {}, label), el('dd', {}, value)];
+}
+
+function actions(item, rerender) {
+  const buttons = Object.entries(item.actions).map(([action, step]) =>
+    el('button', {
+      type: 'button',
+      onclick: async (event) => {
+        event.target.disabled = true;
+        try {
+          await api('/api/items/' + item.id, {
+            method: 'PATCH',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ step, reason: action + ' from the dashboard' }),
+          });
+          rerender();
+        } catch (err) {
+          alert(err.message);
+          event.target.disabled = false;
+        }
+      },
+    }, action.charAt(0).toUpperCase() + action.slice(1) + ' → ' + step),
+  );
+  return buttons.length ? el('div', { class: 'actions' }, buttons) : null;
+}
+
+function episodeGrid(seasons) {
+  if (!seasons.length) return null;
+  return el('section', {},
+    el('h3', {}, 'Episodes'),
+    seasons.map((season) => el('div', { class: 'season' },
+      el('div', { class: 'season-title' }, 'Season ' + season.season_number,
+        season.air_date ? el('span', { class: 'muted' }, ' · ' + day(season.air_date)) : null),
+      el('div', { class: 'episodes' }, season.episodes.map((episode) => el('span', {
+        class: episode.scraped ? 'episode scraped' : 'episode',
+        title: [
+          'E' + episode.episode_number,
+          episode.episode_name,
+          day(episode.air_date),
+          episode.scraped ? 'scraped' : 'not scraped',
+        ].filter(Boolean).join(' · '),
+      }, episode.episode_number))),
+    )),
+  );
+}
+
+// pinRelease pins a candidate or a pasted release, or unpins with body null
+async function pinRelease(item, body, rerender) {
+  try {
+    await api('/api/items/' + item.id + '/pin', body ? {
+      method: 'PUT',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(body),
+    } : { method: 'DELETE' });
+    rerender();
+  } catch (err) {
+    alert(err.message);
+  }
+}
+
+function candidates(item, rerender) {
+  const results = item.scrape_results;
+  const pinned = results.some((result) => result.pinned);
+  const sorted = [...results].sort((a, b) => (b.pinned - a.pinned) ||
+    ((b.scraped_score ?? -Infinity) - (a.scraped_score ?? -Infinity)));
+
+  const release = el('input', { type: 'text', placeholder: 'Magnet link or info hash', class: 'release' });
+  const paste = el('form', {
+    class: 'filters',
+    onsubmit: (e) => {
+      e.preventDefault();
+      if (release.value.trim()) pinRelease(item, { release: release.value.trim() }, rerender);
+    },
+  }, release, el('button', { type: 'submit' }, 'Pin release'),
+  pinned ? el('button', { type: 'button', onclick: () => pinRelease(item, null, rerender) }, 'Unpin') : null);
+
+  const table = results.length ? el('table', {},
+    el('thead', {}, el('tr', {},
+      el('th', { class: 'num' }, 'Score'),
+      el('th', {}, 'Filename'),
+      el('th', {}, 'Resolution'),
+      el('th', {}, 'Codec'),
+      el('th', {}, 'Size'),
+      el('th', {}, 'Status'),
+      el('th', {}, 'Hash'),
+      el('th', {}),
+    )),
+    el('tbody', {}, sorted.map((result) => el('tr', { class: result.pinned ? 'pinned' : null },
+      el('td', { class: 'num' }, result.scraped_score ?? ''),
+      el('td', { class: 'wrap' }, result.scraped_filename || ''),
+      el('td', {}, result.scraped_resolution || ''),
+      el('td', {}, result.scraped_codec || ''),
+      el('td', {}, result.scraped_file_size || ''),
+      el('td', {}, result.status || ''),
+      el('td', { class: 'hash' }, result.info_hash || ''),
+      el('td', {}, result.pinned
+        ? el('span', { class: 'step done' }, 'pinned')
+        : el('button', { type: 'button', onclick: () => pinRelease(item, { scrape_result_id: result.id }, rerender) }, 'Pin')),
+    ))),
+  ) : el('p', { class: 'muted' }, 'None yet.');
+
+  return el('section', {}, el('h3', {}, 'Scrape candidates'), table, paste);
+}
+
+function symlinks(item) {
+  const body = el('div', {}, el('button', {
+    type: 'button',
+    onclick: async () => {
+      body.replaceChildren(el('p', { class: 'muted' }, 'Checking…'));
+      try {
+        const data = await api('/api/items/' + item.id + '/symlinks');
+        if (!data.links.length) {
+          body.replaceChildren(el('p', { class: 'muted' }, 'No library paths apply to this item.'));
+          return;
+        }
+        body.replaceChildren(el('ul', { class: 'links' }, data.links.map((link) => el('li', {},
+          el('span', { class: link.exists ? 'step done' : 'step failed' }, link.exists ? 'linked' : 'missing'),
+          ' ', el('code', {}, link.path), ' → ', el('code', {}, link.target),
+        ))));
+      } catch (err) {
+        showError(body, err);
+      }
+    },
+  }, 'Check symlinks'));
+  return el('section', {}, el('h3', {}, 'Symlinks'), body);
+}
+
+function transitions(list) {
+  if (!list.length) return null;
+  return el('section', {},
+    el('h3', {}, 'History'),
+    el('ul', { class: 'timeline' }, [...list].reverse().map((t) => el('li', {},
+      el('span', { class: 'muted' }, date(t.created_at)), ' ',
+      t.from_state || '∅', ' → ', el('span', { class: stepClass(t.to_state) }, t.to_state),
+      t.reason ? el('span', { class: 'muted' }, ' · ' + t.reason) : null,
+    ))),
+  );
+}
+
+async function renderItem(id) {
+  const rerender = () => renderItem(id);
+  let item;
+  try {
+    item = await api('/api/items/' + id);
+  } catch (err) {
+    showError(view, err);
+    return;
+  }
+
+  view.replaceChildren(
+    el('p', {}, el('a', { href: '#/' }, '← All items')),
+    el('div', { class: 'detail' },
+      el('div', { class: 'detail-poster' }, poster(item)),
+      el('div', {},
+        el('h2', {}, item.title, item.year ? el('span', { class: 'muted' }, ' (' + item.year + ')') : null),
+        el('p', {}, el('span', { class: stepClass(item.current_step) }, item.current_step || 'unknown'),
+          item.status ? el('span', { class: 'muted' }, ' · ' + item.status) : null),
+        actions(item, rerender),
+        item.description ? el('p', { class: 'overview' }, item.description) : null,
+        el('dl', {},
+          field('Media type', item.media_type),
+          field('Genres', item.genres),
+          field('Rating', item.rating),
+          field('Released', day(item.release_date)),
+          field('Show status', item.show_status),
+          field('Seasons', item.total_seasons),
+          field('Episodes', item.total_episodes),
+          field('IMDb', item.imdb_id),
+          field('TMDB', item.tmdb_id),
+          field('TVDB', item.tvdb_id),
+          field('Libraries', item.custom_library),
+          field('Routed to', item.forced_library),
+          field('Quality profile', item.quality_profile),
+          field('Requested', date(item.requested_date)),
+          field('Requested by', item.requested_by),
+          field('Updated', date(item.updated_at)),
+        ),
+      ),
+    ),
+    episodeGrid(item.seasons),
+    candidates(item, rerender),
+    symlinks(item),
+    transitions(item.transitions),
+  );
+}
+
+// follow re-renders an item when it changes state or gets a release, and
+// shows the Real-Debrid progress of its download and its removal
+function follow(id) {
+  const rerender = () => renderItem(id);
+  const params = new URLSearchParams({ item: id });
+  const key = localStorage.getItem(keyStorage);
+  if (key) params.set('apikey', key);
+  events = new EventSource('/api/events?' + params);
+  events.addEventListener('transition', rerender);
+  events.addEventListener('scrape', rerender);
+  events.addEventListener('reconnected', rerender);
+  events.addEventListener('download_progress', (e) => {
+    const data = JSON.parse(e.data).data || {};
+    updated.textContent = 'Real-Debrid ' + data.status + ' ' + data.progress + '%';
+  });
+  events.addEventListener('removed', () => {
+    stop();
+    updated.textContent = 'Removed from the watchlist';
+  });
+}
+
+// stop ends the polling and event stream of the current view
+function stop() {
+  clearInterval(pollTimer);
+  pollTimer = null;
+  if (events) events.close();
+  events = null;
+  updated.textContent = '';
+}
+
+function route() {
+  stop();
+  if (location.hash === '#/key') {
+    askForKey('Enter the API key to use from now on.');
+    return;
+  }
+
+  const match = location.hash.match(/^#\/items\/(\d+)$/);
+  if (match) {
+    renderItem(match[1]);
+    follow(match[1]);
+  } else {
+    renderHome();
+  }
+  window.scrollTo(0, 0);
+}
+
+window.addEventListener('hashchange', route);
+route();
@@ -0,0 +1,22 @@
+// Package web is the dashboard served next to the API
+package web
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the dashboard. It is a single page that reads everything
+// from /api.
+func Handler() http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		// static is embedded, so this only fails if the directive is wrong
+		panic(err)
+	}
+	return http.FileServer(http.FS(files))
+}
@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"mye-r/internal/database"
+	"mye-r/internal/logger"
+)
+
+// ItemProcessor is implemented by stages that can work on a single item
+// in-process. RunManager uses it instead of exec'ing the stage binary when
+// process_management.mode is inprocess.
+type ItemProcessor interface {
+	ProcessItem(ctx context.Context, item *database.WatchlistItem) error
+}
+
+// ItemResult is the outcome of running one stage on one item
+type ItemResult struct {
+	Stage    string
+	ItemID   int
+	Title    string
+	Err      error
+	Duration time.Duration
+}
+
+// itemJob is a single item handed to a pool worker, together with the channel
+// its result has to be sent to
+type itemJob struct {
+	item    *database.WatchlistItem
+	results chan<- ItemResult
+}
+
+// WorkerPool runs one stage on a bounded number of goroutines. Batches are
+// submitted over a channel and every item produces exactly one ItemResult.
+type WorkerPool struct {
+	stage     string
+	processor ItemProcessor
+	size      int
+	jobs      chan itemJob
+	log       *logger.Logger
+	wg        sync.WaitGroup
+	busy      sync.Mutex
+}
+
+func NewWorkerPool(stage string, processor ItemProcessor, size int) *WorkerPool {
+	if size < 1 {
+		size = 1
+	}
+	return &WorkerPool{
+		stage:     stage,
+		processor: processor,
+		size:      size,
+		jobs:      make(chan itemJob),
+		log:       logger.New(),
+	}
+}
+
+// Start launches the workers. They stop when ctx is cancelled.
+func (p *WorkerPool) Start(ctx context.Context) {
+	for i := 0; i < p.size; i++ {
+		p.wg.Add(1)
+		go p.worker(ctx, i+1)
+	}
+	p.log.Info("WorkerPool", p.stage, fmt.Sprintf("Started %d workers", p.size))
+}
+
+// Wait blocks until all workers have stopped
+func (p *WorkerPool) Wait() {
+	p.wg.Wait()
+}
+
+func (p *WorkerPool) worker(ctx context.Context, id int) {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-p.jobs:
+			job.results <- p.run(ctx, job.item)
+		}
+	}
+}
+
+// run processes one item and turns panics into errors so a single bad item
+// cannot take the whole pipeline down
+func (p *WorkerPool) run(ctx context.Context, item *database.WatchlistItem) (result ItemResult) {
+	start := time.Now()
+	result = ItemResult{Stage: p.stage, ItemID: item.ID, Title: item.Title}
+	defer func() {
+		if r := recover(); r != nil {
+			result.Err = fmt.Errorf("panic while processing item %d: %v", item.ID, r)
+		}
+		result.Duration = time.Since(start)
+	}()
+
+	result.Err = p.processor.ProcessItem(ctx, item)
+	return result
+}
+
+// Submit hands a batch to the workers and returns a channel that receives one
+// result per item. The channel is closed once the whole batch is done. Items
+// that could not be handed over before ctx was cancelled are reported with
+// ctx.Err().
+func (p *WorkerPool) Submit(ctx context.Context, batch []*database.WatchlistItem) <-chan ItemResult {
+	results := make(chan ItemResult, len(batch))
+	out := make(chan ItemResult, len(batch))
+
+	go func() {
+		defer close(out)
+		sent := 0
+		for _, item := range batch {
+			select {
+			case p.jobs <- itemJob{item: item, results: results}:
+				sent++
+			case <-ctx.Done():
+				out <- ItemResult{Stage: p.stage, ItemID: item.ID, Title: item.Title, Err: ctx.Err()}
+			}
+		}
+		for i := 0; i < sent; i++ {
+			out <- <-results
+		}
+	}()
+
+	return out
+}
+
+// TryAcquire marks the pool busy. It returns false if a previous round of
+// batches is still running, so the same items are not submitted twice.
+func (p *WorkerPool) TryAcquire() bool {
+	return p.busy.TryLock()
+}
+
+// Release marks the pool idle again
+func (p *WorkerPool) Release() {
+	p.busy.Unlock()
+}